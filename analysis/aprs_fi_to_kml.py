@@ -205,6 +205,11 @@ def _parse_aprs(body: str) -> dict:
         elif c1 == 90:
             parsed.update({"radiorange": (2 * 1.08 ** s1) * 1.609344})  # mul = convert mph to kmh
 
+        # The glider sends course and speed, so its altitude is in the comment
+        altitude_match = re.search(r"/A=(-?\d{5,6})", body)
+        if "altitude" not in parsed and altitude_match:
+            parsed.update({"altitude": int(altitude_match.group(1)) * 0.3048})
+
         parsed.update({
             "symbol": symbol,
             "symbol_table": symbol_table,
@@ -3,6 +3,7 @@
 from dataclasses import dataclass, field
 from typing import Dict, List, Tuple
 import sys
+import zlib
 
 from matplotlib import pyplot
 
@@ -16,7 +17,7 @@ def main() -> None:
     """Plot the log data."""
 
     if len(sys.argv) != 2:
-        print(f"Usage: {sys.argv[0]} <log-file>")
+        print(f"Usage: {sys.argv[0]} <flight-data-csv>")
         return
 
     with open(sys.argv[1]) as file:
@@ -26,12 +27,33 @@ def main() -> None:
 
 
 def parse_file(file) -> ParsedData:
-    """Parses the data."""
+    """Parses a flight data recorder file. See the flightdata Go package for
+    the schema."""
 
     parsed = ParsedData()
 
+    header = None
     for line in file:
-        pass
+        line = line.rstrip("\r\n")
+        if not line or line.startswith("#"):
+            continue
+        values = line.split(",")
+        if values[0] == "time_ns":
+            header = values
+            continue
+        # Skip lines that were torn or corrupted
+        payload, _, checksum = line.rpartition(",")
+        if header is None or len(values) != len(header):
+            continue
+        if zlib.crc32(payload.encode()) != int(checksum, 16):
+            continue
+        row = dict(zip(header, values))
+        parsed.magnetometer.append(
+            (float(row["mag_x"]), float(row["mag_y"]), float(row["mag_z"]))
+        )
+        parsed.accelerometer.append(
+            (float(row["accel_x"]), float(row["accel_y"]), float(row["accel_z"]))
+        )
 
     return parsed
 
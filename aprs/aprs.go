@@ -0,0 +1,185 @@
+// Package aprs formats APRS compressed position reports, frames them as AX.25
+// UI frames, and wraps those for a KISS TNC. It can parse all of those back
+// too, for testing and for ground tools.
+package aprs
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Experimental software, per the APRS tocall list
+const Destination = "APZGLD"
+
+const (
+	metersPerFoot    = 0.3048
+	metersPerKnot    = 1852.0 / 3600.0
+	latitudeScale    = 380926.0
+	longitudeScale   = 190463.0
+	compressedLength = 13
+	// Current fix, RMC source, and software origin
+	compressionType = 0x20 | 0x18 | 0x02
+)
+
+// A station's callsign and secondary station ID, like N0CALL-11
+type Address struct {
+	Callsign string
+	Ssid     uint8
+}
+
+var callsignRegex = regexp.MustCompile(`^[A-Z0-9]{1,6}$`)
+
+func ParseAddress(text string) (Address, error) {
+	parts := strings.Split(strings.ToUpper(strings.TrimSpace(text)), "-")
+	if len(parts) > 2 || !callsignRegex.MatchString(parts[0]) {
+		return Address{}, fmt.Errorf("Bad callsign '%s'", text)
+	}
+	address := Address{Callsign: parts[0]}
+	if len(parts) == 2 {
+		ssid, err := strconv.ParseUint(parts[1], 10, 8)
+		if err != nil || ssid > 15 {
+			return Address{}, fmt.Errorf("Bad SSID in '%s'", text)
+		}
+		address.Ssid = uint8(ssid)
+	}
+	return address, nil
+}
+
+// Parses a comma separated digipeater path, like "WIDE1-1,WIDE2-1"
+func ParsePath(text string) ([]Address, error) {
+	var path []Address
+	if strings.TrimSpace(text) == "" {
+		return path, nil
+	}
+	for _, part := range strings.Split(text, ",") {
+		address, err := ParseAddress(part)
+		if err != nil {
+			return nil, err
+		}
+		path = append(path, address)
+	}
+	if len(path) > maxDigipeaters {
+		return nil, fmt.Errorf("Path '%s' has more than %d digipeaters", text, maxDigipeaters)
+	}
+	return path, nil
+}
+
+func (address Address) String() string {
+	if address.Ssid == 0 {
+		return address.Callsign
+	}
+	return fmt.Sprintf("%s-%d", address.Callsign, address.Ssid)
+}
+
+type Position struct {
+	Latitude  float64
+	Longitude float64
+	Altitude  float64
+	// Degrees clockwise from true north
+	Course float64
+	// Meters per second
+	Speed float64
+}
+
+// Formats a compressed position report without a timestamp. The course and
+// speed are compressed, and the altitude goes at the start of the comment.
+// The symbol is the table, '/' or '\', then the symbol code.
+func FormatCompressedPosition(symbol string, position Position, comment string) (string, error) {
+	if len(symbol) != 2 {
+		return "", fmt.Errorf("Bad symbol '%s'", symbol)
+	}
+	if math.IsNaN(position.Latitude) || position.Latitude < -90 || position.Latitude > 90 {
+		return "", fmt.Errorf("Bad latitude %v", position.Latitude)
+	}
+	if math.IsNaN(position.Longitude) || position.Longitude < -180 || position.Longitude > 180 {
+		return "", fmt.Errorf("Bad longitude %v", position.Longitude)
+	}
+
+	// Both 0 and 360 degrees are north
+	course := int(math.Round(position.Course/4)) % 90
+	if course < 0 {
+		course += 90
+	}
+	speed := int(math.Round(math.Log(position.Speed/metersPerKnot+1) / math.Log(1.08)))
+	speed = int(math.Max(0, math.Min(float64(speed), 89)))
+	// In feet, and the field is always six characters
+	altitude := int(math.Round(position.Altitude / metersPerFoot))
+	altitude = int(math.Max(-99999, math.Min(float64(altitude), 999999)))
+
+	var builder strings.Builder
+	builder.WriteByte('!')
+	builder.WriteByte(symbol[0])
+	// The spec truncates rather than rounds
+	builder.Write(base91(int(latitudeScale*(90-position.Latitude)), 4))
+	builder.Write(base91(int(longitudeScale*(180+position.Longitude)), 4))
+	builder.WriteByte(symbol[1])
+	builder.WriteByte(byte(course + 33))
+	builder.WriteByte(byte(speed + 33))
+	builder.WriteByte(compressionType + 33)
+	fmt.Fprintf(&builder, "/A=%06d", altitude)
+	if comment != "" {
+		builder.WriteByte(' ')
+		builder.WriteString(comment)
+	}
+	return builder.String(), nil
+}
+
+var altitudeRegex = regexp.MustCompile(`/A=(-?\d{5,6})`)
+
+// Parses a report from FormatCompressedPosition, returning the position, the
+// symbol, and the comment after the altitude
+func ParseCompressedPosition(information string) (Position, string, string, error) {
+	if len(information) < 1+compressedLength || (information[0] != '!' && information[0] != '=') {
+		return Position{}, "", "", errors.New("Not a compressed position report")
+	}
+	compressed := information[1 : 1+compressedLength]
+	for i := 1; i < 12; i++ {
+		if compressed[i] < 33 || compressed[i] > 123 {
+			return Position{}, "", "", errors.New("Bad character in compressed position")
+		}
+	}
+	position := Position{
+		Latitude:  90 - float64(fromBase91(compressed[1:5]))/latitudeScale,
+		Longitude: float64(fromBase91(compressed[5:9]))/longitudeScale - 180,
+	}
+	course := int(compressed[10]) - 33
+	speed := int(compressed[11]) - 33
+	if course >= 0 && course < 90 {
+		if course == 0 {
+			course = 90
+		}
+		position.Course = float64(course * 4)
+		position.Speed = (math.Pow(1.08, float64(speed)) - 1) * metersPerKnot
+	}
+
+	comment := information[1+compressedLength:]
+	match := altitudeRegex.FindStringSubmatchIndex(comment)
+	if match != nil {
+		feet, _ := strconv.Atoi(comment[match[2]:match[3]])
+		position.Altitude = float64(feet) * metersPerFoot
+		comment = comment[:match[0]] + comment[match[1]:]
+	}
+	symbol := string([]byte{compressed[0], compressed[9]})
+	return position, symbol, strings.TrimSpace(comment), nil
+}
+
+func base91(value int, length int) []byte {
+	digits := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		digits[i] = byte(value%91 + 33)
+		value /= 91
+	}
+	return digits
+}
+
+func fromBase91(digits string) int {
+	value := 0
+	for i := 0; i < len(digits); i++ {
+		value = value*91 + int(digits[i]) - 33
+	}
+	return value
+}
@@ -0,0 +1,155 @@
+package aprs
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFormatCompressedPosition(t *testing.T) {
+	// The example from the APRS 1.0.1 spec: 49 30' N, 72 45' W, course 88
+	// degrees, and 36.2 knots
+	position := Position{
+		Latitude:  49.5,
+		Longitude: -72.75,
+		Altitude:  1000,
+		Course:    88,
+		Speed:     36.2 * metersPerKnot,
+	}
+	information, err := FormatCompressedPosition("/>", position, "flying")
+	if err != nil {
+		t.Fatalf("Unable to format: %v", err)
+	}
+	if information != "!/5L!!<*e7>7P[/A=003281 flying" {
+		t.Errorf("Bad report '%s'", information)
+	}
+
+	parsed, symbol, comment, err := ParseCompressedPosition(information)
+	if err != nil {
+		t.Fatalf("Unable to parse: %v", err)
+	}
+	if symbol != "/>" || comment != "flying" {
+		t.Errorf("Bad symbol '%s' or comment '%s'", symbol, comment)
+	}
+	if math.Abs(parsed.Latitude-position.Latitude) > 1e-5 || math.Abs(parsed.Longitude-position.Longitude) > 1e-5 {
+		t.Errorf("Bad position %v", parsed)
+	}
+	if math.Abs(parsed.Altitude-position.Altitude) > 0.3 || parsed.Course != 88 || math.Abs(parsed.Speed-position.Speed) > 0.1 {
+		t.Errorf("Bad altitude, course, or speed %v", parsed)
+	}
+}
+
+func TestCompressedCourse(t *testing.T) {
+	for _, course := range []float64{0, 1, 359, 360} {
+		information, err := FormatCompressedPosition("/O", Position{Course: course}, "")
+		if err != nil {
+			t.Fatalf("Unable to format: %v", err)
+		}
+		parsed, _, _, err := ParseCompressedPosition(information)
+		if err != nil {
+			t.Fatalf("Unable to parse: %v", err)
+		}
+		if parsed.Course != 360 {
+			t.Errorf("Course %v should be north, got %v", course, parsed.Course)
+		}
+	}
+
+	// Negative altitudes still fit in six characters
+	information, _ := FormatCompressedPosition("/O", Position{Altitude: -10}, "")
+	if !strings.HasSuffix(information, "/A=-00033") {
+		t.Errorf("Bad altitude in '%s'", information)
+	}
+
+	_, err := FormatCompressedPosition("/O", Position{Latitude: 91}, "")
+	if err == nil {
+		t.Error("Should have failed with a bad latitude")
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	address, err := ParseAddress("ke0fzv-11")
+	if err != nil || address != (Address{"KE0FZV", 11}) || address.String() != "KE0FZV-11" {
+		t.Errorf("Bad address %v %v", address, err)
+	}
+	for _, bad := range []string{"", "TOOLONG1", "N0CALL-16", "N0CALL-1-1", "N0 CALL"} {
+		_, err := ParseAddress(bad)
+		if err == nil {
+			t.Errorf("Should have failed to parse '%s'", bad)
+		}
+	}
+
+	path, err := ParsePath("WIDE1-1,WIDE2-1")
+	if err != nil || len(path) != 2 || path[1] != (Address{"WIDE2", 1}) {
+		t.Errorf("Bad path %v %v", path, err)
+	}
+	path, err = ParsePath("")
+	if err != nil || len(path) != 0 {
+		t.Errorf("Bad empty path %v %v", path, err)
+	}
+}
+
+func TestAx25(t *testing.T) {
+	frame := Frame{
+		Destination: Address{Callsign: Destination},
+		Source:      Address{"N0CALL", 11},
+		Path:        []Address{{"WIDE2", 1}},
+		Information: []byte("!/5L!!<*e7>7P["),
+	}
+	encoded := frame.Encode()
+	expected := []byte{
+		'A' << 1, 'P' << 1, 'Z' << 1, 'G' << 1, 'L' << 1, 'D' << 1, 0xE0,
+		'N' << 1, '0' << 1, 'C' << 1, 'A' << 1, 'L' << 1, 'L' << 1, 0x60 | 11<<1,
+		'W' << 1, 'I' << 1, 'D' << 1, 'E' << 1, '2' << 1, ' ' << 1, 0x60 | 1<<1 | 0x01,
+		0x03, 0xF0,
+	}
+	if !bytes.HasPrefix(encoded, expected) {
+		t.Errorf("Bad header % x", encoded[:len(expected)])
+	}
+
+	decoded, err := DecodeFrame(encoded)
+	if err != nil {
+		t.Fatalf("Unable to decode: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, frame) {
+		t.Errorf("Expected %v, got %v", frame, decoded)
+	}
+	if decoded.String() != "N0CALL-11>APZGLD,WIDE2-1:!/5L!!<*e7>7P[" {
+		t.Errorf("Bad string '%s'", decoded)
+	}
+
+	_, err = DecodeFrame(encoded[:10])
+	if err == nil {
+		t.Error("Should have failed with a truncated frame")
+	}
+}
+
+func TestKiss(t *testing.T) {
+	frame := []byte{1, kissFrameEnd, 2, kissFrameEscape, 3}
+	encoded := EncodeKiss(frame)
+	expected := []byte{kissFrameEnd, 0, 1, kissFrameEscape, kissTransposedFrameEnd, 2, kissFrameEscape, kissTransposedEscape, 3, kissFrameEnd}
+	if !bytes.Equal(encoded, expected) {
+		t.Errorf("Bad KISS frame % x", encoded)
+	}
+
+	// TNCs might send other commands, or repeat frame ends
+	var stream []byte
+	stream = append(stream, kissFrameEnd, kissFrameEnd, 0x06, 1, kissFrameEnd)
+	stream = append(stream, encoded...)
+	stream = append(stream, EncodeKiss([]byte("second"))...)
+	reader := NewKissReader(bytes.NewReader(stream))
+	read, err := reader.Read()
+	if err != nil || !bytes.Equal(read, frame) {
+		t.Errorf("Bad frame % x %v", read, err)
+	}
+	read, err = reader.Read()
+	if err != nil || string(read) != "second" {
+		t.Errorf("Bad frame % x %v", read, err)
+	}
+	_, err = reader.Read()
+	if err != io.EOF {
+		t.Errorf("Expected EOF, got %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+package aprs
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	controlUi       = 0x03
+	protocolNoLayer = 0xF0
+	maxDigipeaters  = 8
+	addressLength   = 7
+
+	kissFrameEnd           = 0xC0
+	kissFrameEscape        = 0xDB
+	kissTransposedFrameEnd = 0xDC
+	kissTransposedEscape   = 0xDD
+	kissDataFrame          = 0x00
+)
+
+// An AX.25 UI frame, without the frame check sequence, which the TNC adds
+type Frame struct {
+	Destination Address
+	Source      Address
+	Path        []Address
+	Information []byte
+}
+
+func (frame Frame) Encode() []byte {
+	data := make([]byte, 0, addressLength*(2+len(frame.Path))+2+len(frame.Information))
+	addresses := append([]Address{frame.Destination, frame.Source}, frame.Path...)
+	for i, address := range addresses {
+		callsign := fmt.Sprintf("%-6s", address.Callsign)
+		for j := 0; j < 6; j++ {
+			data = append(data, callsign[j]<<1)
+		}
+		ssid := 0x60 | address.Ssid<<1
+		// Command frames set the C bit in the destination
+		if i == 0 {
+			ssid |= 0x80
+		}
+		if i == len(addresses)-1 {
+			ssid |= 0x01
+		}
+		data = append(data, ssid)
+	}
+	data = append(data, controlUi, protocolNoLayer)
+	return append(data, frame.Information...)
+}
+
+func DecodeFrame(data []byte) (Frame, error) {
+	var addresses []Address
+	offset := 0
+	for {
+		if len(data) < offset+addressLength {
+			return Frame{}, errors.New("Truncated AX.25 address")
+		}
+		var callsign strings.Builder
+		for _, b := range data[offset : offset+6] {
+			callsign.WriteByte(b >> 1)
+		}
+		ssid := data[offset+6]
+		addresses = append(addresses, Address{
+			Callsign: strings.TrimRight(callsign.String(), " "),
+			Ssid:     (ssid >> 1) & 0x0F,
+		})
+		offset += addressLength
+		if ssid&0x01 != 0 {
+			break
+		}
+	}
+	if len(addresses) < 2 || len(addresses) > 2+maxDigipeaters {
+		return Frame{}, fmt.Errorf("Bad AX.25 address count %d", len(addresses))
+	}
+	if len(data) < offset+2 || data[offset] != controlUi || data[offset+1] != protocolNoLayer {
+		return Frame{}, errors.New("Not an AX.25 UI frame")
+	}
+	return Frame{
+		Destination: addresses[0],
+		Source:      addresses[1],
+		Path:        addresses[2:],
+		Information: append([]byte(nil), data[offset+2:]...),
+	}, nil
+}
+
+// Formats the frame like TNC2 monitors do, e.g.
+// N0CALL-11>APZGLD,WIDE2-1:!/5L!!<*e7>7P[
+func (frame Frame) String() string {
+	addresses := []string{frame.Destination.String()}
+	for _, address := range frame.Path {
+		addresses = append(addresses, address.String())
+	}
+	return fmt.Sprintf("%s>%s:%s", frame.Source, strings.Join(addresses, ","), frame.Information)
+}
+
+// Wraps a frame as a KISS data frame for the first TNC port
+func EncodeKiss(frame []byte) []byte {
+	data := make([]byte, 0, len(frame)+4)
+	data = append(data, kissFrameEnd, kissDataFrame)
+	for _, b := range frame {
+		switch b {
+		case kissFrameEnd:
+			data = append(data, kissFrameEscape, kissTransposedFrameEnd)
+		case kissFrameEscape:
+			data = append(data, kissFrameEscape, kissTransposedEscape)
+		default:
+			data = append(data, b)
+		}
+	}
+	return append(data, kissFrameEnd)
+}
+
+// Reads data frames from a KISS stream, skipping other commands
+type KissReader struct {
+	reader *bufio.Reader
+}
+
+func NewKissReader(reader io.Reader) *KissReader {
+	return &KissReader{reader: bufio.NewReader(reader)}
+}
+
+// Returns the next data frame, without the KISS command byte
+func (kiss *KissReader) Read() ([]byte, error) {
+	var frame []byte
+	escaped := false
+	for {
+		b, err := kiss.reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case b == kissFrameEnd:
+			// The low nibble of the command is the command, and the high
+			// nibble is the port
+			if len(frame) > 1 && frame[0]&0x0F == kissDataFrame {
+				return frame[1:], nil
+			}
+			frame = frame[:0]
+			escaped = false
+		case escaped:
+			escaped = false
+			switch b {
+			case kissTransposedFrameEnd:
+				frame = append(frame, kissFrameEnd)
+			case kissTransposedEscape:
+				frame = append(frame, kissFrameEscape)
+			}
+		case b == kissFrameEscape:
+			escaped = true
+		default:
+			frame = append(frame, b)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/bskari/go-glider/glider"
+	"github.com/stianeikeland/go-rpio/v4"
+	"os"
+	"sort"
+	"time"
+)
+
+// Collects magnetometer readings while the glider is tumbled around, then fits
+// an ellipsoid to them and saves the hard and soft iron calibration
+func calibrateMagnetometer(hardware *glider.Hardware) {
+	magnetometer := hardware.Magnetometer
+	fmt.Println("Slowly tumble the glider through every orientation, then press enter")
+	done := make(chan bool)
+	go func() {
+		reader := bufio.NewReader(os.Stdin)
+		reader.ReadString('\n')
+		done <- true
+	}()
+
+	calibrator := glider.NewMagnetometerCalibrator()
+loop:
+	for {
+		select {
+		case <-done:
+			break loop
+		default:
+			x, y, z, err := magnetometer.SenseRaw()
+			check(err)
+			calibrator.AddSample(x, y, z)
+			if calibrator.SampleCount()%100 == 0 {
+				fmt.Printf("%d samples\n", calibrator.SampleCount())
+			}
+			time.Sleep(time.Millisecond * 20)
+		}
+	}
+
+	saveMagnetometerCalibration(calibrator)
+}
+
+// Fits the samples and writes the result to the configuration file. Returns
+// false if the fit failed or is too poor to trust, so that a bad tumble
+// doesn't overwrite a good calibration.
+func saveMagnetometerCalibration(calibrator *glider.MagnetometerCalibrator) bool {
+	calibration, rmsError, err := calibrator.Fit()
+	if err != nil {
+		fmt.Printf("Unable to fit magnetometer calibration: %v\n", err)
+		return false
+	}
+	coverage := calibrator.Coverage(calibration)
+	fmt.Printf("Fit %d samples with %0.1f%% RMS error, covering %0.0f%% of directions\n", calibrator.SampleCount(), rmsError*100, coverage*100)
+	if rmsError > glider.MaximumMagnetometerRmsError {
+		fmt.Printf("Not saving, the RMS error should be under %0.0f%%\n", glider.MaximumMagnetometerRmsError*100)
+		return false
+	}
+	if coverage < glider.MinimumMagnetometerCoverage {
+		fmt.Printf("Not saving, the samples should cover at least %0.0f%% of directions; keep tumbling\n", glider.MinimumMagnetometerCoverage*100)
+		return false
+	}
+	saveCalibration(calibration.ConfigurationValues())
+	return true
+}
+
+// Holds the glider in each orientation, pressing the button to take readings
+func calibrateAccelerometer(hardware *glider.Hardware) {
+	accelerometer := hardware.Accelerometer
+	buttonPin := hardware.Button
+
+	calibrator := glider.NewAccelerometerCalibrator()
+	for _, orientation := range glider.AccelerometerOrientations() {
+		fmt.Printf("Hold the glider %v and press the button\n", orientation)
+		for buttonPin.Read() != rpio.Low {
+			time.Sleep(time.Millisecond * 20)
+		}
+		// Let the glider settle after pushing the button
+		time.Sleep(time.Millisecond * 500)
+		for i := 0; i < 100; i++ {
+			x, y, z, err := accelerometer.SenseRaw()
+			check(err)
+			calibrator.AddSample(orientation, x, y, z)
+			time.Sleep(time.Millisecond * 10)
+		}
+		hardware.Led.Toggle()
+		for buttonPin.Read() == rpio.Low {
+			time.Sleep(time.Millisecond * 20)
+		}
+	}
+
+	calibration, err := calibrator.Fit()
+	if err != nil {
+		fmt.Printf("Unable to fit accelerometer calibration: %v\n", err)
+		return
+	}
+	saveCalibration(calibration.ConfigurationValues())
+}
+
+func saveCalibration(values map[string]string) {
+	err := glider.WriteConfigurationValues(savePath, values)
+	if err != nil {
+		// Probably a read-only filesystem, so let the user copy them
+		fmt.Printf("Unable to write %s: %v\n", savePath, err)
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Printf("%s = %s\n", key, values[key])
+		}
+		return
+	}
+	fmt.Printf("Wrote calibration to %s\n", savePath)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"github.com/bskari/go-glider/glider"
+	"path/filepath"
+	"strings"
+)
+
+// The base configuration, shared by every airframe
+const configurationPath = "conf.toml"
+
+// Where airframe profiles live, as <name>.toml
+const airframeDirectory = "airframes"
+
+// Where calibrations and tuned parameters are saved. This is the airframe
+// profile if we have one, because they're different for each airframe.
+var savePath = configurationPath
+
+// Repeated -set Key=value flags
+type configurationOverrides []string
+
+func (overrides *configurationOverrides) String() string {
+	return strings.Join(*overrides, " ")
+}
+
+func (overrides *configurationOverrides) Set(value string) error {
+	*overrides = append(*overrides, value)
+	return nil
+}
+
+// An airframe can be a name in the airframes directory, or a path
+func getAirframePath(airframe string) string {
+	if strings.HasSuffix(airframe, ".toml") {
+		return airframe
+	}
+	return filepath.Join(airframeDirectory, airframe+".toml")
+}
+
+// Loads the base configuration, then the airframe profile and the site
+// overlay if we have them, then the overrides
+func loadConfiguration(airframe string, site string, overrides []string) error {
+	paths := []string{configurationPath}
+	if airframe != "" {
+		savePath = getAirframePath(airframe)
+		paths = append(paths, savePath)
+	}
+	if site != "" {
+		paths = append(paths, site)
+	}
+
+	layers := make([]glider.ConfigurationLayer, 0, len(paths)+1)
+	for _, path := range paths {
+		layer, err := glider.OpenConfigurationLayer(path)
+		if err != nil {
+			return err
+		}
+		layers = append(layers, layer)
+	}
+	if len(overrides) > 0 {
+		layer, err := glider.ParseConfigurationOverrides("-set", overrides)
+		if err != nil {
+			return err
+		}
+		layers = append(layers, layer)
+	}
+	return glider.LoadConfigurationLayers(layers...)
+}
+
+// Prints each problem on its own line, so that they can all be fixed at once
+func printConfigurationErrors(err error) {
+	errs, ok := err.(glider.ConfigurationErrors)
+	if !ok {
+		fmt.Printf("Bad configuration: %v\n", err)
+		return
+	}
+	fmt.Println("Bad configuration:")
+	for _, configurationErr := range errs {
+		fmt.Printf("  %v\n", configurationErr)
+	}
+}
@@ -10,9 +10,7 @@ import (
 	"math/rand"
 	"net"
 	"os"
-	"periph.io/x/periph/conn/i2c/i2creg"
 	"periph.io/x/periph/conn/physic"
-	"periph.io/x/periph/host"
 	"time"
 )
 
@@ -47,51 +45,55 @@ func (reader dummyReader) Read(buffer []byte) (n int, err error) {
 }
 
 // Serves data for calibration on the given port
-func serveCalibrationData(port uint16) {
+func serveCalibrationData(hardware *glider.Hardware, port uint16) {
 	portString := fmt.Sprintf(":%d", port)
 	listener, err := net.Listen("tcp", portString)
 	check(err)
 	fmt.Printf("Listening on port %d\n", port)
+	calibrator := glider.NewMagnetometerCalibrator()
 	for {
 		conn, err := listener.Accept()
 		check(err)
 		fmt.Println("Got new connection")
-		go handleConnection(conn)
+		go handleConnection(conn, hardware, calibrator)
 	}
 }
 
-func handleConnection(conn net.Conn) {
+func handleConnection(conn net.Conn, hardware *glider.Hardware, calibrator *glider.MagnetometerCalibrator) {
 	defer conn.Close()
-	_, err := host.Init()
-	check(err)
-	bus, err := i2creg.Open("")
-	check(err)
-	defer bus.Close()
-	accelerometer, err := glider.NewAdxl345(bus)
-	check(err)
-	magnetometer, err := glider.NewHmc5883L(bus)
-	check(err)
+	accelerometer := hardware.Accelerometer
+	magnetometer := hardware.Magnetometer
+	gyroscope := hardware.Gyroscope
 
 	for {
 		xRawA, yRawA, zRawA, err := accelerometer.SenseRaw()
 		check(err)
+		xRawG, yRawG, zRawG, err := gyroscope.SenseRaw()
+		check(err)
 		xRawM, yRawM, zRawM, err := magnetometer.SenseRaw()
 		check(err)
 
-		// TODO: Spit out gyroscope data too
-		line := fmt.Sprintf("Raw:%d,%d,%d,%d,%d,%d,%d,%d,%d\n", xRawA, yRawA, zRawA, 0, 0, 0, xRawM, yRawM, zRawM)
+		line := fmt.Sprintf("Raw:%d,%d,%d,%d,%d,%d,%d,%d,%d\n", xRawA, yRawA, zRawA, xRawG, yRawG, zRawG, xRawM, yRawM, zRawM)
 		_, err = conn.Write([]byte(line))
 		fmt.Print(".")
 		if err != nil {
 			fmt.Println("Closing connection")
 			return
 		}
+
+		calibrator.AddSample(xRawM, yRawM, zRawM)
+		if calibrator.SampleCount()%100 == 0 {
+			_, rmsError, err := calibrator.Fit()
+			if err == nil {
+				fmt.Printf("\n%d samples, %0.1f%% RMS error\n", calibrator.SampleCount(), rmsError*100)
+			}
+		}
 		time.Sleep(time.Millisecond * 100)
 	}
 }
 
-func dumpSensors() {
-	xMinAccelerometer, xMaxAccelerometer, yMinAccelerometer, yMaxAccelerometer, zMinAccelerometer, zMaxAccelerometer, xMinFlux, xMaxFlux, yMinFlux, yMaxFlux, zMinFlux, zMaxFlux := dumpSensorsInner()
+func dumpSensors(hardware *glider.Hardware) {
+	xMinAccelerometer, xMaxAccelerometer, yMinAccelerometer, yMaxAccelerometer, zMinAccelerometer, zMaxAccelerometer, xMinFlux, xMaxFlux, yMinFlux, yMaxFlux, zMinFlux, zMaxFlux := dumpSensorsInner(hardware)
 
 	var buffer [2000]byte
 	outputBuffer := bytes.NewBuffer(buffer[:])
@@ -120,7 +122,7 @@ func dumpSensors() {
 	}
 }
 
-func dumpSensorsInner() (int16, int16, int16, int16, int16, int16, int16, int16, int16, int16, int16, int16) {
+func dumpSensorsInner(hardware *glider.Hardware) (int16, int16, int16, int16, int16, int16, int16, int16, int16, int16, int16, int16) {
 	/*
 		// Set up the GPS
 		var gps *bufio.Reader
@@ -140,37 +142,9 @@ func dumpSensorsInner() (int16, int16, int16, int16, int16, int16, int16, int16,
 		}
 	*/
 
-	// Set up accelerometer and magnetometer
-	var accelerometer *glider.Adxl345
-	var magnetometer *glider.Hmc5883L
-	if glider.IsPi() {
-		// Make sure periph is initialized.
-		if _, err := host.Init(); err != nil {
-			panic(err)
-		}
-
-		// Open a connection, using I²C as an example:
-		bus, err := i2creg.Open("")
-		check(err)
-		defer bus.Close()
-
-		accelerometer, err = glider.NewAdxl345(bus)
-		check(err)
-		magnetometer, err = glider.NewHmc5883L(bus)
-		check(err)
-	} else {
-		accelerometer = nil
-		magnetometer = nil
-	}
-
-	// Set up button
-	var buttonPin *rpio.Pin
-	if glider.IsPi() {
-		response := rpio.Pin(24)
-		buttonPin = &response
-		buttonPin.Input()
-		buttonPin.PullUp()
-	}
+	accelerometer := hardware.Accelerometer
+	magnetometer := hardware.Magnetometer
+	buttonPin := hardware.Button
 
 	// Set up display
 	err := termbox.Init()
@@ -205,7 +179,7 @@ func dumpSensorsInner() (int16, int16, int16, int16, int16, int16, int16, int16,
 	zMaxFlux := int16(math.MinInt16)
 
 	// Let's also test out the LED status indicator
-	statusIndicator := glider.NewLedStatusIndicator(3)
+	statusIndicator := glider.NewLedStatusIndicator(hardware.Led, 3)
 	blinkCount := uint8(3)
 
 	writer := &StringWriter{Line: 0}
@@ -255,21 +229,12 @@ loop:
 
 			// Output accelerometer readings
 			var x, y, z physic.Speed
-			var xRawA, yRawA, zRawA int16
-			if accelerometer != nil {
-				x, y, z, err = accelerometer.Sense()
-				check(err)
-				xRawA, yRawA, zRawA, err = accelerometer.SenseRaw()
+			xRawA, yRawA, zRawA, err := accelerometer.SenseRaw()
+			check(err)
+			// Only the real accelerometer knows its own scale
+			if adxl345, ok := accelerometer.(*glider.Adxl345); ok {
+				x, y, z, err = adxl345.Sense()
 				check(err)
-			} else {
-				offset := -int64(physic.MetrePerSecond) / 10
-				randRange := int64(physic.MetrePerSecond) / 5
-				x = physic.Speed(offset + rand.Int63n(randRange))
-				y = physic.Speed(offset + rand.Int63n(randRange))
-				z = physic.Speed(offset + rand.Int63n(randRange) + int64(9.8*float64(physic.MetrePerSecond)))
-				xRawA = int16(-10 + rand.Intn(21))
-				yRawA = int16(-10 + rand.Intn(21))
-				zRawA = int16(90 + rand.Intn(21))
 			}
 			x2 := int32(xRawA) * int32(xRawA)
 			z2 := int32(zRawA) * int32(zRawA)
@@ -306,15 +271,8 @@ loop:
 			writer.IndentLine(fmt.Sprintf("pitch: %5.1f   roll: %5.1f", pitch_d, roll_d))
 
 			// Output magnetometer readings
-			var xRawM, yRawM, zRawM int16
-			if magnetometer != nil {
-				xRawM, yRawM, zRawM, err = magnetometer.SenseRaw()
-				check(err)
-			} else {
-				xRawM = int16(-10 + rand.Intn(21))
-				yRawM = int16(-10 + rand.Intn(21))
-				zRawM = int16(-10 + rand.Intn(21))
-			}
+			xRawM, yRawM, zRawM, err := magnetometer.SenseRaw()
+			check(err)
 			xMinFlux = min(xRawM, xMinFlux)
 			yMinFlux = min(yRawM, yMinFlux)
 			zMinFlux = min(zRawM, zMinFlux)
@@ -352,12 +310,7 @@ loop:
 			writer.IndentLine(fmt.Sprintf("heading: %0.1f (with declination %0.1f) %0.1f", heading_d, declination, withDeclination_d))
 
 			// Output button state
-			var buttonState rpio.State
-			if buttonPin != nil {
-				buttonState = buttonPin.Read()
-			} else {
-				buttonState = rpio.High
-			}
+			buttonState := buttonPin.Read()
 			writer.WriteLine("=== Button ===")
 			buttonStateString := "unknown"
 			if buttonState == rpio.High {
@@ -0,0 +1,294 @@
+// Package flightdata reads and writes the flight data recorder files that the
+// pilot writes on every control loop tick and every AHRS step.
+//
+// A file is plain text, one line per record, so that it can be opened in a
+// spreadsheet or read with Python's csv module. Lines starting with # are
+// comments. The first other line names the columns, and every line after
+// that is a record:
+//
+//	time_ns             Unix time of the tick, in nanoseconds
+//	state               The pilot state, e.g. flying
+//	button              1 if the button was pressed, else 0
+//	accel_x, _y, _z     Raw accelerometer reading, in the board frame
+//	gyro_x, _y, _z      Raw gyroscope reading, in the board frame
+//	mag_x, _y, _z       Raw magnetometer reading, in the chip frame
+//	roll_d              Filtered roll in degrees, positive is right wing down
+//	pitch_d             Filtered pitch in degrees, positive is nose up
+//	yaw_d               Filtered yaw in degrees clockwise from true north
+//	gps_lock            1 if the GPS had a fix, else 0
+//	gps_time_ns         Unix time of the most recent fix, or 0 if none
+//	gps_received_ns     Unix time that the pilot parsed the most recent fix
+//	latitude            Latitude of the most recent fix, in degrees
+//	longitude           Longitude of the most recent fix, in degrees
+//	altitude_m          Altitude of the most recent fix
+//	speed_mps           Ground speed of the most recent fix
+//	course_d            Course over the ground of the most recent fix
+//	hdop                Horizontal dilution of precision
+//	target_roll_d       What the roll loop was steering to
+//	target_pitch_d      What the pitch loop was steering to
+//	left_servo_d        Commanded left servo angle, 90 is centered
+//	right_servo_d       Commanded right servo angle, 90 is centered
+//	waypoint_index      Index of the active waypoint
+//	waypoint_latitude   Latitude of the active waypoint
+//	waypoint_longitude  Longitude of the active waypoint
+//	sensors_only        1 if the record is an AHRS step instead of a control
+//	                    loop tick, else 0
+//	checksum            CRC-32 (IEEE) of the line up to the last comma, in hex
+//
+// Files are append only, and every record is written with a single write, so
+// if the pilot crashes or loses power, at most the last line is torn. The
+// checksum lets the reader skip torn or corrupted lines. A writer starts with
+// a newline so that a new header never ends up on the same line as a torn
+// record. Readers look columns up by name, so columns can be added later
+// without breaking old readers.
+//
+// Every AHRS step is recorded with the sensor readings that it used, so that
+// a replay can step it the same way. The AHRS steps faster than the control
+// loop, so most records are sensors_only. Version 1 files only have the
+// control loop ticks.
+package flightdata
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const Version = 2
+
+// How often to sync the file to disk, in flight time
+const syncPeriod = time.Second
+
+// One control loop tick, or one AHRS step if SensorsOnly
+type Record struct {
+	Time              time.Time
+	State             string
+	ButtonPressed     bool
+	Accelerometer     [3]int16
+	Gyroscope         [3]int16
+	Magnetometer      [3]int16
+	Roll_d            float64
+	Pitch_d           float64
+	Yaw_d             float64
+	GpsLock           bool
+	GpsTime           time.Time
+	GpsReceived       time.Time
+	Latitude          float64
+	Longitude         float64
+	Altitude_m        float64
+	Speed_mps         float64
+	Course_d          float64
+	Hdop              float64
+	TargetRoll_d      float64
+	TargetPitch_d     float64
+	LeftServo_d       float64
+	RightServo_d      float64
+	WaypointIndex     int
+	WaypointLatitude  float64
+	WaypointLongitude float64
+	SensorsOnly       bool
+}
+
+type column struct {
+	name   string
+	format func(record *Record) string
+	parse  func(record *Record, value string) error
+}
+
+// The columns in the order that they're written, not counting the checksum
+var columns = []column{
+	{"time_ns", formatTime(func(r *Record) *time.Time { return &r.Time }), parseTime(func(r *Record) *time.Time { return &r.Time })},
+	{"state", func(r *Record) string { return r.State }, func(r *Record, value string) error { r.State = value; return nil }},
+	{"button", formatBool(func(r *Record) *bool { return &r.ButtonPressed }), parseBool(func(r *Record) *bool { return &r.ButtonPressed })},
+	{"accel_x", formatInt16(func(r *Record) *int16 { return &r.Accelerometer[0] }), parseInt16(func(r *Record) *int16 { return &r.Accelerometer[0] })},
+	{"accel_y", formatInt16(func(r *Record) *int16 { return &r.Accelerometer[1] }), parseInt16(func(r *Record) *int16 { return &r.Accelerometer[1] })},
+	{"accel_z", formatInt16(func(r *Record) *int16 { return &r.Accelerometer[2] }), parseInt16(func(r *Record) *int16 { return &r.Accelerometer[2] })},
+	{"gyro_x", formatInt16(func(r *Record) *int16 { return &r.Gyroscope[0] }), parseInt16(func(r *Record) *int16 { return &r.Gyroscope[0] })},
+	{"gyro_y", formatInt16(func(r *Record) *int16 { return &r.Gyroscope[1] }), parseInt16(func(r *Record) *int16 { return &r.Gyroscope[1] })},
+	{"gyro_z", formatInt16(func(r *Record) *int16 { return &r.Gyroscope[2] }), parseInt16(func(r *Record) *int16 { return &r.Gyroscope[2] })},
+	{"mag_x", formatInt16(func(r *Record) *int16 { return &r.Magnetometer[0] }), parseInt16(func(r *Record) *int16 { return &r.Magnetometer[0] })},
+	{"mag_y", formatInt16(func(r *Record) *int16 { return &r.Magnetometer[1] }), parseInt16(func(r *Record) *int16 { return &r.Magnetometer[1] })},
+	{"mag_z", formatInt16(func(r *Record) *int16 { return &r.Magnetometer[2] }), parseInt16(func(r *Record) *int16 { return &r.Magnetometer[2] })},
+	{"roll_d", formatFloat(func(r *Record) *float64 { return &r.Roll_d }, 4), parseFloat(func(r *Record) *float64 { return &r.Roll_d })},
+	{"pitch_d", formatFloat(func(r *Record) *float64 { return &r.Pitch_d }, 4), parseFloat(func(r *Record) *float64 { return &r.Pitch_d })},
+	{"yaw_d", formatFloat(func(r *Record) *float64 { return &r.Yaw_d }, 4), parseFloat(func(r *Record) *float64 { return &r.Yaw_d })},
+	{"gps_lock", formatBool(func(r *Record) *bool { return &r.GpsLock }), parseBool(func(r *Record) *bool { return &r.GpsLock })},
+	{"gps_time_ns", formatTime(func(r *Record) *time.Time { return &r.GpsTime }), parseTime(func(r *Record) *time.Time { return &r.GpsTime })},
+	{"gps_received_ns", formatTime(func(r *Record) *time.Time { return &r.GpsReceived }), parseTime(func(r *Record) *time.Time { return &r.GpsReceived })},
+	{"latitude", formatFloat(func(r *Record) *float64 { return &r.Latitude }, 7), parseFloat(func(r *Record) *float64 { return &r.Latitude })},
+	{"longitude", formatFloat(func(r *Record) *float64 { return &r.Longitude }, 7), parseFloat(func(r *Record) *float64 { return &r.Longitude })},
+	{"altitude_m", formatFloat(func(r *Record) *float64 { return &r.Altitude_m }, 1), parseFloat(func(r *Record) *float64 { return &r.Altitude_m })},
+	{"speed_mps", formatFloat(func(r *Record) *float64 { return &r.Speed_mps }, 2), parseFloat(func(r *Record) *float64 { return &r.Speed_mps })},
+	{"course_d", formatFloat(func(r *Record) *float64 { return &r.Course_d }, 1), parseFloat(func(r *Record) *float64 { return &r.Course_d })},
+	{"hdop", formatFloat(func(r *Record) *float64 { return &r.Hdop }, 1), parseFloat(func(r *Record) *float64 { return &r.Hdop })},
+	{"target_roll_d", formatFloat(func(r *Record) *float64 { return &r.TargetRoll_d }, 2), parseFloat(func(r *Record) *float64 { return &r.TargetRoll_d })},
+	{"target_pitch_d", formatFloat(func(r *Record) *float64 { return &r.TargetPitch_d }, 2), parseFloat(func(r *Record) *float64 { return &r.TargetPitch_d })},
+	{"left_servo_d", formatFloat(func(r *Record) *float64 { return &r.LeftServo_d }, 2), parseFloat(func(r *Record) *float64 { return &r.LeftServo_d })},
+	{"right_servo_d", formatFloat(func(r *Record) *float64 { return &r.RightServo_d }, 2), parseFloat(func(r *Record) *float64 { return &r.RightServo_d })},
+	{"waypoint_index", formatInt(func(r *Record) *int { return &r.WaypointIndex }), parseInt(func(r *Record) *int { return &r.WaypointIndex })},
+	{"waypoint_latitude", formatFloat(func(r *Record) *float64 { return &r.WaypointLatitude }, 7), parseFloat(func(r *Record) *float64 { return &r.WaypointLatitude })},
+	{"waypoint_longitude", formatFloat(func(r *Record) *float64 { return &r.WaypointLongitude }, 7), parseFloat(func(r *Record) *float64 { return &r.WaypointLongitude })},
+	{"sensors_only", formatBool(func(r *Record) *bool { return &r.SensorsOnly }), parseBool(func(r *Record) *bool { return &r.SensorsOnly })},
+}
+
+const checksumColumn = "checksum"
+
+// Writes records to a file. Writers aren't safe to use from more than one
+// goroutine.
+type Writer struct {
+	w        io.Writer
+	buffer   bytes.Buffer
+	syncTime time.Time
+}
+
+// Something that can flush its writes to disk, like an os.File
+type syncer interface {
+	Sync() error
+}
+
+// Writes the header and returns a Writer for the records
+func NewWriter(w io.Writer) (*Writer, error) {
+	writer := &Writer{w: w}
+	writer.buffer.WriteString("\n")
+	writer.buffer.WriteString(fmt.Sprintf("# go-glider flight data version %d\n", Version))
+	for _, column := range columns {
+		writer.buffer.WriteString(column.name)
+		writer.buffer.WriteString(",")
+	}
+	writer.buffer.WriteString(checksumColumn)
+	writer.buffer.WriteString("\n")
+	_, err := w.Write(writer.buffer.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+func (writer *Writer) Write(record *Record) error {
+	writer.buffer.Reset()
+	for _, column := range columns {
+		value := column.format(record)
+		if strings.ContainsAny(value, ",\n") {
+			return fmt.Errorf("Bad %s value '%s'", column.name, value)
+		}
+		writer.buffer.WriteString(value)
+		writer.buffer.WriteString(",")
+	}
+	checksum := crc32.ChecksumIEEE(writer.buffer.Bytes()[:writer.buffer.Len()-1])
+	writer.buffer.WriteString(fmt.Sprintf("%08x\n", checksum))
+	_, err := writer.w.Write(writer.buffer.Bytes())
+	if err != nil {
+		return err
+	}
+
+	if file, ok := writer.w.(syncer); ok {
+		if record.Time.Sub(writer.syncTime) >= syncPeriod || record.Time.Before(writer.syncTime) {
+			writer.syncTime = record.Time
+			return file.Sync()
+		}
+	}
+	return nil
+}
+
+func formatTime(field func(*Record) *time.Time) func(*Record) string {
+	return func(record *Record) string {
+		value := field(record)
+		if value.IsZero() {
+			return "0"
+		}
+		return strconv.FormatInt(value.UnixNano(), 10)
+	}
+}
+
+func parseTime(field func(*Record) *time.Time) func(*Record, string) error {
+	return func(record *Record, value string) error {
+		nanoseconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		if nanoseconds == 0 {
+			*field(record) = time.Time{}
+		} else {
+			*field(record) = time.Unix(0, nanoseconds)
+		}
+		return nil
+	}
+}
+
+func formatBool(field func(*Record) *bool) func(*Record) string {
+	return func(record *Record) string {
+		if *field(record) {
+			return "1"
+		}
+		return "0"
+	}
+}
+
+func parseBool(field func(*Record) *bool) func(*Record, string) error {
+	return func(record *Record, value string) error {
+		switch value {
+		case "0":
+			*field(record) = false
+		case "1":
+			*field(record) = true
+		default:
+			return fmt.Errorf("Bad bool '%s'", value)
+		}
+		return nil
+	}
+}
+
+func formatInt16(field func(*Record) *int16) func(*Record) string {
+	return func(record *Record) string {
+		return strconv.Itoa(int(*field(record)))
+	}
+}
+
+func parseInt16(field func(*Record) *int16) func(*Record, string) error {
+	return func(record *Record, value string) error {
+		parsed, err := strconv.ParseInt(value, 10, 16)
+		if err != nil {
+			return err
+		}
+		*field(record) = int16(parsed)
+		return nil
+	}
+}
+
+func formatInt(field func(*Record) *int) func(*Record) string {
+	return func(record *Record) string {
+		return strconv.Itoa(*field(record))
+	}
+}
+
+func parseInt(field func(*Record) *int) func(*Record, string) error {
+	return func(record *Record, value string) error {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*field(record) = parsed
+		return nil
+	}
+}
+
+func formatFloat(field func(*Record) *float64, precision int) func(*Record) string {
+	return func(record *Record) string {
+		return strconv.FormatFloat(*field(record), 'f', precision, 64)
+	}
+}
+
+func parseFloat(field func(*Record) *float64) func(*Record, string) error {
+	return func(record *Record, value string) error {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		*field(record) = parsed
+		return nil
+	}
+}
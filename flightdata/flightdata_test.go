@@ -0,0 +1,167 @@
+package flightdata
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func getTestRecord(i int) Record {
+	return Record{
+		Time:              time.Unix(1601261144, int64(i)*int64(20*time.Millisecond)),
+		State:             "flying",
+		ButtonPressed:     i%2 == 0,
+		Accelerometer:     [3]int16{int16(i), -2, 256},
+		Gyroscope:         [3]int16{-4, 5, 6},
+		Magnetometer:      [3]int16{72, -148, int16(-105 - i)},
+		Roll_d:            -12.34,
+		Pitch_d:           5.67,
+		Yaw_d:             270.5,
+		GpsLock:           true,
+		GpsTime:           time.Unix(1601261144, 0),
+		GpsReceived:       time.Unix(1601261144, int64(300*time.Millisecond)),
+		Latitude:          40.0540123,
+		Longitude:         -105.2950456,
+		Altitude_m:        1800.5,
+		Speed_mps:         10.25,
+		Course_d:          90.5,
+		Hdop:              1.2,
+		TargetRoll_d:      -20,
+		TargetPitch_d:     -3,
+		LeftServo_d:       101.25,
+		RightServo_d:      95.5,
+		WaypointIndex:     i / 3,
+		WaypointLatitude:  40.06,
+		WaypointLongitude: -105.3,
+		SensorsOnly:       i%3 != 0,
+	}
+}
+
+func writeTestRecords(t *testing.T, buffer *bytes.Buffer, count int) {
+	writer, err := NewWriter(buffer)
+	if err != nil {
+		t.Fatalf("Unable to create writer: %v", err)
+	}
+	for i := 0; i < count; i++ {
+		record := getTestRecord(i)
+		err := writer.Write(&record)
+		if err != nil {
+			t.Fatalf("Unable to write record: %v", err)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	var buffer bytes.Buffer
+	writeTestRecords(t, &buffer, 5)
+
+	reader := NewReader(&buffer)
+	records, err := reader.ReadAll()
+	if err != nil {
+		t.Fatalf("Unable to read records: %v", err)
+	}
+	if len(records) != 5 || reader.Skipped != 0 {
+		t.Fatalf("Bad record count %v, skipped %v", len(records), reader.Skipped)
+	}
+	for i, record := range records {
+		expected := getTestRecord(i)
+		if !record.Time.Equal(expected.Time) || !record.GpsTime.Equal(expected.GpsTime) || !record.GpsReceived.Equal(expected.GpsReceived) {
+			t.Errorf("Bad times %v %v", record.Time, record.GpsTime)
+		}
+		if record.State != expected.State || record.ButtonPressed != expected.ButtonPressed || record.GpsLock != expected.GpsLock || record.SensorsOnly != expected.SensorsOnly {
+			t.Errorf("Bad state %v", record)
+		}
+		if record.Accelerometer != expected.Accelerometer || record.Gyroscope != expected.Gyroscope || record.Magnetometer != expected.Magnetometer {
+			t.Errorf("Bad raw sensors %v", record)
+		}
+		if record.WaypointIndex != expected.WaypointIndex {
+			t.Errorf("Bad waypoint index %v", record.WaypointIndex)
+		}
+		for _, pair := range [][2]float64{
+			{record.Roll_d, expected.Roll_d},
+			{record.Yaw_d, expected.Yaw_d},
+			{record.Latitude, expected.Latitude},
+			{record.Longitude, expected.Longitude},
+			{record.Altitude_m, expected.Altitude_m},
+			{record.Hdop, expected.Hdop},
+			{record.LeftServo_d, expected.LeftServo_d},
+			{record.WaypointLongitude, expected.WaypointLongitude},
+		} {
+			if math.Abs(pair[0]-pair[1]) > 1e-6 {
+				t.Errorf("Bad value %v, expected %v", pair[0], pair[1])
+			}
+		}
+	}
+}
+
+func TestTornRecords(t *testing.T) {
+	var buffer bytes.Buffer
+	writeTestRecords(t, &buffer, 3)
+	// Crash partway through the last record, then start writing again
+	buffer.Truncate(buffer.Len() - 10)
+	writeTestRecords(t, &buffer, 2)
+
+	reader := NewReader(&buffer)
+	records, err := reader.ReadAll()
+	if err != nil {
+		t.Fatalf("Unable to read records: %v", err)
+	}
+	if len(records) != 4 {
+		t.Errorf("Bad record count %v", len(records))
+	}
+	if reader.Skipped != 1 {
+		t.Errorf("Bad skipped count %v", reader.Skipped)
+	}
+}
+
+func TestCorruptedRecords(t *testing.T) {
+	var buffer bytes.Buffer
+	writeTestRecords(t, &buffer, 3)
+	// Corrupt the second record
+	lines := strings.Split(buffer.String(), "\n")
+	lines[4] = strings.Replace(lines[4], "flying", "flyinh", 1)
+
+	reader := NewReader(strings.NewReader(strings.Join(lines, "\n")))
+	records, err := reader.ReadAll()
+	if err != nil {
+		t.Fatalf("Unable to read records: %v", err)
+	}
+	if len(records) != 2 || reader.Skipped != 1 {
+		t.Errorf("Bad record count %v, skipped %v", len(records), reader.Skipped)
+	}
+	if records[1].Accelerometer[0] != 2 {
+		t.Errorf("Read the wrong record %v", records[1])
+	}
+}
+
+func TestUnknownColumns(t *testing.T) {
+	// A newer writer might add columns, or reorder them
+	line := "1601261144000000000,12.5,flying"
+	data := fmt.Sprintf("time_ns,airspeed_mps,state,checksum\n%s,%08x\n", line, crc32.ChecksumIEEE([]byte(line)))
+
+	reader := NewReader(strings.NewReader(data))
+	record, err := reader.Read()
+	if err != nil {
+		t.Fatalf("Unable to read record: %v", err)
+	}
+	if record.State != "flying" || record.Time.Unix() != 1601261144 {
+		t.Errorf("Bad record %v", record)
+	}
+	_, err = reader.Read()
+	if err != io.EOF {
+		t.Errorf("Expected EOF, got %v", err)
+	}
+}
+
+func TestMissingHeader(t *testing.T) {
+	reader := NewReader(strings.NewReader("1,flying,abc\n"))
+	_, err := reader.Read()
+	if err == nil || err == io.EOF {
+		t.Errorf("Should have failed without a header, got %v", err)
+	}
+}
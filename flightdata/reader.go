@@ -0,0 +1,130 @@
+package flightdata
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Reads records from a file, skipping lines that were torn or corrupted
+type Reader struct {
+	scanner *bufio.Scanner
+	// Parses each column in the current header, or nil for unknown columns
+	parsers []func(*Record, string) error
+	line    int
+	// How many lines were skipped because they were torn or corrupted
+	Skipped int
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{scanner: bufio.NewScanner(r)}
+}
+
+// Returns the next record, or io.EOF when there are no more
+func (reader *Reader) Read() (*Record, error) {
+	for reader.scanner.Scan() {
+		reader.line++
+		line := strings.TrimRight(reader.scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, columns[0].name+",") {
+			err := reader.readHeader(line)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if reader.parsers == nil {
+			return nil, errors.New("Missing flight data header")
+		}
+
+		record, err := reader.parseRecord(line)
+		if err != nil {
+			reader.Skipped++
+			continue
+		}
+		return record, nil
+	}
+	if err := reader.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// Reads all of the remaining records
+func (reader *Reader) ReadAll() ([]Record, error) {
+	var records []Record
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, *record)
+	}
+}
+
+// Reads all of the records from a file
+func ReadFile(path string) ([]Record, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return NewReader(file).ReadAll()
+}
+
+func (reader *Reader) readHeader(line string) error {
+	names := strings.Split(line, ",")
+	if names[len(names)-1] != checksumColumn {
+		return fmt.Errorf("Bad flight data header on line %d", reader.line)
+	}
+	reader.parsers = make([]func(*Record, string) error, len(names)-1)
+	for i, name := range names[:len(names)-1] {
+		for _, column := range columns {
+			if column.name == name {
+				reader.parsers[i] = column.parse
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (reader *Reader) parseRecord(line string) (*Record, error) {
+	lastComma := strings.LastIndexByte(line, ',')
+	if lastComma < 0 {
+		return nil, errors.New("Missing checksum")
+	}
+	checksum, err := strconv.ParseUint(line[lastComma+1:], 16, 32)
+	if err != nil {
+		return nil, err
+	}
+	if uint32(checksum) != crc32.ChecksumIEEE([]byte(line[:lastComma])) {
+		return nil, errors.New("Bad checksum")
+	}
+
+	values := strings.Split(line[:lastComma], ",")
+	if len(values) != len(reader.parsers) {
+		return nil, fmt.Errorf("Expected %d values, got %d", len(reader.parsers), len(values))
+	}
+	record := &Record{}
+	for i, value := range values {
+		if reader.parsers[i] == nil {
+			continue
+		}
+		err := reader.parsers[i](record, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return record, nil
+}
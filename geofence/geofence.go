@@ -0,0 +1,222 @@
+// Package geofence checks positions against inclusion and exclusion zones
+// and an altitude ceiling. Zones are polygons loaded from KML or GeoJSON
+// files, like the DJI restricted zones that analysis/format_dji.py converts.
+//
+// The polygons are treated as flat in latitude and longitude, which is fine
+// for zones that are a few kilometers across, but not for ones that are
+// hundreds of kilometers across or that cross the antimeridian.
+package geofence
+
+import (
+	"fmt"
+	"math"
+)
+
+// Meters per degree of latitude
+const metersPerDegree = 6371e3 * math.Pi / 180
+
+type Point struct {
+	Latitude  float64
+	Longitude float64
+}
+
+func (point Point) String() string {
+	return fmt.Sprintf("{%0.6f %0.6f}", point.Latitude, point.Longitude)
+}
+
+// A zone that we need to stay in, or out of
+type Polygon struct {
+	Name      string
+	Exclusion bool
+	// The outer boundary, without the closing point
+	Boundary []Point
+	// Areas inside the boundary that aren't part of the zone
+	Holes [][]Point
+}
+
+type Fence struct {
+	// If there are any inclusion zones, we need to stay inside of at least
+	// one of them
+	Polygons []Polygon
+	// Meters above mean sea level, or 0 for no ceiling
+	Ceiling float64
+}
+
+type BreachType uint8
+
+const (
+	// Outside of every inclusion zone
+	BREACH_OUTSIDE BreachType = iota + 1
+	// Inside of an exclusion zone
+	BREACH_EXCLUSION
+	// Above the ceiling
+	BREACH_CEILING
+)
+
+func (breachType BreachType) String() string {
+	return []string{
+		"(unused-0-breach)",
+		"outside",
+		"exclusion",
+		"ceiling",
+	}[breachType]
+}
+
+type Breach struct {
+	Type BreachType
+	// The exclusion zone that we're in, or nil
+	Polygon *Polygon
+}
+
+func (breach Breach) String() string {
+	switch breach.Type {
+	case BREACH_OUTSIDE:
+		return "outside of every inclusion zone"
+	case BREACH_EXCLUSION:
+		return fmt.Sprintf("inside exclusion zone '%s'", breach.Polygon.Name)
+	case BREACH_CEILING:
+		return "above the ceiling"
+	}
+	return breach.Type.String()
+}
+
+// Returns the breach at point and altitude, if any. Exclusion zones are
+// checked first, then inclusion zones, then the ceiling.
+func (fence *Fence) Check(point Point, altitude float64) (Breach, bool) {
+	hasInclusion := false
+	included := false
+	for i := range fence.Polygons {
+		polygon := &fence.Polygons[i]
+		contains := polygon.Contains(point)
+		if polygon.Exclusion {
+			if contains {
+				return Breach{Type: BREACH_EXCLUSION, Polygon: polygon}, true
+			}
+		} else {
+			hasInclusion = true
+			included = included || contains
+		}
+	}
+	if hasInclusion && !included {
+		return Breach{Type: BREACH_OUTSIDE}, true
+	}
+	if fence.Ceiling != 0 && altitude > fence.Ceiling {
+		return Breach{Type: BREACH_CEILING}, true
+	}
+	return Breach{}, false
+}
+
+// Returns the inclusion zone that contains point, or else the one whose
+// boundary is closest. Returns nil if there are no inclusion zones.
+func (fence *Fence) NearestInclusion(point Point) *Polygon {
+	var nearest *Polygon
+	nearestDistance := math.Inf(1)
+	for i := range fence.Polygons {
+		polygon := &fence.Polygons[i]
+		if polygon.Exclusion {
+			continue
+		}
+		if polygon.Contains(point) {
+			return polygon
+		}
+		distance := getDistance(point, polygon.NearestBoundaryPoint(point))
+		if distance < nearestDistance {
+			nearest = polygon
+			nearestDistance = distance
+		}
+	}
+	return nearest
+}
+
+// Returns true if point is inside the boundary and not in any of the holes
+func (polygon *Polygon) Contains(point Point) bool {
+	if !ringContains(polygon.Boundary, point) {
+		return false
+	}
+	for _, hole := range polygon.Holes {
+		if ringContains(hole, point) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the average of the boundary points. This is only guaranteed to be
+// inside of convex polygons.
+func (polygon *Polygon) Center() Point {
+	var center Point
+	for _, point := range polygon.Boundary {
+		center.Latitude += point.Latitude
+		center.Longitude += point.Longitude
+	}
+	count := float64(len(polygon.Boundary))
+	center.Latitude /= count
+	center.Longitude /= count
+	return center
+}
+
+// Returns the point on the boundary, including the holes, that is closest to
+// point
+func (polygon *Polygon) NearestBoundaryPoint(point Point) Point {
+	nearest := point
+	nearestDistance := math.Inf(1)
+	check := func(ring []Point) {
+		for i := range ring {
+			candidate := getNearestSegmentPoint(ring[i], ring[(i+1)%len(ring)], point)
+			distance := getDistance(point, candidate)
+			if distance < nearestDistance {
+				nearest = candidate
+				nearestDistance = distance
+			}
+		}
+	}
+	check(polygon.Boundary)
+	for _, hole := range polygon.Holes {
+		check(hole)
+	}
+	return nearest
+}
+
+// Ray casting, counting the edges that cross the line east of point
+func ringContains(ring []Point, point Point) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a := ring[i]
+		b := ring[j]
+		if (a.Latitude > point.Latitude) == (b.Latitude > point.Latitude) {
+			continue
+		}
+		crossing := a.Longitude + (point.Latitude-a.Latitude)*(b.Longitude-a.Longitude)/(b.Latitude-a.Latitude)
+		if point.Longitude < crossing {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// Returns the north and east offset in meters of point from origin
+func toNorthEast(origin, point Point) (float64, float64) {
+	north := (point.Latitude - origin.Latitude) * metersPerDegree
+	east := (point.Longitude - origin.Longitude) * metersPerDegree * math.Cos(origin.Latitude*math.Pi/180)
+	return north, east
+}
+
+func getDistance(p1, p2 Point) float64 {
+	north, east := toNorthEast(p1, p2)
+	return math.Hypot(north, east)
+}
+
+func getNearestSegmentPoint(start, end, point Point) Point {
+	segmentNorth, segmentEast := toNorthEast(start, end)
+	north, east := toNorthEast(start, point)
+	lengthSquared := segmentNorth*segmentNorth + segmentEast*segmentEast
+	if lengthSquared == 0 {
+		return start
+	}
+	fraction := (north*segmentNorth + east*segmentEast) / lengthSquared
+	fraction = math.Max(0, math.Min(1, fraction))
+	return Point{
+		Latitude:  start.Latitude + fraction*(end.Latitude-start.Latitude),
+		Longitude: start.Longitude + fraction*(end.Longitude-start.Longitude),
+	}
+}
@@ -0,0 +1,221 @@
+package geofence
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// A 0.02 degree square with a 0.01 degree hole in the middle
+func getTestSquare(exclusion bool) Polygon {
+	return Polygon{
+		Name:      "square",
+		Exclusion: exclusion,
+		Boundary:  []Point{{40.0, -105.0}, {40.0, -104.98}, {40.02, -104.98}, {40.02, -105.0}},
+		Holes:     [][]Point{{{40.005, -104.995}, {40.005, -104.985}, {40.015, -104.985}, {40.015, -104.995}}},
+	}
+}
+
+func TestContains(t *testing.T) {
+	square := getTestSquare(false)
+	tests := []struct {
+		point    Point
+		expected bool
+	}{
+		{Point{40.002, -104.998}, true},
+		{Point{40.01, -104.99}, false},
+		{Point{40.03, -104.99}, false},
+		{Point{40.01, -105.01}, false},
+		{Point{40.018, -104.982}, true},
+	}
+	for _, test := range tests {
+		if square.Contains(test.point) != test.expected {
+			t.Errorf("Expected %v for %v", test.expected, test.point)
+		}
+	}
+
+	// A concave U shape, open to the north
+	u := Polygon{Boundary: []Point{{0, 0}, {0, 3}, {3, 3}, {3, 2}, {1, 2}, {1, 1}, {3, 1}, {3, 0}}}
+	if u.Contains(Point{2, 1.5}) {
+		t.Error("Should be in the notch of the U")
+	}
+	if !u.Contains(Point{2, 0.5}) || !u.Contains(Point{0.5, 1.5}) {
+		t.Error("Should be in the U")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	fence := Fence{
+		Polygons: []Polygon{
+			getTestSquare(false),
+			{Name: "tower", Exclusion: true, Boundary: []Point{{40.016, -104.984}, {40.016, -104.982}, {40.018, -104.982}, {40.018, -104.984}}},
+		},
+		Ceiling: 2000,
+	}
+	if breach, ok := fence.Check(Point{40.002, -104.998}, 1900); ok {
+		t.Errorf("Should be clear, got %v", breach)
+	}
+	breach, ok := fence.Check(Point{40.03, -104.99}, 1900)
+	if !ok || breach.Type != BREACH_OUTSIDE {
+		t.Errorf("Should be outside, got %v", breach)
+	}
+	breach, ok = fence.Check(Point{40.017, -104.983}, 1900)
+	if !ok || breach.Type != BREACH_EXCLUSION || breach.Polygon.Name != "tower" {
+		t.Errorf("Should be in the tower zone, got %v", breach)
+	}
+	breach, ok = fence.Check(Point{40.002, -104.998}, 2100)
+	if !ok || breach.Type != BREACH_CEILING {
+		t.Errorf("Should be above the ceiling, got %v", breach)
+	}
+
+	// Without any inclusion zones, anywhere outside of the exclusion zones
+	// is fine
+	fence.Polygons = fence.Polygons[1:]
+	if breach, ok := fence.Check(Point{41.0, -104.0}, 1900); ok {
+		t.Errorf("Should be clear, got %v", breach)
+	}
+}
+
+func TestNearestBoundaryPoint(t *testing.T) {
+	square := getTestSquare(true)
+	// South of the square, so the closest point is straight north
+	nearest := square.NearestBoundaryPoint(Point{39.99, -104.99})
+	if math.Abs(nearest.Latitude-40.0) > 1e-9 || math.Abs(nearest.Longitude+104.99) > 1e-9 {
+		t.Errorf("Bad nearest point %v", nearest)
+	}
+	// In the hole, so the closest point is on the edge of the hole
+	nearest = square.NearestBoundaryPoint(Point{40.006, -104.99})
+	if math.Abs(nearest.Latitude-40.005) > 1e-9 || math.Abs(nearest.Longitude+104.99) > 1e-9 {
+		t.Errorf("Bad nearest point in the hole %v", nearest)
+	}
+
+	fence := Fence{Polygons: []Polygon{square, getTestSquare(false)}}
+	if inclusion := fence.NearestInclusion(Point{39.99, -104.99}); inclusion == nil || inclusion.Exclusion {
+		t.Errorf("Bad nearest inclusion %v", inclusion)
+	}
+}
+
+func writeTestFence(t *testing.T, name, contents string) (string, func()) {
+	directory, err := ioutil.TempDir("", "geofence")
+	if err != nil {
+		t.Fatalf("Couldn't create temporary directory: %v", err)
+	}
+	path := filepath.Join(directory, name)
+	err = ioutil.WriteFile(path, []byte(contents), 0644)
+	if err != nil {
+		t.Fatalf("Couldn't write fence: %v", err)
+	}
+	return path, func() { os.RemoveAll(directory) }
+}
+
+func TestLoad(t *testing.T) {
+	files := map[string]string{
+		// Like analysis/format_dji.py makes, plus an inclusion folder
+		"zones.kml": `<?xml version="1.0" encoding="UTF-8"?>
+<kml xmlns="http://www.opengis.net/kml/2.2">
+<Document>
+    <name>DJI restrictions</name>
+    <Placemark>
+        <name>Airport</name>
+        <Polygon>
+            <tessellate>1</tessellate>
+            <outerBoundaryIs>
+                <LinearRing>
+                    <coordinates>
+                        -105.0,40.0
+                        -104.98,40.0
+                        -104.98,40.02
+                        -105.0,40.02
+                        -105.0,40.0
+                    </coordinates>
+                </LinearRing>
+            </outerBoundaryIs>
+        </Polygon>
+    </Placemark>
+    <Folder>
+        <name>inclusion</name>
+        <Placemark>
+            <name>Field</name>
+            <Point><coordinates>-105.1,40.1</coordinates></Point>
+            <Polygon>
+                <outerBoundaryIs><LinearRing><coordinates>
+                    -105.2,40.0,1600 -105.1,40.0,1600 -105.1,40.1,1600
+                </coordinates></LinearRing></outerBoundaryIs>
+                <innerBoundaryIs><LinearRing><coordinates>
+                    -105.15,40.01 -105.14,40.01 -105.14,40.02
+                </coordinates></LinearRing></innerBoundaryIs>
+            </Polygon>
+        </Placemark>
+    </Folder>
+</Document>
+</kml>`,
+		"zones.geojson": `{
+  "type": "FeatureCollection",
+  "features": [
+    {
+      "type": "Feature",
+      "properties": {"name": "Airport"},
+      "geometry": {"type": "Polygon", "coordinates": [[[-105.0, 40.0], [-104.98, 40.0], [-104.98, 40.02], [-105.0, 40.02], [-105.0, 40.0]]]}
+    },
+    {
+      "type": "Feature",
+      "properties": {"name": "Field", "zone": "inclusion"},
+      "geometry": {"type": "MultiPolygon", "coordinates": [[
+        [[-105.2, 40.0], [-105.1, 40.0], [-105.1, 40.1]],
+        [[-105.15, 40.01], [-105.14, 40.01], [-105.14, 40.02]]
+      ]]}
+    }
+  ]
+}`,
+	}
+	for name, contents := range files {
+		path, remove := writeTestFence(t, name, contents)
+		defer remove()
+		polygons, err := Load(path)
+		if err != nil {
+			t.Errorf("%s: Unable to load: %v", name, err)
+			continue
+		}
+		if len(polygons) != 2 {
+			t.Errorf("%s: Expected 2 polygons, got %d", name, len(polygons))
+			continue
+		}
+		airport := polygons[0]
+		if airport.Name != "Airport" || !airport.Exclusion || len(airport.Boundary) != 4 || len(airport.Holes) != 0 {
+			t.Errorf("%s: Bad airport %+v", name, airport)
+		}
+		field := polygons[1]
+		if field.Name != "Field" || field.Exclusion || len(field.Boundary) != 3 || len(field.Holes) != 1 {
+			t.Errorf("%s: Bad field %+v", name, field)
+		}
+		if field.Boundary[1] != (Point{Latitude: 40.0, Longitude: -105.1}) {
+			t.Errorf("%s: Bad field point %v", name, field.Boundary[1])
+		}
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	files := map[string]string{
+		"empty.kml":       `<kml><Document><name>Nothing</name></Document></kml>`,
+		"line.kml":        `<kml><Placemark><Polygon><outerBoundaryIs><LinearRing><coordinates>-105,40 -104,40</coordinates></LinearRing></outerBoundaryIs></Polygon></Placemark></kml>`,
+		"latitude.kml":    `<kml><Placemark><Polygon><outerBoundaryIs><LinearRing><coordinates>40,-105 40,-104 41,-104</coordinates></LinearRing></outerBoundaryIs></Polygon></Placemark></kml>`,
+		"point.geojson":   `{"type": "Feature", "geometry": {"type": "Point", "coordinates": [-105, 40]}}`,
+		"zones.gpx":       `<gpx></gpx>`,
+		"truncated.kml":   `<kml><Placemark>`,
+		"malformed.json":  `{"type": "Feature", "geometry": {"type": "Polygon", "coordinates": [-105, 40]}}`,
+		"collection.json": `{"type": "Topology"}`,
+	}
+	for name, contents := range files {
+		path, remove := writeTestFence(t, name, contents)
+		defer remove()
+		_, err := Load(path)
+		if err == nil {
+			t.Errorf("%s: Should have failed", name)
+		} else if !strings.Contains(err.Error(), name) {
+			t.Errorf("%s: Error should name the file: %v", name, err)
+		}
+	}
+}
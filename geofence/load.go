@@ -0,0 +1,218 @@
+package geofence
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/bskari/go-glider/geoformat"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	zoneInclusion = "inclusion"
+	zoneExclusion = "exclusion"
+)
+
+// Loads the zones from a file, picking the format from the extension. Zones
+// are exclusion zones unless they're marked as inclusion zones, so that the
+// output of analysis/format_dji.py can be used as is.
+//
+// KML: Polygons, named by the enclosing Placemark. A zone is an inclusion
+// zone if the Placemark, or a Folder or Document that contains it, is named
+// "inclusion".
+// GeoJSON: Polygon and MultiPolygon features, named by their "name" property.
+// A zone is an inclusion zone if its "zone" property is "inclusion".
+func Load(path string) ([]Polygon, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var polygons []Polygon
+	extension := strings.ToLower(filepath.Ext(path))
+	switch extension {
+	case ".kml":
+		polygons, err = parseKml(file)
+	case ".geojson", ".json":
+		polygons, err = parseGeoJson(file)
+	default:
+		return nil, fmt.Errorf("%s: Unknown geofence file type '%s', expected .kml or .geojson", path, extension)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(polygons) == 0 {
+		return nil, fmt.Errorf("%s: No polygons", path)
+	}
+	return polygons, nil
+}
+
+// Checks the points and drops the closing point, if it's there
+func newRing(points []Point, name string) ([]Point, error) {
+	for _, point := range points {
+		if math.IsNaN(point.Latitude) || point.Latitude < -90 || point.Latitude > 90 {
+			return nil, fmt.Errorf("Zone '%s' has bad latitude %v", name, point.Latitude)
+		}
+		if math.IsNaN(point.Longitude) || point.Longitude < -180 || point.Longitude > 180 {
+			return nil, fmt.Errorf("Zone '%s' has bad longitude %v", name, point.Longitude)
+		}
+	}
+	if len(points) > 1 && points[0] == points[len(points)-1] {
+		points = points[:len(points)-1]
+	}
+	if len(points) < 3 {
+		return nil, fmt.Errorf("Zone '%s' needs at least 3 points, found %d", name, len(points))
+	}
+	return points, nil
+}
+
+// Drops the altitudes, since the ceiling is the only vertical limit
+func fromGeoformatPoints(points []geoformat.Point) []Point {
+	converted := make([]Point, 0, len(points))
+	for _, point := range points {
+		converted = append(converted, Point{Latitude: point.Latitude, Longitude: point.Longitude})
+	}
+	return converted
+}
+
+// A Polygon is named by its Placemark, and is an inclusion zone if it or
+// anything that contains it is named "inclusion"
+func getKmlZone(parents []*geoformat.KmlElement) (string, bool) {
+	name := ""
+	exclusion := true
+	for i := len(parents) - 1; i >= 0; i-- {
+		if name == "" {
+			name = parents[i].Name
+		}
+		if strings.EqualFold(parents[i].Name, zoneInclusion) {
+			exclusion = false
+			break
+		}
+		if strings.EqualFold(parents[i].Name, zoneExclusion) {
+			break
+		}
+	}
+	return name, exclusion
+}
+
+func parseKml(reader io.Reader) ([]Polygon, error) {
+	polygons := []Polygon{}
+	// The rings of the Polygon that we're in, which we can only check once
+	// we know its name
+	var boundary []geoformat.Point
+	var holes [][]geoformat.Point
+	err := geoformat.WalkKml(reader, func(element *geoformat.KmlElement, parents []*geoformat.KmlElement) error {
+		switch element.Local {
+		case "coordinates":
+			// Points and lines outside of polygons aren't zones
+			if len(parents) < 3 || parents[len(parents)-3].Local != "Polygon" {
+				return nil
+			}
+			points, err := geoformat.ParseKmlCoordinates(element.Text())
+			if err != nil {
+				return err
+			}
+			switch parents[len(parents)-2].Local {
+			case "outerBoundaryIs":
+				boundary = points
+			case "innerBoundaryIs":
+				holes = append(holes, points)
+			}
+		case "Polygon":
+			name, exclusion := getKmlZone(parents)
+			polygon := Polygon{Name: name, Exclusion: exclusion}
+			if boundary == nil {
+				return fmt.Errorf("Zone '%s' has no outer boundary", name)
+			}
+			ring, err := newRing(fromGeoformatPoints(boundary), name)
+			if err != nil {
+				return err
+			}
+			polygon.Boundary = ring
+			for _, points := range holes {
+				ring, err := newRing(fromGeoformatPoints(points), name)
+				if err != nil {
+					return err
+				}
+				polygon.Holes = append(polygon.Holes, ring)
+			}
+			polygons = append(polygons, polygon)
+			boundary = nil
+			holes = nil
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return polygons, nil
+}
+
+// Converts GeoJSON polygon coordinates, the boundary and then the holes
+func newGeoJsonPolygon(rings [][][]float64, name string, exclusion bool) (Polygon, error) {
+	if len(rings) == 0 {
+		return Polygon{}, fmt.Errorf("Zone '%s' has no outer boundary", name)
+	}
+	polygon := Polygon{Name: name, Exclusion: exclusion}
+	for i, positions := range rings {
+		points, err := geoformat.GeoJsonPositions(positions)
+		if err != nil {
+			return Polygon{}, err
+		}
+		ring, err := newRing(fromGeoformatPoints(points), name)
+		if err != nil {
+			return Polygon{}, err
+		}
+		if i == 0 {
+			polygon.Boundary = ring
+		} else {
+			polygon.Holes = append(polygon.Holes, ring)
+		}
+	}
+	return polygon, nil
+}
+
+func parseGeoJson(reader io.Reader) ([]Polygon, error) {
+	features, err := geoformat.ReadGeoJsonFeatures(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	polygons := []Polygon{}
+	for i, feature := range features {
+		geometry := feature.Geometry
+		name, _ := feature.Properties["name"].(string)
+		if name == "" {
+			name = fmt.Sprintf("feature %d", i+1)
+		}
+		zone, _ := feature.Properties["zone"].(string)
+		exclusion := !strings.EqualFold(zone, zoneInclusion)
+
+		var rings [][][][]float64
+		switch geometry.Type {
+		case "Polygon":
+			var polygon [][][]float64
+			err = json.Unmarshal(geometry.Coordinates, &polygon)
+			rings = [][][][]float64{polygon}
+		case "MultiPolygon":
+			err = json.Unmarshal(geometry.Coordinates, &rings)
+		default:
+			return nil, fmt.Errorf("GeoJSON feature %d: Unsupported geometry '%s', expected Polygon or MultiPolygon", i+1, geometry.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("GeoJSON feature %d: Bad %s: %v", i+1, geometry.Type, err)
+		}
+		for _, polygonRings := range rings {
+			polygon, err := newGeoJsonPolygon(polygonRings, name, exclusion)
+			if err != nil {
+				return nil, fmt.Errorf("GeoJSON feature %d: %v", i+1, err)
+			}
+			polygons = append(polygons, polygon)
+		}
+	}
+	return polygons, nil
+}
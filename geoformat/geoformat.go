@@ -0,0 +1,179 @@
+// Package geoformat reads the parts of KML and GeoJSON files that are the
+// same whatever the shapes are for: coordinates, the KML element tree, and
+// GeoJSON features. Missions and geofences each decide what the shapes mean.
+package geoformat
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Altitude is 0 if the file didn't have one
+type Point struct {
+	Latitude  float64
+	Longitude float64
+	Altitude  float64
+}
+
+func parseNumber(text, name string) (float64, error) {
+	value, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+	if err != nil {
+		return 0, fmt.Errorf("Bad %s '%s'", name, text)
+	}
+	return value, nil
+}
+
+// Parses KML coordinates, which are whitespace separated
+// longitude,latitude[,altitude] tuples
+func ParseKmlCoordinates(text string) ([]Point, error) {
+	points := []Point{}
+	for _, tuple := range strings.Fields(text) {
+		parts := strings.Split(tuple, ",")
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil, fmt.Errorf("Bad KML coordinates '%s'", tuple)
+		}
+		longitude, err := parseNumber(parts[0], "longitude")
+		if err != nil {
+			return nil, err
+		}
+		latitude, err := parseNumber(parts[1], "latitude")
+		if err != nil {
+			return nil, err
+		}
+		point := Point{Latitude: latitude, Longitude: longitude}
+		if len(parts) == 3 {
+			point.Altitude, err = parseNumber(parts[2], "altitude")
+			if err != nil {
+				return nil, err
+			}
+		}
+		points = append(points, point)
+	}
+	return points, nil
+}
+
+// A KML element, e.g. a Placemark, Folder, or coordinates
+type KmlElement struct {
+	Local string
+	// From the element's <name>, if it has one and we've read it yet
+	Name string
+	text strings.Builder
+}
+
+// Returns the text inside the element, not counting its children's elements
+func (element *KmlElement) Text() string {
+	return element.text.String()
+}
+
+// Calls visit as each element ends, with the elements that enclose it,
+// outermost first. KML nests Placemarks in any number of Folders and
+// Documents, so callers look through the enclosing elements instead of
+// expecting a fixed structure. An error from visit stops the walk.
+func WalkKml(reader io.Reader, visit func(element *KmlElement, parents []*KmlElement) error) error {
+	stack := []*KmlElement{}
+	decoder := xml.NewDecoder(reader)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("Bad KML: %v", err)
+		}
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			stack = append(stack, &KmlElement{Local: token.Name.Local})
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text.Write(token)
+			}
+		case xml.EndElement:
+			if len(stack) == 0 {
+				return fmt.Errorf("Bad KML: unexpected </%s>", token.Name.Local)
+			}
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if top.Local == "name" && len(stack) > 0 {
+				stack[len(stack)-1].Name = strings.TrimSpace(top.Text())
+			}
+			err = visit(top, stack)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type GeoJsonGeometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+type GeoJsonFeature struct {
+	Type       string                 `json:"type"`
+	Properties map[string]interface{} `json:"properties"`
+	Geometry   *GeoJsonGeometry       `json:"geometry"`
+}
+
+type geoJsonFile struct {
+	Type     string           `json:"type"`
+	Features []GeoJsonFeature `json:"features"`
+	GeoJsonFeature
+}
+
+// Reads a FeatureCollection, or a single Feature. Every feature has a
+// geometry.
+func ReadGeoJsonFeatures(reader io.Reader) ([]GeoJsonFeature, error) {
+	var geoJson geoJsonFile
+	err := json.NewDecoder(reader).Decode(&geoJson)
+	if err != nil {
+		return nil, fmt.Errorf("Bad GeoJSON: %v", err)
+	}
+
+	var features []GeoJsonFeature
+	switch geoJson.Type {
+	case "FeatureCollection":
+		features = geoJson.Features
+	case "Feature":
+		features = []GeoJsonFeature{geoJson.GeoJsonFeature}
+	default:
+		return nil, fmt.Errorf("Expected a GeoJSON FeatureCollection or Feature, not '%s'", geoJson.Type)
+	}
+	for i, feature := range features {
+		if feature.Geometry == nil {
+			return nil, fmt.Errorf("GeoJSON feature %d has no geometry", i+1)
+		}
+	}
+	return features, nil
+}
+
+// Converts a GeoJSON position, which is longitude, latitude[, altitude]
+func GeoJsonPosition(position []float64) (Point, error) {
+	if len(position) < 2 || len(position) > 3 {
+		return Point{}, fmt.Errorf("Bad GeoJSON position %v", position)
+	}
+	point := Point{Longitude: position[0], Latitude: position[1]}
+	if len(position) == 3 {
+		point.Altitude = position[2]
+	}
+	return point, nil
+}
+
+// Converts a list of GeoJSON positions, like a LineString or a polygon ring
+func GeoJsonPositions(positions [][]float64) ([]Point, error) {
+	points := make([]Point, 0, len(positions))
+	for _, position := range positions {
+		point, err := GeoJsonPosition(position)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, point)
+	}
+	return points, nil
+}
@@ -0,0 +1,90 @@
+package geoformat
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseKmlCoordinates(t *testing.T) {
+	points, err := ParseKmlCoordinates(" -105.29,40.05\n\t-105.28,40.06,1650.5 ")
+	if err != nil {
+		t.Fatalf("Unable to parse: %v", err)
+	}
+	expected := []Point{
+		{Latitude: 40.05, Longitude: -105.29},
+		{Latitude: 40.06, Longitude: -105.28, Altitude: 1650.5},
+	}
+	if len(points) != len(expected) {
+		t.Fatalf("Bad points %v", points)
+	}
+	for i := range expected {
+		if points[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected[i], points[i])
+		}
+	}
+
+	for _, text := range []string{"-105.29", "-105.29,40.05,1,2", "west,40.05", "-105.29,north", "-105.29,40.05,high"} {
+		if _, err := ParseKmlCoordinates(text); err == nil {
+			t.Errorf("Should have failed to parse '%s'", text)
+		}
+	}
+}
+
+func TestWalkKml(t *testing.T) {
+	kml := `<kml><Document><name>Outer</name>
+		<Folder><name> inner </name>
+			<Placemark><name>mark</name><Point><coordinates>-105,40</coordinates></Point></Placemark>
+		</Folder>
+	</Document></kml>`
+	visited := []string{}
+	err := WalkKml(strings.NewReader(kml), func(element *KmlElement, parents []*KmlElement) error {
+		if element.Local != "coordinates" {
+			return nil
+		}
+		for _, parent := range parents {
+			visited = append(visited, parent.Local+":"+parent.Name)
+		}
+		if element.Text() != "-105,40" {
+			t.Errorf("Bad text '%s'", element.Text())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unable to walk: %v", err)
+	}
+	expected := "kml: Document:Outer Folder:inner Placemark:mark Point:"
+	if strings.Join(visited, " ") != expected {
+		t.Errorf("Expected %s, got %v", expected, visited)
+	}
+
+	if WalkKml(strings.NewReader("<kml><Placemark>"), func(*KmlElement, []*KmlElement) error { return nil }) == nil {
+		t.Error("Should have failed on truncated KML")
+	}
+}
+
+func TestReadGeoJsonFeatures(t *testing.T) {
+	features, err := ReadGeoJsonFeatures(strings.NewReader(`{"type": "Feature", "properties": {"name": "zone"}, "geometry": {"type": "Point", "coordinates": [-105, 40]}}`))
+	if err != nil || len(features) != 1 || features[0].Properties["name"] != "zone" || features[0].Geometry.Type != "Point" {
+		t.Errorf("Bad features %v %v", features, err)
+	}
+	features, err = ReadGeoJsonFeatures(strings.NewReader(`{"type": "FeatureCollection", "features": []}`))
+	if err != nil || len(features) != 0 {
+		t.Errorf("Bad features %v %v", features, err)
+	}
+
+	for _, text := range []string{`{"type": "Topology"}`, `{"type": "Feature"}`, `{"type":`} {
+		if _, err := ReadGeoJsonFeatures(strings.NewReader(text)); err == nil {
+			t.Errorf("Should have failed to read %s", text)
+		}
+	}
+}
+
+func TestGeoJsonPositions(t *testing.T) {
+	points, err := GeoJsonPositions([][]float64{{-105, 40}, {-104, 41, 1650}})
+	if err != nil || len(points) != 2 || points[0] != (Point{Latitude: 40, Longitude: -105}) || points[1] != (Point{Latitude: 41, Longitude: -104, Altitude: 1650}) {
+		t.Errorf("Bad points %v %v", points, err)
+	}
+	if _, err := GeoJsonPositions([][]float64{{-105}}); err == nil {
+		t.Error("Should have failed on a short position")
+	}
+}
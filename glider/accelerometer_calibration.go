@@ -0,0 +1,161 @@
+// Six position accelerometer calibration and board mounting alignment
+package glider
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+type accelerometerOrientation_t uint8
+
+// The orientations that the airframe is held in during calibration
+const (
+	ACCELEROMETER_LEVEL accelerometerOrientation_t = iota
+	ACCELEROMETER_INVERTED
+	ACCELEROMETER_NOSE_UP
+	ACCELEROMETER_NOSE_DOWN
+	ACCELEROMETER_LEFT_WING_DOWN
+	ACCELEROMETER_RIGHT_WING_DOWN
+	ACCELEROMETER_ORIENTATION_COUNT
+)
+
+func (orientation accelerometerOrientation_t) String() string {
+	return []string{
+		"level",
+		"upside down",
+		"nose straight up",
+		"nose straight down",
+		"left wing straight down",
+		"right wing straight down",
+	}[orientation]
+}
+
+// Returns all of the calibration orientations, in the order that they should
+// be measured
+func AccelerometerOrientations() []accelerometerOrientation_t {
+	orientations := make([]accelerometerOrientation_t, 0, ACCELEROMETER_ORIENTATION_COUNT)
+	for i := ACCELEROMETER_LEVEL; i < ACCELEROMETER_ORIENTATION_COUNT; i++ {
+		orientations = append(orientations, i)
+	}
+	return orientations
+}
+
+type AccelerometerCalibration struct {
+	// The raw reading at 0 g
+	Bias vector3
+	// Converts raw readings to g
+	Scale vector3
+	// Rotates from the sensor board frame to the airframe
+	Mounting [3][3]float64
+}
+
+// Returns the calibrated reading in g, in the sensor board frame, i.e.
+// (raw - Bias) * Scale
+func (calibration AccelerometerCalibration) Apply(x, y, z int16) vector3 {
+	return vector3{
+		(float64(x) - calibration.Bias[0]) * calibration.Scale[0],
+		(float64(y) - calibration.Bias[1]) * calibration.Scale[1],
+		(float64(z) - calibration.Bias[2]) * calibration.Scale[2],
+	}
+}
+
+// Returns the calibration formatted for WriteConfigurationValues
+func (calibration AccelerometerCalibration) ConfigurationValues() map[string]string {
+	return map[string]string{
+		"AccelerometerBias":  formatTomlVector(calibration.Bias),
+		"AccelerometerScale": formatTomlVector(calibration.Scale),
+		"BoardMounting":      formatTomlMatrix(calibration.Mounting),
+	}
+}
+
+func getAccelerometerCalibration() AccelerometerCalibration {
+	return AccelerometerCalibration{
+		Bias:     configuration.AccelerometerBias,
+		Scale:    configuration.AccelerometerScale,
+		Mounting: configuration.BoardMounting,
+	}
+}
+
+// Rotates a vector from the sensor board frame (+x right, +y forward, +z up)
+// into the airframe, using the same axes
+func boardToAirframe(v vector3) vector3 {
+	return multiplyMatrixVector(configuration.BoardMounting, v)
+}
+
+// Averages raw accelerometer readings in each of the six orientations
+type AccelerometerCalibrator struct {
+	sums   [ACCELEROMETER_ORIENTATION_COUNT]vector3
+	counts [ACCELEROMETER_ORIENTATION_COUNT]int
+}
+
+func NewAccelerometerCalibrator() *AccelerometerCalibrator {
+	return &AccelerometerCalibrator{}
+}
+
+func (calibrator *AccelerometerCalibrator) AddSample(orientation accelerometerOrientation_t, x, y, z int16) {
+	calibrator.sums[orientation][0] += float64(x)
+	calibrator.sums[orientation][1] += float64(y)
+	calibrator.sums[orientation][2] += float64(z)
+	calibrator.counts[orientation]++
+}
+
+func (calibrator *AccelerometerCalibrator) mean(orientation accelerometerOrientation_t) vector3 {
+	count := float64(calibrator.counts[orientation])
+	sum := calibrator.sums[orientation]
+	return vector3{sum[0] / count, sum[1] / count, sum[2] / count}
+}
+
+func (calibrator *AccelerometerCalibrator) Fit() (AccelerometerCalibration, error) {
+	for _, orientation := range AccelerometerOrientations() {
+		if calibrator.counts[orientation] == 0 {
+			return AccelerometerCalibration{}, fmt.Errorf("No readings for %v", orientation)
+		}
+	}
+
+	// Each axis points straight up in one orientation and straight down in
+	// another, so the bias is halfway between them, and they're 2 g apart
+	axisOrientations := [3][2]accelerometerOrientation_t{
+		{ACCELEROMETER_LEFT_WING_DOWN, ACCELEROMETER_RIGHT_WING_DOWN},
+		{ACCELEROMETER_NOSE_UP, ACCELEROMETER_NOSE_DOWN},
+		{ACCELEROMETER_LEVEL, ACCELEROMETER_INVERTED},
+	}
+	calibration := AccelerometerCalibration{}
+	for axis, orientations := range axisOrientations {
+		up := calibrator.mean(orientations[0])[axis]
+		down := calibrator.mean(orientations[1])[axis]
+		if up <= down {
+			return AccelerometerCalibration{}, fmt.Errorf("Readings for %v and %v are backward", orientations[0], orientations[1])
+		}
+		calibration.Bias[axis] = (up + down) * 0.5
+		calibration.Scale[axis] = 2.0 / (up - down)
+	}
+
+	// Now find the board's up and forward directions. Differencing the
+	// opposite orientations cancels out any leftover bias.
+	difference := func(a, b accelerometerOrientation_t) vector3 {
+		first := calibrator.mean(a)
+		second := calibrator.mean(b)
+		var result vector3
+		for i := 0; i < 3; i++ {
+			result[i] = (first[i] - second[i]) * calibration.Scale[i]
+		}
+		return result
+	}
+	up := normalize(difference(ACCELEROMETER_LEVEL, ACCELEROMETER_INVERTED))
+	forward := difference(ACCELEROMETER_NOSE_UP, ACCELEROMETER_NOSE_DOWN)
+	// Level is the most accurate orientation because it can sit on a table,
+	// so make forward perpendicular to it
+	dot := forward[0]*up[0] + forward[1]*up[1] + forward[2]*up[2]
+	for i := 0; i < 3; i++ {
+		forward[i] -= dot * up[i]
+	}
+	if math.Sqrt(forward[0]*forward[0]+forward[1]*forward[1]+forward[2]*forward[2]) < 0.5 {
+		return AccelerometerCalibration{}, errors.New("Nose up and level readings are too similar")
+	}
+	forward = normalize(forward)
+	right := cross(forward, up)
+	calibration.Mounting = [3][3]float64{right, forward, up}
+
+	return calibration, nil
+}
@@ -0,0 +1,83 @@
+package glider
+
+import (
+	"math"
+	"testing"
+)
+
+func setTestAccelerometerConfiguration() {
+	configuration.AccelerometerBias = vector3{0, 0, 0}
+	configuration.AccelerometerScale = vector3{1.0 / 256, 1.0 / 256, 1.0 / 256}
+	configuration.BoardMounting = [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+}
+
+func TestAccelerometerCalibratorFit(t *testing.T) {
+	bias := vector3{10, -20, 30}
+	scale := vector3{1.0 / 250, 1.0 / 260, 1.0 / 240}
+	// The board is pitched up 5 degrees
+	pitch_r := ToRadians(5)
+	c := math.Cos(pitch_r)
+	s := math.Sin(pitch_r)
+	// Where "up" is in the board frame in each orientation
+	ups := map[accelerometerOrientation_t]vector3{
+		ACCELEROMETER_LEVEL:           {0, s, c},
+		ACCELEROMETER_INVERTED:        {0, -s, -c},
+		ACCELEROMETER_NOSE_UP:         {0, c, -s},
+		ACCELEROMETER_NOSE_DOWN:       {0, -c, s},
+		ACCELEROMETER_LEFT_WING_DOWN:  {1, 0, 0},
+		ACCELEROMETER_RIGHT_WING_DOWN: {-1, 0, 0},
+	}
+
+	calibrator := NewAccelerometerCalibrator()
+	for _, orientation := range AccelerometerOrientations() {
+		up := ups[orientation]
+		for i := 0; i < 10; i++ {
+			calibrator.AddSample(
+				orientation,
+				int16(math.Round(up[0]/scale[0]+bias[0])),
+				int16(math.Round(up[1]/scale[1]+bias[1])),
+				int16(math.Round(up[2]/scale[2]+bias[2])),
+			)
+		}
+	}
+
+	calibration, err := calibrator.Fit()
+	if err != nil {
+		t.Errorf("Couldn't fit: %v", err)
+		return
+	}
+	for i := 0; i < 3; i++ {
+		// The misalignment makes the readings a little smaller
+		if math.Abs(calibration.Bias[i]-bias[i]) > 1.0 {
+			t.Errorf("Bad bias %v, expected %v", calibration.Bias, bias)
+		}
+		if math.Abs(calibration.Scale[i]/scale[i]-1) > 0.01 {
+			t.Errorf("Bad scale %v, expected %v", calibration.Scale, scale)
+		}
+	}
+
+	// Level should read level in the airframe
+	configuration.AccelerometerBias = calibration.Bias
+	configuration.AccelerometerScale = calibration.Scale
+	configuration.BoardMounting = calibration.Mounting
+	defer setTestAccelerometerConfiguration()
+	level := ups[ACCELEROMETER_LEVEL]
+	axes := computeAxes(
+		int16(math.Round(level[0]/scale[0]+bias[0])),
+		int16(math.Round(level[1]/scale[1]+bias[1])),
+		int16(math.Round(level[2]/scale[2]+bias[2])),
+		1, 0, 0,
+	)
+	if math.Abs(ToDegrees(axes.Pitch)) > 0.5 || math.Abs(ToDegrees(axes.Roll)) > 0.5 {
+		t.Errorf("Level isn't level: pitch %0.1f roll %0.1f", ToDegrees(axes.Pitch), ToDegrees(axes.Roll))
+	}
+}
+
+func TestAccelerometerCalibratorMissingOrientation(t *testing.T) {
+	calibrator := NewAccelerometerCalibrator()
+	calibrator.AddSample(ACCELEROMETER_LEVEL, 0, 0, 256)
+	_, err := calibrator.Fit()
+	if err == nil {
+		t.Error("Fit should fail without all orientations")
+	}
+}
@@ -0,0 +1,354 @@
+// Attitude and heading reference system. Fuses the accelerometer,
+// magnetometer, and gyroscope into a quaternion.
+package glider
+
+import (
+	"math"
+	"time"
+)
+
+type ahrsFilter_t uint8
+
+const (
+	AHRS_FILTER_RAW ahrsFilter_t = iota
+	AHRS_FILTER_COMPLEMENTARY
+	AHRS_FILTER_MADGWICK
+	AHRS_FILTER_MAHONY
+)
+
+func (filter ahrsFilter_t) String() string {
+	return []string{"raw", "complementary", "madgwick", "mahony"}[filter]
+}
+
+// If we haven't been updated in a while, don't spend forever catching up
+const maxAhrsStepsPerUpdate = 50
+
+// The filters work in a body frame where +x is forward, +y is left, and +z is
+// up, and an earth frame where +x is magnetic north, +y is west, and +z is up.
+// The quaternion rotates vectors from the body frame into the earth frame.
+type Quaternion struct {
+	W, X, Y, Z float64
+}
+
+type vector3 = [3]float64
+
+type Ahrs struct {
+	filter        ahrsFilter_t
+	q             Quaternion
+	integralError vector3
+	initialized   bool
+	// Time that hasn't been integrated yet, because it was less than one
+	// fixed step
+	accumulated time.Duration
+}
+
+func NewAhrs(filter ahrsFilter_t) *Ahrs {
+	return &Ahrs{
+		filter:      filter,
+		q:           Quaternion{W: 1},
+		initialized: false,
+	}
+}
+
+// Feeds new readings to the filter. The readings are in the body frame. The
+// accelerometer and magnetometer readings can be in any units, but the
+// gyroscope readings must be in radians per second. The filter is stepped at
+// a fixed rate, so elapsed time is accumulated until a full step is ready.
+func (ahrs *Ahrs) Update(accelerometer, magnetometer, gyroscope vector3, elapsed time.Duration) {
+	if !ahrs.initialized {
+		ahrs.q = attitudeFromMeasurement(accelerometer, magnetometer)
+		ahrs.initialized = true
+		return
+	}
+
+	period := configuration.AhrsPeriod
+	if period <= 0 {
+		Logger.Errorf("Bad AHRS period %v", period)
+		return
+	}
+	ahrs.accumulated += elapsed
+	steps := 0
+	for ahrs.accumulated >= period {
+		if steps >= maxAhrsStepsPerUpdate {
+			ahrs.accumulated = 0
+			break
+		}
+		ahrs.step(accelerometer, magnetometer, gyroscope, period.Seconds())
+		ahrs.accumulated -= period
+		steps++
+	}
+}
+
+func (ahrs *Ahrs) step(accelerometer, magnetometer, gyroscope vector3, dt float64) {
+	switch ahrs.filter {
+	case AHRS_FILTER_COMPLEMENTARY:
+		ahrs.stepComplementary(accelerometer, magnetometer, gyroscope, dt)
+	case AHRS_FILTER_MADGWICK:
+		ahrs.stepMadgwick(accelerometer, magnetometer, gyroscope, dt)
+	case AHRS_FILTER_MAHONY:
+		ahrs.stepMahony(accelerometer, magnetometer, gyroscope, dt)
+	default:
+		// The raw filter doesn't keep any state
+		ahrs.q = attitudeFromMeasurement(accelerometer, magnetometer)
+	}
+}
+
+func (ahrs *Ahrs) GetQuaternion() Quaternion {
+	return ahrs.q
+}
+
+// Returns the axes using the same conventions as computeAxes: positive pitch
+// is nose up, positive roll is right wing down, and yaw is clockwise from
+// north.
+func (ahrs *Ahrs) GetAxes() Axes {
+	return quaternionToAxes(ahrs.q)
+}
+
+func quaternionToAxes(q Quaternion) Axes {
+	r20 := 2 * (q.X*q.Z - q.W*q.Y)
+	r21 := 2 * (q.Y*q.Z + q.W*q.X)
+	r22 := 1 - 2*(q.X*q.X+q.Y*q.Y)
+	r10 := 2 * (q.X*q.Y + q.W*q.Z)
+	r00 := 1 - 2*(q.Y*q.Y+q.Z*q.Z)
+
+	roll_r := math.Atan2(r21, r22)
+	// The body y axis points left, so a positive rotation around it is
+	// nose down
+	pitch_r := math.Asin(clamp(r20, -1, 1))
+	// The earth z axis points up, so a positive rotation around it is
+	// anticlockwise
+	yaw_r := -math.Atan2(r10, r00)
+	if yaw_r < 0 {
+		yaw_r += ToRadians(360.0)
+	}
+	return Axes{
+		Pitch: pitch_r,
+		Roll:  roll_r,
+		Yaw:   yaw_r,
+	}
+}
+
+// Computes the attitude from just the accelerometer and magnetometer
+func attitudeFromMeasurement(accelerometer, magnetometer vector3) Quaternion {
+	a := normalize(accelerometer)
+	roll_r := math.Atan2(a[1], a[2])
+	pitch_r := math.Atan2(-a[0], math.Sqrt(a[1]*a[1]+a[2]*a[2]))
+
+	// Rotate the magnetometer reading back to level
+	m := magnetometer
+	sinRoll := math.Sin(roll_r)
+	cosRoll := math.Cos(roll_r)
+	sinPitch := math.Sin(pitch_r)
+	cosPitch := math.Cos(pitch_r)
+	yRolled := m[1]*cosRoll - m[2]*sinRoll
+	zRolled := m[1]*sinRoll + m[2]*cosRoll
+	xLevel := m[0]*cosPitch + zRolled*sinPitch
+	yaw_r := math.Atan2(-yRolled, xLevel)
+
+	return quaternionFromEuler(roll_r, pitch_r, yaw_r)
+}
+
+// Builds a quaternion from aerospace sequence (yaw, then pitch, then roll)
+// Euler angles in the body frame
+func quaternionFromEuler(roll_r, pitch_r, yaw_r Radians) Quaternion {
+	cr := math.Cos(roll_r * 0.5)
+	sr := math.Sin(roll_r * 0.5)
+	cp := math.Cos(pitch_r * 0.5)
+	sp := math.Sin(pitch_r * 0.5)
+	cy := math.Cos(yaw_r * 0.5)
+	sy := math.Sin(yaw_r * 0.5)
+	return Quaternion{
+		W: cr*cp*cy + sr*sp*sy,
+		X: sr*cp*cy - cr*sp*sy,
+		Y: cr*sp*cy + sr*cp*sy,
+		Z: cr*cp*sy - sr*sp*cy,
+	}
+}
+
+// Integrates the gyroscope rates into the quaternion
+func integrateGyroscope(q Quaternion, gyroscope vector3, dt float64) Quaternion {
+	gx, gy, gz := gyroscope[0], gyroscope[1], gyroscope[2]
+	return normalizeQuaternion(Quaternion{
+		W: q.W + 0.5*(-q.X*gx-q.Y*gy-q.Z*gz)*dt,
+		X: q.X + 0.5*(q.W*gx+q.Y*gz-q.Z*gy)*dt,
+		Y: q.Y + 0.5*(q.W*gy-q.X*gz+q.Z*gx)*dt,
+		Z: q.Z + 0.5*(q.W*gz+q.X*gy-q.Y*gx)*dt,
+	})
+}
+
+func (ahrs *Ahrs) stepComplementary(accelerometer, magnetometer, gyroscope vector3, dt float64) {
+	predicted := integrateGyroscope(ahrs.q, gyroscope, dt)
+	if isZero(accelerometer) || isZero(magnetometer) {
+		ahrs.q = predicted
+		return
+	}
+	measured := attitudeFromMeasurement(accelerometer, magnetometer)
+	// q and -q are the same rotation, so make sure we blend the short way
+	if predicted.W*measured.W+predicted.X*measured.X+predicted.Y*measured.Y+predicted.Z*measured.Z < 0 {
+		measured = Quaternion{-measured.W, -measured.X, -measured.Y, -measured.Z}
+	}
+	weight := configuration.ComplementaryGyroWeight
+	ahrs.q = normalizeQuaternion(Quaternion{
+		W: weight*predicted.W + (1-weight)*measured.W,
+		X: weight*predicted.X + (1-weight)*measured.X,
+		Y: weight*predicted.Y + (1-weight)*measured.Y,
+		Z: weight*predicted.Z + (1-weight)*measured.Z,
+	})
+}
+
+// Based on Sebastian Madgwick's "An efficient orientation filter for inertial
+// and inertial/magnetic sensor arrays"
+func (ahrs *Ahrs) stepMadgwick(accelerometer, magnetometer, gyroscope vector3, dt float64) {
+	q := ahrs.q
+	if isZero(accelerometer) || isZero(magnetometer) {
+		ahrs.q = integrateGyroscope(q, gyroscope, dt)
+		return
+	}
+	a := normalize(accelerometer)
+	m := normalize(magnetometer)
+	bx, bz := earthMagneticField(q, m)
+
+	q0, q1, q2, q3 := q.W, q.X, q.Y, q.Z
+	// Objective function: the difference between where we expect the
+	// reference directions to be and where we measured them
+	f := [6]float64{
+		2*(q1*q3-q0*q2) - a[0],
+		2*(q0*q1+q2*q3) - a[1],
+		2*(0.5-q1*q1-q2*q2) - a[2],
+		2*bx*(0.5-q2*q2-q3*q3) + 2*bz*(q1*q3-q0*q2) - m[0],
+		2*bx*(q1*q2-q0*q3) + 2*bz*(q0*q1+q2*q3) - m[1],
+		2*bx*(q0*q2+q1*q3) + 2*bz*(0.5-q1*q1-q2*q2) - m[2],
+	}
+	// Jacobian of the objective function
+	j := [6][4]float64{
+		{-2 * q2, 2 * q3, -2 * q0, 2 * q1},
+		{2 * q1, 2 * q0, 2 * q3, 2 * q2},
+		{0, -4 * q1, -4 * q2, 0},
+		{-2 * bz * q2, 2 * bz * q3, -4*bx*q2 - 2*bz*q0, -4*bx*q3 + 2*bz*q1},
+		{-2*bx*q3 + 2*bz*q1, 2*bx*q2 + 2*bz*q0, 2*bx*q1 + 2*bz*q3, -2*bx*q0 + 2*bz*q2},
+		{2 * bx * q2, 2*bx*q3 - 4*bz*q1, 2*bx*q0 - 4*bz*q2, 2 * bx * q1},
+	}
+	var gradient [4]float64
+	for row := 0; row < len(f); row++ {
+		for column := 0; column < 4; column++ {
+			gradient[column] += j[row][column] * f[row]
+		}
+	}
+	norm := math.Sqrt(gradient[0]*gradient[0] + gradient[1]*gradient[1] + gradient[2]*gradient[2] + gradient[3]*gradient[3])
+	if norm > 0 {
+		for i := range gradient {
+			gradient[i] /= norm
+		}
+	}
+
+	gx, gy, gz := gyroscope[0], gyroscope[1], gyroscope[2]
+	beta := configuration.MadgwickBeta
+	qDot0 := 0.5*(-q1*gx-q2*gy-q3*gz) - beta*gradient[0]
+	qDot1 := 0.5*(q0*gx+q2*gz-q3*gy) - beta*gradient[1]
+	qDot2 := 0.5*(q0*gy-q1*gz+q3*gx) - beta*gradient[2]
+	qDot3 := 0.5*(q0*gz+q1*gy-q2*gx) - beta*gradient[3]
+	ahrs.q = normalizeQuaternion(Quaternion{
+		W: q0 + qDot0*dt,
+		X: q1 + qDot1*dt,
+		Y: q2 + qDot2*dt,
+		Z: q3 + qDot3*dt,
+	})
+}
+
+// Based on Robert Mahony's "Nonlinear complementary filters on the special
+// orthogonal group"
+func (ahrs *Ahrs) stepMahony(accelerometer, magnetometer, gyroscope vector3, dt float64) {
+	q := ahrs.q
+	if isZero(accelerometer) || isZero(magnetometer) {
+		ahrs.q = integrateGyroscope(q, gyroscope, dt)
+		return
+	}
+	a := normalize(accelerometer)
+	m := normalize(magnetometer)
+	bx, bz := earthMagneticField(q, m)
+
+	q0, q1, q2, q3 := q.W, q.X, q.Y, q.Z
+	// Estimated direction of gravity and the magnetic field
+	v := vector3{
+		2 * (q1*q3 - q0*q2),
+		2 * (q0*q1 + q2*q3),
+		q0*q0 - q1*q1 - q2*q2 + q3*q3,
+	}
+	w := vector3{
+		2*bx*(0.5-q2*q2-q3*q3) + 2*bz*(q1*q3-q0*q2),
+		2*bx*(q1*q2-q0*q3) + 2*bz*(q0*q1+q2*q3),
+		2*bx*(q0*q2+q1*q3) + 2*bz*(0.5-q1*q1-q2*q2),
+	}
+	// The error is the cross product between the estimated and measured
+	// directions
+	accelerometerError := cross(a, v)
+	magnetometerError := cross(m, w)
+
+	corrected := gyroscope
+	for i := 0; i < 3; i++ {
+		e := accelerometerError[i] + magnetometerError[i]
+		if configuration.MahonyIntegralGain > 0 {
+			ahrs.integralError[i] += configuration.MahonyIntegralGain * e * dt
+			corrected[i] += ahrs.integralError[i]
+		}
+		corrected[i] += configuration.MahonyProportionalGain * e
+	}
+	ahrs.q = integrateGyroscope(q, corrected, dt)
+}
+
+// Rotates the magnetometer reading into the earth frame and returns the
+// horizontal and vertical components of the field
+func earthMagneticField(q Quaternion, m vector3) (float64, float64) {
+	q0, q1, q2, q3 := q.W, q.X, q.Y, q.Z
+	hx := m[0]*(1-2*(q2*q2+q3*q3)) + m[1]*2*(q1*q2-q0*q3) + m[2]*2*(q1*q3+q0*q2)
+	hy := m[0]*2*(q1*q2+q0*q3) + m[1]*(1-2*(q1*q1+q3*q3)) + m[2]*2*(q2*q3-q0*q1)
+	hz := m[0]*2*(q1*q3-q0*q2) + m[1]*2*(q2*q3+q0*q1) + m[2]*(1-2*(q1*q1+q2*q2))
+	return math.Sqrt(hx*hx + hy*hy), hz
+}
+
+func normalizeQuaternion(q Quaternion) Quaternion {
+	norm := math.Sqrt(q.W*q.W + q.X*q.X + q.Y*q.Y + q.Z*q.Z)
+	if norm == 0 {
+		return Quaternion{W: 1}
+	}
+	return Quaternion{q.W / norm, q.X / norm, q.Y / norm, q.Z / norm}
+}
+
+func normalize(v vector3) vector3 {
+	norm := math.Sqrt(v[0]*v[0] + v[1]*v[1] + v[2]*v[2])
+	if norm == 0 {
+		return v
+	}
+	return vector3{v[0] / norm, v[1] / norm, v[2] / norm}
+}
+
+func cross(a, b vector3) vector3 {
+	return vector3{
+		a[1]*b[2] - a[2]*b[1],
+		a[2]*b[0] - a[0]*b[2],
+		a[0]*b[1] - a[1]*b[0],
+	}
+}
+
+func isZero(v vector3) bool {
+	return v[0] == 0 && v[1] == 0 && v[2] == 0
+}
+
+// The accelerometer and gyroscope have +x right, +y forward, and +z up
+func accelerometerToBody(x, y, z int16) vector3 {
+	airframe := boardToAirframe(getAccelerometerCalibration().Apply(x, y, z))
+	return vector3{airframe[1], -airframe[0], airframe[2]}
+}
+
+func gyroscopeToBody(x, y, z RadiansPerSecond) vector3 {
+	airframe := boardToAirframe(vector3{x, y, z})
+	return vector3{airframe[1], -airframe[0], airframe[2]}
+}
+
+// The magnetometer is mounted 180 degrees off, so +x is backward and +y is
+// right
+func magnetometerToBody(x, y, z int16) vector3 {
+	calibrated := getMagnetometerCalibration().Apply(x, y, z)
+	airframe := boardToAirframe(vector3{calibrated[1], -calibrated[0], calibrated[2]})
+	return vector3{airframe[1], -airframe[0], airframe[2]}
+}
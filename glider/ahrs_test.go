@@ -0,0 +1,143 @@
+package glider
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// Returns what the accelerometer and magnetometer would read in the body
+// frame at some attitude
+func getFakeReadings(roll_r, pitch_r, yaw_r Radians) (vector3, vector3) {
+	// Our pitch and yaw are the opposite of the aerospace convention in
+	// the body frame
+	q := quaternionFromEuler(roll_r, -pitch_r, -yaw_r)
+	q0, q1, q2, q3 := q.W, q.X, q.Y, q.Z
+	// The transpose of the rotation matrix rotates from earth to body
+	r := [3][3]float64{
+		{1 - 2*(q2*q2+q3*q3), 2 * (q1*q2 - q0*q3), 2 * (q1*q3 + q0*q2)},
+		{2 * (q1*q2 + q0*q3), 1 - 2*(q1*q1+q3*q3), 2 * (q2*q3 - q0*q1)},
+		{2 * (q1*q3 - q0*q2), 2 * (q2*q3 + q0*q1), 1 - 2*(q1*q1+q2*q2)},
+	}
+	gravity := vector3{0, 0, 1}
+	// Boulder's field points north and down about 65 degrees
+	field := vector3{math.Cos(ToRadians(65)), 0, -math.Sin(ToRadians(65))}
+	var accelerometer, magnetometer vector3
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			accelerometer[i] += r[j][i] * gravity[j]
+			magnetometer[i] += r[j][i] * field[j]
+		}
+	}
+	return accelerometer, magnetometer
+}
+
+func checkAxes(t *testing.T, name string, axes Axes, roll_d, pitch_d, yaw_d Degrees) {
+	const tolerance_d = 1.0
+	if math.Abs(ToDegrees(axes.Roll)-roll_d) > tolerance_d {
+		t.Errorf("%s: bad roll %0.1f, expected %0.1f", name, ToDegrees(axes.Roll), roll_d)
+	}
+	if math.Abs(ToDegrees(axes.Pitch)-pitch_d) > tolerance_d {
+		t.Errorf("%s: bad pitch %0.1f, expected %0.1f", name, ToDegrees(axes.Pitch), pitch_d)
+	}
+	if math.Abs(ToDegrees(GetAngleTo(axes.Yaw, ToRadians(yaw_d)))) > tolerance_d {
+		t.Errorf("%s: bad yaw %0.1f, expected %0.1f", name, ToDegrees(axes.Yaw), yaw_d)
+	}
+}
+
+func TestAttitudeFromMeasurement(t *testing.T) {
+	attitudes := [][3]Degrees{
+		{0, 0, 0},
+		{20, 0, 0},
+		{-20, 0, 0},
+		{0, 15, 0},
+		{0, -15, 0},
+		{0, 0, 90},
+		{0, 0, 270},
+		{10, -5, 45},
+		{-30, 10, 200},
+	}
+	for _, attitude := range attitudes {
+		accelerometer, magnetometer := getFakeReadings(ToRadians(attitude[0]), ToRadians(attitude[1]), ToRadians(attitude[2]))
+		axes := quaternionToAxes(attitudeFromMeasurement(accelerometer, magnetometer))
+		checkAxes(t, "measurement", axes, attitude[0], attitude[1], attitude[2])
+	}
+}
+
+func TestAttitudeMatchesComputeAxes(t *testing.T) {
+	setTestAccelerometerConfiguration()
+	configuration.MagnetometerHardIron = vector3{72, -148, -105}
+	configuration.MagnetometerSoftIron = [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+	// Level, with the raw sensor readings in the sensor frames
+	type reading struct {
+		xA, yA, zA, xM, yM, zM int16
+	}
+	readings := []reading{
+		{0, 0, 256, 72 - 300, -148, -105 - 200},
+		{0, 0, 256, 72 + 300, -148, -105 - 200},
+		{0, 0, 256, 72, -148 + 300, -105 - 200},
+		{0, 0, 256, 72 + 200, -148 - 200, -105 - 200},
+		{-40, 0, 250, 72 - 300, -148, -105 - 200},
+		{0, 60, 240, 72 - 300, -148, -105 - 200},
+	}
+	for _, r := range readings {
+		expected := computeAxes(r.xA, r.yA, r.zA, r.xM, r.yM, r.zM)
+		actual := quaternionToAxes(attitudeFromMeasurement(accelerometerToBody(r.xA, r.yA, r.zA), magnetometerToBody(r.xM, r.yM, r.zM)))
+		// computeAxes only tilt compensates approximately, so just check
+		// pitch and roll here, and yaw when level
+		if math.Abs(ToDegrees(expected.Roll-actual.Roll)) > 0.1 {
+			t.Errorf("Bad roll %0.1f, expected %0.1f", ToDegrees(actual.Roll), ToDegrees(expected.Roll))
+		}
+		if math.Abs(ToDegrees(expected.Pitch-actual.Pitch)) > 0.1 {
+			t.Errorf("Bad pitch %0.1f, expected %0.1f", ToDegrees(actual.Pitch), ToDegrees(expected.Pitch))
+		}
+		if r.xA == 0 && r.yA == 0 && math.Abs(ToDegrees(GetAngleTo(expected.Yaw, actual.Yaw))) > 0.1 {
+			t.Errorf("Bad yaw %0.1f, expected %0.1f", ToDegrees(actual.Yaw), ToDegrees(expected.Yaw))
+		}
+	}
+}
+
+func TestAhrsFiltersConverge(t *testing.T) {
+	configuration.AhrsPeriod = 10 * time.Millisecond
+	configuration.MadgwickBeta = 0.5
+	configuration.MahonyProportionalGain = 2.0
+	configuration.MahonyIntegralGain = 0.0
+	configuration.ComplementaryGyroWeight = 0.98
+
+	filters := []ahrsFilter_t{
+		AHRS_FILTER_COMPLEMENTARY,
+		AHRS_FILTER_MADGWICK,
+		AHRS_FILTER_MAHONY,
+	}
+	for _, filter := range filters {
+		ahrs := NewAhrs(filter)
+		// Start out level and pointing north
+		accelerometer, magnetometer := getFakeReadings(0, 0, 0)
+		ahrs.Update(accelerometer, magnetometer, vector3{}, 0)
+		checkAxes(t, filter.String(), ahrs.GetAxes(), 0, 0, 0)
+
+		// Then pretend we instantly moved, and let the filter catch up
+		accelerometer, magnetometer = getFakeReadings(ToRadians(20), ToRadians(-10), ToRadians(30))
+		for i := 0; i < 300; i++ {
+			ahrs.Update(accelerometer, magnetometer, vector3{}, 100*time.Millisecond)
+		}
+		checkAxes(t, filter.String(), ahrs.GetAxes(), 20, -10, 30)
+	}
+}
+
+func TestAhrsFixedStep(t *testing.T) {
+	configuration.AhrsPeriod = 10 * time.Millisecond
+	ahrs := NewAhrs(AHRS_FILTER_COMPLEMENTARY)
+	accelerometer, magnetometer := getFakeReadings(0, 0, 0)
+	ahrs.Update(accelerometer, magnetometer, vector3{}, 0)
+
+	ahrs.Update(accelerometer, magnetometer, vector3{}, 25*time.Millisecond)
+	if ahrs.accumulated != 5*time.Millisecond {
+		t.Errorf("Bad accumulated time %v", ahrs.accumulated)
+	}
+	// If we fall way behind, we should drop the time instead of spinning
+	ahrs.Update(accelerometer, magnetometer, vector3{}, time.Hour)
+	if ahrs.accumulated != 0 {
+		t.Errorf("Bad accumulated time %v", ahrs.accumulated)
+	}
+}
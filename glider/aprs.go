@@ -0,0 +1,129 @@
+// Sends APRS position beacons through a KISS TNC, so that chase crews and
+// aprs.fi can track the glider
+package glider
+
+import (
+	"github.com/argandas/serial"
+	"github.com/bskari/go-glider/aprs"
+	"io"
+	"math"
+	"time"
+)
+
+// How often to check whether it's time to beacon
+const aprsCheckPeriod = time.Second
+
+// Below this vertical speed we're coming down, even if we're not flying yet,
+// e.g. after a balloon release
+const aprsDescentSpeed MetersPerSecond = -1.0
+
+type AprsBeacon struct {
+	port  io.Writer
+	pilot *Pilot
+	// When we last beaconed, and our course then, for corner pegging
+	beaconTime   time.Time
+	beaconCourse Radians
+}
+
+// Opens the TNC. Returns nil if AprsTty is empty.
+func NewAprsBeacon() (*AprsBeacon, error) {
+	if configuration.AprsTty == "" {
+		return nil, nil
+	}
+	port := serial.New()
+	port.Verbose = false
+	err := port.Open(configuration.AprsTty, configuration.AprsBitRate)
+	if err != nil {
+		return nil, err
+	}
+	Logger.Infof("Opened APRS TNC on %s as %v", configuration.AprsTty, configuration.AprsCallsign)
+	return newAprsBeacon(port), nil
+}
+
+func newAprsBeacon(port io.Writer) *AprsBeacon {
+	return &AprsBeacon{port: port}
+}
+
+func (beacon *AprsBeacon) Close() error {
+	closer, ok := beacon.port.(io.Closer)
+	if !ok {
+		return nil
+	}
+	return closer.Close()
+}
+
+// Beacons if it's been long enough, or if we've turned enough while
+// descending. This runs as a scheduler task.
+func (beacon *AprsBeacon) update() {
+	estimate := beacon.pilot.telemetry.GetPositionEstimate()
+	if !estimate.Valid {
+		return
+	}
+	now := pilotClock.Now()
+	speed, course_r := estimate.GetCourse()
+	state := beacon.pilot.state
+	descending := state == flying || state == landing || estimate.VerticalSpeed < aprsDescentSpeed
+	if !beacon.beaconTime.IsZero() && !beacon.isBeaconDue(now, speed, course_r, descending) {
+		return
+	}
+
+	information, err := aprs.FormatCompressedPosition(
+		configuration.AprsSymbol,
+		aprs.Position{
+			Latitude:  estimate.Latitude,
+			Longitude: estimate.Longitude,
+			Altitude:  estimate.Altitude,
+			Course:    ToDegrees(course_r),
+			Speed:     speed,
+		},
+		beacon.pilot.state.String(),
+	)
+	if err != nil {
+		Logger.Errorf("Unable to format APRS position: %v", err)
+		return
+	}
+	frame := aprs.Frame{
+		Destination: aprs.Address{Callsign: aprs.Destination},
+		Source:      configuration.AprsCallsign,
+		Path:        configuration.AprsPath,
+		Information: []byte(information),
+	}
+	// Even if the write fails, wait for the next interval instead of
+	// retrying every check
+	beacon.beaconTime = now
+	beacon.beaconCourse = course_r
+	_, err = beacon.port.Write(aprs.EncodeKiss(frame.Encode()))
+	if err != nil {
+		Logger.Errorf("Unable to send APRS beacon: %v", err)
+		return
+	}
+	Logger.Infof("APRS beacon %v", frame)
+}
+
+func (beacon *AprsBeacon) isBeaconDue(now time.Time, speed MetersPerSecond, course_r Radians, descending bool) bool {
+	elapsed := now.Sub(beacon.beaconTime)
+	if !descending {
+		return elapsed >= configuration.AprsBeaconInterval
+	}
+	if elapsed >= getSmartBeaconInterval(speed) {
+		return true
+	}
+	// Corner pegging, so that the track follows our turns. The course is
+	// noise when we're barely moving.
+	if speed < configuration.AprsSlowSpeed || elapsed < configuration.AprsMinTurnTime {
+		return false
+	}
+	threshold := configuration.AprsMinTurnAngle + configuration.AprsTurnSlope/speed
+	return math.Abs(GetAngleTo(beacon.beaconCourse, course_r)) >= threshold
+}
+
+// SmartBeaconing: beacon more often the faster we go
+func getSmartBeaconInterval(speed MetersPerSecond) time.Duration {
+	if speed <= configuration.AprsSlowSpeed {
+		return configuration.AprsSlowRate
+	}
+	if speed >= configuration.AprsFastSpeed {
+		return configuration.AprsFastRate
+	}
+	return time.Duration(float64(configuration.AprsFastRate) * configuration.AprsFastSpeed / speed)
+}
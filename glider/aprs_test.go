@@ -0,0 +1,139 @@
+package glider
+
+import (
+	"bytes"
+	"github.com/bskari/go-glider/aprs"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setTestAprsConfiguration() {
+	configuration.AprsCallsign = aprs.Address{Callsign: "N0CALL", Ssid: 11}
+	configuration.AprsPath = []aprs.Address{{Callsign: "WIDE2", Ssid: 1}}
+	configuration.AprsSymbol = "/O"
+	configuration.AprsBeaconInterval = time.Minute
+	configuration.AprsFastRate = 15 * time.Second
+	configuration.AprsFastSpeed = 15
+	configuration.AprsSlowRate = time.Minute
+	configuration.AprsSlowSpeed = 2
+	configuration.AprsMinTurnAngle = ToRadians(25)
+	configuration.AprsTurnSlope = ToRadians(100)
+	configuration.AprsMinTurnTime = 10 * time.Second
+}
+
+func TestSmartBeaconInterval(t *testing.T) {
+	setTestAprsConfiguration()
+	tests := []struct {
+		speed    MetersPerSecond
+		interval time.Duration
+	}{
+		{0, time.Minute},
+		{2, time.Minute},
+		{7.5, 30 * time.Second},
+		{15, 15 * time.Second},
+		{50, 15 * time.Second},
+	}
+	for _, test := range tests {
+		interval := getSmartBeaconInterval(test.speed)
+		if interval != test.interval {
+			t.Errorf("Expected %v at %v m/s, got %v", test.interval, test.speed, interval)
+		}
+	}
+
+	beacon := newAprsBeacon(&bytes.Buffer{})
+	start := time.Now()
+	beacon.beaconTime = start
+	beacon.beaconCourse = ToRadians(350)
+	// At 10 m/s, we need to turn 35 degrees
+	if beacon.isBeaconDue(start.Add(11*time.Second), 10, ToRadians(20), true) {
+		t.Error("Shouldn't beacon for a small turn")
+	}
+	if !beacon.isBeaconDue(start.Add(11*time.Second), 10, ToRadians(30), true) {
+		t.Error("Should beacon for a large turn")
+	}
+	if beacon.isBeaconDue(start.Add(5*time.Second), 10, ToRadians(30), true) {
+		t.Error("Shouldn't beacon for a turn so soon")
+	}
+	if beacon.isBeaconDue(start.Add(11*time.Second), 1, ToRadians(180), true) {
+		t.Error("Shouldn't beacon for turns while barely moving")
+	}
+	if beacon.isBeaconDue(start.Add(30*time.Second), 10, ToRadians(180), false) {
+		t.Error("Should only beacon on an interval when not descending")
+	}
+	if !beacon.isBeaconDue(start.Add(time.Minute), 10, 0, false) {
+		t.Error("Should beacon on an interval when not descending")
+	}
+}
+
+func TestAprsBeacon(t *testing.T) {
+	loadTestConfiguration(t)
+	defer loadTestConfiguration(t)
+	setTestSimulatorConfiguration()
+	setTestGuidanceConfiguration()
+	setTestAprsConfiguration()
+	configuration.MissionFile = ""
+	configuration.DistanceFormula = DISTANCE_FORMULA_HAVERSINE
+	configuration.ControlPeriod = 20 * time.Millisecond
+	configuration.GpsPeriod = 100 * time.Millisecond
+	configuration.SimulatorTimeLimit = time.Minute
+
+	var buffer bytes.Buffer
+	simulator := NewSimulator()
+	simulator.SetAprsBeacon(newAprsBeacon(&buffer))
+	_, err := simulator.Run()
+	if err != nil {
+		t.Fatalf("Simulation failed: %v", err)
+	}
+
+	launch := Point{
+		Latitude:  configuration.SimulatorLaunchLatitude,
+		Longitude: configuration.SimulatorLaunchLongitude,
+	}
+	reader := aprs.NewKissReader(&buffer)
+	count := 0
+	comment := ""
+	for {
+		data, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Unable to read KISS frame: %v", err)
+		}
+		frame, err := aprs.DecodeFrame(data)
+		if err != nil {
+			t.Fatalf("Unable to decode frame: %v", err)
+		}
+		if !strings.HasPrefix(frame.String(), "N0CALL-11>APZGLD,WIDE2-1:!") {
+			t.Errorf("Bad frame %v", frame)
+		}
+		var position aprs.Position
+		var symbol string
+		position, symbol, comment, err = aprs.ParseCompressedPosition(string(frame.Information))
+		if err != nil {
+			t.Fatalf("Unable to parse position: %v", err)
+		}
+		if symbol != "/O" {
+			t.Errorf("Bad symbol %v", symbol)
+		}
+		point := Point{Latitude: position.Latitude, Longitude: position.Longitude}
+		if Distance(launch, point) > 1000 {
+			t.Errorf("Position %v is too far from launch", position)
+		}
+		if position.Altitude < configuration.SimulatorGroundAltitude || position.Altitude > configuration.SimulatorLaunchAltitude+10 {
+			t.Errorf("Bad altitude %v", position.Altitude)
+		}
+		count++
+	}
+	// The first beacon can go out as soon as we have a fix
+	if comment != flying.String() {
+		t.Errorf("Bad state in the last comment %v", comment)
+	}
+	// Flying at about 10 m/s, it should beacon every 20 seconds or so, plus
+	// the first beacon
+	if count < 3 || count > 8 {
+		t.Errorf("Bad beacon count %v", count)
+	}
+}
@@ -0,0 +1,356 @@
+// Checks the configuration file against the schema in the tags on
+// tomlConfiguration_t, so that typos, missing values, and values that would
+// make us fly badly are all caught before we touch any hardware
+package glider
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"io"
+	"math"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// The merged configuration that we last loaded, with the defaults filled in,
+// and the names of the layers that it came from
+var effectiveConfiguration string
+var configurationSources []string
+
+// Units for the key suffixes, for keys that don't have a units tag
+var configurationSuffixUnits = map[string]string{
+	"m":    "m",
+	"mps":  "m/s",
+	"mpss": "m/s²",
+	"s":    "s",
+	"hz":   "Hz",
+	"d":    "°",
+	"us":   "µs",
+	"g":    "g",
+	"kg":   "kg",
+	"sqm":  "m²",
+}
+
+// One problem with one configuration value
+type ConfigurationError struct {
+	// The layer that set the value, if any
+	Source  string
+	Key     string
+	Message string
+}
+
+func newConfigurationError(key string, format string, args ...interface{}) *ConfigurationError {
+	return &ConfigurationError{Key: key, Message: fmt.Sprintf(format, args...)}
+}
+
+func (err *ConfigurationError) Error() string {
+	if err.Source != "" {
+		return fmt.Sprintf("%s: %s %s", err.Source, err.Key, err.Message)
+	}
+	return fmt.Sprintf("%s %s", err.Key, err.Message)
+}
+
+// Every problem that we found in a configuration file, so that they can all
+// be fixed at once
+type ConfigurationErrors []*ConfigurationError
+
+func (errs ConfigurationErrors) Error() string {
+	messages := make([]string, 0, len(errs))
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	return fmt.Sprintf("%d configuration errors: %s", len(errs), strings.Join(messages, "; "))
+}
+
+// Adds err if it's not nil
+func (errs *ConfigurationErrors) add(err error) {
+	if err == nil {
+		return
+	}
+	if configurationErr, ok := err.(*ConfigurationError); ok {
+		*errs = append(*errs, configurationErr)
+	} else {
+		*errs = append(*errs, &ConfigurationError{Message: err.Error()})
+	}
+}
+
+// The allowed values for a number, from a range tag like "(0,1000]" or
+// "[0,)". An empty end is unbounded.
+type configurationRange struct {
+	text         string
+	min          float64
+	max          float64
+	minExclusive bool
+	maxExclusive bool
+}
+
+func parseConfigurationRange(text string) (configurationRange, error) {
+	parsed := configurationRange{text: text, min: math.Inf(-1), max: math.Inf(1)}
+	if len(text) < 3 || !strings.Contains("[(", text[:1]) || !strings.Contains("])", text[len(text)-1:]) {
+		return parsed, fmt.Errorf("Bad range '%s'", text)
+	}
+	parsed.minExclusive = text[0] == '('
+	parsed.maxExclusive = text[len(text)-1] == ')'
+	ends := strings.Split(text[1:len(text)-1], ",")
+	if len(ends) != 2 {
+		return parsed, fmt.Errorf("Bad range '%s'", text)
+	}
+	var err error
+	if ends[0] != "" {
+		parsed.min, err = strconv.ParseFloat(ends[0], 64)
+		if err != nil {
+			return parsed, fmt.Errorf("Bad range '%s'", text)
+		}
+	}
+	if ends[1] != "" {
+		parsed.max, err = strconv.ParseFloat(ends[1], 64)
+		if err != nil {
+			return parsed, fmt.Errorf("Bad range '%s'", text)
+		}
+	}
+	return parsed, nil
+}
+
+func (allowed configurationRange) contains(value float64) bool {
+	if math.IsNaN(value) {
+		return false
+	}
+	if value < allowed.min || (allowed.minExclusive && value == allowed.min) {
+		return false
+	}
+	if value > allowed.max || (allowed.maxExclusive && value == allowed.max) {
+		return false
+	}
+	return true
+}
+
+// The units of a configuration key, from its units tag or its suffix
+func configurationUnits(field reflect.StructField) string {
+	if units, ok := field.Tag.Lookup("units"); ok {
+		return units
+	}
+	separator := strings.LastIndex(field.Name, "_")
+	if separator == -1 {
+		return ""
+	}
+	return configurationSuffixUnits[field.Name[separator+1:]]
+}
+
+// A TOML document with the default value of every key that has one
+func configurationDefaults() string {
+	var builder strings.Builder
+	tomlType := reflect.TypeOf(tomlConfiguration_t{})
+	for i := 0; i < tomlType.NumField(); i++ {
+		field := tomlType.Field(i)
+		value, ok := field.Tag.Lookup("default")
+		if !ok {
+			continue
+		}
+		if field.Type.Kind() == reflect.String {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(&builder, "%s = %s\n", field.Name, value)
+	}
+	return builder.String()
+}
+
+// Checks for unknown keys, missing required keys, and numbers that are out of
+// range
+func checkConfigurationSchema(tomlConfiguration *tomlConfiguration_t, metadata toml.MetaData) ConfigurationErrors {
+	var errs ConfigurationErrors
+	for _, key := range metadata.Undecoded() {
+		errs.add(newConfigurationError(key.String(), "is not a configuration key"))
+	}
+
+	value := reflect.ValueOf(tomlConfiguration).Elem()
+	tomlType := value.Type()
+	for i := 0; i < tomlType.NumField(); i++ {
+		field := tomlType.Field(i)
+		if field.Tag.Get("required") == "true" && !metadata.IsDefined(field.Name) {
+			errs.add(newConfigurationError(field.Name, "is missing"))
+			continue
+		}
+
+		rangeText, ok := field.Tag.Lookup("range")
+		if !ok {
+			continue
+		}
+		allowed, err := parseConfigurationRange(rangeText)
+		if err != nil {
+			errs.add(newConfigurationError(field.Name, "has a bad schema: %v", err))
+			continue
+		}
+		var number float64
+		switch field.Type.Kind() {
+		case reflect.Float64:
+			number = value.Field(i).Float()
+		case reflect.Int64:
+			number = float64(value.Field(i).Int())
+		default:
+			errs.add(newConfigurationError(field.Name, "has a range but isn't a number"))
+			continue
+		}
+		if !allowed.contains(number) {
+			units := configurationUnits(field)
+			if units != "" {
+				units = " " + units
+			}
+			errs.add(newConfigurationError(field.Name, "is %v%s, but must be in %s", number, units, allowed.text))
+		}
+	}
+	return errs
+}
+
+// One layer of configuration, like the base file, an airframe profile, a site
+// overlay, or overrides from the command line
+type ConfigurationLayer struct {
+	// Where the values came from, for errors and the log header
+	Name   string
+	Values map[string]interface{}
+}
+
+func ReadConfigurationLayer(name string, reader io.Reader) (ConfigurationLayer, error) {
+	values := make(map[string]interface{})
+	_, err := toml.DecodeReader(reader, &values)
+	if err != nil {
+		return ConfigurationLayer{}, fmt.Errorf("Unable to parse %s: %v", name, err)
+	}
+	return ConfigurationLayer{Name: name, Values: values}, nil
+}
+
+func OpenConfigurationLayer(path string) (ConfigurationLayer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return ConfigurationLayer{}, err
+	}
+	defer file.Close()
+	return ReadConfigurationLayer(path, file)
+}
+
+// Parses overrides like "ProportionalRollMultiplier=3.5". Values are TOML,
+// except that strings don't need quotes and integers are fine for floats.
+func ParseConfigurationOverrides(name string, overrides []string) (ConfigurationLayer, error) {
+	layer := ConfigurationLayer{Name: name, Values: make(map[string]interface{})}
+	tomlType := reflect.TypeOf(tomlConfiguration_t{})
+	for _, override := range overrides {
+		separator := strings.Index(override, "=")
+		if separator == -1 {
+			return layer, fmt.Errorf("Override '%s' should look like Key=value", override)
+		}
+		key := strings.TrimSpace(override[:separator])
+		text := strings.TrimSpace(override[separator+1:])
+
+		parsed := make(map[string]interface{})
+		_, err := toml.Decode("value = "+text, &parsed)
+		value, ok := parsed["value"]
+		field, known := tomlType.FieldByName(key)
+		if known && field.Type.Kind() == reflect.String {
+			if _, isString := value.(string); !isString {
+				value = text
+			}
+		} else if err != nil {
+			return layer, fmt.Errorf("Bad value in override '%s': %v", override, err)
+		} else if integer, isInteger := value.(int64); ok && isInteger && known && field.Type.Kind() == reflect.Float64 {
+			value = float64(integer)
+		}
+		layer.Values[key] = value
+	}
+	return layer, nil
+}
+
+// Merges the layers in order, with later layers winning, and loads the result
+// like LoadConfiguration
+func LoadConfigurationLayers(layers ...ConfigurationLayer) error {
+	defaults, err := ReadConfigurationLayer("default", strings.NewReader(configurationDefaults()))
+	if err != nil {
+		return err
+	}
+	merged := make(map[string]interface{})
+	sources := make(map[string]string)
+	names := make([]string, 0, len(layers))
+	for _, layer := range append([]ConfigurationLayer{defaults}, layers...) {
+		for key, value := range layer.Values {
+			merged[key] = value
+			sources[key] = layer.Name
+		}
+		if layer.Name != defaults.Name {
+			names = append(names, layer.Name)
+		}
+	}
+	base := ""
+	if len(layers) > 0 {
+		base = layers[0].Name
+	}
+
+	text, err := formatConfiguration(merged, sources, base)
+	if err != nil {
+		return err
+	}
+	err = loadConfiguration(text)
+	if errs, ok := err.(ConfigurationErrors); ok {
+		for _, configurationErr := range errs {
+			// Unknown tables are reported as table.key
+			key := strings.SplitN(configurationErr.Key, ".", 2)[0]
+			configurationErr.Source = sources[key]
+		}
+	}
+	if err != nil {
+		return err
+	}
+	effectiveConfiguration = text
+	configurationSources = names
+	return nil
+}
+
+// Formats the values in the same order as conf.toml, noting where each one
+// came from if it's not the base layer. Unknown keys go at the end so that
+// they get reported.
+func formatConfiguration(values map[string]interface{}, sources map[string]string, base string) (string, error) {
+	var buffer bytes.Buffer
+	encoder := toml.NewEncoder(&buffer)
+	tomlType := reflect.TypeOf(tomlConfiguration_t{})
+	for i := 0; i < tomlType.NumField(); i++ {
+		key := tomlType.Field(i).Name
+		value, ok := values[key]
+		if !ok {
+			continue
+		}
+		var line bytes.Buffer
+		err := toml.NewEncoder(&line).Encode(map[string]interface{}{key: value})
+		if err != nil {
+			return "", fmt.Errorf("Unable to format %s: %v", key, err)
+		}
+		buffer.WriteString(strings.TrimSuffix(line.String(), "\n"))
+		if sources[key] != base {
+			fmt.Fprintf(&buffer, "  # %s", sources[key])
+		}
+		buffer.WriteString("\n")
+	}
+
+	unknown := make(map[string]interface{})
+	for key, value := range values {
+		if _, ok := tomlType.FieldByName(key); !ok {
+			unknown[key] = value
+		}
+	}
+	err := encoder.Encode(unknown)
+	if err != nil {
+		return "", fmt.Errorf("Unable to format unknown keys: %v", err)
+	}
+	return buffer.String(), nil
+}
+
+// Writes the effective configuration as TOML comments and values, so that
+// the flight can be reproduced from the log
+func WriteConfigurationHeader(writer io.Writer) error {
+	_, err := fmt.Fprintf(
+		writer,
+		"# Effective configuration from %s\n%s# End of configuration\n",
+		strings.Join(configurationSources, ", "),
+		effectiveConfiguration,
+	)
+	return err
+}
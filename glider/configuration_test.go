@@ -0,0 +1,255 @@
+package glider
+
+import (
+	"github.com/BurntSushi/toml"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigurationSchema(t *testing.T) {
+	var tomlConfiguration tomlConfiguration_t
+	_, err := toml.Decode(configurationDefaults(), &tomlConfiguration)
+	if err != nil {
+		t.Fatalf("Bad defaults: %v", err)
+	}
+
+	tomlType := reflect.TypeOf(tomlConfiguration)
+	for i := 0; i < tomlType.NumField(); i++ {
+		field := tomlType.Field(i)
+		_, hasDefault := field.Tag.Lookup("default")
+		required := field.Tag.Get("required") == "true"
+		if hasDefault == required {
+			t.Errorf("%s needs either a default or to be required", field.Name)
+		}
+		kind := field.Type.Kind()
+		rangeText, hasRange := field.Tag.Lookup("range")
+		if (kind == reflect.Float64 || kind == reflect.Int64) != hasRange {
+			t.Errorf("%s should have a range if and only if it's a number", field.Name)
+		}
+		if hasRange {
+			if _, err := parseConfigurationRange(rangeText); err != nil {
+				t.Errorf("%s: %v", field.Name, err)
+			}
+		}
+	}
+}
+
+func TestParseConfigurationRange(t *testing.T) {
+	tests := []struct {
+		text    string
+		inside  []float64
+		outside []float64
+	}{
+		{"[0,1]", []float64{0, 0.5, 1}, []float64{-0.1, 1.1}},
+		{"(0,1000]", []float64{0.001, 1000}, []float64{0, -1, 1000.1}},
+		{"[0,)", []float64{0, 1e9}, []float64{-1}},
+		{"(,0)", []float64{-1e9}, []float64{0}},
+	}
+	for _, test := range tests {
+		allowed, err := parseConfigurationRange(test.text)
+		if err != nil {
+			t.Errorf("Unable to parse %s: %v", test.text, err)
+			continue
+		}
+		for _, value := range test.inside {
+			if !allowed.contains(value) {
+				t.Errorf("%v should be in %s", value, test.text)
+			}
+		}
+		for _, value := range test.outside {
+			if allowed.contains(value) {
+				t.Errorf("%v shouldn't be in %s", value, test.text)
+			}
+		}
+	}
+
+	for _, bad := range []string{"", "0,1", "[0,1", "[0;1]", "[a,1]", "[0,1,2]"} {
+		if _, err := parseConfigurationRange(bad); err == nil {
+			t.Errorf("Should have rejected '%s'", bad)
+		}
+	}
+}
+
+func TestLoadConfigurationDefaults(t *testing.T) {
+	loadTestConfiguration(t)
+	// Only the required keys
+	err := LoadConfiguration(strings.NewReader(`
+DefaultWaypointLatitude = 40.015
+DefaultWaypointLongitude = -105.270
+LandingPointAltitude_m = 1556.0
+`))
+	if err != nil {
+		t.Fatalf("Unable to load configuration: %v", err)
+	}
+	if configuration.ProportionalRollMultiplier != 3.0 {
+		t.Errorf("Bad ProportionalRollMultiplier default %v", configuration.ProportionalRollMultiplier)
+	}
+	if configuration.ControlPeriod != 100*time.Millisecond {
+		t.Errorf("Bad ControlPeriod default %v", configuration.ControlPeriod)
+	}
+	if configuration.BoardMounting[1][1] != 1.0 || configuration.AprsCallsign.Callsign != "N0CALL" {
+		t.Errorf("Bad defaults %v %v", configuration.BoardMounting, configuration.AprsCallsign)
+	}
+	loadTestConfiguration(t)
+}
+
+func TestLoadConfigurationErrors(t *testing.T) {
+	loadTestConfiguration(t)
+	data, err := ioutil.ReadFile("../conf.toml")
+	if err != nil {
+		t.Fatalf("Unable to read configuration: %v", err)
+	}
+	text := string(data)
+	replacements := []struct {
+		old string
+		new string
+	}{
+		{"ProportionalRollMultiplier =", "ProportinalRollMultiplier ="},
+		{"ErrorSleepDuration_s = 0.01", "ErrorSleepDuration_s = 0.0"},
+		{`DistanceFormula = "cachedEquirectangular"`, `DistanceFormula = "manhattan"`},
+		{"LandingPointAltitude_m = 1556.0", ""},
+		{"AccelerometerBias = [0.0, 0.0, 0.0]", "AccelerometerBias = [0.0, 0.0]"},
+	}
+	for _, replacement := range replacements {
+		if !strings.Contains(text, replacement.old) {
+			t.Fatalf("conf.toml doesn't have '%s'", replacement.old)
+		}
+		text = strings.Replace(text, replacement.old, replacement.new, 1)
+	}
+	previous := configuration
+
+	err = LoadConfiguration(strings.NewReader(text))
+	errs, ok := err.(ConfigurationErrors)
+	if !ok {
+		t.Fatalf("Expected ConfigurationErrors, got %v", err)
+	}
+	keys := make(map[string]bool)
+	for _, configurationErr := range errs {
+		keys[configurationErr.Key] = true
+	}
+	expected := []string{
+		"ProportinalRollMultiplier",
+		"ErrorSleepDuration_s",
+		"DistanceFormula",
+		"LandingPointAltitude_m",
+		"AccelerometerBias",
+	}
+	for _, key := range expected {
+		if !keys[key] {
+			t.Errorf("Should have reported %s, got %v", key, err)
+		}
+	}
+	if len(errs) != len(expected) {
+		t.Errorf("Expected %d errors, got %v", len(expected), err)
+	}
+	if !reflect.DeepEqual(configuration, previous) {
+		t.Error("Shouldn't have changed the configuration")
+	}
+
+	// Syntax errors and mismatched types are reported too
+	for _, bad := range []string{"ControlFrequency_hz = ", "ControlFrequency_hz = \"fast\""} {
+		if err := LoadConfiguration(strings.NewReader(bad)); err == nil {
+			t.Errorf("Should have rejected '%s'", bad)
+		}
+	}
+}
+
+func TestParseConfigurationOverrides(t *testing.T) {
+	layer, err := ParseConfigurationOverrides("-set", []string{
+		"ProportionalRollMultiplier=4",
+		"GuidanceMode = direct",
+		`AprsPath="WIDE1-1,WIDE2-1"`,
+		"MissionFile=missions/wonderland_lake.kml",
+		"AccelerometerBias=[1.0, 2.0, 3.0]",
+		"ButtonPin=23",
+	})
+	if err != nil {
+		t.Fatalf("Unable to parse overrides: %v", err)
+	}
+	expected := map[string]interface{}{
+		"ProportionalRollMultiplier": 4.0,
+		"GuidanceMode":               "direct",
+		"AprsPath":                   "WIDE1-1,WIDE2-1",
+		"MissionFile":                "missions/wonderland_lake.kml",
+		"AccelerometerBias":          []interface{}{1.0, 2.0, 3.0},
+		"ButtonPin":                  int64(23),
+	}
+	if !reflect.DeepEqual(layer.Values, expected) {
+		t.Errorf("Expected %v, got %v", expected, layer.Values)
+	}
+
+	for _, bad := range []string{"ProportionalRollMultiplier", "ProportionalRollMultiplier=fast"} {
+		if _, err := ParseConfigurationOverrides("-set", []string{bad}); err == nil {
+			t.Errorf("Should have rejected '%s'", bad)
+		}
+	}
+}
+
+func TestLoadConfigurationLayers(t *testing.T) {
+	loadTestConfiguration(t)
+	defer loadTestConfiguration(t)
+	base, err := OpenConfigurationLayer("../conf.toml")
+	if err != nil {
+		t.Fatalf("Unable to open conf.toml: %v", err)
+	}
+	airframe, err := OpenConfigurationLayer("../airframes/example.toml")
+	if err != nil {
+		t.Fatalf("Unable to open airframe: %v", err)
+	}
+	site, err := ReadConfigurationLayer("site", strings.NewReader("LandingPointAltitude_m = 1600.0\nProportionalRollMultiplier = 5.0\n"))
+	if err != nil {
+		t.Fatalf("Unable to read site: %v", err)
+	}
+	overrides, err := ParseConfigurationOverrides("-set", []string{"ProportionalRollMultiplier=6"})
+	if err != nil {
+		t.Fatalf("Unable to parse overrides: %v", err)
+	}
+
+	err = LoadConfigurationLayers(base, airframe, site, overrides)
+	if err != nil {
+		t.Fatalf("Unable to load layers: %v", err)
+	}
+	if configuration.ProportionalRollMultiplier != 6.0 || configuration.LandingPointAltitude != 1600.0 {
+		t.Errorf("Later layers should win, got %v %v", configuration.ProportionalRollMultiplier, configuration.LandingPointAltitude)
+	}
+	if configuration.LeftServoCenter_us != 1430 || configuration.BoardMounting[1][2] != -0.057564 {
+		t.Errorf("Should have loaded the airframe, got %v %v", configuration.LeftServoCenter_us, configuration.BoardMounting)
+	}
+
+	// The header should have where each value came from, and reproduce the
+	// same configuration
+	var header strings.Builder
+	err = WriteConfigurationHeader(&header)
+	if err != nil {
+		t.Fatalf("Unable to write header: %v", err)
+	}
+	for _, expected := range []string{
+		"# Effective configuration from ../conf.toml, ../airframes/example.toml, site, -set\n",
+		"ProportionalRollMultiplier = 6.0  # -set\n",
+		"LandingPointAltitude_m = 1600.0  # site\n",
+		"ControlFrequency_hz = 10.0\n",
+	} {
+		if !strings.Contains(header.String(), expected) {
+			t.Errorf("Header should contain '%s', got %s", expected, header.String())
+		}
+	}
+	loaded := configuration
+	err = LoadConfiguration(strings.NewReader(header.String()))
+	if err != nil {
+		t.Fatalf("Unable to load header: %v", err)
+	}
+	if !reflect.DeepEqual(configuration, loaded) {
+		t.Errorf("Header should reproduce the configuration")
+	}
+
+	// Errors say which layer they came from
+	bad, _ := ParseConfigurationOverrides("-set", []string{"ControlFrequency_hz=0"})
+	err = LoadConfigurationLayers(base, bad)
+	errs, ok := err.(ConfigurationErrors)
+	if !ok || len(errs) != 1 || errs[0].Source != "-set" || errs[0].Key != "ControlFrequency_hz" {
+		t.Errorf("Expected one error from -set, got %v", err)
+	}
+}
@@ -3,7 +3,6 @@ package glider
 
 import (
 	"errors"
-	"github.com/stianeikeland/go-rpio/v4"
 )
 
 const HERTZ = 50
@@ -16,51 +15,68 @@ const US_PER_CYCLE = (1000 * 1000) / HERTZ
 const US_PER_DEGREE = 800 / 90
 
 type Control struct {
-	left         *rpio.Pin
-	right        *rpio.Pin
+	left         servoOutput
+	right        servoOutput
 	leftZero_us  float64
 	rightZero_us float64
+	// The most recently commanded angles
+	leftAngle_r  Radians
+	rightAngle_r Radians
 }
 
-func NewControl() *Control {
-	tempLeft := rpio.Pin(configuration.LeftServoPin)
-	tempRight := rpio.Pin(configuration.RightServoPin)
-	tempLeft.Pwm()
-	tempRight.Pwm()
-	tempLeft.Freq(HERTZ * MULTIPLIER)
-	tempRight.Freq(HERTZ * MULTIPLIER)
-	tempLeft.DutyCycle(1000, MULTIPLIER)
-	control := Control{
-		left:         &tempLeft,
-		right:        &tempRight,
+func NewControl(left, right servoOutput) *Control {
+	control := &Control{
+		left:         left,
+		right:        right,
 		leftZero_us:  float64(configuration.LeftServoCenter_us - US_PER_DEGREE*90),
 		rightZero_us: float64(configuration.RightServoCenter_us - US_PER_DEGREE*90),
+		leftAngle_r:  ToRadians(90),
+		rightAngle_r: ToRadians(90),
 	}
-	// Param freq should be in range 4688Hz - 19.2MHz to prevent
-	// unexpected behavior
-	return &control
+	// The PWM outputs don't send any pulses until they're commanded, so
+	// center the servos instead of leaving them limp until the first one
+	control.SetLeft(ToRadians(90))
+	control.SetRight(ToRadians(90))
+	return control
 }
 
 func (control *Control) SetLeft(angle_r Radians) error {
-	return control.set(control.left, angle_r, control.leftZero_us)
+	err := control.set(control.left, angle_r, control.leftZero_us)
+	if err == nil {
+		control.leftAngle_r = angle_r
+	}
+	return err
 }
 
 func (control *Control) SetRight(angle_r Radians) error {
-	return control.set(control.right, angle_r, control.rightZero_us)
+	err := control.set(control.right, angle_r, control.rightZero_us)
+	if err == nil {
+		control.rightAngle_r = angle_r
+	}
+	return err
+}
+
+// Returns the most recently commanded left and right servo angles, where 90
+// degrees is centered
+func (control *Control) GetAngles() (Radians, Radians) {
+	return control.leftAngle_r, control.rightAngle_r
 }
 
-func (control *Control) set(pin *rpio.Pin, angle_r Radians, offset float64) error {
-	// Output frequency is computed as pwm clock frequency divided by cycle length.
-	// So, to set Pwm pin to freqency 38kHz with duty cycle 1/4, use this combination:
-	//  pin.DutyCycle(1, 4)
-	//  pin.Freq(38000*4)
+// Returns the most recently commanded left and right pulse widths in
+// microseconds
+func (control *Control) GetPulseWidths() (uint32, uint32) {
+	return getPulseWidth(control.leftAngle_r, control.leftZero_us), getPulseWidth(control.rightAngle_r, control.rightZero_us)
+}
+
+func (control *Control) set(servo servoOutput, angle_r Radians, offset float64) error {
 	if angle_r < ToRadians(45) || angle_r > ToRadians(135) {
 		return errors.New("Bad angle")
 	}
-	target_us := uint32(ToDegrees(angle_r)*US_PER_DEGREE + offset)
-	a := getDutyCycleForUs(target_us)
-	pin.DutyCycle(a, MULTIPLIER)
-	return nil
+	return servo.SetPulseWidth(getPulseWidth(angle_r, offset))
+}
+
+func getPulseWidth(angle_r Radians, offset float64) uint32 {
+	return uint32(ToDegrees(angle_r)*US_PER_DEGREE + offset)
 }
 
 func getDutyCycleForUs(target_us uint32) uint32 {
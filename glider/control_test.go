@@ -14,3 +14,16 @@ func TestGetDutyCyce(t *testing.T) {
 		t.Errorf("Bad dutyLength: %v", dutyLength)
 	}
 }
+
+func TestNewControlCenters(t *testing.T) {
+	restore := replaceTestConfiguration(t)
+	defer restore()
+	configuration.LeftServoCenter_us = 1430
+	configuration.RightServoCenter_us = 1530
+	left := &fakeServo{}
+	right := &fakeServo{}
+	NewControl(left, right)
+	if left.width_us != 1430 || right.width_us != 1530 {
+		t.Errorf("Should have centered the servos, got %v %v", left.width_us, right.width_us)
+	}
+}
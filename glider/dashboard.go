@@ -4,16 +4,30 @@ import (
 	"container/list"
 	"fmt"
 	"github.com/nsf/termbox-go"
-	"time"
+	"sync"
 )
 
 type stringWriter struct {
 	Line int
 }
 
-var dashboardTime time.Time = time.Now()
 var dashboardMessages *list.List
 
+// The dashboard shows the newest few messages, and the ground station
+// catches up on the rest
+const dashboardShownMessages = 3
+const dashboardMessageHistory = 20
+
+type dashboardMessage struct {
+	sequence uint64
+	text     string
+}
+
+var dashboardMessageSequence uint64
+
+// Anything can log, like the GPS reader and the parameter server
+var dashboardMutex sync.Mutex
+
 func (writer *stringWriter) WriteLine(str string) {
 	for x := 0; x < len(str); x++ {
 		termbox.SetCell(x, writer.Line, rune(str[x]), termbox.ColorWhite, termbox.ColorBlack)
@@ -29,38 +43,54 @@ func (writer *stringWriter) IndentLine(str string) {
 }
 
 func logDashboard(message string) {
+	dashboardMutex.Lock()
+	defer dashboardMutex.Unlock()
 	if dashboardMessages == nil {
 		dashboardMessages = list.New()
 	}
-	now := time.Now()
+	now := pilotClock.Now()
 	formatted := fmt.Sprintf("%s %s", now.Format("15:04:05.000"), message)
-	dashboardMessages.PushFront(formatted)
-	if dashboardMessages.Len() > 3 {
+	dashboardMessageSequence++
+	dashboardMessages.PushFront(dashboardMessage{sequence: dashboardMessageSequence, text: formatted})
+	if dashboardMessages.Len() > dashboardMessageHistory {
 		dashboardMessages.Remove(dashboardMessages.Back())
 	}
 }
 
+// Returns the messages after sequence, oldest first, and the sequence of the
+// newest one
+func getDashboardMessages(sequence uint64) ([]string, uint64) {
+	dashboardMutex.Lock()
+	defer dashboardMutex.Unlock()
+	var messages []string
+	if dashboardMessages == nil {
+		return messages, sequence
+	}
+	for e := dashboardMessages.Back(); e != nil; e = e.Prev() {
+		message := e.Value.(dashboardMessage)
+		if message.sequence > sequence {
+			messages = append(messages, message.text)
+		}
+	}
+	return messages, dashboardMessageSequence
+}
+
 type StringWriter struct {
 	Line int
 }
 
 func updateDashboard(telemetry *Telemetry, pilot *Pilot) {
-	// Only update this often
-	if time.Since(dashboardTime).Milliseconds() < 500 {
-		return
-	}
-	dashboardTime = time.Now()
-
 	writer := &StringWriter{Line: 0}
 	termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
 
 	writer.WriteLine("=== GPS ===")
-	if telemetry.HasGpsLock {
+	if !telemetry.HasGpsLock() {
 		writer.IndentLine("(No lock)")
 	} else {
-		position := telemetry.GetPosition()
-		writer.IndentLine(fmt.Sprintf("Lat/Long:%10.5f %10.5f", position.Latitude, position.Longitude))
-		writer.IndentLine(fmt.Sprintf("Altitude:%6.1f m", position.Altitude))
+		estimate := telemetry.GetPositionEstimate()
+		writer.IndentLine(fmt.Sprintf("Lat/Long:%10.5f %10.5f", estimate.Latitude, estimate.Longitude))
+		writer.IndentLine(fmt.Sprintf("Altitude:%6.1f m", estimate.Altitude))
+		writer.IndentLine(fmt.Sprintf("Uncertainty:%6.1f m Age:%4.1f s", estimate.Uncertainty, estimate.Age.Seconds()))
 	}
 
 	writer.WriteLine("=== Telemetry ===")
@@ -70,7 +100,7 @@ func updateDashboard(telemetry *Telemetry, pilot *Pilot) {
 	} else {
 		writer.IndentLine(fmt.Sprintf("Pitch:%6.1f", ToDegrees(axes.Pitch)))
 		writer.IndentLine(fmt.Sprintf("Roll:%6.1f", ToDegrees(axes.Roll)))
-		writer.IndentLine(fmt.Sprintf("Yaw:%6.1f", ToDegrees(axes.Yaw)))
+		writer.IndentLine(fmt.Sprintf("Yaw:%6.1f (declination%5.1f)", ToDegrees(axes.Yaw), ToDegrees(telemetry.GetDeclination())))
 	}
 
 	writer.WriteLine("=== State ===")
@@ -79,13 +109,35 @@ func updateDashboard(telemetry *Telemetry, pilot *Pilot) {
 		writer.IndentLine(fmt.Sprintf("Target yaw:%6.1f", ToDegrees(configuration.FlyDirection)))
 		angle_r := GetAngleTo(axes.Yaw, configuration.FlyDirection)
 		writer.IndentLine(fmt.Sprintf("Difference:%6.1f", ToDegrees(angle_r)))
-		targetRoll_r := getTargetRollHeading(axes.Yaw, configuration.FlyDirection)
-		writer.IndentLine(fmt.Sprintf("Target roll:%6.1f", ToDegrees(targetRoll_r)))
+	}
+	writer.IndentLine(fmt.Sprintf("Target roll:%6.1f", ToDegrees(pilot.targetRoll_r)))
+	if pilot.state == flying && configuration.GuidanceMode == GUIDANCE_MODE_L1 {
+		writer.IndentLine(fmt.Sprintf("Cross track:%6.1f m", pilot.crossTrackError))
 	}
 
+	if pilot.scheduler != nil {
+		writer.WriteLine("=== Loops ===")
+		for _, stats := range pilot.scheduler.GetStats() {
+			writer.IndentLine(stats.String())
+		}
+	}
+
+	if pilot.parameters != nil {
+		writer.WriteLine("=== Tuning ===")
+		name := pilot.parameters.Names()[pilot.selectedParameter]
+		value, _ := pilot.parameters.Get(name)
+		writer.IndentLine(fmt.Sprintf("%s: %g", name, value))
+		writer.IndentLine("up/down selects, left/right adjusts, w saves")
+	}
+	writer.IndentLine("q quits")
+
 	writer.WriteLine("=== Messages ===")
-	for e := dashboardMessages.Back(); e != nil; e = e.Prev() {
-		writer.IndentLine(e.Value.(string))
+	messages, _ := getDashboardMessages(0)
+	if len(messages) > dashboardShownMessages {
+		messages = messages[len(messages)-dashboardShownMessages:]
+	}
+	for _, message := range messages {
+		writer.IndentLine(message)
 	}
 
 	/*
@@ -107,6 +159,35 @@ func updateDashboard(telemetry *Telemetry, pilot *Pilot) {
 	termbox.Flush()
 }
 
+// Handles a key press on the dashboard. Returns true if we should quit.
+func (pilot *Pilot) handleKey(event termbox.Event) bool {
+	if event.Ch == 'q' || event.Key == termbox.KeyEsc || event.Key == termbox.KeyCtrlC {
+		return true
+	}
+	if pilot.parameters == nil {
+		return false
+	}
+
+	names := pilot.parameters.Names()
+	var err error
+	switch {
+	case event.Key == termbox.KeyArrowUp:
+		pilot.selectedParameter = (pilot.selectedParameter + len(names) - 1) % len(names)
+	case event.Key == termbox.KeyArrowDown:
+		pilot.selectedParameter = (pilot.selectedParameter + 1) % len(names)
+	case event.Key == termbox.KeyArrowLeft || event.Ch == '-':
+		err = pilot.parameters.Adjust(names[pilot.selectedParameter], -1, "dashboard")
+	case event.Key == termbox.KeyArrowRight || event.Ch == '+' || event.Ch == '=':
+		err = pilot.parameters.Adjust(names[pilot.selectedParameter], 1, "dashboard")
+	case event.Ch == 'w':
+		_, err = pilot.parameters.Persist()
+	}
+	if err != nil {
+		Logger.Errorf("Unable to tune: %v", err)
+	}
+	return false
+}
+
 func (writer *StringWriter) WriteLine(str string) {
 	for x := 0; x < len(str); x++ {
 		termbox.SetCell(x, writer.Line, rune(str[x]), termbox.ColorWhite, termbox.ColorBlack)
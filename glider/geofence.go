@@ -0,0 +1,247 @@
+// Keeps the glider in its inclusion zones, out of its exclusion zones, and
+// below its ceiling
+package glider
+
+import (
+	"fmt"
+	"github.com/bskari/go-glider/geofence"
+	"github.com/bskari/go-glider/mavlink"
+	"math"
+	"time"
+)
+
+type geofenceAction_t uint8
+
+const (
+	// Head away from the zone, then carry on with the mission
+	GEOFENCE_ACTION_STEER geofenceAction_t = iota + 1
+	// Circle where we are until it's time to land
+	GEOFENCE_ACTION_LOITER
+	// Spin down
+	GEOFENCE_ACTION_TERMINATE
+)
+
+func (action geofenceAction_t) String() string {
+	return []string{
+		"(unused-0-action)",
+		"steer",
+		"loiter",
+		"terminate",
+	}[action]
+}
+
+// How far past a zone's boundary to aim when steering away from it
+const geofenceEscapeDistance Meters = 1000.0
+
+type Geofence struct {
+	fence geofence.Fence
+	pilot *Pilot
+	// The breach that we last reported, and whether we were already in it or
+	// only heading for it
+	breach  *geofence.Breach
+	current bool
+	// Where we're steering to get away, and when the breach cleared, so
+	// that we keep going for a while instead of turning right back
+	escape      *Point
+	escapeClear time.Time
+	// Where we started loitering, or nil
+	loiterCenter *Point
+}
+
+// Loads the zones. Returns nil if there are no zones and no ceiling.
+func NewGeofence() (*Geofence, error) {
+	if configuration.GeofenceFile == "" && configuration.GeofenceCeiling == 0 {
+		return nil, nil
+	}
+	fence := geofence.Fence{Ceiling: configuration.GeofenceCeiling}
+	if configuration.GeofenceFile != "" {
+		polygons, err := geofence.Load(configuration.GeofenceFile)
+		if err != nil {
+			return nil, err
+		}
+		fence.Polygons = polygons
+		Logger.Infof("Loaded %d geofence zones from %s", len(polygons), configuration.GeofenceFile)
+	}
+	return newGeofence(fence), nil
+}
+
+func newGeofence(fence geofence.Fence) *Geofence {
+	return &Geofence{fence: fence}
+}
+
+func toFencePoint(point Point) geofence.Point {
+	return geofence.Point{Latitude: point.Latitude, Longitude: point.Longitude}
+}
+
+func fromFencePoint(point geofence.Point) Point {
+	return Point{Latitude: point.Latitude, Longitude: point.Longitude}
+}
+
+// Returns the breach where we are, or else the one where we'll be after
+// GeofencePredictionTime, and whether we're already in it. Logs and reports
+// to the ground station when that changes.
+func (fence *Geofence) check(estimate PositionEstimate) (geofence.Breach, bool, bool) {
+	breach, ok := fence.fence.Check(toFencePoint(estimate.Point), estimate.Altitude)
+	current := ok
+	if !ok {
+		seconds := configuration.GeofencePredictionTime.Seconds()
+		predicted := fromNorthEast(estimate.Point, estimate.VelocityNorth*seconds, estimate.VelocityEast*seconds)
+		breach, ok = fence.fence.Check(toFencePoint(predicted), estimate.Altitude+estimate.VerticalSpeed*seconds)
+	}
+
+	if !ok {
+		if fence.breach != nil {
+			fence.breach = nil
+			Logger.Info("Geofence clear")
+			fence.report(mavlink.MAV_SEVERITY_NOTICE, "Geofence clear")
+		}
+		return geofence.Breach{}, false, false
+	}
+	if fence.breach == nil || *fence.breach != breach || fence.current != current {
+		fence.breach = &breach
+		fence.current = current
+		when := "predicted"
+		if current {
+			when = "now"
+		}
+		text := fmt.Sprintf("Geofence breach %s, %v", when, breach)
+		Logger.Warningf("%s, at %v %0.1f m", text, estimate.Point, estimate.Altitude)
+		fence.report(mavlink.MAV_SEVERITY_WARNING, text)
+	}
+	return breach, current, true
+}
+
+// Tells the ground station, if we have one
+func (fence *Geofence) report(severity uint8, text string) {
+	if fence.pilot != nil && fence.pilot.mavlink != nil {
+		fence.pilot.mavlink.sendStatus(severity, text)
+	}
+}
+
+// Returns a point to head for to get away from the breach, or false if
+// steering can't help
+func (fence *Geofence) getEscapePoint(breach geofence.Breach, position Point) (Point, bool) {
+	switch breach.Type {
+	case geofence.BREACH_EXCLUSION:
+		nearest := fromFencePoint(breach.Polygon.NearestBoundaryPoint(toFencePoint(position)))
+		north, east := toNorthEast(nearest, position)
+		distance := math.Hypot(north, east)
+		if distance < 0.1 {
+			return Point{}, false
+		}
+		if breach.Polygon.Contains(toFencePoint(position)) {
+			// Take the shortest way out
+			return fromNorthEast(nearest, -north/distance*geofenceEscapeDistance, -east/distance*geofenceEscapeDistance), true
+		}
+		return fromNorthEast(position, north/distance*geofenceEscapeDistance, east/distance*geofenceEscapeDistance), true
+	case geofence.BREACH_OUTSIDE:
+		inclusion := fence.fence.NearestInclusion(toFencePoint(position))
+		if inclusion == nil {
+			return Point{}, false
+		}
+		return fromFencePoint(inclusion.Center()), true
+	}
+	// We can't do much about being too high in a glider
+	return Point{}, false
+}
+
+// Takes the configured action if we're in or heading for a breach. Returns
+// true if that took over from the mission.
+func (pilot *Pilot) runGeofence(estimate PositionEstimate, axes Axes) bool {
+	fence := pilot.geofence
+	breach, current, ok := fence.check(estimate)
+	if !ok && fence.escape == nil && fence.loiterCenter == nil {
+		return false
+	}
+
+	action := configuration.GeofenceAction
+	if ok && action == GEOFENCE_ACTION_TERMINATE {
+		Logger.Warningf("Terminating the flight because of the geofence, %v", breach)
+		pilot.state = terminated
+		return true
+	}
+	// Loitering inside of a zone would keep us there, so get out first
+	if action == GEOFENCE_ACTION_STEER || (ok && current) || fence.escape != nil {
+		return pilot.runGeofenceEscape(estimate, axes, breach, ok)
+	}
+
+	if fence.loiterCenter == nil {
+		center := estimate.Point
+		fence.loiterCenter = &center
+		Logger.Infof("Loitering over %v because of the geofence", center)
+	}
+	targetRoll_r := getTargetRollLoiter(estimate, axes.Yaw, *fence.loiterCenter, configuration.GeofenceLoiterRadius)
+	pilot.adjustAileronsToRollPitch(targetRoll_r, configuration.TargetPitch, axes)
+	return true
+}
+
+// While spiraling down to land, we can only steer away from breaches, since
+// it's too late to loiter and terminating would be silly. Returns true if
+// that took over from the landing.
+func (pilot *Pilot) runGeofenceLanding(estimate PositionEstimate, axes Axes) bool {
+	breach, _, ok := pilot.geofence.check(estimate)
+	if !ok && pilot.geofence.escape == nil {
+		return false
+	}
+	return pilot.runGeofenceEscape(estimate, axes, breach, ok)
+}
+
+// Heads for the escape point until the breach has been clear for
+// GeofencePredictionTime
+func (pilot *Pilot) runGeofenceEscape(estimate PositionEstimate, axes Axes, breach geofence.Breach, breached bool) bool {
+	fence := pilot.geofence
+	now := pilotClock.Now()
+	if breached {
+		fence.escapeClear = time.Time{}
+		if fence.escape == nil {
+			escape, canEscape := fence.getEscapePoint(breach, estimate.Point)
+			if !canEscape {
+				return false
+			}
+			fence.escape = &escape
+			fence.loiterCenter = nil
+			Logger.Infof("Steering toward %v to avoid the geofence", escape)
+		}
+	} else {
+		if fence.escapeClear.IsZero() {
+			fence.escapeClear = now
+		}
+		if now.Sub(fence.escapeClear) >= configuration.GeofencePredictionTime {
+			fence.escape = nil
+			if configuration.GeofenceAction == GEOFENCE_ACTION_STEER || pilot.state == landing {
+				Logger.Info("Clear of the geofence, resuming the mission")
+				return false
+			}
+			// Loiter where we got out, instead of letting the mission take
+			// us right back
+			center := estimate.Point
+			fence.loiterCenter = &center
+			Logger.Infof("Clear of the geofence, loitering over %v", center)
+			return pilot.runGeofence(estimate, axes)
+		}
+	}
+	// Heading straight for the escape point
+	targetRoll_r, _ := getTargetRollL1(estimate, axes.Yaw, estimate.Point, *fence.escape)
+	Logger.Debugf("geofence escape:%v targetRoll:%0.1f", *fence.escape, ToDegrees(targetRoll_r))
+	pilot.adjustAileronsToRollPitch(targetRoll_r, configuration.TargetPitch, axes)
+	return true
+}
+
+// Spins down, with full up elevator and full right aileron. The mixing puts
+// the left servo at full deflection and leaves the right one centered.
+func (pilot *Pilot) runTerminated() {
+	pilot.control.SetLeft(ToRadians(90) - configuration.MaxServoAngleOffset)
+	pilot.control.SetRight(ToRadians(90))
+	pilot.previousLeftAngle_r = -configuration.MaxServoAngleOffset
+	pilot.previousRightAngle_r = 0
+
+	axes, err := pilot.telemetry.GetAxes()
+	if err != nil {
+		Logger.Errorf("runTerminated unable to get axes: %v", err)
+		pilotClock.Sleep(configuration.ErrorSleepDuration)
+		return
+	}
+	if pilot.hasLanded(axes) {
+		pilot.state = landed
+	}
+}
@@ -0,0 +1,235 @@
+package glider
+
+import (
+	"bytes"
+	"github.com/bskari/go-glider/flightdata"
+	"github.com/bskari/go-glider/geofence"
+	"math"
+	"testing"
+	"time"
+)
+
+// An exclusion zone 200 m to 400 m north of origin, and 400 m wide
+func getTestExclusionZone(origin Point) geofence.Polygon {
+	corners := [][2]Meters{{200, -200}, {200, 200}, {400, 200}, {400, -200}}
+	polygon := geofence.Polygon{Name: "test", Exclusion: true}
+	for _, corner := range corners {
+		polygon.Boundary = append(polygon.Boundary, toFencePoint(offsetPoint(origin, corner[0], corner[1])))
+	}
+	return polygon
+}
+
+func TestGeofenceCheck(t *testing.T) {
+	loadTestConfiguration(t)
+	configuration.GeofencePredictionTime = 10 * time.Second
+	origin := Point{Latitude: 40.0, Longitude: -105.0}
+	fence := newGeofence(geofence.Fence{Polygons: []geofence.Polygon{getTestExclusionZone(origin)}, Ceiling: 2000})
+
+	// Flying away is fine
+	estimate := getTestEstimate(origin, 15, 180)
+	if breach, _, ok := fence.check(estimate); ok {
+		t.Errorf("Should be clear, got %v", breach)
+	}
+	// We'll be in the zone in 10 seconds
+	estimate = getTestEstimate(origin, 25, 0)
+	breach, current, ok := fence.check(estimate)
+	if !ok || current || breach.Type != geofence.BREACH_EXCLUSION {
+		t.Errorf("Should predict the breach, got %v %v %v", breach, current, ok)
+	}
+	// Already in it
+	estimate = getTestEstimate(offsetPoint(origin, 300, 0), 15, 180)
+	breach, current, ok = fence.check(estimate)
+	if !ok || !current || breach.Type != geofence.BREACH_EXCLUSION {
+		t.Errorf("Should be in the zone, got %v %v %v", breach, current, ok)
+	}
+	// Climbing through the ceiling
+	estimate = getTestEstimate(origin, 0, 0)
+	estimate.Altitude = 1990
+	estimate.VerticalSpeed = 5
+	breach, current, ok = fence.check(estimate)
+	if !ok || current || breach.Type != geofence.BREACH_CEILING {
+		t.Errorf("Should predict the ceiling, got %v %v %v", breach, current, ok)
+	}
+}
+
+func TestGeofenceEscapePoint(t *testing.T) {
+	loadTestConfiguration(t)
+	origin := Point{Latitude: 40.0, Longitude: -105.0}
+	zone := getTestExclusionZone(origin)
+	fence := newGeofence(geofence.Fence{Polygons: []geofence.Polygon{zone}})
+	breach := geofence.Breach{Type: geofence.BREACH_EXCLUSION, Polygon: &fence.fence.Polygons[0]}
+
+	// South of the zone, so head south
+	escape, ok := fence.getEscapePoint(breach, origin)
+	if !ok {
+		t.Fatal("Should be able to escape")
+	}
+	if north, east := toNorthEast(origin, escape); north > -500 || math.Abs(east) > 1 {
+		t.Errorf("Should head south, got %0.1f %0.1f", north, east)
+	}
+	// Just inside the northern edge, so head north
+	position := offsetPoint(origin, 390, 0)
+	escape, ok = fence.getEscapePoint(breach, position)
+	if !ok || zone.Contains(toFencePoint(escape)) {
+		t.Fatalf("Should escape the zone, got %v", escape)
+	}
+	if north, _ := toNorthEast(position, escape); north < 500 {
+		t.Errorf("Should head north, got %0.1f", north)
+	}
+	// Nothing to do about the ceiling
+	if _, ok := fence.getEscapePoint(geofence.Breach{Type: geofence.BREACH_CEILING}, origin); ok {
+		t.Error("Shouldn't be able to escape the ceiling")
+	}
+}
+
+func TestSimulatedGeofence(t *testing.T) {
+	for _, action := range []geofenceAction_t{GEOFENCE_ACTION_STEER, GEOFENCE_ACTION_LOITER, GEOFENCE_ACTION_TERMINATE} {
+		loadTestConfiguration(t)
+		configuration.MissionFile = ""
+		configuration.DistanceFormula = DISTANCE_FORMULA_HAVERSINE
+		configuration.BearingFormula = BEARING_FORMULA_EQUIRECTANGULAR
+		launch := Point{Latitude: configuration.SimulatorLaunchLatitude, Longitude: configuration.SimulatorLaunchLongitude}
+		// The waypoint is on the far side of the zone
+		waypoint := offsetPoint(launch, 1000, 0)
+		configuration.DefaultWaypointLatitude = waypoint.Latitude
+		configuration.DefaultWaypointLongitude = waypoint.Longitude
+		configuration.LandingPointAltitude = configuration.SimulatorGroundAltitude
+		// High enough to reach the zone before landing
+		configuration.SimulatorLaunchAltitude = configuration.SimulatorGroundAltitude + 400
+		configuration.GeofenceAction = action
+		zone := getTestExclusionZone(launch)
+
+		var buffer bytes.Buffer
+		recorder, err := flightdata.NewWriter(&buffer)
+		if err != nil {
+			t.Fatalf("Unable to create recorder: %v", err)
+		}
+		simulator := NewSimulator()
+		simulator.SetFlightRecorder(recorder)
+		simulator.SetGeofence(newGeofence(geofence.Fence{Polygons: []geofence.Polygon{zone}}))
+		result, err := simulator.Run()
+		if err != nil {
+			t.Fatalf("%v: Simulation failed: %v", action, err)
+		}
+		if !result.Landed {
+			t.Errorf("%v: Should have landed: %v", action, result)
+		}
+
+		records, err := flightdata.NewReader(&buffer).ReadAll()
+		if err != nil {
+			t.Fatalf("%v: Unable to read records: %v", action, err)
+		}
+		terminated := false
+		for _, record := range records {
+			// We're committed once we're on final
+			onFinal := record.Altitude_m-configuration.LandingPointAltitude < configuration.LandingFinalAltitude
+			if !onFinal && zone.Contains(geofence.Point{Latitude: record.Latitude, Longitude: record.Longitude}) {
+				t.Errorf("%v: Flew into the zone at %v while %s", action, record.Time, record.State)
+				break
+			}
+			terminated = terminated || record.State == "terminated"
+		}
+		if terminated != (action == GEOFENCE_ACTION_TERMINATE) {
+			t.Errorf("%v: Terminated should be %v", action, !terminated)
+		}
+	}
+}
+
+// Starting in a zone, we should get out and then loiter where we got out,
+// instead of heading back through it to the waypoint
+func TestSimulatedGeofenceLoiterAfterEscape(t *testing.T) {
+	loadTestConfiguration(t)
+	defer loadTestConfiguration(t)
+	configuration.MissionFile = ""
+	configuration.DistanceFormula = DISTANCE_FORMULA_HAVERSINE
+	configuration.BearingFormula = BEARING_FORMULA_EQUIRECTANGULAR
+	launch := Point{Latitude: configuration.SimulatorLaunchLatitude, Longitude: configuration.SimulatorLaunchLongitude}
+	waypoint := offsetPoint(launch, 0, 2000)
+	configuration.DefaultWaypointLatitude = waypoint.Latitude
+	configuration.DefaultWaypointLongitude = waypoint.Longitude
+	configuration.LandingPointAltitude = configuration.SimulatorGroundAltitude
+	configuration.SimulatorLaunchAltitude = configuration.SimulatorGroundAltitude + 400
+	configuration.GeofenceAction = GEOFENCE_ACTION_LOITER
+	// Launch in the middle of the zone
+	zone := getTestExclusionZone(offsetPoint(launch, -300, 0))
+
+	var buffer bytes.Buffer
+	recorder, err := flightdata.NewWriter(&buffer)
+	if err != nil {
+		t.Fatalf("Unable to create recorder: %v", err)
+	}
+	simulator := NewSimulator()
+	simulator.SetFlightRecorder(recorder)
+	simulator.SetGeofence(newGeofence(geofence.Fence{Polygons: []geofence.Polygon{zone}}))
+	_, err = simulator.Run()
+	if err != nil {
+		t.Fatalf("Simulation failed: %v", err)
+	}
+
+	records, err := flightdata.NewReader(&buffer).ReadAll()
+	if err != nil {
+		t.Fatalf("Unable to read records: %v", err)
+	}
+	var exit *Point
+	loitered := false
+	for _, record := range records {
+		if record.State != "flying" {
+			continue
+		}
+		position := Point{Latitude: record.Latitude, Longitude: record.Longitude}
+		inside := zone.Contains(toFencePoint(position))
+		if exit == nil {
+			if !inside {
+				exit = &position
+			}
+			continue
+		}
+		if inside {
+			t.Fatalf("Flew back into the zone at %v", record.Time)
+		}
+		// The escape keeps going for GeofencePredictionTime, then we circle
+		if distance := Distance(*exit, position); distance > 500 {
+			t.Fatalf("Should have loitered near where we got out, but got %0.0f m away at %v", distance, record.Time)
+		}
+		loitered = true
+	}
+	if exit == nil || !loitered {
+		t.Error("Should have gotten out of the zone and loitered")
+	}
+}
+
+func TestSimulatedGeofenceCeiling(t *testing.T) {
+	loadTestConfiguration(t)
+	configuration.MissionFile = "../missions/wonderland_lake.kml"
+	configuration.DistanceFormula = DISTANCE_FORMULA_HAVERSINE
+	configuration.SimulatorBalloonAscentSpeed = 5
+	configuration.ReleaseAltitude = configuration.SimulatorLaunchAltitude + 500
+	ceiling := configuration.SimulatorLaunchAltitude + 200
+
+	var buffer bytes.Buffer
+	recorder, err := flightdata.NewWriter(&buffer)
+	if err != nil {
+		t.Fatalf("Unable to create recorder: %v", err)
+	}
+	simulator := NewSimulator()
+	simulator.SetFlightRecorder(recorder)
+	simulator.SetGeofence(newGeofence(geofence.Fence{Ceiling: ceiling}))
+	_, err = simulator.Run()
+	if err != nil {
+		t.Fatalf("Simulation failed: %v", err)
+	}
+
+	records, err := flightdata.NewReader(&buffer).ReadAll()
+	if err != nil {
+		t.Fatalf("Unable to read records: %v", err)
+	}
+	// We should release before reaching the ceiling, not at the release
+	// altitude
+	maxAltitude := 0.0
+	for _, record := range records {
+		maxAltitude = math.Max(maxAltitude, record.Altitude_m)
+	}
+	if maxAltitude > ceiling || maxAltitude < ceiling-100 {
+		t.Errorf("Should have released just below the ceiling %0.0f, got up to %0.0f", ceiling, maxAltitude)
+	}
+}
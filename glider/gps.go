@@ -0,0 +1,257 @@
+// Reads NMEA sentences from the GPS in the background and turns them into
+// typed fixes
+package glider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/adrianmo/go-nmea"
+	"io"
+	"strings"
+	"time"
+)
+
+// How long to wait before checking the serial port again when it's empty. The
+// GPS sends about a byte per millisecond at 9600 baud.
+const gpsPollPeriod = 10 * time.Millisecond
+
+// How long to wait after a read error, so that we don't fill up the log
+const gpsErrorSleep = 1 * time.Second
+
+// NMEA sentences are at most 82 characters, so anything much longer without a
+// newline is noise
+const gpsMaxLineLength = 256
+
+// How many fixes can be waiting before the reader blocks. The GPS sends a few
+// sentences per fix, so this covers a few fixes.
+const gpsFixQueueLength = 16
+
+// A typed update from one NMEA sentence. Which fields are set depends on the
+// sentence: RMC has the position, velocity, and date and time, GGA has the
+// position, altitude, and fix quality, and VTG has the speed.
+type GpsFix struct {
+	// The NMEA sentence type, e.g. nmea.TypeRMC
+	Type string
+	// When we parsed it, according to pilotClock
+	Received time.Time
+	// When the GPS took it. Only RMC has the date, so this is zero for the
+	// others.
+	Time time.Time
+	// The RMC status is valid, or the GGA quality isn't invalid
+	Valid     bool
+	Latitude  Coordinate
+	Longitude Coordinate
+	Altitude  Meters
+	Speed     MetersPerSecond
+	Course    Radians
+	Quality   string
+	Hdop      float64
+	// GPS time of day, which GGA and RMC both have, so that we can tell when
+	// they're from the same fix
+	fixTime nmea.Time
+}
+
+func (fix GpsFix) String() string {
+	return fmt.Sprintf(
+		"%s valid:%v %0.6f %0.6f %0.1f m %0.1f m/s %0.1f quality:%s hdop:%0.1f",
+		fix.Type,
+		fix.Valid,
+		fix.Latitude,
+		fix.Longitude,
+		fix.Altitude,
+		fix.Speed,
+		ToDegrees(fix.Course),
+		fix.Quality,
+		fix.Hdop,
+	)
+}
+
+// Checks that sentence looks like $<fields>*<checksum> and that the checksum
+// matches
+func checkNmeaChecksum(sentence string) error {
+	if !strings.HasPrefix(sentence, "$") {
+		return errors.New("NMEA sentence doesn't start with $")
+	}
+	separator := strings.LastIndex(sentence, nmea.ChecksumSep)
+	if separator == -1 {
+		return errors.New("NMEA sentence has no checksum")
+	}
+	expected := nmea.Checksum(sentence[1:separator])
+	actual := strings.ToUpper(sentence[separator+1:])
+	if actual != expected {
+		return fmt.Errorf("NMEA checksum mismatch, %s != %s", actual, expected)
+	}
+	return nil
+}
+
+// Parses a sentence into a fix. Returns false if it's a valid sentence that
+// we don't use, like satellites in view.
+func parseGpsFix(sentence string, received time.Time) (GpsFix, bool, error) {
+	// We see $GPGSV, $GPRMC, $GPVTG, $GPGGA, $GPGSA, $GPGLL messages
+	// $GPGSV is satellites in view, not useful
+	// $GPRMC has latitude, longitude, speed in knots, and magnetic variation
+	// $GPVTG has speed in knots and km/h
+	// $GPGGA has latitude, longitude, and altitude
+	// $GPGSA is active satellites, not useful
+	// $GPGLL is just latitude and longitude
+	sentence = strings.TrimSpace(sentence)
+	err := checkNmeaChecksum(sentence)
+	if err != nil {
+		return GpsFix{}, false, err
+	}
+	// Skip the $ and the talker, like GP or GN
+	if len(sentence) < 6 {
+		return GpsFix{}, false, nil
+	}
+	sentenceType := sentence[3:6]
+	if sentenceType != nmea.TypeRMC && sentenceType != nmea.TypeGGA && sentenceType != nmea.TypeVTG {
+		return GpsFix{}, false, nil
+	}
+
+	parsed, err := nmea.Parse(sentence)
+	if err != nil {
+		return GpsFix{}, false, err
+	}
+	fix := GpsFix{Type: sentenceType, Received: received}
+	switch message := parsed.(type) {
+	case nmea.RMC:
+		fix.Valid = message.Validity == nmea.ValidRMC
+		fix.Latitude = message.Latitude
+		fix.Longitude = message.Longitude
+		fix.Speed = message.Speed * knotsToMetersPerSecond
+		fix.Course = ToRadians(message.Course)
+		fix.fixTime = message.Time
+		fix.Time = time.Date(
+			message.Date.YY+2000,
+			time.Month(message.Date.MM),
+			message.Date.DD,
+			message.Time.Hour,
+			message.Time.Minute,
+			message.Time.Second,
+			message.Time.Millisecond*int(time.Millisecond),
+			time.UTC,
+		)
+	case nmea.GGA:
+		fix.Valid = message.FixQuality != nmea.Invalid
+		fix.Latitude = message.Latitude
+		fix.Longitude = message.Longitude
+		fix.Altitude = message.Altitude
+		fix.Quality = message.FixQuality
+		fix.Hdop = message.HDOP
+		fix.fixTime = message.Time
+	case nmea.VTG:
+		fix.Valid = true
+		fix.Speed = MetersPerSecond(message.GroundSpeedKPH * 1000.0 / 3600.0)
+	}
+	return fix, true, nil
+}
+
+// Collects the chunks that the serial port hands us into whole lines, so
+// that a sentence that arrives in pieces isn't lost or parsed early
+type gpsLineReader struct {
+	partial string
+}
+
+// Returns the next complete line from gps, without the line ending, or false
+// if there isn't one yet
+func (reader *gpsLineReader) readLine(gps serialInterface) (string, bool, error) {
+	for {
+		if end := strings.IndexByte(reader.partial, '\n'); end != -1 {
+			line := strings.TrimSpace(reader.partial[:end])
+			reader.partial = reader.partial[end+1:]
+			if line == "" {
+				continue
+			}
+			return line, true, nil
+		}
+		if len(reader.partial) > gpsMaxLineLength {
+			Logger.Warningf("Dropping %d bytes of GPS data without a newline", len(reader.partial))
+			reader.partial = ""
+		}
+		if gps.Available() == 0 {
+			return "", false, nil
+		}
+		chunk, err := gps.ReadLine()
+		if err == io.EOF {
+			return "", false, nil
+		}
+		if err != nil {
+			return "", false, err
+		}
+		reader.partial += chunk
+	}
+}
+
+// Reads the GPS continuously in its own goroutine and sends the fixes on a
+// channel
+type GpsReader struct {
+	gps    serialInterface
+	lines  gpsLineReader
+	fixes  chan GpsFix
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewGpsReader(gps serialInterface) *GpsReader {
+	return &GpsReader{
+		gps:   gps,
+		fixes: make(chan GpsFix, gpsFixQueueLength),
+		done:  make(chan struct{}),
+	}
+}
+
+// The fixes, in the order that the GPS sent them. This is closed once the
+// reader stops.
+func (reader *GpsReader) Fixes() <-chan GpsFix {
+	return reader.fixes
+}
+
+// Reads until ctx is done or Stop is called
+func (reader *GpsReader) Start(ctx context.Context) {
+	ctx, reader.cancel = context.WithCancel(ctx)
+	go reader.run(ctx)
+}
+
+// Stops reading and waits for the goroutine to finish
+func (reader *GpsReader) Stop() {
+	reader.cancel()
+	<-reader.done
+}
+
+func (reader *GpsReader) run(ctx context.Context) {
+	defer recoverGoroutine()
+	defer close(reader.done)
+	defer close(reader.fixes)
+	for {
+		line, ok, err := reader.lines.readLine(reader.gps)
+		if err != nil {
+			Logger.Errorf("Unable to read GPS: %v", err)
+			if !sleepContext(ctx, gpsErrorSleep) {
+				return
+			}
+			continue
+		}
+		if !ok {
+			if !sleepContext(ctx, gpsPollPeriod) {
+				return
+			}
+			continue
+		}
+
+		Logger.Debug(line)
+		fix, ok, err := parseGpsFix(line, pilotClock.Now())
+		if err != nil {
+			Logger.Warningf("Bad GPS sentence '%s': %v", line, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		select {
+		case reader.fixes <- fix:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
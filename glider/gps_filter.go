@@ -0,0 +1,215 @@
+// Kalman filtering and forward projection of the GPS fixes
+package glider
+
+import (
+	"math"
+	"time"
+)
+
+// A filtered position, projected forward to some time
+type PositionEstimate struct {
+	Point
+	VelocityNorth MetersPerSecond
+	VelocityEast  MetersPerSecond
+	VerticalSpeed MetersPerSecond
+	// One standard deviation of the horizontal position
+	Uncertainty Meters
+	// Time since the last GPS fix
+	Age time.Duration
+	// False until we have received a fix
+	Valid bool
+	// False until we have received an altitude. RMC sentences only have the
+	// horizontal position, so we can have a fix without one. Altitude and
+	// VerticalSpeed are 0 until then.
+	AltitudeValid bool
+}
+
+// Returns true if the estimate is too old or too uncertain to navigate with
+func (estimate PositionEstimate) IsStale() bool {
+	if !estimate.Valid {
+		return true
+	}
+	return estimate.Age > configuration.GpsStaleDuration || estimate.Uncertainty > configuration.GpsMaxUncertainty
+}
+
+// Returns the ground speed and course
+func (estimate PositionEstimate) GetCourse() (MetersPerSecond, Radians) {
+	speed := math.Sqrt(estimate.VelocityNorth*estimate.VelocityNorth + estimate.VelocityEast*estimate.VelocityEast)
+	course_r := math.Atan2(estimate.VelocityEast, estimate.VelocityNorth)
+	if course_r < 0 {
+		course_r += ToRadians(360.0)
+	}
+	return speed, course_r
+}
+
+// A constant velocity Kalman filter for one axis. The state is position and
+// velocity.
+type kalman1d struct {
+	x [2]float64
+	p [2][2]float64
+}
+
+func newKalman1d(position, positionVariance, velocityVariance float64) kalman1d {
+	return kalman1d{
+		x: [2]float64{position, 0},
+		p: [2][2]float64{{positionVariance, 0}, {0, velocityVariance}},
+	}
+}
+
+// Projects the state forward using white noise acceleration
+func (filter kalman1d) predict(dt, accelerationVariance float64) kalman1d {
+	dt2 := dt * dt
+	dt3 := dt2 * dt
+	dt4 := dt3 * dt
+	p := filter.p
+	return kalman1d{
+		x: [2]float64{filter.x[0] + filter.x[1]*dt, filter.x[1]},
+		p: [2][2]float64{
+			{
+				p[0][0] + dt*(p[0][1]+p[1][0]) + dt2*p[1][1] + dt4*0.25*accelerationVariance,
+				p[0][1] + dt*p[1][1] + dt3*0.5*accelerationVariance,
+			},
+			{
+				p[1][0] + dt*p[1][1] + dt3*0.5*accelerationVariance,
+				p[1][1] + dt2*accelerationVariance,
+			},
+		},
+	}
+}
+
+// Incorporates a measurement of state index 0 (position) or 1 (velocity)
+func (filter *kalman1d) update(index int, measurement, variance float64) {
+	innovation := measurement - filter.x[index]
+	s := filter.p[index][index] + variance
+	if s <= 0 {
+		return
+	}
+	gain := [2]float64{filter.p[0][index] / s, filter.p[1][index] / s}
+	filter.x[0] += gain[0] * innovation
+	filter.x[1] += gain[1] * innovation
+	p := filter.p
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 2; j++ {
+			filter.p[i][j] = p[i][j] - gain[i]*p[index][j]
+		}
+	}
+}
+
+// Tracks position in a local north/east plane around the first fix, and
+// altitude separately
+type gpsFilter struct {
+	initialized         bool
+	altitudeInitialized bool
+	origin              Point
+	north               kalman1d
+	east                kalman1d
+	altitude            kalman1d
+	// When the state was last projected to
+	stateTime time.Time
+	// When the last fix was received
+	fixTime time.Time
+}
+
+func (filter *gpsFilter) toLocal(point Point) (Meters, Meters) {
+	north := latitudeDistance(filter.origin.Latitude, point.Latitude)
+	east := ToCoordinateRadians(point.Longitude-filter.origin.Longitude) * math.Cos(ToCoordinateRadians(filter.origin.Latitude)) * RADIUS_M
+	return north, east
+}
+
+func (filter *gpsFilter) fromLocal(north, east Meters) (Coordinate, Coordinate) {
+	latitude := filter.origin.Latitude + ToDegrees(north/RADIUS_M)
+	longitude := filter.origin.Longitude + ToDegrees(east/(RADIUS_M*math.Cos(ToCoordinateRadians(filter.origin.Latitude))))
+	return latitude, longitude
+}
+
+// Moves the state forward to the given time
+func (filter *gpsFilter) predict(now time.Time) {
+	dt := now.Sub(filter.stateTime).Seconds()
+	if dt <= 0 {
+		return
+	}
+	variance := configuration.GpsAccelerationNoise * configuration.GpsAccelerationNoise
+	filter.north = filter.north.predict(dt, variance)
+	filter.east = filter.east.predict(dt, variance)
+	filter.altitude = filter.altitude.predict(dt, variance)
+	filter.stateTime = now
+}
+
+// Adds a horizontal fix. hdop scales the configured position noise.
+func (filter *gpsFilter) updatePosition(latitude, longitude Coordinate, hdop float64, now time.Time) {
+	standardDeviation := configuration.GpsPositionNoise * math.Max(hdop, 1.0)
+	variance := standardDeviation * standardDeviation
+	if !filter.initialized {
+		filter.origin = Point{Latitude: latitude, Longitude: longitude}
+		// We don't know anything about the velocity yet, so start with
+		// something big
+		const velocityVariance = 100.0
+		filter.north = newKalman1d(0, variance, velocityVariance)
+		filter.east = newKalman1d(0, variance, velocityVariance)
+		filter.initialized = true
+		filter.stateTime = now
+		filter.fixTime = now
+		return
+	}
+	filter.predict(now)
+	north, east := filter.toLocal(Point{Latitude: latitude, Longitude: longitude})
+	filter.north.update(0, north, variance)
+	filter.east.update(0, east, variance)
+	filter.fixTime = now
+}
+
+func (filter *gpsFilter) updateVelocity(speed MetersPerSecond, course_r Radians, now time.Time) {
+	if !filter.initialized {
+		return
+	}
+	filter.predict(now)
+	variance := configuration.GpsVelocityNoise * configuration.GpsVelocityNoise
+	filter.north.update(1, speed*math.Cos(course_r), variance)
+	filter.east.update(1, speed*math.Sin(course_r), variance)
+}
+
+func (filter *gpsFilter) updateAltitude(altitude Meters, now time.Time) {
+	variance := configuration.GpsAltitudeNoise * configuration.GpsAltitudeNoise
+	if !filter.altitudeInitialized {
+		const verticalSpeedVariance = 25.0
+		filter.altitude = newKalman1d(altitude, variance, verticalSpeedVariance)
+		filter.altitudeInitialized = true
+		return
+	}
+	filter.predict(now)
+	filter.altitude.update(0, altitude, variance)
+}
+
+// Projects the filtered state forward to now, without changing the filter
+func (filter *gpsFilter) estimate(now time.Time) PositionEstimate {
+	if !filter.initialized {
+		return PositionEstimate{Valid: false}
+	}
+	dt := now.Sub(filter.stateTime).Seconds()
+	if dt < 0 {
+		dt = 0
+	}
+	variance := configuration.GpsAccelerationNoise * configuration.GpsAccelerationNoise
+	north := filter.north.predict(dt, variance)
+	east := filter.east.predict(dt, variance)
+
+	latitude, longitude := filter.fromLocal(north.x[0], east.x[0])
+	estimate := PositionEstimate{
+		Point: Point{
+			Latitude:  latitude,
+			Longitude: longitude,
+		},
+		VelocityNorth: north.x[1],
+		VelocityEast:  east.x[1],
+		Uncertainty:   math.Sqrt(north.p[0][0] + east.p[0][0]),
+		Age:           now.Sub(filter.fixTime),
+		Valid:         true,
+	}
+	if filter.altitudeInitialized {
+		altitude := filter.altitude.predict(dt, variance)
+		estimate.Altitude = altitude.x[0]
+		estimate.VerticalSpeed = altitude.x[1]
+		estimate.AltitudeValid = true
+	}
+	return estimate
+}
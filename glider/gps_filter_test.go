@@ -0,0 +1,117 @@
+package glider
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func setTestGpsConfiguration() {
+	configuration.GpsPositionNoise = 5.0
+	configuration.GpsVelocityNoise = 0.5
+	configuration.GpsAltitudeNoise = 10.0
+	configuration.GpsAccelerationNoise = 2.0
+	configuration.GpsStaleDuration = 3 * time.Second
+	configuration.GpsMaxUncertainty = 50.0
+}
+
+func TestGpsFilterForwardProjection(t *testing.T) {
+	setTestGpsConfiguration()
+	filter := gpsFilter{}
+	start := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+	origin := Point{Latitude: 40.0, Longitude: -105.0}
+
+	// Fly east at 10 m/s for a while
+	const speed = 10.0
+	for i := 0; i < 20; i++ {
+		now := start.Add(time.Duration(i) * time.Second)
+		east := speed * float64(i)
+		longitude := origin.Longitude + ToDegrees(east/(RADIUS_M*math.Cos(ToCoordinateRadians(origin.Latitude))))
+		filter.updatePosition(origin.Latitude, longitude, 1.0, now)
+		filter.updateVelocity(speed, ToRadians(90), now)
+		filter.updateAltitude(2000-float64(i), now)
+	}
+
+	last := start.Add(19 * time.Second)
+	lastEstimate := filter.estimate(last)
+	halfway := filter.estimate(last.Add(500 * time.Millisecond))
+	moved := Distance(lastEstimate.Point, halfway.Point)
+	if math.Abs(moved-speed*0.5) > 1.0 {
+		t.Errorf("Bad forward projection %0.1f", moved)
+	}
+	if halfway.Longitude <= lastEstimate.Longitude {
+		t.Error("Projected the wrong direction")
+	}
+	groundSpeed, course_r := halfway.GetCourse()
+	if math.Abs(groundSpeed-speed) > 0.5 {
+		t.Errorf("Bad speed %0.1f", groundSpeed)
+	}
+	if math.Abs(ToDegrees(course_r)-90) > 2 {
+		t.Errorf("Bad course %0.1f", ToDegrees(course_r))
+	}
+	if math.Abs(halfway.VerticalSpeed - -1.0) > 0.5 {
+		t.Errorf("Bad vertical speed %0.1f", halfway.VerticalSpeed)
+	}
+	if halfway.Uncertainty <= lastEstimate.Uncertainty {
+		t.Error("Uncertainty should grow between fixes")
+	}
+	if halfway.Age != 500*time.Millisecond {
+		t.Errorf("Bad age %v", halfway.Age)
+	}
+}
+
+func TestGpsFilterStale(t *testing.T) {
+	setTestGpsConfiguration()
+	filter := gpsFilter{}
+	if !filter.estimate(time.Now()).IsStale() {
+		t.Error("Estimate without a fix should be stale")
+	}
+
+	now := time.Now()
+	filter.updatePosition(40.0, -105.0, 1.0, now)
+	if filter.estimate(now.Add(time.Second)).IsStale() {
+		t.Error("Fresh estimate should not be stale")
+	}
+	if !filter.estimate(now.Add(10 * time.Second)).IsStale() {
+		t.Error("Old estimate should be stale")
+	}
+}
+
+func TestGpsFilterAltitudeValid(t *testing.T) {
+	setTestGpsConfiguration()
+	filter := gpsFilter{}
+	now := time.Now()
+	// RMC only has the horizontal position
+	filter.updatePosition(40.0, -105.0, 1.0, now)
+	filter.updateVelocity(10.0, ToRadians(90), now)
+	estimate := filter.estimate(now.Add(time.Second))
+	if !estimate.Valid || estimate.AltitudeValid {
+		t.Errorf("Should have a position without an altitude, got %+v", estimate)
+	}
+
+	filter.updateAltitude(2000.0, now.Add(time.Second))
+	estimate = filter.estimate(now.Add(time.Second))
+	if !estimate.AltitudeValid || estimate.Altitude != 2000.0 {
+		t.Errorf("Should have the altitude, got %+v", estimate)
+	}
+}
+
+func TestParseSentenceFiltersOnce(t *testing.T) {
+	setTestGpsConfiguration()
+	telemetry := Telemetry{}
+	telemetry.parseSentence("$GPRMC,081836,A,3700.00,N,13300.00,W,000.0,360.0,130998,011.3,E*69")
+	if !telemetry.gpsFilter.initialized {
+		t.Error("RMC fix not filtered")
+	}
+	estimate := telemetry.GetPositionEstimate()
+	if math.Abs(estimate.Latitude-37.0) > 0.0001 || math.Abs(estimate.Longitude - -133.0) > 0.0001 {
+		t.Errorf("Bad filtered position %v", estimate.Point)
+	}
+
+	// An invalid fix shouldn't move us
+	telemetry.parseSentence("$GPRMC,081837,V,3800.00,N,13300.00,W,000.0,360.0,130998,011.3,E*70")
+	estimate = telemetry.GetPositionEstimate()
+	if math.Abs(estimate.Latitude-37.0) > 0.0001 {
+		t.Errorf("Invalid fix was filtered %v", estimate.Point)
+	}
+}
@@ -0,0 +1,217 @@
+package glider
+
+import (
+	"context"
+	"github.com/adrianmo/go-nmea"
+	"io"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseGpsFix(t *testing.T) {
+	received := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+	fix, ok, err := parseGpsFix("$GPRMC,081836,A,3700.00,N,13300.00,W,000.0,360.0,130998,011.3,E*69\r\n", received)
+	if !ok || err != nil {
+		t.Fatalf("Unable to parse RMC: %v %v", ok, err)
+	}
+	if fix.Type != nmea.TypeRMC || !fix.Valid || fix.Latitude != 37.0 || fix.Longitude != -133.0 || fix.Received != received {
+		t.Errorf("Bad RMC fix %v", fix)
+	}
+	when := time.Date(2020, 10, 1, 12, 34, 56, 0, time.UTC)
+	fix, _, _ = parseGpsFix(formatNmeaSentences(when, Point{}, 0, 0, 0, 1.0)[0], received)
+	if !fix.Time.Equal(when) {
+		t.Errorf("Bad RMC time %v", fix.Time)
+	}
+
+	fix, ok, err = parseGpsFix("$GPGGA,134658.00,4300.00,S,04000,E,2,09,1.0,1048.47,M,-16.27,M,08,AAAA*43", received)
+	if !ok || err != nil {
+		t.Fatalf("Unable to parse GGA: %v %v", ok, err)
+	}
+	if fix.Type != nmea.TypeGGA || !fix.Valid || fix.Altitude != 1048.47 || fix.Quality != nmea.DGPS || fix.Hdop != 1.0 {
+		t.Errorf("Bad GGA fix %v", fix)
+	}
+
+	fix, ok, err = parseGpsFix("$GPVTG,054.7,T,034.4,M,005.5,N,007.2,K*4E", received)
+	if !ok || err != nil || fix.Type != nmea.TypeVTG || math.Abs(fix.Speed-2) > 1e-9 {
+		t.Errorf("Bad VTG fix %v %v %v", fix, ok, err)
+	}
+
+	// Other talkers work too
+	if fix, ok, err := parseGpsFix(formatNmeaSentence("GNVTG,054.7,T,034.4,M,005.5,N,007.2,K"), received); !ok || err != nil || fix.Type != nmea.TypeVTG {
+		t.Errorf("Bad GNVTG fix %v %v %v", fix, ok, err)
+	}
+	// Valid, but not useful
+	if _, ok, err := parseGpsFix("$GPGSA,A,3,04,05,,09,12,,,24,,,,,2.5,1.3,2.1*39", received); ok || err != nil {
+		t.Errorf("Should have skipped GSA %v %v", ok, err)
+	}
+
+	bad := []string{
+		// Corrupted latitude
+		"$GPRMC,081836,A,3800.00,N,13300.00,W,000.0,360.0,130998,011.3,E*69",
+		"$GPRMC,081836,A,3700.00,N,13300.00,W,000.0,360.0,130998,011.3,E",
+		"GPRMC,081836,A,3700.00,N,13300.00,W,000.0,360.0,130998,011.3,E*69",
+		// Truncated, but with a good checksum
+		formatNmeaSentence("GPRMC,081836,A,3700.00,N"),
+	}
+	for _, sentence := range bad {
+		if fix, _, err := parseGpsFix(sentence, received); err == nil {
+			t.Errorf("Should have rejected '%s', got %v", sentence, fix)
+		}
+	}
+}
+
+// A serial port that hands out chunks, and that's safe to fill from a test
+// while the GPS reader goroutine is reading it
+type chunkedSerial struct {
+	mutex  sync.Mutex
+	chunks []string
+}
+
+func (gps *chunkedSerial) add(chunks ...string) {
+	gps.mutex.Lock()
+	defer gps.mutex.Unlock()
+	gps.chunks = append(gps.chunks, chunks...)
+}
+
+func (gps *chunkedSerial) Available() int {
+	gps.mutex.Lock()
+	defer gps.mutex.Unlock()
+	available := 0
+	for _, chunk := range gps.chunks {
+		available += len(chunk)
+	}
+	return available
+}
+
+func (gps *chunkedSerial) ReadLine() (string, error) {
+	gps.mutex.Lock()
+	defer gps.mutex.Unlock()
+	if len(gps.chunks) == 0 {
+		return "", io.EOF
+	}
+	chunk := gps.chunks[0]
+	gps.chunks = gps.chunks[1:]
+	return chunk, nil
+}
+
+func TestGpsLineReader(t *testing.T) {
+	gps := &chunkedSerial{}
+	var reader gpsLineReader
+	gps.add("$GPVTG,054.7,T,", "034.4,M,005.5,N")
+	if line, ok, err := reader.readLine(gps); ok || err != nil {
+		t.Errorf("Shouldn't have a line yet, got '%s' %v", line, err)
+	}
+	// The end of the first sentence and all of the next one
+	gps.add(",007.2,K*4E\r\n$GPGSA,A,3,04,05,,09,12,,,24,,,,,2.5,1.3,2.1*39\r\n\r\n")
+	expected := []string{
+		"$GPVTG,054.7,T,034.4,M,005.5,N,007.2,K*4E",
+		"$GPGSA,A,3,04,05,,09,12,,,24,,,,,2.5,1.3,2.1*39",
+	}
+	for _, expectedLine := range expected {
+		line, ok, err := reader.readLine(gps)
+		if !ok || err != nil || line != expectedLine {
+			t.Errorf("Expected '%s', got '%s' %v %v", expectedLine, line, ok, err)
+		}
+	}
+	if line, ok, _ := reader.readLine(gps); ok {
+		t.Errorf("Should be empty, got '%s'", line)
+	}
+
+	// Noise without a newline is dropped
+	for i := 0; i < 30; i++ {
+		gps.add("0123456789")
+	}
+	gps.add("$GPVTG,054.7,T,034.4,M,005.5,N,007.2,K*4E\n")
+	line, ok, err := reader.readLine(gps)
+	if !ok || err != nil {
+		t.Fatalf("Should have a line, got %v %v", ok, err)
+	}
+	if _, _, err := parseGpsFix(line, time.Now()); err == nil {
+		t.Errorf("Should have dropped some of the noise, got '%s'", line)
+	}
+	if line, ok, _ := reader.readLine(gps); ok {
+		t.Errorf("Should be empty, got '%s'", line)
+	}
+}
+
+func TestGpsReader(t *testing.T) {
+	loadTestConfiguration(t)
+	configuration.DistanceFormula = DISTANCE_FORMULA_HAVERSINE
+	configuration.BearingFormula = BEARING_FORMULA_EQUIRECTANGULAR
+	gps := &chunkedSerial{}
+	hardware := newFakeHardware()
+	hardware.Gps = gps
+	telemetry := NewTelemetry(hardware)
+	telemetry.StartGpsReader(context.Background())
+	defer telemetry.StopGpsReader()
+
+	if parsed, _ := telemetry.ParseQueuedMessage(); parsed {
+		t.Error("The reader goroutine should be doing the parsing")
+	}
+
+	// Read like the dashboard would while the fixes come in
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				telemetry.GetPositionEstimate()
+				telemetry.GetSpeed()
+				telemetry.getGpsSnapshot()
+				telemetry.GetAxes()
+			}
+		}
+	}()
+
+	start := time.Date(2020, 10, 1, 12, 34, 56, 0, time.UTC)
+	position := Point{Latitude: 40.054, Longitude: -105.295}
+	for i := 0; i < 5; i++ {
+		now := start.Add(time.Duration(i) * time.Second)
+		for _, sentence := range formatNmeaSentences(now, position, 1800, 10, 90, 1.0) {
+			// Split each one, like the serial port might
+			gps.add(sentence[:10], sentence[10:])
+		}
+		gps.add("$GPRMC,bad*00\r\n")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for telemetry.getGpsSnapshot().fixTime != start.Add(4*time.Second) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for fixes, got %v", telemetry.getGpsSnapshot())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(stop)
+	<-done
+
+	if !telemetry.HasGpsLock() {
+		t.Error("Should have GPS lock")
+	}
+	snapshot := telemetry.getGpsSnapshot()
+	if math.Abs(snapshot.point.Latitude-position.Latitude) > 1e-6 || math.Abs(snapshot.point.Longitude-position.Longitude) > 1e-6 {
+		t.Errorf("Bad position %v", snapshot.point)
+	}
+	if math.Abs(telemetry.GetSpeed()-10) > 0.1 {
+		t.Errorf("Bad speed %v", telemetry.GetSpeed())
+	}
+	if telemetry.GetTimestamp() != start.Unix() {
+		t.Errorf("Bad timestamp %v", telemetry.GetTimestamp())
+	}
+	if !telemetry.GetPositionEstimate().Valid {
+		t.Error("Position estimate should be valid")
+	}
+
+	// Stopping twice is fine, and then we can poll again
+	telemetry.StopGpsReader()
+	telemetry.StopGpsReader()
+	gps.add(formatNmeaSentences(start, position, 1800, 10, 90, 1.0)...)
+	if parsed, err := telemetry.ParseQueuedMessage(); !parsed || err != nil {
+		t.Errorf("Should have parsed after stopping %v %v", parsed, err)
+	}
+}
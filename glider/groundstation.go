@@ -0,0 +1,350 @@
+// Serves a web page that shows the telemetry, so that we can watch a flight
+// from a phone on the Pi's hotspot instead of over SSH. The page's assets are
+// compiled in, because the filesystem is read-only, and the telemetry is
+// streamed to it over a WebSocket.
+package glider
+
+import (
+	"encoding/json"
+	"github.com/bskari/go-glider/websocket"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// How long a slow client can hold up its writer before we drop it
+const groundStationWriteTimeout = 5 * time.Second
+
+// How many messages can queue up for a client before we skip some
+const groundStationClientQueueLength = 16
+
+// How often to add to the track, and how much of it to keep for clients that
+// connect partway through a flight
+const groundStationTrackPeriod = time.Second
+const groundStationTrackLength = 1800
+
+type groundStationPoint struct {
+	Latitude  Coordinate `json:"lat"`
+	Longitude Coordinate `json:"lon"`
+	Altitude  Meters     `json:"alt"`
+}
+
+func newGroundStationPoint(point Point) groundStationPoint {
+	return groundStationPoint{Latitude: point.Latitude, Longitude: point.Longitude, Altitude: point.Altitude}
+}
+
+// Sent to new clients, and whenever the waypoints change
+type groundStationMission struct {
+	Type      string               `json:"type"`
+	Waypoints []groundStationPoint `json:"waypoints"`
+	// The index of the first repeating waypoint, which is where we land
+	RepeatingIndex int `json:"repeatingIndex"`
+}
+
+// Sent to new clients
+type groundStationHistory struct {
+	Type     string               `json:"type"`
+	Track    []groundStationPoint `json:"track"`
+	Messages []string             `json:"messages"`
+}
+
+type groundStationServos struct {
+	LeftAngle_d  Degrees `json:"leftAngle_d"`
+	RightAngle_d Degrees `json:"rightAngle_d"`
+	Left_us      uint32  `json:"left_us"`
+	Right_us     uint32  `json:"right_us"`
+}
+
+// Sent every GroundStationPeriod
+type groundStationTelemetry struct {
+	Type string `json:"type"`
+	// Unix milliseconds
+	Time          int64   `json:"time"`
+	State         string  `json:"state"`
+	Roll_d        Degrees `json:"roll_d"`
+	Pitch_d       Degrees `json:"pitch_d"`
+	Yaw_d         Degrees `json:"yaw_d"`
+	TargetRoll_d  Degrees `json:"targetRoll_d"`
+	TargetPitch_d Degrees `json:"targetPitch_d"`
+	GpsLock       bool    `json:"gpsLock"`
+	// Nil until we have a position estimate
+	Position          *groundStationPoint `json:"position"`
+	Speed_mps         MetersPerSecond     `json:"speed_mps"`
+	VerticalSpeed_mps MetersPerSecond     `json:"verticalSpeed_mps"`
+	Uncertainty_m     Meters              `json:"uncertainty_m"`
+	Servos            groundStationServos `json:"servos"`
+	WaypointIndex     int                 `json:"waypointIndex"`
+	// Zero until we have a position estimate
+	WaypointDistance_m Meters `json:"waypointDistance_m"`
+	CrossTrackError_m  Meters `json:"crossTrackError_m"`
+	Landing            bool   `json:"landing"`
+	// Logged since the last telemetry
+	Messages []string `json:"messages"`
+}
+
+type groundStationClient struct {
+	conn     *websocket.Conn
+	messages chan []byte
+}
+
+// Serves the page and streams telemetry to every connected browser
+type GroundStation struct {
+	server   *http.Server
+	listener net.Listener
+	pilot    *Pilot
+	// The newest log message that has been sent
+	messageSequence uint64
+
+	// Everything below is shared with the HTTP handlers
+	mutex   sync.Mutex
+	clients map[*groundStationClient]bool
+	// Where we've been, oldest first
+	track     []groundStationPoint
+	trackTime time.Time
+	// The waypoints that the mission message was built from, so that we
+	// notice uploads from MAVLink
+	waypoints *Waypoints
+	mission   []byte
+	messages  []string
+}
+
+// Starts serving on GroundStationAddress. Returns nil if GroundStationAddress
+// is empty.
+func NewGroundStation() (*GroundStation, error) {
+	if configuration.GroundStationAddress == "" {
+		return nil, nil
+	}
+	listener, err := net.Listen("tcp", configuration.GroundStationAddress)
+	if err != nil {
+		return nil, err
+	}
+	station := newGroundStation()
+	station.listener = listener
+	station.server = &http.Server{Handler: station.newHandler()}
+	go func() {
+		defer recoverGoroutine()
+		err := station.server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			Logger.Errorf("Ground station stopped: %v", err)
+		}
+	}()
+	Logger.Infof("Serving ground station on %s", listener.Addr())
+	return station, nil
+}
+
+func newGroundStation() *GroundStation {
+	return &GroundStation{clients: make(map[*groundStationClient]bool)}
+}
+
+// Where we're listening, e.g. if GroundStationAddress has port 0
+func (station *GroundStation) Addr() net.Addr {
+	return station.listener.Addr()
+}
+
+// Stops serving and disconnects every client
+func (station *GroundStation) Close() error {
+	// The WebSockets are hijacked, so the server has forgotten about them
+	// and we have to disconnect them ourselves
+	err := shutdownHttpServer(station.server)
+	station.mutex.Lock()
+	defer station.mutex.Unlock()
+	for client := range station.clients {
+		station.removeClient(client)
+	}
+	return err
+}
+
+func (station *GroundStation) newHandler() http.Handler {
+	mux := http.NewServeMux()
+	for path, asset := range groundStationAssets {
+		path, asset := path, asset
+		mux.HandleFunc(path, func(response http.ResponseWriter, request *http.Request) {
+			// / matches everything that isn't more specific
+			if request.URL.Path != path {
+				http.NotFound(response, request)
+				return
+			}
+			response.Header().Set("Content-Type", asset.contentType)
+			response.Write([]byte(asset.content))
+		})
+	}
+	mux.HandleFunc("/ws", station.serveWebSocket)
+	return mux
+}
+
+func (station *GroundStation) serveWebSocket(response http.ResponseWriter, request *http.Request) {
+	conn, err := websocket.Upgrade(response, request)
+	if err != nil {
+		Logger.Warningf("Bad ground station connection from %s: %v", request.RemoteAddr, err)
+		return
+	}
+	Logger.Infof("Ground station connected from %s", request.RemoteAddr)
+	client := station.addClient(conn)
+	go station.writeForever(client)
+
+	// We don't take any commands, but reading handles pings and closes
+	for {
+		_, _, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+	}
+	station.mutex.Lock()
+	station.removeClient(client)
+	station.mutex.Unlock()
+	Logger.Infof("Ground station disconnected from %s", request.RemoteAddr)
+}
+
+// Queues what's happened so far for a new client, then adds it so that it
+// gets everything after
+func (station *GroundStation) addClient(conn *websocket.Conn) *groundStationClient {
+	client := &groundStationClient{
+		conn:     conn,
+		messages: make(chan []byte, groundStationClientQueueLength),
+	}
+	station.mutex.Lock()
+	defer station.mutex.Unlock()
+	if station.mission != nil {
+		client.messages <- station.mission
+	}
+	history, err := json.Marshal(groundStationHistory{
+		Type:     "history",
+		Track:    station.track,
+		Messages: station.messages,
+	})
+	if err != nil {
+		Logger.Errorf("Unable to encode ground station history: %v", err)
+	} else {
+		client.messages <- history
+	}
+	station.clients[client] = true
+	return client
+}
+
+// Only call this while holding the mutex
+func (station *GroundStation) removeClient(client *groundStationClient) {
+	if !station.clients[client] {
+		return
+	}
+	delete(station.clients, client)
+	close(client.messages)
+}
+
+func (station *GroundStation) writeForever(client *groundStationClient) {
+	defer recoverGoroutine()
+	defer client.conn.Close()
+	for message := range client.messages {
+		client.conn.SetWriteDeadline(time.Now().Add(groundStationWriteTimeout))
+		err := client.conn.WriteText(message)
+		if err != nil {
+			Logger.Debugf("Unable to write to ground station %s: %v", client.conn.RemoteAddr(), err)
+			// Closing makes the reader remove the client
+			return
+		}
+	}
+}
+
+// Only call this while holding the mutex
+func (station *GroundStation) broadcast(message []byte) {
+	for client := range station.clients {
+		select {
+		case client.messages <- message:
+		default:
+			// It'll catch up with the next one
+		}
+	}
+}
+
+// Sends the telemetry to every client's queue, and the mission too if it
+// changed. The clients' writers send them, so a slow phone doesn't hold up
+// the pilot.
+func (station *GroundStation) update() {
+	now := pilotClock.Now()
+	telemetry := station.getTelemetry(now)
+	telemetry.Messages, station.messageSequence = getDashboardMessages(station.messageSequence)
+	encoded, err := json.Marshal(telemetry)
+	if err != nil {
+		Logger.Errorf("Unable to encode ground station telemetry: %v", err)
+		return
+	}
+
+	station.mutex.Lock()
+	defer station.mutex.Unlock()
+	if station.waypoints != station.pilot.waypoints {
+		station.waypoints = station.pilot.waypoints
+		station.mission, err = json.Marshal(getGroundStationMission(station.waypoints))
+		if err != nil {
+			Logger.Errorf("Unable to encode ground station mission: %v", err)
+			station.mission = nil
+		} else {
+			station.broadcast(station.mission)
+		}
+	}
+	if telemetry.Position != nil && now.Sub(station.trackTime) >= groundStationTrackPeriod {
+		station.trackTime = now
+		station.track = append(station.track, *telemetry.Position)
+		if len(station.track) > groundStationTrackLength {
+			station.track = station.track[len(station.track)-groundStationTrackLength:]
+		}
+	}
+	station.messages = append(station.messages, telemetry.Messages...)
+	if len(station.messages) > dashboardMessageHistory {
+		station.messages = station.messages[len(station.messages)-dashboardMessageHistory:]
+	}
+	station.broadcast(encoded)
+}
+
+func (station *GroundStation) getTelemetry(now time.Time) groundStationTelemetry {
+	pilot := station.pilot
+	_, _, _, axes := pilot.telemetry.getRecentSensors()
+	leftAngle_r, rightAngle_r := pilot.control.GetAngles()
+	left_us, right_us := pilot.control.GetPulseWidths()
+	telemetry := groundStationTelemetry{
+		Type:          "telemetry",
+		Time:          now.UnixNano() / int64(time.Millisecond),
+		State:         pilot.state.String(),
+		Roll_d:        ToDegrees(axes.Roll),
+		Pitch_d:       ToDegrees(axes.Pitch),
+		Yaw_d:         ToDegrees(axes.Yaw),
+		TargetRoll_d:  ToDegrees(pilot.targetRoll_r),
+		TargetPitch_d: ToDegrees(pilot.targetPitch_r),
+		GpsLock:       pilot.telemetry.HasGpsLock(),
+		Servos: groundStationServos{
+			LeftAngle_d:  ToDegrees(leftAngle_r),
+			RightAngle_d: ToDegrees(rightAngle_r),
+			Left_us:      left_us,
+			Right_us:     right_us,
+		},
+		WaypointIndex:     pilot.waypoints.index,
+		CrossTrackError_m: pilot.crossTrackError,
+		Landing:           pilot.landing != nil,
+	}
+
+	estimate := pilot.telemetry.GetPositionEstimate()
+	if estimate.Valid {
+		position := newGroundStationPoint(estimate.Point)
+		telemetry.Position = &position
+		telemetry.Speed_mps = math.Hypot(estimate.VelocityNorth, estimate.VelocityEast)
+		telemetry.VerticalSpeed_mps = estimate.VerticalSpeed
+		telemetry.Uncertainty_m = estimate.Uncertainty
+		telemetry.WaypointDistance_m = Distance(estimate.Point, pilot.waypoints.GetWaypoint())
+	}
+	return telemetry
+}
+
+func getGroundStationMission(waypoints *Waypoints) groundStationMission {
+	mission := groundStationMission{
+		Type:           "mission",
+		Waypoints:      make([]groundStationPoint, 0, len(waypoints.first)+len(waypoints.repeating)),
+		RepeatingIndex: len(waypoints.first),
+	}
+	for _, point := range waypoints.first {
+		mission.Waypoints = append(mission.Waypoints, newGroundStationPoint(point))
+	}
+	for _, point := range waypoints.repeating {
+		mission.Waypoints = append(mission.Waypoints, newGroundStationPoint(point))
+	}
+	return mission
+}
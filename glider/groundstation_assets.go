@@ -0,0 +1,631 @@
+package glider
+
+// The ground station page, compiled in so that it can be served from a
+// read-only filesystem without anything from the internet. The script sticks
+// to ES5 so that it works on older phones.
+
+type groundStationAsset struct {
+	contentType string
+	content     string
+}
+
+var groundStationAssets = map[string]groundStationAsset{
+	"/":                  {"text/html; charset=utf-8", groundStationHtml},
+	"/groundstation.css": {"text/css; charset=utf-8", groundStationCss},
+	"/groundstation.js":  {"application/javascript; charset=utf-8", groundStationJs},
+}
+
+const groundStationHtml = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Glider</title>
+<link rel="stylesheet" href="/groundstation.css">
+</head>
+<body>
+<header>
+  <h1>Glider</h1>
+  <span id="state">-</span>
+  <span id="connection" class="disconnected">Connecting</span>
+</header>
+<main>
+  <section>
+    <h2>Attitude <span class="legend">yellow is the target</span></h2>
+    <canvas id="attitude"></canvas>
+  </section>
+  <section>
+    <h2>Map</h2>
+    <canvas id="map"></canvas>
+  </section>
+  <section>
+    <h2>Pilot</h2>
+    <table>
+      <tr><th>State</th><td id="pilot-state">-</td></tr>
+      <tr><th>GPS</th><td id="pilot-gps">-</td></tr>
+      <tr><th>Position</th><td id="pilot-position">-</td></tr>
+      <tr><th>Altitude</th><td id="pilot-altitude">-</td></tr>
+      <tr><th>Speed</th><td id="pilot-speed">-</td></tr>
+      <tr><th>Vertical speed</th><td id="pilot-vertical-speed">-</td></tr>
+      <tr><th>Heading</th><td id="pilot-heading">-</td></tr>
+      <tr><th>Roll / target</th><td id="pilot-roll">-</td></tr>
+      <tr><th>Pitch / target</th><td id="pilot-pitch">-</td></tr>
+      <tr><th>Waypoint</th><td id="pilot-waypoint">-</td></tr>
+      <tr><th>Cross track</th><td id="pilot-cross-track">-</td></tr>
+    </table>
+  </section>
+  <section>
+    <h2>Servos</h2>
+    <div class="servo">
+      <span>Left</span>
+      <div class="bar"><div id="servo-left-bar"></div></div>
+      <span id="servo-left">-</span>
+    </div>
+    <div class="servo">
+      <span>Right</span>
+      <div class="bar"><div id="servo-right-bar"></div></div>
+      <span id="servo-right">-</span>
+    </div>
+  </section>
+  <section class="wide">
+    <h2>Messages</h2>
+    <ol id="messages"></ol>
+  </section>
+</main>
+<script src="/groundstation.js"></script>
+</body>
+</html>
+`
+
+const groundStationCss = `body {
+  margin: 0;
+  font-family: sans-serif;
+  background: #111;
+  color: #eee;
+}
+header {
+  display: flex;
+  align-items: center;
+  padding: 0.5em 1em;
+  background: #222;
+}
+header > * {
+  margin-right: 1em;
+}
+h1 {
+  font-size: 1.2em;
+  margin: 0;
+}
+h2 {
+  font-size: 1em;
+  font-weight: normal;
+  margin: 0 0 0.5em;
+  color: #aaa;
+}
+.legend {
+  font-size: 0.8em;
+  color: #dc3;
+}
+#state {
+  font-weight: bold;
+}
+#connection {
+  margin-left: auto;
+  margin-right: 0;
+}
+.connected {
+  color: #6c6;
+}
+.disconnected {
+  color: #e66;
+}
+main {
+  display: grid;
+  grid-template-columns: repeat(auto-fit, minmax(300px, 1fr));
+  grid-gap: 0.5em;
+  padding: 0.5em;
+}
+section {
+  background: #1b1b1b;
+  border-radius: 4px;
+  padding: 0.5em;
+}
+.wide {
+  grid-column: 1 / -1;
+}
+canvas {
+  display: block;
+  width: 100%;
+}
+#attitude {
+  height: 300px;
+}
+#map {
+  height: 360px;
+}
+table {
+  width: 100%;
+  border-collapse: collapse;
+}
+th {
+  text-align: left;
+  font-weight: normal;
+  color: #aaa;
+  padding: 0.15em 0;
+}
+td {
+  text-align: right;
+  font-variant-numeric: tabular-nums;
+}
+.servo {
+  display: flex;
+  align-items: center;
+  margin: 0.6em 0;
+}
+.servo > span:first-child {
+  width: 3em;
+}
+.servo > span:last-child {
+  width: 9em;
+  text-align: right;
+  font-variant-numeric: tabular-nums;
+}
+.bar {
+  position: relative;
+  flex: 1;
+  height: 1.2em;
+  background: #333;
+  border-radius: 2px;
+}
+.bar::after {
+  content: "";
+  position: absolute;
+  left: 50%;
+  top: 0;
+  bottom: 0;
+  border-left: 1px solid #777;
+}
+.bar > div {
+  position: absolute;
+  left: 50%;
+  top: 0;
+  bottom: 0;
+  width: 4px;
+  margin-left: -2px;
+  background: #f90;
+}
+#messages {
+  margin: 0;
+  padding: 0;
+  list-style: none;
+  max-height: 15em;
+  overflow-y: auto;
+  font-family: monospace;
+  font-size: 0.85em;
+}
+`
+
+const groundStationJs = `// Draws the telemetry that the glider streams over a WebSocket
+(function () {
+  "use strict";
+
+  var maxMessages = 50;
+  var maxTrack = 5000;
+  var trackPeriod_ms = 1000;
+  var reconnectDelay_ms = 2000;
+  // Servo pulse widths that fill the bars
+  var minPulse_us = 1000;
+  var maxPulse_us = 2000;
+  var metersPerDegreeLatitude = 110540;
+  var metersPerDegreeLongitude = 111320;
+
+  var telemetry = null;
+  var mission = null;
+  var track = [];
+  var trackTime = 0;
+  var drawPending = false;
+
+  function element(id) {
+    return document.getElementById(id);
+  }
+
+  function toRadians(degrees) {
+    return degrees * Math.PI / 180;
+  }
+
+  function format(value, digits, units) {
+    if (value === null || value === undefined) {
+      return "-";
+    }
+    return value.toFixed(digits) + (units || "");
+  }
+
+  function setText(id, text) {
+    element(id).textContent = text;
+  }
+
+  function connect() {
+    var protocol = location.protocol === "https:" ? "wss://" : "ws://";
+    var socket = new WebSocket(protocol + location.host + "/ws");
+    socket.onopen = function () {
+      setConnection(true);
+    };
+    socket.onclose = function () {
+      setConnection(false);
+      setTimeout(connect, reconnectDelay_ms);
+    };
+    socket.onmessage = function (event) {
+      handle(JSON.parse(event.data));
+      requestDraw();
+    };
+  }
+
+  function setConnection(connected) {
+    var connection = element("connection");
+    connection.textContent = connected ? "Connected" : "Disconnected";
+    connection.className = connected ? "connected" : "disconnected";
+  }
+
+  function handle(message) {
+    if (message.type === "mission") {
+      mission = message;
+    } else if (message.type === "history") {
+      track = message.track || [];
+      trackTime = 0;
+      element("messages").innerHTML = "";
+      addMessages(message.messages);
+    } else if (message.type === "telemetry") {
+      telemetry = message;
+      if (message.position && message.time - trackTime >= trackPeriod_ms) {
+        trackTime = message.time;
+        track.push(message.position);
+        if (track.length > maxTrack) {
+          track.shift();
+        }
+      }
+      addMessages(message.messages);
+      updatePilot();
+      updateServos();
+    }
+  }
+
+  function addMessages(messages) {
+    var list = element("messages");
+    (messages || []).forEach(function (text) {
+      var item = document.createElement("li");
+      item.textContent = text;
+      list.insertBefore(item, list.firstChild);
+    });
+    while (list.childNodes.length > maxMessages) {
+      list.removeChild(list.lastChild);
+    }
+  }
+
+  function updatePilot() {
+    var position = telemetry.position;
+    setText("state", telemetry.state);
+    setText("pilot-state", telemetry.state + (telemetry.landing ? " (landing)" : ""));
+    setText("pilot-gps", telemetry.gpsLock ? "Locked" : "No lock");
+    if (position) {
+      setText("pilot-position", format(position.lat, 5) + ", " + format(position.lon, 5) + " ±" + format(telemetry.uncertainty_m, 0, " m"));
+      setText("pilot-altitude", format(position.alt, 1, " m"));
+      setText("pilot-speed", format(telemetry.speed_mps, 1, " m/s"));
+      setText("pilot-vertical-speed", format(telemetry.verticalSpeed_mps, 1, " m/s"));
+    } else {
+      ["pilot-position", "pilot-altitude", "pilot-speed", "pilot-vertical-speed"].forEach(function (id) {
+        setText(id, "-");
+      });
+    }
+    setText("pilot-heading", format(telemetry.yaw_d, 0, "°"));
+    setText("pilot-roll", format(telemetry.roll_d, 1, "°") + " / " + format(telemetry.targetRoll_d, 1, "°"));
+    setText("pilot-pitch", format(telemetry.pitch_d, 1, "°") + " / " + format(telemetry.targetPitch_d, 1, "°"));
+    var waypoint = String(telemetry.waypointIndex + 1);
+    if (mission) {
+      waypoint += " of " + mission.waypoints.length;
+    }
+    if (position) {
+      waypoint += ", " + format(telemetry.waypointDistance_m, 0, " m");
+    }
+    setText("pilot-waypoint", waypoint);
+    setText("pilot-cross-track", format(telemetry.crossTrackError_m, 1, " m"));
+  }
+
+  function updateServos() {
+    var servos = telemetry.servos;
+    [["left", servos.left_us, servos.leftAngle_d], ["right", servos.right_us, servos.rightAngle_d]].forEach(function (servo) {
+      var fraction = (servo[1] - minPulse_us) / (maxPulse_us - minPulse_us);
+      fraction = Math.max(0, Math.min(1, fraction));
+      element("servo-" + servo[0] + "-bar").style.left = (fraction * 100) + "%";
+      setText("servo-" + servo[0], format(servo[2], 1, "°") + " " + servo[1] + " µs");
+    });
+  }
+
+  function requestDraw() {
+    if (drawPending) {
+      return;
+    }
+    drawPending = true;
+    window.requestAnimationFrame(function () {
+      drawPending = false;
+      drawAttitude();
+      drawMap();
+    });
+  }
+
+  // Matches the canvas resolution to its size on the screen, and returns
+  // the size in CSS pixels
+  function fitCanvas(canvas) {
+    var ratio = window.devicePixelRatio || 1;
+    var width = canvas.clientWidth;
+    var height = canvas.clientHeight;
+    if (canvas.width !== Math.round(width * ratio) || canvas.height !== Math.round(height * ratio)) {
+      canvas.width = Math.round(width * ratio);
+      canvas.height = Math.round(height * ratio);
+    }
+    var context = canvas.getContext("2d");
+    context.setTransform(ratio, 0, 0, ratio, 0, 0);
+    context.clearRect(0, 0, width, height);
+    return {context: context, width: width, height: height};
+  }
+
+  function drawAttitude() {
+    var canvas = fitCanvas(element("attitude"));
+    var context = canvas.context;
+    var radius = Math.min(canvas.width, canvas.height) / 2 - 4;
+    // Show 30 degrees of pitch above and below the horizon
+    var pixelsPerDegree = radius / 30;
+    var roll = telemetry ? telemetry.roll_d : 0;
+    var pitch = telemetry ? telemetry.pitch_d : 0;
+
+    context.save();
+    context.translate(canvas.width / 2, canvas.height / 2);
+
+    // The horizon and pitch ladder move with the plane
+    context.save();
+    context.beginPath();
+    context.arc(0, 0, radius, 0, 2 * Math.PI);
+    context.clip();
+    context.rotate(toRadians(-roll));
+    context.translate(0, pitch * pixelsPerDegree);
+    var extent = 90 * pixelsPerDegree + 2 * radius;
+    context.fillStyle = "#3b78c2";
+    context.fillRect(-2 * radius, -extent, 4 * radius, extent);
+    context.fillStyle = "#7a5230";
+    context.fillRect(-2 * radius, 0, 4 * radius, extent);
+    context.strokeStyle = "#fff";
+    context.fillStyle = "#fff";
+    context.lineWidth = 2;
+    context.beginPath();
+    context.moveTo(-2 * radius, 0);
+    context.lineTo(2 * radius, 0);
+    context.stroke();
+    context.lineWidth = 1;
+    context.font = "11px sans-serif";
+    context.textBaseline = "middle";
+    for (var ladder = -80; ladder <= 80; ladder += 5) {
+      if (ladder === 0) {
+        continue;
+      }
+      var y = -ladder * pixelsPerDegree;
+      var halfWidth = ladder % 10 === 0 ? radius * 0.25 : radius * 0.1;
+      context.beginPath();
+      context.moveTo(-halfWidth, y);
+      context.lineTo(halfWidth, y);
+      context.stroke();
+      if (ladder % 10 === 0) {
+        context.fillText(String(Math.abs(ladder)), halfWidth + 4, y);
+      }
+    }
+    context.restore();
+
+    // The target pitch, relative to the current pitch
+    if (telemetry) {
+      var targetY = -(telemetry.targetPitch_d - pitch) * pixelsPerDegree;
+      targetY = Math.max(-radius, Math.min(radius, targetY));
+      context.strokeStyle = "#dc3";
+      context.lineWidth = 3;
+      context.beginPath();
+      context.moveTo(-radius * 0.75, targetY);
+      context.lineTo(-radius * 0.55, targetY);
+      context.moveTo(radius * 0.55, targetY);
+      context.lineTo(radius * 0.75, targetY);
+      context.stroke();
+    }
+
+    // The roll scale
+    context.strokeStyle = "#fff";
+    context.lineWidth = 2;
+    context.beginPath();
+    context.arc(0, 0, radius, toRadians(-150), toRadians(-30));
+    context.stroke();
+    [-60, -45, -30, -20, -10, 0, 10, 20, 30, 45, 60].forEach(function (tick) {
+      var angle = toRadians(tick - 90);
+      var length = tick % 30 === 0 ? 12 : 7;
+      context.beginPath();
+      context.moveTo(Math.cos(angle) * radius, Math.sin(angle) * radius);
+      context.lineTo(Math.cos(angle) * (radius - length), Math.sin(angle) * (radius - length));
+      context.stroke();
+    });
+    drawRollPointer(context, radius, roll, "#fff");
+    if (telemetry) {
+      drawRollPointer(context, radius, telemetry.targetRoll_d, "#dc3");
+    }
+
+    // The plane stays put
+    context.strokeStyle = "#f90";
+    context.lineWidth = 4;
+    context.beginPath();
+    context.moveTo(-radius * 0.45, 0);
+    context.lineTo(-radius * 0.12, 0);
+    context.lineTo(0, radius * 0.08);
+    context.lineTo(radius * 0.12, 0);
+    context.lineTo(radius * 0.45, 0);
+    context.stroke();
+    context.restore();
+  }
+
+  // Points at the roll on the scale
+  function drawRollPointer(context, radius, roll, color) {
+    context.save();
+    context.rotate(toRadians(-roll));
+    context.fillStyle = color;
+    context.beginPath();
+    context.moveTo(0, -radius + 14);
+    context.lineTo(-7, -radius + 26);
+    context.lineTo(7, -radius + 26);
+    context.closePath();
+    context.fill();
+    context.restore();
+  }
+
+  function drawMap() {
+    var canvas = fitCanvas(element("map"));
+    var context = canvas.context;
+    var waypoints = mission ? mission.waypoints : [];
+    var position = telemetry ? telemetry.position : null;
+    var points = waypoints.concat(track);
+    if (position) {
+      points.push(position);
+    }
+    if (points.length === 0) {
+      context.fillStyle = "#aaa";
+      context.font = "14px sans-serif";
+      context.textAlign = "center";
+      context.fillText("Waiting for a position", canvas.width / 2, canvas.height / 2);
+      return;
+    }
+
+    // Meters north and east of the first point. This is close enough over
+    // the few kilometers that a flight covers.
+    var origin = points[0];
+    var longitudeScale = metersPerDegreeLongitude * Math.cos(toRadians(origin.lat));
+    function project(point) {
+      return {
+        east: (point.lon - origin.lon) * longitudeScale,
+        north: (point.lat - origin.lat) * metersPerDegreeLatitude
+      };
+    }
+    var projected = points.map(project);
+    var minEast = Math.min.apply(null, projected.map(function (p) { return p.east; }));
+    var maxEast = Math.max.apply(null, projected.map(function (p) { return p.east; }));
+    var minNorth = Math.min.apply(null, projected.map(function (p) { return p.north; }));
+    var maxNorth = Math.max.apply(null, projected.map(function (p) { return p.north; }));
+    var padding = 24;
+    var span = Math.max(maxEast - minEast, maxNorth - minNorth, 100);
+    var scale = Math.min(canvas.width - 2 * padding, canvas.height - 2 * padding) / span;
+    var centerEast = (minEast + maxEast) / 2;
+    var centerNorth = (minNorth + maxNorth) / 2;
+    function toScreen(point) {
+      var p = project(point);
+      return {
+        x: canvas.width / 2 + (p.east - centerEast) * scale,
+        y: canvas.height / 2 - (p.north - centerNorth) * scale
+      };
+    }
+
+    // The legs, including the one back to the start of the repeating
+    // waypoints
+    if (waypoints.length > 1) {
+      context.strokeStyle = "#888";
+      context.lineWidth = 1;
+      context.setLineDash([6, 4]);
+      context.beginPath();
+      waypoints.forEach(function (waypoint, i) {
+        var p = toScreen(waypoint);
+        if (i === 0) {
+          context.moveTo(p.x, p.y);
+        } else {
+          context.lineTo(p.x, p.y);
+        }
+      });
+      var repeatStart = toScreen(waypoints[mission.repeatingIndex]);
+      context.lineTo(repeatStart.x, repeatStart.y);
+      context.stroke();
+      context.setLineDash([]);
+    }
+
+    if (track.length > 1) {
+      context.strokeStyle = "#4cd";
+      context.lineWidth = 2;
+      context.beginPath();
+      track.forEach(function (point, i) {
+        var p = toScreen(point);
+        if (i === 0) {
+          context.moveTo(p.x, p.y);
+        } else {
+          context.lineTo(p.x, p.y);
+        }
+      });
+      context.stroke();
+    }
+
+    context.font = "11px sans-serif";
+    context.textAlign = "center";
+    context.textBaseline = "middle";
+    waypoints.forEach(function (waypoint, i) {
+      var p = toScreen(waypoint);
+      var current = telemetry && telemetry.waypointIndex === i;
+      context.fillStyle = current ? "#dc3" : "#555";
+      context.strokeStyle = "#eee";
+      context.lineWidth = 1;
+      context.beginPath();
+      context.arc(p.x, p.y, 9, 0, 2 * Math.PI);
+      context.fill();
+      context.stroke();
+      context.fillStyle = current ? "#000" : "#fff";
+      // The first repeating waypoint is where we land
+      context.fillText(i === mission.repeatingIndex ? "L" : String(i + 1), p.x, p.y);
+    });
+
+    if (position) {
+      var p = toScreen(position);
+      context.save();
+      context.translate(p.x, p.y);
+      context.rotate(toRadians(telemetry.yaw_d));
+      context.fillStyle = "#f90";
+      context.strokeStyle = "#000";
+      context.beginPath();
+      context.moveTo(0, -12);
+      context.lineTo(8, 9);
+      context.lineTo(0, 4);
+      context.lineTo(-8, 9);
+      context.closePath();
+      context.fill();
+      context.stroke();
+      context.restore();
+    }
+
+    drawScaleBar(context, canvas, scale);
+  }
+
+  // Draws a round distance that's about a quarter of the width
+  function drawScaleBar(context, canvas, scale) {
+    var target = canvas.width / 4 / scale;
+    var distance = 1;
+    [1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000, 10000, 20000, 50000].forEach(function (candidate) {
+      if (candidate <= target) {
+        distance = candidate;
+      }
+    });
+    var length = distance * scale;
+    var x = 10;
+    var y = canvas.height - 10;
+    context.strokeStyle = "#eee";
+    context.lineWidth = 2;
+    context.beginPath();
+    context.moveTo(x, y - 5);
+    context.lineTo(x, y);
+    context.lineTo(x + length, y);
+    context.lineTo(x + length, y - 5);
+    context.stroke();
+    context.fillStyle = "#eee";
+    context.textAlign = "left";
+    context.textBaseline = "bottom";
+    context.fillText(distance >= 1000 ? (distance / 1000) + " km" : distance + " m", x + 4, y - 4);
+  }
+
+  window.addEventListener("resize", requestDraw);
+  connect();
+  requestDraw();
+})();
+`
@@ -0,0 +1,167 @@
+package glider
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/bskari/go-glider/websocket"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func newTestGroundStationPilot(t *testing.T) *Pilot {
+	configuration.MissionFile = ""
+	hardware := newFakeHardware()
+	waypoints, err := NewWaypoints()
+	if err != nil {
+		t.Fatalf("Unable to load waypoints: %v", err)
+	}
+	pilot := newPilot(hardware, NewTelemetry(hardware), NewControl(hardware.LeftServo, hardware.RightServo), waypoints)
+	pilot.state = testMode
+	return pilot
+}
+
+func TestGetDashboardMessages(t *testing.T) {
+	_, sequence := getDashboardMessages(0)
+	Logger.Info("first")
+	Logger.Info("second")
+	messages, newSequence := getDashboardMessages(sequence)
+	if len(messages) != 2 || !strings.HasSuffix(messages[0], " first") || !strings.HasSuffix(messages[1], " second") {
+		t.Errorf("Bad messages %v", messages)
+	}
+	if newSequence != sequence+2 {
+		t.Errorf("Expected sequence %d, got %d", sequence+2, newSequence)
+	}
+	if messages, _ := getDashboardMessages(newSequence); len(messages) != 0 {
+		t.Errorf("Shouldn't have any new messages, got %v", messages)
+	}
+}
+
+func TestGroundStationTelemetry(t *testing.T) {
+	loadTestConfiguration(t)
+	defer loadTestConfiguration(t)
+	// The distances cache the longitude multiplier for the first points
+	previousMultiplier := longitudeMultiplier
+	defer func() {
+		longitudeMultiplier = previousMultiplier
+	}()
+	pilot := newTestGroundStationPilot(t)
+	station := newGroundStation()
+	pilot.SetGroundStation(station)
+	pilot.targetRoll_r = ToRadians(10)
+	pilot.control.SetLeft(ToRadians(95))
+
+	telemetry := station.getTelemetry(pilotClock.Now())
+	if telemetry.State != "testMode" || !approximatelyEqual(telemetry.TargetRoll_d, 10) {
+		t.Errorf("Bad telemetry %+v", telemetry)
+	}
+	left_us, right_us := pilot.control.GetPulseWidths()
+	if !approximatelyEqual(telemetry.Servos.LeftAngle_d, 95) || telemetry.Servos.Left_us != left_us || telemetry.Servos.Right_us != right_us {
+		t.Errorf("Bad servos %+v", telemetry.Servos)
+	}
+	// No GPS yet
+	if telemetry.Position != nil {
+		t.Errorf("Shouldn't have a position, got %v", telemetry.Position)
+	}
+
+	station.update()
+	var mission groundStationMission
+	if err := json.Unmarshal(station.mission, &mission); err != nil {
+		t.Fatalf("Bad mission %s: %v", station.mission, err)
+	}
+	if len(mission.Waypoints) != 1 || mission.RepeatingIndex != 0 || mission.Waypoints[0].Latitude != configuration.DefaultWaypointLatitude {
+		t.Errorf("Bad mission %+v", mission)
+	}
+
+	// A new mission is noticed
+	previous := station.mission
+	pilot.waypoints = newWaypointsFromMission(Mission{
+		First:     []Point{{Latitude: 40.0, Longitude: -105.0}},
+		Repeating: []Point{{Latitude: 40.1, Longitude: -105.1}, {Latitude: 40.2, Longitude: -105.2}},
+	})
+	station.update()
+	if string(station.mission) == string(previous) || !strings.Contains(string(station.mission), `"repeatingIndex":1`) {
+		t.Errorf("Should have updated the mission, got %s", station.mission)
+	}
+}
+
+func TestGroundStationServer(t *testing.T) {
+	loadTestConfiguration(t)
+	defer loadTestConfiguration(t)
+	// The distances cache the longitude multiplier for the first points
+	previousMultiplier := longitudeMultiplier
+	defer func() {
+		longitudeMultiplier = previousMultiplier
+	}()
+	configuration.GroundStationAddress = "127.0.0.1:0"
+	pilot := newTestGroundStationPilot(t)
+	station, err := NewGroundStation()
+	if err != nil {
+		t.Fatalf("Unable to start ground station: %v", err)
+	}
+	defer station.Close()
+	pilot.SetGroundStation(station)
+	url := fmt.Sprintf("http://%s", station.Addr())
+
+	for path, asset := range groundStationAssets {
+		response, err := http.Get(url + path)
+		if err != nil {
+			t.Fatalf("Unable to get %s: %v", path, err)
+		}
+		body, _ := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		if response.StatusCode != http.StatusOK || string(body) != asset.content || response.Header.Get("Content-Type") != asset.contentType {
+			t.Errorf("Bad response for %s: %v %s", path, response.StatusCode, response.Header.Get("Content-Type"))
+		}
+	}
+	response, err := http.Get(url + "/missing.js")
+	if err != nil {
+		t.Fatalf("Unable to get: %v", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404, got %v", response.StatusCode)
+	}
+
+	// New clients get the mission and history first
+	station.update()
+	conn, err := websocket.Dial(station.Addr().String(), "/ws")
+	if err != nil {
+		t.Fatalf("Unable to connect: %v", err)
+	}
+	defer conn.Close()
+	read := func() map[string]interface{} {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("Unable to read: %v", err)
+		}
+		message := make(map[string]interface{})
+		if err := json.Unmarshal(data, &message); err != nil {
+			t.Fatalf("Bad message %s: %v", data, err)
+		}
+		return message
+	}
+	for _, expected := range []string{"mission", "history"} {
+		if message := read(); message["type"] != expected {
+			t.Errorf("Expected %s, got %v", expected, message)
+		}
+	}
+
+	Logger.Info("Hello, ground station")
+	station.update()
+	message := read()
+	if message["type"] != "telemetry" || message["state"] != "testMode" {
+		t.Errorf("Bad telemetry %v", message)
+	}
+	if messages, ok := message["messages"].([]interface{}); !ok || !strings.HasSuffix(fmt.Sprint(messages[len(messages)-1]), "Hello, ground station") {
+		t.Errorf("Should have sent the log message, got %v", message["messages"])
+	}
+
+	// Closing disconnects the clients
+	station.Close()
+	if _, _, err := conn.ReadMessage(); err != io.EOF {
+		t.Errorf("Expected EOF, got %v", err)
+	}
+}
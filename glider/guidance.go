@@ -0,0 +1,108 @@
+// L1 path following between waypoints
+package glider
+
+import (
+	"math"
+)
+
+type guidanceMode_t uint8
+
+const (
+	// Steer straight at the next waypoint
+	GUIDANCE_MODE_DIRECT guidanceMode_t = iota
+	// Follow the line from the previous waypoint to the next one
+	GUIDANCE_MODE_L1
+)
+
+func (mode guidanceMode_t) String() string {
+	return []string{"direct", "l1"}[mode]
+}
+
+const gravity_mps2 = 9.80665
+
+// Below this, the L1 distance gets too short to be useful, e.g. before we
+// have a good velocity estimate
+const l1MinimumSpeed MetersPerSecond = 5.0
+
+// Returns the north and east offset of point from origin
+func toNorthEast(origin, point Point) (Meters, Meters) {
+	return latitudeDistance(origin.Latitude, point.Latitude), longitudeDistance(origin, point)
+}
+
+// Returns the point that is north and east of origin
+func fromNorthEast(origin Point, north, east Meters) Point {
+	return Point{
+		Latitude:  origin.Latitude + ToDegrees(north/RADIUS_M),
+		Longitude: origin.Longitude + ToDegrees(east/(RADIUS_M*math.Cos(ToCoordinateRadians(origin.Latitude)))),
+		Altitude:  origin.Altitude,
+	}
+}
+
+// Returns the cross track error, positive when we're right of the line from
+// start to end, and the distance along the track from start
+func getTrackErrors(start, end, position Point) (Meters, Meters) {
+	trackNorth, trackEast := toNorthEast(start, end)
+	length := math.Sqrt(trackNorth*trackNorth + trackEast*trackEast)
+	north, east := toNorthEast(start, position)
+	if length < 0.1 {
+		return 0, math.Sqrt(north*north + east*east)
+	}
+	trackNorth /= length
+	trackEast /= length
+	crossTrack := east*trackNorth - north*trackEast
+	alongTrack := north*trackNorth + east*trackEast
+	return crossTrack, alongTrack
+}
+
+// Computes the roll needed to follow the line from start to end using the L1
+// guidance law, like ArduPilot's. Also returns the cross track error, positive
+// when we're right of the track.
+func getTargetRollL1(estimate PositionEstimate, yaw_r Radians, start, end Point) (Radians, Meters) {
+	speed, course_r := estimate.GetCourse()
+	// If we're barely moving, the GPS course is noise, so use the compass
+	if speed < 1.0 {
+		course_r = yaw_r
+	}
+	speed = math.Max(speed, l1MinimumSpeed)
+	velocityNorth := speed * math.Cos(course_r)
+	velocityEast := speed * math.Sin(course_r)
+
+	period := configuration.GuidancePeriod.Seconds()
+	damping := configuration.GuidanceDamping
+	l1Distance := damping * period * speed / math.Pi
+	gain := 4.0 * damping * damping
+
+	crossTrack, alongTrack := getTrackErrors(start, end, estimate.Point)
+	trackNorth, trackEast := toNorthEast(start, end)
+	length := math.Sqrt(trackNorth*trackNorth + trackEast*trackEast)
+
+	var eta Radians
+	fromStartNorth, fromStartEast := toNorthEast(start, estimate.Point)
+	distanceFromStart := math.Sqrt(fromStartNorth*fromStartNorth + fromStartEast*fromStartEast)
+	if length < 0.1 || (alongTrack < 0 && distanceFromStart > l1Distance) {
+		// We're well behind the start of the leg, or there is no leg, so
+		// just head to the nearest end
+		target := end
+		if length >= 0.1 {
+			target = start
+		}
+		toTargetNorth, toTargetEast := toNorthEast(estimate.Point, target)
+		eta = GetAngleTo(course_r, math.Atan2(toTargetEast, toTargetNorth))
+	} else {
+		trackNorth /= length
+		trackEast /= length
+		// Angle between our velocity and the track
+		crossTrackVelocity := velocityEast*trackNorth - velocityNorth*trackEast
+		alongTrackVelocity := velocityNorth*trackNorth + velocityEast*trackEast
+		eta2 := math.Atan2(crossTrackVelocity, alongTrackVelocity)
+		// Angle from the track to the L1 point
+		sinEta1 := clamp(crossTrack/math.Max(l1Distance, 0.1), -math.Sqrt2/2, math.Sqrt2/2)
+		eta = -(math.Asin(sinEta1) + eta2)
+	}
+	eta = clamp(eta, -math.Pi/2, math.Pi/2)
+
+	lateralAcceleration := gain * speed * speed / l1Distance * math.Sin(eta)
+	targetRoll_r := math.Atan(lateralAcceleration / gravity_mps2)
+	targetRoll_r = clamp(targetRoll_r, -configuration.MaxTargetRoll, configuration.MaxTargetRoll)
+	return targetRoll_r, crossTrack
+}
@@ -0,0 +1,124 @@
+package glider
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func setTestGuidanceConfiguration() {
+	configuration.GuidancePeriod = 17 * time.Second
+	configuration.GuidanceDamping = 0.75
+	configuration.MaxTargetRoll = ToRadians(25)
+	configuration.WaypointReachedDistance = 20.0
+}
+
+// Returns a point offset north and east of the origin, in meters
+func offsetPoint(origin Point, north, east Meters) Point {
+	return Point{
+		Latitude:  origin.Latitude + ToDegrees(north/RADIUS_M),
+		Longitude: origin.Longitude + ToDegrees(east/(RADIUS_M*math.Cos(ToCoordinateRadians(origin.Latitude)))),
+	}
+}
+
+func getTestEstimate(position Point, speed MetersPerSecond, course_d Degrees) PositionEstimate {
+	return PositionEstimate{
+		Point:         position,
+		VelocityNorth: speed * math.Cos(ToRadians(course_d)),
+		VelocityEast:  speed * math.Sin(ToRadians(course_d)),
+		Valid:         true,
+	}
+}
+
+func TestGetTargetRollL1(t *testing.T) {
+	setTestGuidanceConfiguration()
+	start := Point{Latitude: 40.0, Longitude: -105.0}
+	end := offsetPoint(start, 1000, 0)
+
+	// On the track and heading along it
+	roll_r, crossTrack := getTargetRollL1(getTestEstimate(offsetPoint(start, 100, 0), 10, 0), 0, start, end)
+	if math.Abs(ToDegrees(roll_r)) > 0.5 || math.Abs(crossTrack) > 0.5 {
+		t.Errorf("Bad on track roll %0.1f cross track %0.1f", ToDegrees(roll_r), crossTrack)
+	}
+
+	// Right of the track, so turn left
+	roll_r, crossTrack = getTargetRollL1(getTestEstimate(offsetPoint(start, 100, 30), 10, 0), 0, start, end)
+	if roll_r >= 0 {
+		t.Errorf("Bad right of track roll %0.1f", ToDegrees(roll_r))
+	}
+	if math.Abs(crossTrack-30) > 0.5 {
+		t.Errorf("Bad cross track %0.1f", crossTrack)
+	}
+
+	// Left of the track, so turn right
+	roll_r, crossTrack = getTargetRollL1(getTestEstimate(offsetPoint(start, 100, -30), 10, 0), 0, start, end)
+	if roll_r <= 0 || crossTrack >= 0 {
+		t.Errorf("Bad left of track roll %0.1f cross track %0.1f", ToDegrees(roll_r), crossTrack)
+	}
+
+	// On the track, but being blown across it, so turn into the wind
+	roll_r, _ = getTargetRollL1(getTestEstimate(offsetPoint(start, 100, 0), 10, 30), 0, start, end)
+	if roll_r >= 0 {
+		t.Errorf("Bad crabbing roll %0.1f", ToDegrees(roll_r))
+	}
+
+	// Way off course should be limited
+	roll_r, _ = getTargetRollL1(getTestEstimate(offsetPoint(start, 100, 500), 10, 90), 0, start, end)
+	if !approximatelyEqual(roll_r, -configuration.MaxTargetRoll) {
+		t.Errorf("Bad limited roll %0.1f", ToDegrees(roll_r))
+	}
+}
+
+func TestGetTargetRollL1Converges(t *testing.T) {
+	setTestGuidanceConfiguration()
+	start := Point{Latitude: 40.0, Longitude: -105.0}
+	end := offsetPoint(start, 5000, 0)
+
+	// Simple coordinated turn model, starting off the track
+	north := 0.0
+	east := 100.0
+	course_r := 0.0
+	const speed = 10.0
+	const dt = 0.1
+	for i := 0; i < 1000; i++ {
+		position := offsetPoint(start, north, east)
+		roll_r, _ := getTargetRollL1(getTestEstimate(position, speed, ToDegrees(course_r)), course_r, start, end)
+		course_r += gravity_mps2 * math.Tan(roll_r) / speed * dt
+		north += speed * math.Cos(course_r) * dt
+		east += speed * math.Sin(course_r) * dt
+	}
+	if math.Abs(east) > 2.0 {
+		t.Errorf("Didn't converge to the track, %0.1f m off", east)
+	}
+}
+
+func TestLegCompleted(t *testing.T) {
+	setTestGuidanceConfiguration()
+	// The distances cache the longitude multiplier for the first points
+	previousMultiplier := longitudeMultiplier
+	defer func() {
+		longitudeMultiplier = previousMultiplier
+	}()
+	start := Point{Latitude: 40.0, Longitude: -105.0}
+	end := offsetPoint(start, 1000, 0)
+	waypoints := newWaypointsFromMission(Mission{Repeating: []Point{end, start}})
+
+	if waypoints.LegCompleted(start) {
+		t.Error("Leg completed at the start")
+	}
+	// Blown way off the leg, but not past the waypoint yet
+	if waypoints.LegCompleted(offsetPoint(start, 990, 200)) {
+		t.Error("Leg completed before the waypoint")
+	}
+	// Passed the perpendicular, even though we never got close
+	if !waypoints.LegCompleted(offsetPoint(start, 1010, 200)) {
+		t.Error("Leg not completed after passing the waypoint")
+	}
+
+	// The next leg should start at the waypoint
+	waypoints.Next()
+	legStart, legEnd := waypoints.GetLeg(offsetPoint(start, 1010, 200))
+	if Distance(legStart, end) > 0.1 || Distance(legEnd, start) > 0.1 {
+		t.Errorf("Bad next leg %v %v", legStart, legEnd)
+	}
+}
@@ -0,0 +1,477 @@
+// Interfaces to the hardware, so that the pilot can run on a Pi, on a laptop
+// with fake hardware, or in the simulator
+package glider
+
+import (
+	"errors"
+	"github.com/argandas/serial"
+	"github.com/stianeikeland/go-rpio/v4"
+	"io"
+	"io/ioutil"
+	"periph.io/x/periph/conn/i2c/i2creg"
+	"periph.io/x/periph/host"
+	"sync"
+	"time"
+)
+
+type hardware_t uint8
+
+const (
+	// A Pi if we're running on one, otherwise fake
+	HARDWARE_AUTO hardware_t = iota + 1
+	HARDWARE_PI
+	HARDWARE_FAKE
+)
+
+func (hardware hardware_t) String() string {
+	return []string{
+		"(unused-0-hardware)",
+		"auto",
+		"pi",
+		"fake",
+	}[hardware]
+}
+
+type cutdown_t uint8
+
+const (
+	CUTDOWN_NONE cutdown_t = iota + 1
+	CUTDOWN_SERVO
+	CUTDOWN_GPIO
+)
+
+func (cutdown cutdown_t) String() string {
+	return []string{
+		"(unused-0-cutdown)",
+		"none",
+		"servo",
+		"gpio",
+	}[cutdown]
+}
+
+// Something we can drive a servo with
+type servoOutput interface {
+	SetPulseWidth(width_us uint32) error
+}
+
+// Something we can read a button from, like an rpio.Pin
+type digitalInput interface {
+	Read() rpio.State
+}
+
+// Something that releases the glider from a balloon, like a burn wire or a
+// servo that pulls a pin
+type cutdownOutput interface {
+	Set(active bool) error
+}
+
+type statusLed interface {
+	Set(on bool) error
+	Toggle() error
+}
+
+// Everything that the pilot reads from or drives
+type Hardware struct {
+	LeftServo     servoOutput
+	RightServo    servoOutput
+	Button        digitalInput
+	Led           statusLed
+	Cutdown       cutdownOutput
+	Gps           serialInterface
+	Accelerometer sensor
+	Magnetometer  sensor
+	Gyroscope     sensor
+	closers       []io.Closer
+	// Set once the outputs are shared with the shutdown
+	guard *outputGuard
+}
+
+// Opens the hardware picked by the configuration
+func NewHardware() (*Hardware, error) {
+	hardware := configuration.Hardware
+	if hardware == HARDWARE_AUTO {
+		if IsPi() {
+			hardware = HARDWARE_PI
+		} else {
+			hardware = HARDWARE_FAKE
+		}
+	}
+	Logger.Infof("Using %v hardware", hardware)
+	if hardware == HARDWARE_PI {
+		return newPiHardware()
+	}
+	return newFakeHardware(), nil
+}
+
+// Releases the hardware, in the reverse order that it was opened
+func (hardware *Hardware) Close() error {
+	if hardware.guard != nil {
+		// Closing the cutdown turns it off, which the pilot might be doing
+		hardware.guard.mutex.Lock()
+		defer hardware.guard.mutex.Unlock()
+	}
+	var firstErr error
+	for i := len(hardware.closers) - 1; i >= 0; i-- {
+		err := hardware.closers[i].Close()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	hardware.closers = nil
+	return firstErr
+}
+
+// The shutdown can move the servos to failsafe from the signal goroutine
+// while the pilot is still running, so this makes the servo and cutdown
+// commands take turns. Once the shutdown holds the outputs, the pilot's
+// commands are dropped, so that it can't undo the failsafe.
+type outputGuard struct {
+	mutex sync.Mutex
+	held  bool
+	// The outputs without the guard, for the shutdown
+	left    servoOutput
+	right   servoOutput
+	cutdown cutdownOutput
+}
+
+var errOutputsHeld = errors.New("The outputs are held for shutting down")
+
+type guardedServo struct {
+	guard *outputGuard
+	servo servoOutput
+}
+
+func (servo *guardedServo) SetPulseWidth(width_us uint32) error {
+	servo.guard.mutex.Lock()
+	defer servo.guard.mutex.Unlock()
+	if servo.guard.held {
+		return errOutputsHeld
+	}
+	return servo.servo.SetPulseWidth(width_us)
+}
+
+type guardedCutdown struct {
+	guard   *outputGuard
+	cutdown cutdownOutput
+}
+
+func (cutdown *guardedCutdown) Set(active bool) error {
+	cutdown.guard.mutex.Lock()
+	defer cutdown.guard.mutex.Unlock()
+	if cutdown.guard.held {
+		return errOutputsHeld
+	}
+	return cutdown.cutdown.Set(active)
+}
+
+// Puts the servos and cutdown behind an outputGuard. Call this before handing
+// the outputs to anything else.
+func (hardware *Hardware) guardOutputs() {
+	if hardware.guard != nil {
+		return
+	}
+	guard := &outputGuard{
+		left:    hardware.LeftServo,
+		right:   hardware.RightServo,
+		cutdown: hardware.Cutdown,
+	}
+	hardware.guard = guard
+	hardware.LeftServo = &guardedServo{guard: guard, servo: guard.left}
+	hardware.RightServo = &guardedServo{guard: guard, servo: guard.right}
+	if guard.cutdown != nil {
+		hardware.Cutdown = &guardedCutdown{guard: guard, cutdown: guard.cutdown}
+	}
+}
+
+type closerFunc func() error
+
+func (close closerFunc) Close() error {
+	return close()
+}
+
+func newPiHardware() (*Hardware, error) {
+	hardware := &Hardware{}
+	err := rpio.Open()
+	if err != nil {
+		return nil, err
+	}
+	hardware.closers = append(hardware.closers, closerFunc(rpio.Close))
+
+	// Make sure periph is initialized.
+	if _, err := host.Init(); err != nil {
+		hardware.Close()
+		return nil, err
+	}
+
+	rawGps := serial.New()
+	rawGps.Verbose = false
+	err = rawGps.Open(configuration.GpsTty, configuration.GpsBitRate)
+	if err != nil {
+		hardware.Close()
+		return nil, err
+	}
+	hardware.closers = append(hardware.closers, closerFunc(rawGps.Close))
+	hardware.Gps = &concreteSerial{ser: rawGps}
+
+	bus, err := i2creg.Open("")
+	if err != nil {
+		hardware.Close()
+		return nil, err
+	}
+	hardware.closers = append(hardware.closers, bus)
+	accelerometer, err := NewAdxl345(bus)
+	if err != nil {
+		hardware.Close()
+		return nil, err
+	}
+	magnetometer, err := NewHmc5883L(bus)
+	if err != nil {
+		hardware.Close()
+		return nil, err
+	}
+	gyroscope, err := NewItg3200(bus)
+	if err != nil {
+		hardware.Close()
+		return nil, err
+	}
+	hardware.Accelerometer = accelerometer
+	hardware.Magnetometer = magnetometer
+	hardware.Gyroscope = gyroscope
+
+	hardware.LeftServo = newPiServo(configuration.LeftServoPin)
+	hardware.RightServo = newPiServo(configuration.RightServoPin)
+	button := rpio.Pin(configuration.ButtonPin)
+	button.Input()
+	button.PullUp()
+	hardware.Button = &button
+	hardware.Led = &piLed{}
+
+	switch configuration.CutdownType {
+	case CUTDOWN_SERVO:
+		cutdown := newPiServoCutdown(configuration.CutdownPin)
+		hardware.closers = append(hardware.closers, cutdown)
+		hardware.Cutdown = cutdown
+	case CUTDOWN_GPIO:
+		cutdown := newPiGpioCutdown(configuration.CutdownPin)
+		hardware.closers = append(hardware.closers, cutdown)
+		hardware.Cutdown = cutdown
+	default:
+		hardware.Cutdown = &fakeCutdown{}
+	}
+	return hardware, nil
+}
+
+// Hardware that does nothing, but reads like a glider sitting level and still,
+// pointed at magnetic north, without a GPS fix
+func newFakeHardware() *Hardware {
+	accelerometer := accelerometerRawFromAirframe(vector3{0, 0, 1})
+	// Roughly the field in Boulder, in nT
+	magnetometer, _ := magnetometerRawFromAirframe(vector3{0, 21000, -48000})
+	return &Hardware{
+		LeftServo:     &fakeServo{},
+		RightServo:    &fakeServo{},
+		Button:        &fakeDigitalInput{state: rpio.High},
+		Led:           &fakeLed{},
+		Cutdown:       &fakeCutdown{},
+		Gps:           &fakeGps{},
+		Accelerometer: newFakeImuSensor(accelerometer),
+		Magnetometer:  newFakeImuSensor(magnetometer),
+		Gyroscope:     newFakeImuSensor(vector3{0, 0, 0}),
+	}
+}
+
+type piServo struct {
+	pin rpio.Pin
+}
+
+func newPiServo(pinNumber uint8) *piServo {
+	pin := rpio.Pin(pinNumber)
+	pin.Pwm()
+	// Param freq should be in range 4688Hz - 19.2MHz to prevent
+	// unexpected behavior
+	pin.Freq(HERTZ * MULTIPLIER)
+	return &piServo{pin: pin}
+}
+
+func (servo *piServo) SetPulseWidth(width_us uint32) error {
+	// Output frequency is computed as pwm clock frequency divided by cycle length.
+	// So, to set Pwm pin to freqency 38kHz with duty cycle 1/4, use this combination:
+	//  pin.DutyCycle(1, 4)
+	//  pin.Freq(38000*4)
+	servo.pin.DutyCycle(getDutyCycleForUs(width_us), MULTIPLIER)
+	return nil
+}
+
+// Drives a burn wire, e.g. through a MOSFET
+type piGpioCutdown struct {
+	pin rpio.Pin
+}
+
+func newPiGpioCutdown(pinNumber uint8) *piGpioCutdown {
+	pin := rpio.Pin(pinNumber)
+	pin.Output()
+	pin.Low()
+	return &piGpioCutdown{pin: pin}
+}
+
+func (cutdown *piGpioCutdown) Set(active bool) error {
+	if active {
+		cutdown.pin.High()
+	} else {
+		cutdown.pin.Low()
+	}
+	return nil
+}
+
+func (cutdown *piGpioCutdown) Close() error {
+	return cutdown.Set(false)
+}
+
+// Drives a release servo. Both of the Pi's PWM channels are used by the
+// ailerons, so this bit bangs the pulses, and only while it's active. Servos
+// hold still without a signal, so arm it by hand before launch.
+type piServoCutdown struct {
+	pin  rpio.Pin
+	stop chan struct{}
+}
+
+func newPiServoCutdown(pinNumber uint8) *piServoCutdown {
+	pin := rpio.Pin(pinNumber)
+	pin.Output()
+	pin.Low()
+	return &piServoCutdown{pin: pin}
+}
+
+func (cutdown *piServoCutdown) Set(active bool) error {
+	if active && cutdown.stop == nil {
+		cutdown.stop = make(chan struct{})
+		go cutdown.pulse(cutdown.stop)
+	} else if !active && cutdown.stop != nil {
+		close(cutdown.stop)
+		cutdown.stop = nil
+	}
+	return nil
+}
+
+func (cutdown *piServoCutdown) pulse(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Second / HERTZ)
+	defer ticker.Stop()
+	width := time.Duration(configuration.CutdownRelease_us) * time.Microsecond
+	for {
+		// time.Sleep can run long, so the pulse jitters. Servos mostly
+		// cope, but see CutdownType in conf.toml.
+		cutdown.pin.High()
+		time.Sleep(width)
+		cutdown.pin.Low()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cutdown *piServoCutdown) Close() error {
+	return cutdown.Set(false)
+}
+
+type piLed struct {
+	enabled bool
+	on      bool
+}
+
+func (led *piLed) initialize() error {
+	if !led.enabled {
+		err := ioutil.WriteFile("/sys/class/leds/led0/trigger", []byte("gpio"), 0644)
+		if err != nil {
+			return err
+		}
+		led.enabled = true
+	}
+	return nil
+}
+
+func (led *piLed) Set(on bool) error {
+	err := led.initialize()
+	if err != nil {
+		return err
+	}
+
+	// The Pi Zero is reversed, because the power LED doubles as the activity
+	// LED, so when there's activity, it's _off_
+	ledValue := "0"
+	if on {
+		ledValue = "1"
+	}
+	led.on = on
+
+	return ioutil.WriteFile("/sys/class/leds/led0/brightness", []byte(ledValue), 0644)
+}
+
+func (led *piLed) Toggle() error {
+	return led.Set(!led.on)
+}
+
+type fakeServo struct {
+	width_us uint32
+}
+
+func (servo *fakeServo) SetPulseWidth(width_us uint32) error {
+	servo.width_us = width_us
+	return nil
+}
+
+type fakeCutdown struct {
+	active bool
+}
+
+func (cutdown *fakeCutdown) Set(active bool) error {
+	cutdown.active = active
+	return nil
+}
+
+type fakeDigitalInput struct {
+	state rpio.State
+}
+
+func (input *fakeDigitalInput) Read() rpio.State {
+	return input.state
+}
+
+type fakeLed struct {
+	on bool
+}
+
+func (led *fakeLed) Set(on bool) error {
+	led.on = on
+	return nil
+}
+
+func (led *fakeLed) Toggle() error {
+	led.on = !led.on
+	return nil
+}
+
+// A GPS that never has anything to say
+type fakeGps struct{}
+
+func (*fakeGps) Available() int {
+	return 0
+}
+
+func (*fakeGps) ReadLine() (string, error) {
+	return "", io.EOF
+}
+
+// Always reads the same thing
+type fakeImuSensor struct {
+	x, y, z int16
+}
+
+func newFakeImuSensor(raw vector3) *fakeImuSensor {
+	return &fakeImuSensor{x: toRaw(raw[0]), y: toRaw(raw[1]), z: toRaw(raw[2])}
+}
+
+func (sensor *fakeImuSensor) SenseRaw() (int16, int16, int16, error) {
+	return sensor.x, sensor.y, sensor.z, nil
+}
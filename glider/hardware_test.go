@@ -0,0 +1,55 @@
+package glider
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFakeHardware(t *testing.T) {
+	setTestAccelerometerConfiguration()
+	configuration.MagnetometerHardIron = vector3{0, 0, 0}
+	configuration.MagnetometerSoftIron = [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+	configuration.AhrsFilter = AHRS_FILTER_RAW
+	configuration.Hardware = HARDWARE_FAKE
+	hardware, err := NewHardware()
+	if err != nil {
+		t.Fatalf("Unable to create fake hardware: %v", err)
+	}
+	defer hardware.Close()
+
+	telemetry := NewTelemetry(hardware)
+	axes, err := telemetry.GetAxes()
+	if err != nil {
+		t.Fatalf("Unable to read axes: %v", err)
+	}
+	if math.Abs(axes.Pitch) > ToRadians(1) || math.Abs(axes.Roll) > ToRadians(1) {
+		t.Errorf("Bad pitch %v or roll %v", ToDegrees(axes.Pitch), ToDegrees(axes.Roll))
+	}
+	parsed, err := telemetry.ParseQueuedMessage()
+	if parsed || err != nil {
+		t.Errorf("Bad GPS message %v %v", parsed, err)
+	}
+
+	configuration.LeftServoCenter_us = 1430
+	configuration.RightServoCenter_us = 1430
+	control := NewControl(hardware.LeftServo, hardware.RightServo)
+	control.SetLeft(ToRadians(90))
+	control.SetRight(ToRadians(100))
+	left := hardware.LeftServo.(*fakeServo)
+	right := hardware.RightServo.(*fakeServo)
+	if left.width_us != 1430 {
+		t.Errorf("Bad left pulse width %v", left.width_us)
+	}
+	if right.width_us <= left.width_us {
+		t.Errorf("Bad right pulse width %v", right.width_us)
+	}
+	if control.SetLeft(ToRadians(10)) == nil {
+		t.Error("Should have rejected a bad angle")
+	}
+
+	statusIndicator := NewLedStatusIndicator(hardware.Led, 1)
+	statusIndicator.Reset()
+	if hardware.Led.(*fakeLed).on {
+		t.Error("LED should be off after reset")
+	}
+}
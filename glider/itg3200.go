@@ -0,0 +1,183 @@
+package glider
+
+import (
+	"encoding/binary"
+	"fmt"
+	"periph.io/x/periph/conn/i2c"
+	"periph.io/x/periph/conn/mmr"
+	"periph.io/x/periph/conn/physic"
+	"time"
+)
+
+// Digital low pass filter bandwidths. The internal sample rate is 8 kHz for
+// the 256 Hz setting and 1 kHz for everything else.
+type Itg3200LowPassFilter int
+
+const (
+	ITG3200_LOW_PASS_FILTER_256HZ Itg3200LowPassFilter = 0b000
+	ITG3200_LOW_PASS_FILTER_188HZ                      = 0b001
+	ITG3200_LOW_PASS_FILTER_98HZ                       = 0b010
+	ITG3200_LOW_PASS_FILTER_42HZ                       = 0b011
+	ITG3200_LOW_PASS_FILTER_20HZ                       = 0b100
+	ITG3200_LOW_PASS_FILTER_10HZ                       = 0b101
+	ITG3200_LOW_PASS_FILTER_5HZ                        = 0b110
+)
+
+// Clock sources
+type Itg3200ClockSource int
+
+const (
+	ITG3200_CLOCK_INTERNAL Itg3200ClockSource = 0b000
+	// The data sheet recommends using one of the gyro references for
+	// better stability
+	ITG3200_CLOCK_PLL_X_GYRO = 0b001
+	ITG3200_CLOCK_PLL_Y_GYRO = 0b010
+	ITG3200_CLOCK_PLL_Z_GYRO = 0b011
+)
+
+type Itg3200 struct {
+	Mmr mmr.Dev8
+}
+
+func NewItg3200(bus i2c.Bus) (*Itg3200, error) {
+	device := &Itg3200{
+		Mmr: mmr.Dev8{
+			Conn: &i2c.Dev{Bus: bus, Addr: uint16(ITG3200_ADDRESS)},
+			// The data registers are high byte first
+			Order: binary.BigEndian,
+		},
+	}
+	chipId, err := device.Mmr.ReadUint8(ITG3200_WHO_AM_I)
+	if err != nil {
+		return nil, err
+	}
+	// Only bits 6-1 hold the ID, bit 0 is the AD0 pin
+	if chipId&0b0111_1110 != 0x68 {
+		return nil, fmt.Errorf("No ITG3200 detected: %v", chipId)
+	}
+
+	// Reset the device so that we start from a known state
+	err = device.Mmr.WriteUint8(ITG3200_PWR_MGM, 0b1000_0000)
+	if err != nil {
+		return nil, err
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	err = device.SetClockSource(ITG3200_CLOCK_PLL_X_GYRO)
+	if err != nil {
+		return nil, err
+	}
+	// 1 kHz / (9 + 1) = 100 Hz
+	err = device.SetSampleRateDivider(9)
+	if err != nil {
+		return nil, err
+	}
+	err = device.SetLowPassFilter(ITG3200_LOW_PASS_FILTER_42HZ)
+	if err != nil {
+		return nil, err
+	}
+
+	return device, nil
+}
+
+func (a *Itg3200) SetClockSource(source Itg3200ClockSource) error {
+	value, err := a.Mmr.ReadUint8(ITG3200_PWR_MGM)
+	if err != nil {
+		return err
+	}
+	value = value & 0b1111_1000
+	value = value | uint8(source)
+	err = a.Mmr.WriteUint8(ITG3200_PWR_MGM, value)
+	if err != nil {
+		return err
+	}
+	// The PLL takes a while to settle
+	time.Sleep(50 * time.Millisecond)
+	return nil
+}
+
+// The sample rate is the internal rate / (divider + 1)
+func (a *Itg3200) SetSampleRateDivider(divider uint8) error {
+	err := a.Mmr.WriteUint8(ITG3200_SMPLRT_DIV, divider)
+	if err != nil {
+		return err
+	}
+	time.Sleep(20 * time.Millisecond)
+	return nil
+}
+
+func (a *Itg3200) SetLowPassFilter(filter Itg3200LowPassFilter) error {
+	// Bits 4 and 3 are FS_SEL, which must be set to 0b11 for proper
+	// operation. Bits 2-0 are the low pass filter configuration. The
+	// upper bits are unused, so we can just clobber the whole register.
+	value := uint8(0b0001_1000) | uint8(filter)
+	err := a.Mmr.WriteUint8(ITG3200_DLPF_FS, value)
+	if err != nil {
+		return err
+	}
+	time.Sleep(20 * time.Millisecond)
+	return nil
+}
+
+func (a *Itg3200) SenseRaw() (int16, int16, int16, error) {
+	var buffer [6]byte
+	err := a.Mmr.Conn.Tx([]byte{ITG3200_GYRO_XOUT_H}, buffer[:])
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	x := int16(binary.BigEndian.Uint16(buffer[0:2]))
+	y := int16(binary.BigEndian.Uint16(buffer[2:4]))
+	z := int16(binary.BigEndian.Uint16(buffer[4:6]))
+	return x, y, z, nil
+}
+
+func (a *Itg3200) Sense() (RadiansPerSecond, RadiansPerSecond, RadiansPerSecond, error) {
+	xRaw, yRaw, zRaw, err := a.SenseRaw()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return itg3200RawToRadiansPerSecond(xRaw), itg3200RawToRadiansPerSecond(yRaw), itg3200RawToRadiansPerSecond(zRaw), nil
+}
+
+func (a *Itg3200) SenseTemperature() (physic.Temperature, error) {
+	raw, err := a.Mmr.ReadUint16(ITG3200_TEMP_OUT_H)
+	if err != nil {
+		return 0, err
+	}
+	return itg3200RawToTemperature(int16(raw)), nil
+}
+
+func itg3200RawToRadiansPerSecond(raw int16) RadiansPerSecond {
+	return ToRadians(float64(raw) / ITG3200_LSB_PER_DEGREE_PER_SECOND)
+}
+
+func itg3200RawToTemperature(raw int16) physic.Temperature {
+	// From the data sheet: -13200 is 35 C, and there are 280 LSB per C
+	celsius := 35.0 + (float64(raw)+13200.0)/280.0
+	return physic.ZeroCelsius + physic.Temperature(celsius*float64(physic.Celsius))
+}
+
+// ITG3200 registers
+const (
+	// Copied from the data sheet. Unused values are commented out.
+	ITG3200_WHO_AM_I   = 0x00
+	ITG3200_SMPLRT_DIV = 0x15
+	ITG3200_DLPF_FS    = 0x16
+	//ITG3200_INT_CFG = 0x17
+	//ITG3200_INT_STATUS = 0x1A
+	ITG3200_TEMP_OUT_H  = 0x1B
+	ITG3200_TEMP_OUT_L  = 0x1C
+	ITG3200_GYRO_XOUT_H = 0x1D
+	ITG3200_GYRO_XOUT_L = 0x1E
+	ITG3200_GYRO_YOUT_H = 0x1F
+	ITG3200_GYRO_YOUT_L = 0x20
+	ITG3200_GYRO_ZOUT_H = 0x21
+	ITG3200_GYRO_ZOUT_L = 0x22
+	ITG3200_PWR_MGM     = 0x3E
+)
+
+// AD0 is pulled low on the Sparkfun 9DOF stick
+const ITG3200_ADDRESS = 0x68
+
+// The typical scale factor in LSB/(degrees/s)
+const ITG3200_LSB_PER_DEGREE_PER_SECOND = 14.375
@@ -0,0 +1,246 @@
+// Lands over a target: spirals down over it, lines up a final approach into
+// the wind, then flares
+package glider
+
+import (
+	"fmt"
+	"math"
+)
+
+type landingPhase_t uint8
+
+const (
+	// Circling the target, which also brings us to it from afar
+	LANDING_PHASE_SPIRAL landingPhase_t = iota + 1
+	// Gliding straight at the target into the wind
+	LANDING_PHASE_FINAL
+	// Wings level and nose up, just above the ground
+	LANDING_PHASE_FLARE
+)
+
+func (phase landingPhase_t) String() string {
+	return []string{
+		"(unused-0-phase)",
+		"spiral",
+		"final",
+		"flare",
+	}[phase]
+}
+
+// Below this, we can't tell which way the wind is blowing, so we just head
+// straight in
+const landingCalmWindSpeed MetersPerSecond = 1.0
+
+// We still hold the final approach course while flaring, but gently
+const landingFlareMaxRoll_d = 10.0
+
+type Landing struct {
+	target Point
+	phase  landingPhase_t
+	wind   windEstimator
+	// Where the final approach starts, downwind of the target
+	gate Point
+}
+
+func newLanding(target Point) *Landing {
+	return &Landing{
+		target: target,
+		phase:  LANDING_PHASE_SPIRAL,
+	}
+}
+
+// Returns the target roll and pitch for the current phase, moving on to the
+// next phase when it's time
+func (landing *Landing) update(estimate PositionEstimate, yaw_r Radians) (Radians, Radians) {
+	height := estimate.Altitude - configuration.LandingPointAltitude
+	landing.wind.update(estimate)
+
+	// Wait until we're downwind of the target to turn onto final. If we get
+	// too low first, head straight in from wherever we are.
+	if landing.phase == LANDING_PHASE_SPIRAL && height <= configuration.LandingFinalAltitude {
+		if landing.isLinedUp(estimate.Point) {
+			landing.gate = landing.getGate(estimate.Point)
+		} else if height <= configuration.LandingFinalAltitude*0.5 {
+			landing.gate = landing.getGateFrom(estimate.Point)
+		}
+		if landing.gate != (Point{}) {
+			landing.phase = LANDING_PHASE_FINAL
+			Logger.Infof("Starting final approach from %v at %0.1f m, wind %v", landing.gate, height, landing.wind)
+		}
+	}
+	if landing.phase != LANDING_PHASE_FLARE && height <= configuration.LandingFlareAltitude {
+		landing.phase = LANDING_PHASE_FLARE
+		Logger.Infof("Flaring at %0.1f m", height)
+	}
+
+	if landing.phase == LANDING_PHASE_SPIRAL {
+		return getTargetRollLoiter(estimate, yaw_r, landing.target, configuration.LandingSpiralRadius), configuration.TargetPitch
+	}
+	targetRoll_r, _ := getTargetRollL1(estimate, yaw_r, landing.gate, landing.target)
+	if landing.phase == LANDING_PHASE_FINAL {
+		return targetRoll_r, configuration.TargetPitch
+	}
+	// Keep the wings nearly level so that a wingtip doesn't dig in
+	limit := ToRadians(landingFlareMaxRoll_d)
+	return clamp(targetRoll_r, -limit, limit), configuration.LandingFlarePitch
+}
+
+// Returns true if we've just passed the downwind side of the spiral, so that
+// turning onto final is a gentle right turn
+func (landing *Landing) isLinedUp(position Point) bool {
+	north, east, ok := landing.wind.get()
+	if !ok || math.Hypot(north, east) < landingCalmWindSpeed {
+		return true
+	}
+	downwind_r := math.Atan2(east, north)
+	past_r := GetAngleTo(downwind_r, Course(landing.target, position))
+	return past_r >= 0 && past_r < math.Pi/2
+}
+
+// Returns the start of the final approach, one spiral radius downwind of the
+// target. Without a wind estimate, we come straight in from where we are.
+func (landing *Landing) getGate(position Point) Point {
+	north, east, ok := landing.wind.get()
+	if !ok || math.Hypot(north, east) < landingCalmWindSpeed {
+		return landing.getGateFrom(position)
+	}
+	return landing.getGateToward(north, east)
+}
+
+// Returns the start of a final approach straight in from position
+func (landing *Landing) getGateFrom(position Point) Point {
+	north, east := toNorthEast(landing.target, position)
+	if math.Hypot(north, east) < 0.1 {
+		return position
+	}
+	return landing.getGateToward(north, east)
+}
+
+// Returns the point one spiral radius from the target in the direction of
+// the north and east offset
+func (landing *Landing) getGateToward(north, east Meters) Point {
+	length := math.Hypot(north, east)
+	radius := configuration.LandingSpiralRadius
+	return fromNorthEast(landing.target, north/length*radius, east/length*radius)
+}
+
+// Circles clockwise around center. Far away, this heads straight at the
+// circle.
+func getTargetRollLoiter(estimate PositionEstimate, yaw_r Radians, center Point, radius Meters) Radians {
+	speed, course_r := estimate.GetCourse()
+	if speed < 1.0 {
+		course_r = yaw_r
+	}
+	speed = math.Max(speed, l1MinimumSpeed)
+	l1Distance := configuration.GuidanceDamping * configuration.GuidancePeriod.Seconds() * speed / math.Pi
+
+	north, east := toNorthEast(center, estimate.Point)
+	distance := math.Hypot(north, east)
+	// The clockwise tangent, turned in toward the circle when we're outside
+	// of it and out when we're inside
+	desired_r := math.Atan2(east, north) + math.Pi/2 + math.Atan2(distance-radius, l1Distance)
+	eta := clamp(GetAngleTo(course_r, math.Mod(desired_r+2*math.Pi, 2*math.Pi)), -math.Pi/2, math.Pi/2)
+
+	// Circling needs a constant turn, plus a correction toward the desired
+	// course. Far from the circle, just steer.
+	lateralAcceleration := 2.0 * speed * speed / l1Distance * math.Sin(eta)
+	if distance < radius+l1Distance {
+		lateralAcceleration += speed * speed / radius
+	}
+	targetRoll_r := math.Atan(lateralAcceleration / gravity_mps2)
+	return clamp(targetRoll_r, -configuration.MaxTargetRoll, configuration.MaxTargetRoll)
+}
+
+// Estimates the wind while circling. At a constant airspeed, our ground
+// velocities lie on a circle centered on the wind velocity, so we fit a
+// circle to them after every full turn.
+type windEstimator struct {
+	// Sums for the least squares fit
+	n, x, y, xx, yy, xy, xz, yz, z float64
+	turned_r                       Radians
+	previousCourse                 Radians
+	// The wind velocity, i.e. where it's blowing to
+	north MetersPerSecond
+	east  MetersPerSecond
+	valid bool
+}
+
+func (wind *windEstimator) update(estimate PositionEstimate) {
+	speed, course_r := estimate.GetCourse()
+	if estimate.IsStale() || speed < 1.0 {
+		return
+	}
+	if wind.n > 0 {
+		wind.turned_r += GetAngleTo(wind.previousCourse, course_r)
+	}
+	wind.previousCourse = course_r
+
+	x := estimate.VelocityNorth
+	y := estimate.VelocityEast
+	z := x*x + y*y
+	wind.n++
+	wind.x += x
+	wind.y += y
+	wind.xx += x * x
+	wind.yy += y * y
+	wind.xy += x * y
+	wind.xz += x * z
+	wind.yz += y * z
+	wind.z += z
+
+	if math.Abs(wind.turned_r) >= 2*math.Pi {
+		north, east, ok := wind.fit()
+		if ok {
+			wind.north = north
+			wind.east = east
+			wind.valid = true
+		}
+		previousCourse := wind.previousCourse
+		*wind = windEstimator{north: wind.north, east: wind.east, valid: wind.valid, previousCourse: previousCourse}
+	}
+}
+
+// Fits x² + y² = 2·a·x + 2·b·y + c, where (a, b) is the center
+func (wind *windEstimator) fit() (MetersPerSecond, MetersPerSecond, bool) {
+	m := [3][3]float64{
+		{wind.xx, wind.xy, wind.x},
+		{wind.xy, wind.yy, wind.y},
+		{wind.x, wind.y, wind.n},
+	}
+	v := [3]float64{wind.xz, wind.yz, wind.z}
+	determinant := getDeterminant(m)
+	if math.Abs(determinant) < 1e-9 {
+		return 0, 0, false
+	}
+	var solution [2]float64
+	for column := range solution {
+		replaced := m
+		for row := range replaced {
+			replaced[row][column] = v[row]
+		}
+		solution[column] = getDeterminant(replaced) / determinant
+	}
+	return solution[0] * 0.5, solution[1] * 0.5, true
+}
+
+func getDeterminant(m [3][3]float64) float64 {
+	return m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+}
+
+// Returns the wind velocity, and false if we haven't circled yet
+func (wind *windEstimator) get() (MetersPerSecond, MetersPerSecond, bool) {
+	return wind.north, wind.east, wind.valid
+}
+
+func (wind windEstimator) String() string {
+	if !wind.valid {
+		return "unknown"
+	}
+	from_d := ToDegrees(math.Atan2(-wind.east, -wind.north))
+	if from_d < 0 {
+		from_d += 360
+	}
+	return fmt.Sprintf("%0.1f m/s from %0.0f", math.Hypot(wind.north, wind.east), from_d)
+}
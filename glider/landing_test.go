@@ -0,0 +1,172 @@
+package glider
+
+import (
+	"bytes"
+	"github.com/bskari/go-glider/flightdata"
+	"github.com/stianeikeland/go-rpio/v4"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestGetTargetRollLoiter(t *testing.T) {
+	setTestGuidanceConfiguration()
+	configuration.MaxTargetRoll = ToRadians(45)
+	center := Point{Latitude: 40.0, Longitude: -105.0}
+	radius := 60.0
+
+	// North of the center, flying east is clockwise, so we should hold a
+	// right bank that balances the turn
+	estimate := getTestEstimate(offsetPoint(center, radius, 0), 10, 90)
+	roll_r := getTargetRollLoiter(estimate, 0, center, radius)
+	expected_r := math.Atan(10 * 10 / radius / gravity_mps2)
+	if math.Abs(roll_r-expected_r) > ToRadians(1) {
+		t.Errorf("Expected roll %0.1f, got %0.1f", ToDegrees(expected_r), ToDegrees(roll_r))
+	}
+
+	// Flying west is the wrong way around, so turn hard
+	estimate = getTestEstimate(offsetPoint(center, radius, 0), 10, 270)
+	roll_r = getTargetRollLoiter(estimate, 0, center, radius)
+	if math.Abs(roll_r) < ToRadians(30) {
+		t.Errorf("Should turn around, got %0.1f", ToDegrees(roll_r))
+	}
+
+	// Far to the east and flying away, turn back toward the center
+	estimate = getTestEstimate(offsetPoint(center, 0, 1000), 10, 80)
+	roll_r = getTargetRollLoiter(estimate, 0, center, radius)
+	if math.Abs(roll_r) < ToRadians(20) {
+		t.Errorf("Should turn back, got %0.1f", ToDegrees(roll_r))
+	}
+	// Far to the east and flying at the center, fly straight
+	estimate = getTestEstimate(offsetPoint(center, 0, 1000), 10, 270)
+	roll_r = getTargetRollLoiter(estimate, 0, center, radius)
+	if math.Abs(roll_r) > ToRadians(10) {
+		t.Errorf("Should fly straight, got %0.1f", ToDegrees(roll_r))
+	}
+}
+
+func TestWindEstimator(t *testing.T) {
+	configuration.GpsStaleDuration = time.Second
+	configuration.GpsMaxUncertainty = 10
+	var wind windEstimator
+	// Circle at 10 m/s with the wind blowing to the north east
+	for i := 0; i < 180; i++ {
+		heading_r := ToRadians(float64(i) * 3)
+		estimate := PositionEstimate{
+			VelocityNorth: 10*math.Cos(heading_r) + 2,
+			VelocityEast:  10*math.Sin(heading_r) + 1,
+			Valid:         true,
+		}
+		wind.update(estimate)
+	}
+	north, east, ok := wind.get()
+	if !ok {
+		t.Fatal("Should have estimated the wind")
+	}
+	if math.Abs(north-2) > 0.2 || math.Abs(east-1) > 0.2 {
+		t.Errorf("Bad wind %v %v", north, east)
+	}
+}
+
+func TestSimulatedLanding(t *testing.T) {
+	loadTestConfiguration(t)
+	configuration.MissionFile = ""
+	configuration.DistanceFormula = DISTANCE_FORMULA_HAVERSINE
+	// The cached formula would cache this latitude for other tests
+	configuration.BearingFormula = BEARING_FORMULA_EQUIRECTANGULAR
+	configuration.DefaultWaypointLatitude = configuration.SimulatorLaunchLatitude
+	configuration.DefaultWaypointLongitude = configuration.SimulatorLaunchLongitude + 0.005
+	configuration.LandingPointAltitude = configuration.SimulatorGroundAltitude
+	configuration.SimulatorWindSpeed = 3
+	configuration.SimulatorWindDirection = ToRadians(270)
+
+	var buffer bytes.Buffer
+	recorder, err := flightdata.NewWriter(&buffer)
+	if err != nil {
+		t.Fatalf("Unable to create recorder: %v", err)
+	}
+	simulator := NewSimulator()
+	simulator.SetFlightRecorder(recorder)
+	result, err := simulator.Run()
+	if err != nil {
+		t.Fatalf("Simulation failed: %v", err)
+	}
+	if !result.Landed || result.FinalState != landing {
+		t.Fatalf("Should have landed while landing: %v", result)
+	}
+	target := Point{Latitude: configuration.DefaultWaypointLatitude, Longitude: configuration.DefaultWaypointLongitude}
+	distance := Distance(result.LandingPoint, target)
+	if distance > 200 {
+		t.Errorf("Landed too far from the target, %0.0f m: %v", distance, result)
+	}
+
+	records, err := flightdata.NewReader(&buffer).ReadAll()
+	if err != nil {
+		t.Fatalf("Unable to read records: %v", err)
+	}
+	// It should have flared on a final approach into the wind
+	flare := -1
+	for i, record := range records {
+		if record.State == landing.String() && approximatelyEqual(record.TargetPitch_d, ToDegrees(configuration.LandingFlarePitch)) {
+			flare = i
+			break
+		}
+	}
+	if flare < 0 {
+		t.Fatal("Should have flared")
+	}
+	course_d := records[flare].Course_d
+	if math.Abs(ToDegrees(GetAngleTo(ToRadians(course_d), ToRadians(270)))) > 45 {
+		t.Errorf("Should have landed into the wind, course %0.0f", course_d)
+	}
+}
+
+func TestLandTwice(t *testing.T) {
+	loadTestConfiguration(t)
+	defer loadTestConfiguration(t)
+	configuration.MissionFile = ""
+	hardware := newFakeHardware()
+	button := &fakeDigitalInput{state: rpio.High}
+	hardware.Button = button
+	waypoints, err := NewWaypoints()
+	if err != nil {
+		t.Fatalf("Unable to load waypoints: %v", err)
+	}
+	pilot := newPilot(hardware, NewTelemetry(hardware), NewControl(hardware.LeftServo, hardware.RightServo), waypoints)
+
+	pilot.state = landing
+	pilot.step()
+	first := pilot.landing
+	if first == nil {
+		t.Fatal("Should have started landing")
+	}
+	first.phase = LANDING_PHASE_FLARE
+
+	// Land, then press the button to go again
+	pilot.state = landed
+	pilot.step()
+	if pilot.landing != nil {
+		t.Error("Should have cleared the landing after landing")
+	}
+	if left_r, right_r := pilot.control.GetAngles(); !approximatelyEqual(left_r, ToRadians(90)) || !approximatelyEqual(right_r, ToRadians(90)) {
+		t.Errorf("Should have centered the servos, got %0.1f %0.1f", ToDegrees(left_r), ToDegrees(right_r))
+	}
+	button.state = rpio.Low
+	pilot.step()
+	if pilot.state != waitingForLaunch {
+		t.Fatalf("Should be waiting for launch, got %v", pilot.state)
+	}
+	pilot.state = landing
+	pilot.step()
+	if pilot.landing == nil || pilot.landing == first || pilot.landing.phase != LANDING_PHASE_SPIRAL {
+		t.Errorf("Should have started a new landing, got %+v", pilot.landing)
+	}
+
+	// A new mission moves the landing point
+	target := Point{Latitude: 40.1, Longitude: -105.1}
+	pilot.setWaypoints(newWaypointsFromMission(Mission{Repeating: []Point{target}}))
+	pilot.step()
+	if pilot.landing == nil || pilot.landing.target != target {
+		t.Errorf("Should be landing at the new target, got %+v", pilot.landing)
+	}
+}
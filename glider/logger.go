@@ -4,13 +4,20 @@ import (
 	"fmt"
 	"github.com/fatih/color"
 	"os"
-	"time"
 )
 
 func ConfigureLogger(file *os.File) {
 	fileLog = file
 }
 
+// Flushes the log file to disk
+func SyncLogger() error {
+	if fileLog == nil {
+		return nil
+	}
+	return fileLog.Sync()
+}
+
 type MultiLogger struct {
 	warningColor *color.Color
 	errorColor   *color.Color
@@ -27,7 +34,7 @@ func newLogger() MultiLogger {
 }
 
 func getFileFormatString(level string) string {
-	now := time.Now()
+	now := pilotClock.Now()
 	return fmt.Sprintf("%s %4s %%s\n", now.Format("15:04:05.000"), level)
 }
 
@@ -0,0 +1,356 @@
+// Hard and soft iron calibration for the magnetometer
+package glider
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+)
+
+// The fewest samples that we'll try to fit an ellipsoid to
+const minimumMagnetometerSamples = 100
+
+// The worst fit that's worth saving. A good calibration has about 1% RMS
+// error.
+const MaximumMagnetometerRmsError = 0.05
+
+// The fraction of directions that the samples need to cover before we trust
+// the fit. An ellipsoid fit to part of the sphere can be far off in the rest.
+const MinimumMagnetometerCoverage = 0.75
+
+type MagnetometerCalibration struct {
+	// The center of the ellipsoid, in raw units
+	HardIron vector3
+	// Maps the ellipsoid to a sphere
+	SoftIron [3][3]float64
+}
+
+// Returns the calibrated reading, i.e. SoftIron * (raw - HardIron)
+func (calibration MagnetometerCalibration) Apply(x, y, z int16) vector3 {
+	centered := vector3{
+		float64(x) - calibration.HardIron[0],
+		float64(y) - calibration.HardIron[1],
+		float64(z) - calibration.HardIron[2],
+	}
+	return multiplyMatrixVector(calibration.SoftIron, centered)
+}
+
+// Returns the calibration formatted for WriteConfigurationValues
+func (calibration MagnetometerCalibration) ConfigurationValues() map[string]string {
+	return map[string]string{
+		"MagnetometerHardIron": formatTomlVector(calibration.HardIron),
+		"MagnetometerSoftIron": formatTomlMatrix(calibration.SoftIron),
+	}
+}
+
+func getMagnetometerCalibration() MagnetometerCalibration {
+	return MagnetometerCalibration{
+		HardIron: configuration.MagnetometerHardIron,
+		SoftIron: configuration.MagnetometerSoftIron,
+	}
+}
+
+// Collects raw magnetometer readings while the airframe is tumbled around, so
+// that we can fit an ellipsoid to them. Safe to use from multiple goroutines.
+type MagnetometerCalibrator struct {
+	mutex   sync.Mutex
+	samples []vector3
+}
+
+func NewMagnetometerCalibrator() *MagnetometerCalibrator {
+	return &MagnetometerCalibrator{
+		samples: make([]vector3, 0, 1000),
+	}
+}
+
+func (calibrator *MagnetometerCalibrator) AddSample(x, y, z int16) {
+	calibrator.mutex.Lock()
+	defer calibrator.mutex.Unlock()
+	calibrator.samples = append(calibrator.samples, vector3{float64(x), float64(y), float64(z)})
+}
+
+func (calibrator *MagnetometerCalibrator) SampleCount() int {
+	calibrator.mutex.Lock()
+	defer calibrator.mutex.Unlock()
+	return len(calibrator.samples)
+}
+
+// Fits an ellipsoid to the samples. Also returns the RMS error of the
+// calibrated field strength, as a fraction of the mean field strength.
+func (calibrator *MagnetometerCalibrator) Fit() (MagnetometerCalibration, float64, error) {
+	calibrator.mutex.Lock()
+	samples := make([]vector3, len(calibrator.samples))
+	copy(samples, calibrator.samples)
+	calibrator.mutex.Unlock()
+	return fitEllipsoid(samples)
+}
+
+// Returns the fraction of the sphere that the calibrated samples point
+// toward, split into 24 equal sections by the largest axis and the signs of
+// all three
+func (calibrator *MagnetometerCalibrator) Coverage(calibration MagnetometerCalibration) float64 {
+	calibrator.mutex.Lock()
+	defer calibrator.mutex.Unlock()
+	return getMagnetometerCoverage(calibrator.samples, calibration)
+}
+
+func getMagnetometerCoverage(samples []vector3, calibration MagnetometerCalibration) float64 {
+	var sections [24]bool
+	for _, sample := range samples {
+		calibrated := multiplyMatrixVector(calibration.SoftIron, vector3{
+			sample[0] - calibration.HardIron[0],
+			sample[1] - calibration.HardIron[1],
+			sample[2] - calibration.HardIron[2],
+		})
+		largest := 0
+		for i := 1; i < 3; i++ {
+			if math.Abs(calibrated[i]) > math.Abs(calibrated[largest]) {
+				largest = i
+			}
+		}
+		section := largest * 8
+		for i := 0; i < 3; i++ {
+			if calibrated[i] < 0 {
+				section += 1 << uint(i)
+			}
+		}
+		sections[section] = true
+	}
+	covered := 0
+	for _, section := range sections {
+		if section {
+			covered++
+		}
+	}
+	return float64(covered) / float64(len(sections))
+}
+
+func fitEllipsoid(samples []vector3) (MagnetometerCalibration, float64, error) {
+	if len(samples) < minimumMagnetometerSamples {
+		return MagnetometerCalibration{}, 0, fmt.Errorf("Need at least %d samples, have %d", minimumMagnetometerSamples, len(samples))
+	}
+
+	// The raw values are in the hundreds, so squaring them makes the
+	// normal equations badly conditioned. Center and scale them first.
+	var mean vector3
+	for _, sample := range samples {
+		for i := 0; i < 3; i++ {
+			mean[i] += sample[i]
+		}
+	}
+	for i := 0; i < 3; i++ {
+		mean[i] /= float64(len(samples))
+	}
+	scale := 0.0
+	for _, sample := range samples {
+		for i := 0; i < 3; i++ {
+			scale = math.Max(scale, math.Abs(sample[i]-mean[i]))
+		}
+	}
+	if scale == 0 {
+		return MagnetometerCalibration{}, 0, errors.New("All samples are the same")
+	}
+
+	// Least squares fit of the general quadric
+	// ax^2 + by^2 + cz^2 + 2dxy + 2exz + 2fyz + 2gx + 2hy + 2iz = 1
+	var normal [9][9]float64
+	var right [9]float64
+	for _, sample := range samples {
+		x := (sample[0] - mean[0]) / scale
+		y := (sample[1] - mean[1]) / scale
+		z := (sample[2] - mean[2]) / scale
+		row := [9]float64{x * x, y * y, z * z, 2 * x * y, 2 * x * z, 2 * y * z, 2 * x, 2 * y, 2 * z}
+		for i := 0; i < 9; i++ {
+			for j := 0; j < 9; j++ {
+				normal[i][j] += row[i] * row[j]
+			}
+			right[i] += row[i]
+		}
+	}
+	v, err := solveLinearSystem(normal, right)
+	if err != nil {
+		return MagnetometerCalibration{}, 0, err
+	}
+
+	a := [3][3]float64{
+		{v[0], v[3], v[4]},
+		{v[3], v[1], v[5]},
+		{v[4], v[5], v[2]},
+	}
+	aInverse, err := invertMatrix(a)
+	if err != nil {
+		return MagnetometerCalibration{}, 0, errors.New("Samples don't cover enough orientations")
+	}
+	center := multiplyMatrixVector(aInverse, vector3{-v[6], -v[7], -v[8]})
+	ac := multiplyMatrixVector(a, center)
+	k := 1 + center[0]*ac[0] + center[1]*ac[1] + center[2]*ac[2]
+	if k <= 0 {
+		return MagnetometerCalibration{}, 0, errors.New("Samples don't fit an ellipsoid")
+	}
+	// Now (p - center)' * m * (p - center) = 1, undoing the scaling
+	var m [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			m[i][j] = a[i][j] / k / (scale * scale)
+		}
+	}
+
+	eigenvalues, eigenvectors := symmetricEigen(m)
+	for _, eigenvalue := range eigenvalues {
+		if eigenvalue <= 0 {
+			return MagnetometerCalibration{}, 0, errors.New("Samples don't fit an ellipsoid; keep tumbling")
+		}
+	}
+	// Keep the calibrated values in roughly raw units, by scaling to the
+	// geometric mean of the ellipsoid radii
+	radius := math.Pow(eigenvalues[0]*eigenvalues[1]*eigenvalues[2], -1.0/6.0)
+	var softIron [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for e := 0; e < 3; e++ {
+				softIron[i][j] += eigenvectors[i][e] * math.Sqrt(eigenvalues[e]) * eigenvectors[j][e]
+			}
+			softIron[i][j] *= radius
+		}
+	}
+
+	calibration := MagnetometerCalibration{
+		HardIron: vector3{mean[0] + center[0]*scale, mean[1] + center[1]*scale, mean[2] + center[2]*scale},
+		SoftIron: softIron,
+	}
+
+	// Figure out how well the calibrated values fit a sphere
+	squaredError := 0.0
+	for _, sample := range samples {
+		calibrated := multiplyMatrixVector(softIron, vector3{
+			sample[0] - calibration.HardIron[0],
+			sample[1] - calibration.HardIron[1],
+			sample[2] - calibration.HardIron[2],
+		})
+		norm := math.Sqrt(calibrated[0]*calibrated[0] + calibrated[1]*calibrated[1] + calibrated[2]*calibrated[2])
+		difference := norm/radius - 1
+		squaredError += difference * difference
+	}
+	return calibration, math.Sqrt(squaredError / float64(len(samples))), nil
+}
+
+// Solves a linear system using Gaussian elimination with partial pivoting
+func solveLinearSystem(a [9][9]float64, b [9]float64) ([9]float64, error) {
+	const n = len(b)
+	for column := 0; column < n; column++ {
+		pivot := column
+		for row := column + 1; row < n; row++ {
+			if math.Abs(a[row][column]) > math.Abs(a[pivot][column]) {
+				pivot = row
+			}
+		}
+		if math.Abs(a[pivot][column]) < 1e-12 {
+			return b, errors.New("Singular matrix")
+		}
+		a[column], a[pivot] = a[pivot], a[column]
+		b[column], b[pivot] = b[pivot], b[column]
+		for row := column + 1; row < n; row++ {
+			factor := a[row][column] / a[column][column]
+			for k := column; k < n; k++ {
+				a[row][k] -= factor * a[column][k]
+			}
+			b[row] -= factor * b[column]
+		}
+	}
+	var x [9]float64
+	for row := n - 1; row >= 0; row-- {
+		sum := b[row]
+		for k := row + 1; k < n; k++ {
+			sum -= a[row][k] * x[k]
+		}
+		x[row] = sum / a[row][row]
+	}
+	return x, nil
+}
+
+func invertMatrix(m [3][3]float64) ([3][3]float64, error) {
+	determinant := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+	if math.Abs(determinant) < 1e-12 {
+		return m, errors.New("Singular matrix")
+	}
+	inverse := [3][3]float64{
+		{
+			m[1][1]*m[2][2] - m[1][2]*m[2][1],
+			m[0][2]*m[2][1] - m[0][1]*m[2][2],
+			m[0][1]*m[1][2] - m[0][2]*m[1][1],
+		},
+		{
+			m[1][2]*m[2][0] - m[1][0]*m[2][2],
+			m[0][0]*m[2][2] - m[0][2]*m[2][0],
+			m[0][2]*m[1][0] - m[0][0]*m[1][2],
+		},
+		{
+			m[1][0]*m[2][1] - m[1][1]*m[2][0],
+			m[0][1]*m[2][0] - m[0][0]*m[2][1],
+			m[0][0]*m[1][1] - m[0][1]*m[1][0],
+		},
+	}
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			inverse[i][j] /= determinant
+		}
+	}
+	return inverse, nil
+}
+
+// Jacobi eigenvalue algorithm. Returns the eigenvalues and a matrix whose
+// columns are the eigenvectors.
+func symmetricEigen(m [3][3]float64) ([3]float64, [3][3]float64) {
+	a := m
+	v := [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+	for sweep := 0; sweep < 50; sweep++ {
+		offDiagonal := math.Abs(a[0][1]) + math.Abs(a[0][2]) + math.Abs(a[1][2])
+		if offDiagonal < 1e-15 {
+			break
+		}
+		for p := 0; p < 2; p++ {
+			for q := p + 1; q < 3; q++ {
+				if a[p][q] == 0 {
+					continue
+				}
+				theta := (a[q][q] - a[p][p]) / (2 * a[p][q])
+				t := 1 / (math.Abs(theta) + math.Sqrt(theta*theta+1))
+				if theta < 0 {
+					t = -t
+				}
+				c := 1 / math.Sqrt(t*t+1)
+				s := t * c
+				for k := 0; k < 3; k++ {
+					akp := a[k][p]
+					akq := a[k][q]
+					a[k][p] = c*akp - s*akq
+					a[k][q] = s*akp + c*akq
+				}
+				for k := 0; k < 3; k++ {
+					apk := a[p][k]
+					aqk := a[q][k]
+					a[p][k] = c*apk - s*aqk
+					a[q][k] = s*apk + c*aqk
+				}
+				for k := 0; k < 3; k++ {
+					vkp := v[k][p]
+					vkq := v[k][q]
+					v[k][p] = c*vkp - s*vkq
+					v[k][q] = s*vkp + c*vkq
+				}
+			}
+		}
+	}
+	return [3]float64{a[0][0], a[1][1], a[2][2]}, v
+}
+
+func multiplyMatrixVector(m [3][3]float64, v vector3) vector3 {
+	return vector3{
+		m[0][0]*v[0] + m[0][1]*v[1] + m[0][2]*v[2],
+		m[1][0]*v[0] + m[1][1]*v[1] + m[1][2]*v[2],
+		m[2][0]*v[0] + m[2][1]*v[1] + m[2][2]*v[2],
+	}
+}
@@ -0,0 +1,153 @@
+package glider
+
+import (
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Generates readings on an ellipsoid with the given hard and soft iron
+// distortion, from a field of the given strength
+func getEllipsoidSamples(center vector3, distortion [3][3]float64, strength float64, count int) []vector3 {
+	random := rand.New(rand.NewSource(1))
+	samples := make([]vector3, 0, count)
+	for i := 0; i < count; i++ {
+		// Uniform on a sphere
+		z := random.Float64()*2 - 1
+		theta := random.Float64() * 2 * math.Pi
+		r := math.Sqrt(1 - z*z)
+		direction := vector3{r * math.Cos(theta) * strength, r * math.Sin(theta) * strength, z * strength}
+		distorted := multiplyMatrixVector(distortion, direction)
+		samples = append(samples, vector3{
+			distorted[0] + center[0] + random.NormFloat64(),
+			distorted[1] + center[1] + random.NormFloat64(),
+			distorted[2] + center[2] + random.NormFloat64(),
+		})
+	}
+	return samples
+}
+
+func TestFitEllipsoid(t *testing.T) {
+	center := vector3{72, -148, -105}
+	distortion := [3][3]float64{
+		{1.2, 0.1, 0.0},
+		{0.1, 0.9, 0.05},
+		{0.0, 0.05, 1.0},
+	}
+	samples := getEllipsoidSamples(center, distortion, 400, 1000)
+
+	calibration, rmsError, err := fitEllipsoid(samples)
+	if err != nil {
+		t.Errorf("Couldn't fit ellipsoid: %v", err)
+		return
+	}
+	for i := 0; i < 3; i++ {
+		if math.Abs(calibration.HardIron[i]-center[i]) > 2.0 {
+			t.Errorf("Bad hard iron %v, expected %v", calibration.HardIron, center)
+		}
+	}
+	if rmsError > 0.01 {
+		t.Errorf("Bad RMS error %v", rmsError)
+	}
+
+	// The calibrated readings should all be about the same strength
+	minNorm := math.MaxFloat64
+	maxNorm := 0.0
+	for _, sample := range samples {
+		calibrated := calibration.Apply(int16(sample[0]), int16(sample[1]), int16(sample[2]))
+		norm := math.Sqrt(calibrated[0]*calibrated[0] + calibrated[1]*calibrated[1] + calibrated[2]*calibrated[2])
+		minNorm = math.Min(minNorm, norm)
+		maxNorm = math.Max(maxNorm, norm)
+	}
+	if (maxNorm-minNorm)/maxNorm > 0.05 {
+		t.Errorf("Calibrated field varies too much: %0.1f to %0.1f", minNorm, maxNorm)
+	}
+}
+
+func TestFitEllipsoidTooFewSamples(t *testing.T) {
+	samples := getEllipsoidSamples(vector3{}, [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}, 400, 10)
+	_, _, err := fitEllipsoid(samples)
+	if err == nil {
+		t.Error("Fit should fail with too few samples")
+	}
+}
+
+func TestMagnetometerCoverage(t *testing.T) {
+	identity := [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+	samples := getEllipsoidSamples(vector3{72, -148, -105}, identity, 400, 1000)
+	calibration := MagnetometerCalibration{HardIron: vector3{72, -148, -105}, SoftIron: identity}
+	if coverage := getMagnetometerCoverage(samples, calibration); coverage < 0.99 {
+		t.Errorf("Should cover everything, got %v", coverage)
+	}
+
+	// Only tumbled right side up
+	var upright []vector3
+	for _, sample := range samples {
+		if sample[2] > calibration.HardIron[2] {
+			upright = append(upright, sample)
+		}
+	}
+	if coverage := getMagnetometerCoverage(upright, calibration); coverage > 0.5 {
+		t.Errorf("Should only cover half, got %v", coverage)
+	}
+}
+
+func TestSymmetricEigen(t *testing.T) {
+	m := [3][3]float64{
+		{4, 1, 0},
+		{1, 3, 1},
+		{0, 1, 2},
+	}
+	eigenvalues, eigenvectors := symmetricEigen(m)
+	for e := 0; e < 3; e++ {
+		vector := vector3{eigenvectors[0][e], eigenvectors[1][e], eigenvectors[2][e]}
+		product := multiplyMatrixVector(m, vector)
+		for i := 0; i < 3; i++ {
+			if !approximatelyEqual(product[i], eigenvalues[e]*vector[i]) {
+				t.Errorf("Bad eigenpair %v %v", eigenvalues[e], vector)
+			}
+		}
+	}
+}
+
+func TestWriteConfigurationValues(t *testing.T) {
+	directory, err := ioutil.TempDir("", "glider")
+	if err != nil {
+		t.Errorf("Couldn't create temporary directory: %v", err)
+		return
+	}
+	defer os.RemoveAll(directory)
+	path := filepath.Join(directory, "conf.toml")
+	original := "# Comment\nDeclination_d = 8.1\nMagnetometerHardIron = [0.0, 0.0, 0.0]\n"
+	err = ioutil.WriteFile(path, []byte(original), 0644)
+	if err != nil {
+		t.Errorf("Couldn't write configuration: %v", err)
+		return
+	}
+
+	calibration := MagnetometerCalibration{
+		HardIron: vector3{72, -148.5, -105},
+		SoftIron: [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}},
+	}
+	err = WriteConfigurationValues(path, calibration.ConfigurationValues())
+	if err != nil {
+		t.Errorf("Couldn't write configuration values: %v", err)
+		return
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Errorf("Couldn't read configuration: %v", err)
+		return
+	}
+	expected := "# Comment\n" +
+		"Declination_d = 8.1\n" +
+		"MagnetometerHardIron = [72.0, -148.5, -105.0]\n" +
+		"MagnetometerSoftIron = [[1.0, 0.0, 0.0], [0.0, 1.0, 0.0], [0.0, 0.0, 1.0]]\n"
+	if string(data) != expected {
+		t.Errorf("Bad configuration file:\n%v", string(data))
+	}
+}
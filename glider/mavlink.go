@@ -0,0 +1,659 @@
+// Talks MAVLink to ground stations like QGroundControl, so that they can show
+// the telemetry, upload missions, and change the pilot state
+package glider
+
+import (
+	"fmt"
+	"github.com/argandas/serial"
+	"github.com/bskari/go-glider/mavlink"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+type mavlinkTransport_t uint8
+
+const (
+	MAVLINK_TRANSPORT_NONE mavlinkTransport_t = iota + 1
+	MAVLINK_TRANSPORT_UDP
+	MAVLINK_TRANSPORT_SERIAL
+)
+
+func (transport mavlinkTransport_t) String() string {
+	return []string{
+		"(unused-0-transport)",
+		"none",
+		"udp",
+		"serial",
+	}[transport]
+}
+
+// MAV_COMP_ID_AUTOPILOT1
+const mavlinkComponentId = 1
+const mavlinkHeartbeatPeriod = time.Second
+
+// How long to wait for a mission item before asking for it again, and how
+// many times to ask before giving up on the upload
+const mavlinkMissionItemTimeout = 1500 * time.Millisecond
+const mavlinkMissionItemRetries = 5
+
+// Sends and receives MAVLink frames without blocking
+type mavlinkTransport interface {
+	Write(data []byte) (int, error)
+	// Returns whatever has been received since the last call
+	ReadAvailable() ([]byte, error)
+	Close() error
+}
+
+// Sends telemetry and status to a ground station, and handles mission uploads
+// and mode changes from it. The custom mode in heartbeats and SET_MODE is the
+// PilotState.
+type MavlinkLink struct {
+	transport     mavlinkTransport
+	encoder       *mavlink.Encoder
+	parser        mavlink.Parser
+	pilot         *Pilot
+	bootTime      time.Time
+	heartbeatTime time.Time
+	reportedState PilotState
+	// Nil unless a ground station is uploading a mission
+	upload *missionUpload
+}
+
+// A mission that a ground station is partway through uploading
+type missionUpload struct {
+	systemId    uint8
+	componentId uint8
+	count       int
+	items       []mavlink.MissionItemInt
+	requestTime time.Time
+	retries     int
+}
+
+// Opens the configured transport. Returns nil if MavlinkTransport is "none".
+func NewMavlinkLink() (*MavlinkLink, error) {
+	var transport mavlinkTransport
+	var err error
+	switch configuration.MavlinkTransport {
+	case MAVLINK_TRANSPORT_UDP:
+		transport, err = newUdpTransport(configuration.MavlinkUdpPort, configuration.MavlinkUdpAddress)
+	case MAVLINK_TRANSPORT_SERIAL:
+		transport, err = newSerialTransport(configuration.MavlinkTty, configuration.MavlinkBitRate)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	Logger.Infof("Opened MAVLink %v transport as system %d", configuration.MavlinkTransport, configuration.MavlinkSystemId)
+	return newMavlinkLink(transport), nil
+}
+
+func newMavlinkLink(transport mavlinkTransport) *MavlinkLink {
+	return &MavlinkLink{
+		transport: transport,
+		encoder:   mavlink.NewEncoder(configuration.MavlinkSystemId, mavlinkComponentId),
+	}
+}
+
+func (link *MavlinkLink) Close() error {
+	return link.transport.Close()
+}
+
+// Handles received messages and sends telemetry. This runs as a scheduler
+// task, so it's on the same goroutine as the rest of the pilot.
+func (link *MavlinkLink) update() {
+	now := pilotClock.Now()
+	if link.bootTime.IsZero() {
+		link.bootTime = now
+	}
+	link.receive()
+	link.checkUpload(now)
+
+	if now.Sub(link.heartbeatTime) >= mavlinkHeartbeatPeriod {
+		link.heartbeatTime = now
+		link.sendHeartbeat()
+	}
+	if link.pilot.state != link.reportedState {
+		link.reportedState = link.pilot.state
+		link.sendStatus(mavlink.MAV_SEVERITY_INFO, fmt.Sprintf("State %s", link.pilot.state))
+	}
+	link.sendTelemetry(now)
+}
+
+func (link *MavlinkLink) receive() {
+	data, err := link.transport.ReadAvailable()
+	if err != nil {
+		Logger.Errorf("Unable to read MAVLink: %v", err)
+		return
+	}
+	for _, frame := range link.parser.Parse(data) {
+		message, err := mavlink.Decode(frame)
+		if err != nil {
+			Logger.Errorf("Unable to decode MAVLink message %d: %v", frame.MessageId, err)
+			continue
+		}
+		link.handle(frame, message)
+	}
+}
+
+func (link *MavlinkLink) handle(frame mavlink.Frame, message mavlink.Message) {
+	systemId := link.encoder.SystemId
+	switch message := message.(type) {
+	case *mavlink.SetMode:
+		if message.TargetSystem == systemId {
+			link.setMode(message)
+		}
+	case *mavlink.MissionCount:
+		if message.TargetSystem == systemId {
+			link.startUpload(frame, message)
+		}
+	case *mavlink.MissionItemInt:
+		if message.TargetSystem == systemId {
+			link.receiveMissionItem(frame, message)
+		}
+	case *mavlink.MissionRequestList:
+		if message.TargetSystem == systemId {
+			count := 0
+			if message.MissionType == mavlink.MAV_MISSION_TYPE_MISSION {
+				count = len(getMissionItems(link.pilot.waypoints))
+			}
+			link.send(&mavlink.MissionCount{
+				Count:           uint16(count),
+				TargetSystem:    frame.SystemId,
+				TargetComponent: frame.ComponentId,
+				MissionType:     message.MissionType,
+			})
+		}
+	// Ground stations should ask for MISSION_ITEM_INT, but we send it for
+	// either request
+	case *mavlink.MissionRequestInt:
+		if message.TargetSystem == systemId {
+			link.sendMissionItem(frame, int(message.Seq), message.MissionType)
+		}
+	case *mavlink.MissionRequest:
+		if message.TargetSystem == systemId {
+			link.sendMissionItem(frame, int(message.Seq), message.MissionType)
+		}
+	case *mavlink.MissionClearAll:
+		if message.TargetSystem == systemId {
+			link.clearMission(frame, message.MissionType)
+		}
+	}
+}
+
+func (link *MavlinkLink) send(message mavlink.Message) {
+	frame, err := link.encoder.Encode(message)
+	if err != nil {
+		Logger.Errorf("Unable to encode MAVLink message %d: %v", message.MessageId(), err)
+		return
+	}
+	_, err = link.transport.Write(frame)
+	if err != nil {
+		Logger.Debugf("Unable to send MAVLink message %d: %v", message.MessageId(), err)
+	}
+}
+
+func (link *MavlinkLink) sendStatus(severity uint8, text string) {
+	link.send(mavlink.NewStatusText(severity, text))
+}
+
+func (link *MavlinkLink) sendHeartbeat() {
+	baseMode, systemStatus := getMavlinkMode(link.pilot.state)
+	link.send(&mavlink.Heartbeat{
+		CustomMode:     uint32(link.pilot.state),
+		Type:           mavlink.MAV_TYPE_FIXED_WING,
+		Autopilot:      mavlink.MAV_AUTOPILOT_GENERIC,
+		BaseMode:       baseMode,
+		SystemStatus:   systemStatus,
+		MavlinkVersion: mavlink.MAVLINK_VERSION,
+	})
+}
+
+// Returns the MAVLink base mode and system status for a pilot state
+func getMavlinkMode(state PilotState) (uint8, uint8) {
+	baseMode := uint8(mavlink.MAV_MODE_FLAG_CUSTOM_MODE_ENABLED)
+	switch state {
+	case flying, released, landing:
+		baseMode |= mavlink.MAV_MODE_FLAG_AUTO_ENABLED | mavlink.MAV_MODE_FLAG_STABILIZE_ENABLED | mavlink.MAV_MODE_FLAG_SAFETY_ARMED
+		return baseMode, mavlink.MAV_STATE_ACTIVE
+	case waitingForLaunch, testMode, ascending:
+		baseMode |= mavlink.MAV_MODE_FLAG_STABILIZE_ENABLED | mavlink.MAV_MODE_FLAG_SAFETY_ARMED
+		return baseMode, mavlink.MAV_STATE_ACTIVE
+	case initializing:
+		return baseMode, mavlink.MAV_STATE_BOOT
+	case terminated:
+		baseMode |= mavlink.MAV_MODE_FLAG_SAFETY_ARMED
+		return baseMode, mavlink.MAV_STATE_FLIGHT_TERMINATION
+	}
+	return baseMode, mavlink.MAV_STATE_STANDBY
+}
+
+func (link *MavlinkLink) sendTelemetry(now time.Time) {
+	telemetry := link.pilot.telemetry
+	sinceBoot := now.Sub(link.bootTime)
+
+	_, _, _, axes := telemetry.getRecentSensors()
+	yaw_r := axes.Yaw
+	if yaw_r > PI {
+		yaw_r -= 2 * PI
+	}
+	// +x is right, +y is forward, and +z is up
+	gyroscope := telemetry.gyroscope.raw
+	link.send(&mavlink.Attitude{
+		TimeBootMs: uint32(sinceBoot / time.Millisecond),
+		Roll:       float32(axes.Roll),
+		Pitch:      float32(axes.Pitch),
+		Yaw:        float32(yaw_r),
+		Rollspeed:  float32(itg3200RawToRadiansPerSecond(gyroscope[1])),
+		Pitchspeed: float32(itg3200RawToRadiansPerSecond(gyroscope[0])),
+		Yawspeed:   float32(-itg3200RawToRadiansPerSecond(gyroscope[2])),
+	})
+
+	estimate := telemetry.GetPositionEstimate()
+	if estimate.Valid {
+		// 65535 means the heading is unknown
+		heading := uint16(math.MaxUint16)
+		if math.Hypot(estimate.VelocityNorth, estimate.VelocityEast) > 0.5 {
+			heading_d := ToDegrees(math.Atan2(estimate.VelocityEast, estimate.VelocityNorth))
+			if heading_d < 0 {
+				heading_d += 360
+			}
+			heading = uint16(heading_d * 100)
+		}
+		link.send(&mavlink.GlobalPositionInt{
+			TimeBootMs:  uint32(sinceBoot / time.Millisecond),
+			Lat:         int32(estimate.Latitude * 1e7),
+			Lon:         int32(estimate.Longitude * 1e7),
+			Alt:         int32(estimate.Altitude * 1000),
+			RelativeAlt: int32((estimate.Altitude - configuration.LandingPointAltitude) * 1000),
+			Vx:          toCentimetersPerSecond(estimate.VelocityNorth),
+			Vy:          toCentimetersPerSecond(estimate.VelocityEast),
+			Vz:          toCentimetersPerSecond(-estimate.VerticalSpeed),
+			Hdg:         heading,
+		})
+	}
+
+	gps := telemetry.getGpsSnapshot()
+	fixType := uint8(mavlink.GPS_FIX_TYPE_NO_FIX)
+	if gps.lock {
+		fixType = mavlink.GPS_FIX_TYPE_3D_FIX
+	}
+	course_d := ToDegrees(gps.course)
+	link.send(&mavlink.GpsRawInt{
+		TimeUsec: uint64(gps.fixTime.UnixNano() / int64(time.Microsecond)),
+		Lat:      int32(gps.point.Latitude * 1e7),
+		Lon:      int32(gps.point.Longitude * 1e7),
+		Alt:      int32(gps.point.Altitude * 1000),
+		Eph:      uint16(math.Min(gps.hdop*100, math.MaxUint16)),
+		// Unknown
+		Epv:               math.MaxUint16,
+		Vel:               uint16(math.Min(gps.speed*100, math.MaxUint16-1)),
+		Cog:               uint16(math.Mod(course_d+360, 360) * 100),
+		FixType:           fixType,
+		SatellitesVisible: math.MaxUint8,
+	})
+
+	left_us, right_us := link.pilot.control.GetPulseWidths()
+	link.send(&mavlink.ServoOutputRaw{
+		TimeUsec: uint32(sinceBoot / time.Microsecond),
+		Servos:   [8]uint16{uint16(left_us), uint16(right_us)},
+	})
+	link.send(&mavlink.MissionCurrent{Seq: uint16(link.pilot.waypoints.index)})
+}
+
+func toCentimetersPerSecond(speed MetersPerSecond) int16 {
+	return int16(clamp(speed*100, math.MinInt16, math.MaxInt16))
+}
+
+func (link *MavlinkLink) setMode(message *mavlink.SetMode) {
+	if message.BaseMode&mavlink.MAV_MODE_FLAG_CUSTOM_MODE_ENABLED == 0 {
+		link.sendStatus(mavlink.MAV_SEVERITY_WARNING, "Only custom modes are supported")
+		return
+	}
+	state := PilotState(message.CustomMode)
+	if message.CustomMode > math.MaxUint8 || !state.isValid() {
+		link.sendStatus(mavlink.MAV_SEVERITY_WARNING, fmt.Sprintf("Unknown mode %d", message.CustomMode))
+		return
+	}
+	Logger.Infof("Ground station changed state from %s to %s", link.pilot.state, state)
+	if state == waitingForLaunch {
+		// Act like the button was pressed
+		link.pilot.buttonPressTime = pilotClock.Now()
+	}
+	link.pilot.state = state
+}
+
+func (link *MavlinkLink) sendMissionAck(frame mavlink.Frame, result uint8, missionType uint8) {
+	link.send(&mavlink.MissionAck{
+		TargetSystem:    frame.SystemId,
+		TargetComponent: frame.ComponentId,
+		Type:            result,
+		MissionType:     missionType,
+	})
+}
+
+func (link *MavlinkLink) startUpload(frame mavlink.Frame, count *mavlink.MissionCount) {
+	if count.MissionType != mavlink.MAV_MISSION_TYPE_MISSION {
+		link.sendMissionAck(frame, mavlink.MAV_MISSION_UNSUPPORTED, count.MissionType)
+		return
+	}
+	if count.Count == 0 {
+		link.clearMission(frame, count.MissionType)
+		return
+	}
+	Logger.Infof("Ground station is uploading %d mission items", count.Count)
+	link.upload = &missionUpload{
+		systemId:    frame.SystemId,
+		componentId: frame.ComponentId,
+		count:       int(count.Count),
+		items:       make([]mavlink.MissionItemInt, 0, count.Count),
+	}
+	link.requestMissionItem()
+}
+
+func (link *MavlinkLink) requestMissionItem() {
+	upload := link.upload
+	upload.requestTime = pilotClock.Now()
+	link.send(&mavlink.MissionRequestInt{
+		Seq:             uint16(len(upload.items)),
+		TargetSystem:    upload.systemId,
+		TargetComponent: upload.componentId,
+	})
+}
+
+// Asks for the next item again if the ground station hasn't sent it
+func (link *MavlinkLink) checkUpload(now time.Time) {
+	upload := link.upload
+	if upload == nil || now.Sub(upload.requestTime) < mavlinkMissionItemTimeout {
+		return
+	}
+	if upload.retries >= mavlinkMissionItemRetries {
+		Logger.Warningf("Mission upload timed out after %d of %d items", len(upload.items), upload.count)
+		link.sendStatus(mavlink.MAV_SEVERITY_WARNING, "Mission upload timed out")
+		link.upload = nil
+		return
+	}
+	upload.retries++
+	link.requestMissionItem()
+}
+
+func (link *MavlinkLink) receiveMissionItem(frame mavlink.Frame, item *mavlink.MissionItemInt) {
+	upload := link.upload
+	if upload == nil || item.MissionType != mavlink.MAV_MISSION_TYPE_MISSION {
+		return
+	}
+	if int(item.Seq) != len(upload.items) {
+		// Probably a duplicate, so ask for the one we want again
+		link.requestMissionItem()
+		return
+	}
+	if isMavlinkNavigationCommand(item.Command) && !isMavlinkGlobalFrame(item.Frame) {
+		link.upload = nil
+		Logger.Warningf("Rejected mission item %d with frame %d", item.Seq, item.Frame)
+		link.sendStatus(mavlink.MAV_SEVERITY_WARNING, fmt.Sprintf("Unsupported frame %d in item %d", item.Frame, item.Seq))
+		link.sendMissionAck(frame, mavlink.MAV_MISSION_UNSUPPORTED_FRAME, item.MissionType)
+		return
+	}
+	upload.items = append(upload.items, *item)
+	upload.retries = 0
+	if len(upload.items) < upload.count {
+		link.requestMissionItem()
+		return
+	}
+
+	link.upload = nil
+	mission, err := getMissionFromItems(upload.items)
+	if err != nil {
+		Logger.Warningf("Rejected uploaded mission: %v", err)
+		link.sendStatus(mavlink.MAV_SEVERITY_WARNING, fmt.Sprintf("Rejected mission: %v", err))
+		link.sendMissionAck(frame, mavlink.MAV_MISSION_ERROR, item.MissionType)
+		return
+	}
+	link.pilot.setWaypoints(newWaypointsFromMission(mission))
+	Logger.Infof("Uploaded %d first and %d repeating waypoints", len(mission.First), len(mission.Repeating))
+	link.sendMissionAck(frame, mavlink.MAV_MISSION_ACCEPTED, item.MissionType)
+}
+
+func (link *MavlinkLink) sendMissionItem(frame mavlink.Frame, seq int, missionType uint8) {
+	items := getMissionItems(link.pilot.waypoints)
+	if missionType != mavlink.MAV_MISSION_TYPE_MISSION || seq >= len(items) {
+		link.sendMissionAck(frame, mavlink.MAV_MISSION_INVALID_SEQUENCE, missionType)
+		return
+	}
+	item := items[seq]
+	item.TargetSystem = frame.SystemId
+	item.TargetComponent = frame.ComponentId
+	link.send(&item)
+}
+
+// Goes back to the configured mission, because we always need somewhere to
+// fly to
+func (link *MavlinkLink) clearMission(frame mavlink.Frame, missionType uint8) {
+	if missionType != mavlink.MAV_MISSION_TYPE_MISSION {
+		link.sendMissionAck(frame, mavlink.MAV_MISSION_UNSUPPORTED, missionType)
+		return
+	}
+	waypoints, err := NewWaypoints()
+	if err != nil {
+		Logger.Errorf("Unable to load the configured mission: %v", err)
+		link.sendMissionAck(frame, mavlink.MAV_MISSION_ERROR, missionType)
+		return
+	}
+	Logger.Info("Ground station cleared the mission, using the configured one")
+	link.pilot.setWaypoints(waypoints)
+	link.sendMissionAck(frame, mavlink.MAV_MISSION_ACCEPTED, missionType)
+}
+
+// Returns true for the commands that fly to a position
+func isMavlinkNavigationCommand(command uint16) bool {
+	switch command {
+	case mavlink.MAV_CMD_NAV_WAYPOINT,
+		mavlink.MAV_CMD_NAV_LOITER_UNLIM,
+		mavlink.MAV_CMD_NAV_LOITER_TURNS,
+		mavlink.MAV_CMD_NAV_LOITER_TIME,
+		mavlink.MAV_CMD_NAV_LAND,
+		mavlink.MAV_CMD_NAV_LOITER_TO_ALT:
+		return true
+	}
+	return false
+}
+
+func isMavlinkLoiterCommand(command uint16) bool {
+	switch command {
+	case mavlink.MAV_CMD_NAV_LOITER_UNLIM,
+		mavlink.MAV_CMD_NAV_LOITER_TURNS,
+		mavlink.MAV_CMD_NAV_LOITER_TIME,
+		mavlink.MAV_CMD_NAV_LOITER_TO_ALT:
+		return true
+	}
+	return false
+}
+
+// Returns true for frames where x and y are latitude and longitude
+func isMavlinkGlobalFrame(frame uint8) bool {
+	switch frame {
+	case mavlink.MAV_FRAME_GLOBAL,
+		mavlink.MAV_FRAME_GLOBAL_RELATIVE_ALT,
+		mavlink.MAV_FRAME_GLOBAL_INT,
+		mavlink.MAV_FRAME_GLOBAL_RELATIVE_ALT_INT,
+		mavlink.MAV_FRAME_GLOBAL_TERRAIN_ALT,
+		mavlink.MAV_FRAME_GLOBAL_TERRAIN_ALT_INT:
+		return true
+	}
+	return false
+}
+
+// Converts uploaded mission items into waypoints. The waypoints before the
+// first loiter are flown once and the rest repeat; without a loiter, they all
+// repeat. Items that don't fly to a position are skipped.
+func getMissionFromItems(items []mavlink.MissionItemInt) (Mission, error) {
+	mission := Mission{}
+	loitering := false
+	for _, item := range items {
+		if !isMavlinkNavigationCommand(item.Command) {
+			Logger.Infof("Skipping mission item %d with command %d", item.Seq, item.Command)
+			continue
+		}
+		loitering = loitering || isMavlinkLoiterCommand(item.Command)
+		point := Point{
+			Latitude:  float64(item.X) / 1e7,
+			Longitude: float64(item.Y) / 1e7,
+			Altitude:  float64(item.Z),
+		}
+		if loitering {
+			mission.Repeating = append(mission.Repeating, point)
+		} else {
+			mission.First = append(mission.First, point)
+		}
+	}
+	if !loitering {
+		mission.Repeating = mission.First
+		mission.First = nil
+	}
+	err := mission.Validate()
+	if err != nil {
+		return Mission{}, err
+	}
+	return mission, nil
+}
+
+// Converts waypoints into mission items, so that uploading them again gives
+// the same waypoints
+func getMissionItems(waypoints *Waypoints) []mavlink.MissionItemInt {
+	var items []mavlink.MissionItemInt
+	add := func(point Point, command uint16) {
+		seq := len(items)
+		current := uint8(0)
+		if seq == waypoints.index {
+			current = 1
+		}
+		items = append(items, mavlink.MissionItemInt{
+			X:            int32(math.Round(point.Latitude * 1e7)),
+			Y:            int32(math.Round(point.Longitude * 1e7)),
+			Z:            float32(point.Altitude),
+			Seq:          uint16(seq),
+			Command:      command,
+			Frame:        mavlink.MAV_FRAME_GLOBAL,
+			Current:      current,
+			Autocontinue: 1,
+		})
+	}
+	for _, point := range waypoints.first {
+		add(point, mavlink.MAV_CMD_NAV_WAYPOINT)
+	}
+	for i, point := range waypoints.repeating {
+		if i == 0 && len(waypoints.first) > 0 {
+			add(point, mavlink.MAV_CMD_NAV_LOITER_UNLIM)
+		} else {
+			add(point, mavlink.MAV_CMD_NAV_WAYPOINT)
+		}
+	}
+	return items
+}
+
+// Sends to the configured address until a ground station sends us something,
+// then replies to whoever sent the most recent datagram
+type udpTransport struct {
+	connection *net.UDPConn
+	received   chan []byte
+	mutex      sync.Mutex
+	remote     *net.UDPAddr
+}
+
+func newUdpTransport(port uint16, address string) (*udpTransport, error) {
+	remote, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	connection, err := net.ListenUDP("udp", &net.UDPAddr{Port: int(port)})
+	if err != nil {
+		return nil, err
+	}
+	transport := &udpTransport{
+		connection: connection,
+		received:   make(chan []byte, 64),
+		remote:     remote,
+	}
+	go transport.receiveForever()
+	return transport, nil
+}
+
+func (transport *udpTransport) receiveForever() {
+	buffer := make([]byte, 2048)
+	for {
+		count, address, err := transport.connection.ReadFromUDP(buffer)
+		if err != nil {
+			// The connection was closed
+			return
+		}
+		transport.mutex.Lock()
+		transport.remote = address
+		transport.mutex.Unlock()
+		select {
+		case transport.received <- append([]byte(nil), buffer[:count]...):
+		default:
+			Logger.Warning("Dropped MAVLink datagram")
+		}
+	}
+}
+
+func (transport *udpTransport) ReadAvailable() ([]byte, error) {
+	var data []byte
+	for {
+		select {
+		case datagram := <-transport.received:
+			data = append(data, datagram...)
+		default:
+			return data, nil
+		}
+	}
+}
+
+func (transport *udpTransport) Write(data []byte) (int, error) {
+	transport.mutex.Lock()
+	remote := transport.remote
+	transport.mutex.Unlock()
+	return transport.connection.WriteToUDP(data, remote)
+}
+
+func (transport *udpTransport) Close() error {
+	return transport.connection.Close()
+}
+
+// For a telemetry radio
+type serialTransport struct {
+	port *serial.SerialPort
+}
+
+func newSerialTransport(tty string, bitRate int) (*serialTransport, error) {
+	port := serial.New()
+	port.Verbose = false
+	err := port.Open(tty, bitRate)
+	if err != nil {
+		return nil, err
+	}
+	return &serialTransport{port: port}, nil
+}
+
+func (transport *serialTransport) ReadAvailable() ([]byte, error) {
+	count := transport.port.Available()
+	data := make([]byte, 0, count)
+	for i := 0; i < count; i++ {
+		b, err := transport.port.Read()
+		if err != nil {
+			break
+		}
+		data = append(data, b)
+	}
+	return data, nil
+}
+
+func (transport *serialTransport) Write(data []byte) (int, error) {
+	return transport.port.Write(data)
+}
+
+func (transport *serialTransport) Close() error {
+	return transport.port.Close()
+}
@@ -0,0 +1,288 @@
+package glider
+
+import (
+	"github.com/bskari/go-glider/mavlink"
+	"net"
+	"testing"
+	"time"
+)
+
+// Talks to a MavlinkLink over UDP on the loopback interface
+type testGroundStation struct {
+	t          *testing.T
+	link       *MavlinkLink
+	pilot      *Pilot
+	connection *net.UDPConn
+	encoder    *mavlink.Encoder
+	parser     mavlink.Parser
+	// Where the link sends from, once we've heard from it
+	address *net.UDPAddr
+}
+
+func newTestGroundStation(t *testing.T) *testGroundStation {
+	loadTestConfiguration(t)
+	configuration.MissionFile = ""
+	// The cached formula caches the first latitude that it sees, which would
+	// break the navigation tests
+	configuration.DistanceFormula = DISTANCE_FORMULA_HAVERSINE
+	connection, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	transport, err := newUdpTransport(0, connection.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Unable to open transport: %v", err)
+	}
+	link := newMavlinkLink(transport)
+
+	hardware := newFakeHardware()
+	waypoints, err := NewWaypoints()
+	if err != nil {
+		t.Fatalf("Unable to load waypoints: %v", err)
+	}
+	control := NewControl(hardware.LeftServo, hardware.RightServo)
+	pilot := newPilot(hardware, NewTelemetry(hardware), control, waypoints)
+	pilot.SetMavlinkLink(link)
+
+	station := &testGroundStation{
+		t:          t,
+		link:       link,
+		pilot:      pilot,
+		connection: connection,
+		encoder:    mavlink.NewEncoder(255, 190),
+	}
+	return station
+}
+
+func (station *testGroundStation) close() {
+	station.link.Close()
+	station.connection.Close()
+}
+
+// Updates the link until it sends a message that matches
+func (station *testGroundStation) receive(matches func(mavlink.Message) bool) mavlink.Message {
+	buffer := make([]byte, 2048)
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		station.link.update()
+		// Read everything that it sent
+		for {
+			station.connection.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+			count, address, err := station.connection.ReadFromUDP(buffer)
+			if err != nil {
+				break
+			}
+			station.address = address
+			for _, frame := range station.parser.Parse(buffer[:count]) {
+				message, err := mavlink.Decode(frame)
+				if err != nil {
+					station.t.Fatalf("Unable to decode: %v", err)
+				}
+				if matches(message) {
+					return message
+				}
+			}
+		}
+	}
+	station.t.Fatal("Timed out waiting for message")
+	return nil
+}
+
+func (station *testGroundStation) send(message mavlink.Message) {
+	if station.address == nil {
+		station.receive(func(message mavlink.Message) bool {
+			_, ok := message.(*mavlink.Heartbeat)
+			return ok
+		})
+	}
+	frame, err := station.encoder.Encode(message)
+	if err != nil {
+		station.t.Fatalf("Unable to encode: %v", err)
+	}
+	_, err = station.connection.WriteToUDP(frame, station.address)
+	if err != nil {
+		station.t.Fatalf("Unable to send: %v", err)
+	}
+}
+
+func TestMavlinkTelemetry(t *testing.T) {
+	station := newTestGroundStation(t)
+	defer station.close()
+
+	heartbeat := station.receive(func(message mavlink.Message) bool {
+		_, ok := message.(*mavlink.Heartbeat)
+		return ok
+	}).(*mavlink.Heartbeat)
+	if heartbeat.Type != mavlink.MAV_TYPE_FIXED_WING || heartbeat.CustomMode != uint32(initializing) || heartbeat.SystemStatus != mavlink.MAV_STATE_BOOT {
+		t.Errorf("Bad heartbeat %v", heartbeat)
+	}
+
+	servos := station.receive(func(message mavlink.Message) bool {
+		_, ok := message.(*mavlink.ServoOutputRaw)
+		return ok
+	}).(*mavlink.ServoOutputRaw)
+	if servos.Servos[0] != configuration.LeftServoCenter_us || servos.Servos[1] != configuration.RightServoCenter_us {
+		t.Errorf("Bad servo outputs %v", servos.Servos)
+	}
+
+	station.receive(func(message mavlink.Message) bool {
+		gps, ok := message.(*mavlink.GpsRawInt)
+		return ok && gps.FixType == mavlink.GPS_FIX_TYPE_NO_FIX
+	})
+	station.receive(func(message mavlink.Message) bool {
+		_, ok := message.(*mavlink.Attitude)
+		return ok
+	})
+}
+
+func TestMavlinkSetMode(t *testing.T) {
+	station := newTestGroundStation(t)
+	defer station.close()
+
+	station.send(&mavlink.SetMode{
+		CustomMode:   uint32(waitingForLaunch),
+		TargetSystem: configuration.MavlinkSystemId,
+		BaseMode:     mavlink.MAV_MODE_FLAG_CUSTOM_MODE_ENABLED,
+	})
+	station.receive(func(message mavlink.Message) bool {
+		status, ok := message.(*mavlink.StatusText)
+		return ok && status.String() == "State waitingForLaunch"
+	})
+	if station.pilot.state != waitingForLaunch {
+		t.Errorf("Bad state %v", station.pilot.state)
+	}
+	station.receive(func(message mavlink.Message) bool {
+		heartbeat, ok := message.(*mavlink.Heartbeat)
+		return ok && heartbeat.CustomMode == uint32(waitingForLaunch)
+	})
+
+	// Other systems and unknown modes are ignored
+	station.send(&mavlink.SetMode{
+		CustomMode:   uint32(flying),
+		TargetSystem: configuration.MavlinkSystemId + 1,
+		BaseMode:     mavlink.MAV_MODE_FLAG_CUSTOM_MODE_ENABLED,
+	})
+	station.send(&mavlink.SetMode{
+		CustomMode:   99,
+		TargetSystem: configuration.MavlinkSystemId,
+		BaseMode:     mavlink.MAV_MODE_FLAG_CUSTOM_MODE_ENABLED,
+	})
+	station.receive(func(message mavlink.Message) bool {
+		status, ok := message.(*mavlink.StatusText)
+		return ok && status.String() == "Unknown mode 99"
+	})
+	if station.pilot.state != waitingForLaunch {
+		t.Errorf("Bad state %v", station.pilot.state)
+	}
+}
+
+func TestMavlinkMissionUpload(t *testing.T) {
+	station := newTestGroundStation(t)
+	defer station.close()
+	systemId := configuration.MavlinkSystemId
+
+	items := []mavlink.MissionItemInt{
+		{X: 400540000, Y: -1052950000, Command: mavlink.MAV_CMD_NAV_WAYPOINT, Frame: mavlink.MAV_FRAME_GLOBAL_RELATIVE_ALT_INT},
+		// Speed changes don't go anywhere, so they're skipped
+		{Param2: 12, Command: 178},
+		{X: 400550000, Y: -1052950000, Command: mavlink.MAV_CMD_NAV_WAYPOINT, Frame: mavlink.MAV_FRAME_GLOBAL_RELATIVE_ALT_INT},
+		{X: 400560000, Y: -1052960000, Command: mavlink.MAV_CMD_NAV_LOITER_UNLIM, Frame: mavlink.MAV_FRAME_GLOBAL_RELATIVE_ALT_INT},
+	}
+	station.send(&mavlink.MissionCount{Count: uint16(len(items)), TargetSystem: systemId})
+	for i := range items {
+		request := station.receive(func(message mavlink.Message) bool {
+			_, ok := message.(*mavlink.MissionRequestInt)
+			return ok
+		}).(*mavlink.MissionRequestInt)
+		if int(request.Seq) != i || request.TargetSystem != 255 || request.TargetComponent != 190 {
+			t.Fatalf("Bad request %v", request)
+		}
+		item := items[i]
+		item.Seq = uint16(i)
+		item.TargetSystem = systemId
+		station.send(&item)
+	}
+	ack := station.receive(func(message mavlink.Message) bool {
+		_, ok := message.(*mavlink.MissionAck)
+		return ok
+	}).(*mavlink.MissionAck)
+	if ack.Type != mavlink.MAV_MISSION_ACCEPTED {
+		t.Fatalf("Mission was rejected %v", ack)
+	}
+
+	waypoints := station.pilot.waypoints
+	if len(waypoints.first) != 2 || len(waypoints.repeating) != 1 {
+		t.Fatalf("Bad waypoints %v %v", waypoints.first, waypoints.repeating)
+	}
+	if !approximatelyEqual(waypoints.first[1].Latitude, 40.055) || !approximatelyEqual(waypoints.repeating[0].Longitude, -105.296) {
+		t.Errorf("Bad waypoints %v %v", waypoints.first, waypoints.repeating)
+	}
+
+	// Download it again
+	station.send(&mavlink.MissionRequestList{TargetSystem: systemId})
+	count := station.receive(func(message mavlink.Message) bool {
+		_, ok := message.(*mavlink.MissionCount)
+		return ok
+	}).(*mavlink.MissionCount)
+	if count.Count != 3 {
+		t.Fatalf("Bad count %v", count)
+	}
+	station.send(&mavlink.MissionRequestInt{Seq: 2, TargetSystem: systemId})
+	item := station.receive(func(message mavlink.Message) bool {
+		_, ok := message.(*mavlink.MissionItemInt)
+		return ok
+	}).(*mavlink.MissionItemInt)
+	if item.Seq != 2 || item.Command != mavlink.MAV_CMD_NAV_LOITER_UNLIM || item.X != 400560000 || item.Y != -1052960000 {
+		t.Errorf("Bad item %v", item)
+	}
+}
+
+func TestMavlinkMissionRejected(t *testing.T) {
+	station := newTestGroundStation(t)
+	defer station.close()
+	systemId := configuration.MavlinkSystemId
+	original := station.pilot.waypoints
+
+	// Waypoints that are too close together
+	items := []mavlink.MissionItemInt{
+		{X: 400540000, Y: -1052950000, Command: mavlink.MAV_CMD_NAV_WAYPOINT},
+		{X: 400540001, Y: -1052950000, Command: mavlink.MAV_CMD_NAV_WAYPOINT},
+	}
+	station.send(&mavlink.MissionCount{Count: uint16(len(items)), TargetSystem: systemId})
+	for i := range items {
+		station.receive(func(message mavlink.Message) bool {
+			request, ok := message.(*mavlink.MissionRequestInt)
+			return ok && int(request.Seq) == i
+		})
+		item := items[i]
+		item.Seq = uint16(i)
+		item.TargetSystem = systemId
+		station.send(&item)
+	}
+	ack := station.receive(func(message mavlink.Message) bool {
+		_, ok := message.(*mavlink.MissionAck)
+		return ok
+	}).(*mavlink.MissionAck)
+	if ack.Type != mavlink.MAV_MISSION_ERROR {
+		t.Errorf("Mission should have been rejected %v", ack)
+	}
+
+	// Local frames aren't supported
+	station.send(&mavlink.MissionCount{Count: 1, TargetSystem: systemId})
+	station.receive(func(message mavlink.Message) bool {
+		_, ok := message.(*mavlink.MissionRequestInt)
+		return ok
+	})
+	station.send(&mavlink.MissionItemInt{X: 100, Y: 100, Command: mavlink.MAV_CMD_NAV_WAYPOINT, Frame: 1, TargetSystem: systemId})
+	ack = station.receive(func(message mavlink.Message) bool {
+		_, ok := message.(*mavlink.MissionAck)
+		return ok
+	}).(*mavlink.MissionAck)
+	if ack.Type != mavlink.MAV_MISSION_UNSUPPORTED_FRAME {
+		t.Errorf("Mission should have been rejected %v", ack)
+	}
+
+	if station.pilot.waypoints != original {
+		t.Error("Rejected missions shouldn't change the waypoints")
+	}
+}
@@ -0,0 +1,296 @@
+// Loads waypoints from GPX, KML, or GeoJSON mission files
+package glider
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"github.com/bskari/go-glider/geoformat"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Legs longer than this are probably a typo, like swapped latitude and
+// longitude
+const maxMissionLegDistance Meters = 100e3
+
+const (
+	missionSectionFirst     = "first"
+	missionSectionRepeating = "repeating"
+)
+
+// The waypoints to fly through. The first waypoints are visited once, then the
+// repeating waypoints are visited forever.
+type Mission struct {
+	First     []Point
+	Repeating []Point
+}
+
+// Loads a mission file, picking the format from the extension. In every
+// format, waypoints are put in the "first" or "repeating" section by the name
+// of the route, folder, or feature that contains them; anything else is
+// repeating.
+//
+// GPX: routes, tracks, or waypoints. Routes and tracks are sectioned by their
+// <name>, and waypoints by their <type>.
+// KML: Points and LineStrings, sectioned by the name of the enclosing
+// Placemark, Folder, or Document.
+// GeoJSON: Point, MultiPoint, and LineString features, sectioned by their
+// "section" property.
+func LoadMission(path string) (Mission, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Mission{}, err
+	}
+	defer file.Close()
+
+	var mission Mission
+	extension := strings.ToLower(filepath.Ext(path))
+	switch extension {
+	case ".gpx":
+		mission, err = parseGpxMission(file)
+	case ".kml":
+		mission, err = parseKmlMission(file)
+	case ".geojson", ".json":
+		mission, err = parseGeoJsonMission(file)
+	default:
+		return Mission{}, fmt.Errorf("%s: Unknown mission file type '%s', expected .gpx, .kml, or .geojson", path, extension)
+	}
+	if err != nil {
+		return Mission{}, fmt.Errorf("%s: %v", path, err)
+	}
+
+	err = mission.Validate()
+	if err != nil {
+		return Mission{}, fmt.Errorf("%s: %v", path, err)
+	}
+	return mission, nil
+}
+
+func (mission *Mission) add(section string, point Point) {
+	if strings.EqualFold(section, missionSectionFirst) {
+		mission.First = append(mission.First, point)
+	} else {
+		mission.Repeating = append(mission.Repeating, point)
+	}
+}
+
+// Checks that the coordinates are sane and that the waypoints aren't too close
+// together or too far apart
+func (mission Mission) Validate() error {
+	if len(mission.Repeating) == 0 {
+		return fmt.Errorf("No repeating waypoints, found %d first waypoints", len(mission.First))
+	}
+
+	type namedPoint struct {
+		name  string
+		point Point
+	}
+	points := make([]namedPoint, 0, len(mission.First)+len(mission.Repeating))
+	for i, point := range mission.First {
+		points = append(points, namedPoint{fmt.Sprintf("first waypoint %d", i+1), point})
+	}
+	for i, point := range mission.Repeating {
+		points = append(points, namedPoint{fmt.Sprintf("repeating waypoint %d", i+1), point})
+	}
+
+	for _, named := range points {
+		latitude := named.point.Latitude
+		longitude := named.point.Longitude
+		if math.IsNaN(latitude) || latitude < -90 || latitude > 90 {
+			return fmt.Errorf("%s has bad latitude %v", named.name, latitude)
+		}
+		if math.IsNaN(longitude) || longitude < -180 || longitude > 180 {
+			return fmt.Errorf("%s has bad longitude %v", named.name, longitude)
+		}
+	}
+
+	checkLeg := func(from, to namedPoint) error {
+		distance := Distance(from.point, to.point)
+		if distance < configuration.WaypointReachedDistance {
+			return fmt.Errorf("%s is only %0.1f m from %s, closer than WaypointReachedDistance_m", to.name, distance, from.name)
+		}
+		if distance > maxMissionLegDistance {
+			return fmt.Errorf("%s is %0.1f km from %s, which is too far", to.name, distance/1000, from.name)
+		}
+		return nil
+	}
+	for i := 1; i < len(points); i++ {
+		err := checkLeg(points[i-1], points[i])
+		if err != nil {
+			return err
+		}
+	}
+	// The repeating waypoints loop back around
+	if len(mission.Repeating) > 1 {
+		last := points[len(points)-1]
+		firstRepeating := points[len(mission.First)]
+		err := checkLeg(last, firstRepeating)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseCoordinate(text, name string) (Coordinate, error) {
+	value, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+	if err != nil {
+		return 0, fmt.Errorf("Bad %s '%s'", name, text)
+	}
+	return value, nil
+}
+
+type gpxPoint struct {
+	Latitude  string  `xml:"lat,attr"`
+	Longitude string  `xml:"lon,attr"`
+	Elevation float64 `xml:"ele"`
+	Type      string  `xml:"type"`
+}
+
+func (point gpxPoint) toPoint() (Point, error) {
+	latitude, err := parseCoordinate(point.Latitude, "latitude")
+	if err != nil {
+		return Point{}, err
+	}
+	longitude, err := parseCoordinate(point.Longitude, "longitude")
+	if err != nil {
+		return Point{}, err
+	}
+	return Point{Latitude: latitude, Longitude: longitude, Altitude: point.Elevation}, nil
+}
+
+type gpxFile struct {
+	Waypoints []gpxPoint `xml:"wpt"`
+	Routes    []struct {
+		Name   string     `xml:"name"`
+		Points []gpxPoint `xml:"rtept"`
+	} `xml:"rte"`
+	Tracks []struct {
+		Name     string `xml:"name"`
+		Segments []struct {
+			Points []gpxPoint `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+}
+
+func parseGpxMission(reader io.Reader) (Mission, error) {
+	var gpx gpxFile
+	err := xml.NewDecoder(reader).Decode(&gpx)
+	if err != nil {
+		return Mission{}, fmt.Errorf("Bad GPX: %v", err)
+	}
+
+	mission := Mission{}
+	addPoints := func(section string, points []gpxPoint) error {
+		for _, gpxPoint := range points {
+			point, err := gpxPoint.toPoint()
+			if err != nil {
+				return err
+			}
+			mission.add(section, point)
+		}
+		return nil
+	}
+	for _, route := range gpx.Routes {
+		err = addPoints(strings.TrimSpace(route.Name), route.Points)
+		if err != nil {
+			return Mission{}, err
+		}
+	}
+	for _, track := range gpx.Tracks {
+		for _, segment := range track.Segments {
+			err = addPoints(strings.TrimSpace(track.Name), segment.Points)
+			if err != nil {
+				return Mission{}, err
+			}
+		}
+	}
+	for _, waypoint := range gpx.Waypoints {
+		err = addPoints(strings.TrimSpace(waypoint.Type), []gpxPoint{waypoint})
+		if err != nil {
+			return Mission{}, err
+		}
+	}
+	return mission, nil
+}
+
+// Returns the "first" or "repeating" section that encloses a KML shape, or ""
+func getKmlSection(parents []*geoformat.KmlElement) string {
+	for i := len(parents) - 1; i >= 0; i-- {
+		if strings.EqualFold(parents[i].Name, missionSectionFirst) || strings.EqualFold(parents[i].Name, missionSectionRepeating) {
+			return parents[i].Name
+		}
+	}
+	return ""
+}
+
+func parseKmlMission(reader io.Reader) (Mission, error) {
+	mission := Mission{}
+	err := geoformat.WalkKml(reader, func(element *geoformat.KmlElement, parents []*geoformat.KmlElement) error {
+		if element.Local != "coordinates" {
+			return nil
+		}
+		points, err := geoformat.ParseKmlCoordinates(element.Text())
+		if err != nil {
+			return err
+		}
+		section := getKmlSection(parents)
+		for _, point := range points {
+			mission.add(section, Point(point))
+		}
+		return nil
+	})
+	if err != nil {
+		return Mission{}, err
+	}
+	return mission, nil
+}
+
+func getGeoJsonPoints(geometry *geoformat.GeoJsonGeometry) ([]geoformat.Point, error) {
+	switch geometry.Type {
+	case "Point":
+		var position []float64
+		err := json.Unmarshal(geometry.Coordinates, &position)
+		if err != nil {
+			return nil, fmt.Errorf("Bad GeoJSON Point: %v", err)
+		}
+		point, err := geoformat.GeoJsonPosition(position)
+		if err != nil {
+			return nil, err
+		}
+		return []geoformat.Point{point}, nil
+	case "MultiPoint", "LineString":
+		var positions [][]float64
+		err := json.Unmarshal(geometry.Coordinates, &positions)
+		if err != nil {
+			return nil, fmt.Errorf("Bad GeoJSON %s: %v", geometry.Type, err)
+		}
+		return geoformat.GeoJsonPositions(positions)
+	}
+	return nil, fmt.Errorf("Unsupported GeoJSON geometry '%s'", geometry.Type)
+}
+
+func parseGeoJsonMission(reader io.Reader) (Mission, error) {
+	features, err := geoformat.ReadGeoJsonFeatures(reader)
+	if err != nil {
+		return Mission{}, err
+	}
+
+	mission := Mission{}
+	for i, feature := range features {
+		points, err := getGeoJsonPoints(feature.Geometry)
+		if err != nil {
+			return Mission{}, fmt.Errorf("GeoJSON feature %d: %v", i+1, err)
+		}
+		section, _ := feature.Properties["section"].(string)
+		for _, point := range points {
+			mission.add(section, Point(point))
+		}
+	}
+	return mission, nil
+}
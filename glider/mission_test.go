@@ -0,0 +1,149 @@
+package glider
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestMission(t *testing.T, name, contents string) (string, func()) {
+	directory, err := ioutil.TempDir("", "glider")
+	if err != nil {
+		t.Fatalf("Couldn't create temporary directory: %v", err)
+	}
+	path := filepath.Join(directory, name)
+	err = ioutil.WriteFile(path, []byte(contents), 0644)
+	if err != nil {
+		t.Fatalf("Couldn't write mission: %v", err)
+	}
+	return path, func() { os.RemoveAll(directory) }
+}
+
+func checkTestMission(t *testing.T, mission Mission) {
+	if len(mission.First) != 1 || len(mission.Repeating) != 2 {
+		t.Errorf("Bad section sizes %d %d", len(mission.First), len(mission.Repeating))
+		return
+	}
+	if mission.First[0].Latitude != 40.05 || mission.First[0].Longitude != -105.29 {
+		t.Errorf("Bad first waypoint %v", mission.First[0])
+	}
+	if mission.Repeating[1].Latitude != 40.07 || mission.Repeating[1].Longitude != -105.29 {
+		t.Errorf("Bad repeating waypoint %v", mission.Repeating[1])
+	}
+}
+
+func TestLoadMission(t *testing.T) {
+	configuration.WaypointReachedDistance = 20.0
+	missions := map[string]string{
+		"mission.gpx": `<?xml version="1.0"?>
+<gpx version="1.1" creator="test" xmlns="http://www.topografix.com/GPX/1/1">
+  <rte><name>first</name><rtept lat="40.05" lon="-105.29"><ele>1600</ele></rtept></rte>
+  <rte>
+    <name>repeating</name>
+    <rtept lat="40.06" lon="-105.29"></rtept>
+    <rtept lat="40.07" lon="-105.29"></rtept>
+  </rte>
+</gpx>`,
+		"mission.kml": `<?xml version="1.0" encoding="UTF-8"?>
+<kml xmlns="http://www.opengis.net/kml/2.2">
+<Document>
+<Folder>
+<name>first</name>
+<Placemark><name>Start</name><Point><coordinates>-105.29,40.05,1600</coordinates></Point></Placemark>
+</Folder>
+<Placemark>
+<name>repeating</name>
+<LineString><coordinates>
+-105.29,40.06
+-105.29,40.07
+</coordinates></LineString>
+</Placemark>
+</Document>
+</kml>`,
+		"mission.geojson": `{
+  "type": "FeatureCollection",
+  "features": [
+    {"type": "Feature", "properties": {"section": "first"}, "geometry": {"type": "Point", "coordinates": [-105.29, 40.05]}},
+    {"type": "Feature", "properties": {"section": "repeating"}, "geometry": {"type": "LineString", "coordinates": [[-105.29, 40.06], [-105.29, 40.07]]}}
+  ]
+}`,
+	}
+	for name, contents := range missions {
+		path, cleanup := writeTestMission(t, name, contents)
+		mission, err := LoadMission(path)
+		cleanup()
+		if err != nil {
+			t.Errorf("Couldn't load %s: %v", name, err)
+			continue
+		}
+		checkTestMission(t, mission)
+	}
+}
+
+func TestLoadMissionUnsectioned(t *testing.T) {
+	configuration.WaypointReachedDistance = 20.0
+	// Like the KML that aprs_fi_to_kml.py makes
+	path, cleanup := writeTestMission(t, "path.kml", `<?xml version="1.0" encoding="UTF-8"?>
+<kml xmlns="http://www.opengis.net/kml/2.2">
+<Document><name>APRS path</name><Placemark><name>Flight path</name><LineString><coordinates>
+-105.29,40.05,1600
+-105.29,40.06,1700
+</coordinates></LineString></Placemark></Document>
+</kml>`)
+	defer cleanup()
+	mission, err := LoadMission(path)
+	if err != nil {
+		t.Errorf("Couldn't load mission: %v", err)
+		return
+	}
+	if len(mission.First) != 0 || len(mission.Repeating) != 2 {
+		t.Errorf("Bad section sizes %d %d", len(mission.First), len(mission.Repeating))
+	}
+	if mission.Repeating[1].Altitude != 1700 {
+		t.Errorf("Bad altitude %v", mission.Repeating[1].Altitude)
+	}
+}
+
+func TestLoadMissionErrors(t *testing.T) {
+	configuration.WaypointReachedDistance = 20.0
+	tests := []struct {
+		name     string
+		contents string
+		expected string
+	}{
+		{"bad.txt", "", "Unknown mission file type"},
+		{"empty.geojson", `{"type": "FeatureCollection", "features": []}`, "No repeating waypoints"},
+		{"latitude.geojson", `{"type": "Feature", "geometry": {"type": "Point", "coordinates": [-105.29, 91]}}`, "bad latitude"},
+		{"swapped.geojson", `{"type": "Feature", "geometry": {"type": "LineString", "coordinates": [[-105.29, 40.05], [40.05, -105.29]]}}`, "bad latitude"},
+		{"close.geojson", `{"type": "Feature", "geometry": {"type": "LineString", "coordinates": [[-105.29, 40.05], [-105.29, 40.05001]]}}`, "closer than WaypointReachedDistance_m"},
+		{"far.geojson", `{"type": "Feature", "geometry": {"type": "LineString", "coordinates": [[-105.29, 40.05], [-104.29, 41.05]]}}`, "too far"},
+		{"coordinates.kml", `<kml><Placemark><Point><coordinates>-105.29</coordinates></Point></Placemark></kml>`, "Bad KML coordinates"},
+		{"attribute.gpx", `<gpx><wpt lat="forty" lon="-105.29"></wpt></gpx>`, "Bad latitude 'forty'"},
+	}
+	for _, test := range tests {
+		path, cleanup := writeTestMission(t, test.name, test.contents)
+		_, err := LoadMission(path)
+		cleanup()
+		if err == nil {
+			t.Errorf("%s: expected an error", test.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.expected) || !strings.Contains(err.Error(), test.name) {
+			t.Errorf("%s: bad error '%v'", test.name, err)
+		}
+	}
+}
+
+func TestLoadShippedMission(t *testing.T) {
+	configuration.WaypointReachedDistance = 20.0
+	mission, err := LoadMission("../missions/wonderland_lake.kml")
+	if err != nil {
+		t.Errorf("Couldn't load mission: %v", err)
+		return
+	}
+	if len(mission.Repeating) != 3 {
+		t.Errorf("Bad mission %v", mission)
+	}
+}
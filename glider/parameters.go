@@ -0,0 +1,328 @@
+// Lets the gains and a few other values be tuned while we're running, from
+// the dashboard or over HTTP, and optionally saved back to the configuration
+// file
+package glider
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A configuration value that can be changed while we're running
+type tunableParameter struct {
+	// The key in the configuration file
+	name  string
+	value *float64
+	// Configuration file units per configuration_t units, e.g. degrees per
+	// radian
+	scale float64
+	// How much to change it by from the dashboard
+	step float64
+}
+
+func degreesParameter(name string, value *Radians, step Degrees) tunableParameter {
+	return tunableParameter{name: name, value: value, scale: 180.0 / PI, step: step}
+}
+
+func multiplierParameter(name string, value *float64, step float64) tunableParameter {
+	return tunableParameter{name: name, value: value, scale: 1.0, step: step}
+}
+
+// Only values that are read every iteration, or that the pilot rebuilds the
+// controllers from, can go in here
+var tunableParameters = []tunableParameter{
+	multiplierParameter("ProportionalRollMultiplier", &configuration.ProportionalRollMultiplier, 0.1),
+	multiplierParameter("IntegralRollMultiplier", &configuration.IntegralRollMultiplier, 0.05),
+	multiplierParameter("DerivativeRollMultiplier", &configuration.DerivativeRollMultiplier, 0.01),
+	multiplierParameter("ProportionalPitchMultiplier", &configuration.ProportionalPitchMultiplier, 0.1),
+	multiplierParameter("IntegralPitchMultiplier", &configuration.IntegralPitchMultiplier, 0.05),
+	multiplierParameter("DerivativePitchMultiplier", &configuration.DerivativePitchMultiplier, 0.01),
+	multiplierParameter("ProportionalTargetRollMultiplier", &configuration.ProportionalTargetRollMultiplier, 0.1),
+	multiplierParameter("IntegralTargetRollMultiplier", &configuration.IntegralTargetRollMultiplier, 0.05),
+	multiplierParameter("DerivativeTargetRollMultiplier", &configuration.DerivativeTargetRollMultiplier, 0.01),
+	degreesParameter("MaxRollIntegral_d", &configuration.MaxRollIntegral, 1),
+	degreesParameter("MaxPitchIntegral_d", &configuration.MaxPitchIntegral, 1),
+	degreesParameter("MaxTargetRollIntegral_d", &configuration.MaxTargetRollIntegral, 1),
+	multiplierParameter("PidDerivativeCutoff_hz", &configuration.PidDerivativeCutoff_hz, 0.5),
+	degreesParameter("MaxTargetRoll_d", &configuration.MaxTargetRoll, 1),
+	degreesParameter("TargetPitch_d", &configuration.TargetPitch, 0.5),
+	degreesParameter("MaxServoPitchAdjustment_d", &configuration.MaxServoPitchAdjustment, 1),
+	multiplierParameter("GuidanceDamping", &configuration.GuidanceDamping, 0.05),
+	degreesParameter("LandingFlarePitch_d", &configuration.LandingFlarePitch, 0.5),
+	degreesParameter("FlyDirection_d", &configuration.FlyDirection, 5),
+}
+
+type parameterChange struct {
+	parameter tunableParameter
+	value     float64
+	// Who asked for it, for the log
+	source string
+}
+
+// Wraps the configuration so that it can be read and changed from other
+// goroutines. Changes are queued, and the pilot applies them between control
+// iterations, so that the configuration is only ever written from the same
+// goroutine that reads it.
+type ParameterStore struct {
+	mutex sync.Mutex
+	// Where to save changes
+	path string
+	// The most recently requested values, in configuration file units
+	values  map[string]float64
+	changed map[string]bool
+	pending []parameterChange
+}
+
+// Changes are saved to path, which should be the airframe profile if there
+// is one
+func NewParameterStore(path string) *ParameterStore {
+	store := &ParameterStore{
+		path:    path,
+		values:  make(map[string]float64),
+		changed: make(map[string]bool),
+	}
+	for _, parameter := range tunableParameters {
+		store.values[parameter.name] = *parameter.value * parameter.scale
+	}
+	return store
+}
+
+func findTunableParameter(name string) (tunableParameter, bool) {
+	for _, parameter := range tunableParameters {
+		if parameter.name == name {
+			return parameter, true
+		}
+	}
+	return tunableParameter{}, false
+}
+
+// The names of the parameters, in the order that the dashboard shows them
+func (store *ParameterStore) Names() []string {
+	names := make([]string, 0, len(tunableParameters))
+	for _, parameter := range tunableParameters {
+		names = append(names, parameter.name)
+	}
+	return names
+}
+
+// Returns the value in configuration file units, including changes that
+// haven't been applied yet
+func (store *ParameterStore) Get(name string) (float64, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	value, ok := store.values[name]
+	if !ok {
+		return 0, fmt.Errorf("Unknown parameter %s", name)
+	}
+	return value, nil
+}
+
+// Every value, in configuration file units
+func (store *ParameterStore) GetAll() map[string]float64 {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	values := make(map[string]float64, len(store.values))
+	for name, value := range store.values {
+		values[name] = value
+	}
+	return values
+}
+
+// Queues a change, in configuration file units, after checking it against
+// the configuration schema. source is who asked for it, for the log.
+func (store *ParameterStore) Set(name string, value float64, source string) error {
+	parameter, ok := findTunableParameter(name)
+	if !ok {
+		return fmt.Errorf("Unknown parameter %s", name)
+	}
+	field, _ := reflect.TypeOf(tomlConfiguration_t{}).FieldByName(name)
+	allowed, err := parseConfigurationRange(field.Tag.Get("range"))
+	if err != nil {
+		return err
+	}
+	if !allowed.contains(value) {
+		return newConfigurationError(name, "must be in %s", allowed.text)
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.values[name] = value
+	store.changed[name] = true
+	store.pending = append(store.pending, parameterChange{parameter: parameter, value: value, source: source})
+	return nil
+}
+
+// Changes a value by its step times steps, e.g. from the dashboard
+func (store *ParameterStore) Adjust(name string, steps int, source string) error {
+	parameter, ok := findTunableParameter(name)
+	if !ok {
+		return fmt.Errorf("Unknown parameter %s", name)
+	}
+	value, err := store.Get(name)
+	if err != nil {
+		return err
+	}
+	// Round so that repeated steps don't accumulate noise like
+	// 0.30000000000000004
+	adjusted := value + float64(steps)*parameter.step
+	adjusted, err = strconv.ParseFloat(strconv.FormatFloat(adjusted, 'g', 6, 64), 64)
+	if err != nil {
+		return err
+	}
+	return store.Set(name, adjusted, source)
+}
+
+// Writes the queued changes into the configuration and logs them. Returns
+// true if anything changed. Only call this from the pilot's goroutine.
+func (store *ParameterStore) apply() bool {
+	store.mutex.Lock()
+	pending := store.pending
+	store.pending = nil
+	store.mutex.Unlock()
+
+	for _, change := range pending {
+		before := *change.parameter.value * change.parameter.scale
+		*change.parameter.value = change.value / change.parameter.scale
+		Logger.Infof("Tuned %s from %.6g to %.6g (%s)", change.parameter.name, before, change.value, change.source)
+	}
+	return len(pending) > 0
+}
+
+// Saves the values that have been changed to the configuration file. Returns
+// the names of the values that were saved.
+func (store *ParameterStore) Persist() ([]string, error) {
+	store.mutex.Lock()
+	values := make(map[string]string)
+	names := make([]string, 0, len(store.changed))
+	for name := range store.changed {
+		values[name] = formatTomlFloat(store.values[name])
+		names = append(names, name)
+	}
+	store.mutex.Unlock()
+	sort.Strings(names)
+
+	if len(values) == 0 {
+		return names, nil
+	}
+	err := WriteConfigurationValues(store.path, values)
+	if err != nil {
+		return nil, err
+	}
+	Logger.Infof("Saved %s to %s", strings.Join(names, ", "), store.path)
+	return names, nil
+}
+
+// Serves the parameters over HTTP:
+//
+//	GET /parameters returns every value
+//	GET /parameters/<name> returns one value
+//	PUT /parameters/<name> with {"value": 3.5} changes one value
+//	POST /persist saves the changes to the configuration file
+type ParameterServer struct {
+	server   *http.Server
+	listener net.Listener
+}
+
+// Starts serving on TuningAddress. Returns nil if TuningAddress is empty.
+func NewParameterServer(store *ParameterStore) (*ParameterServer, error) {
+	if configuration.TuningAddress == "" {
+		return nil, nil
+	}
+	listener, err := net.Listen("tcp", configuration.TuningAddress)
+	if err != nil {
+		return nil, err
+	}
+	server := &ParameterServer{
+		server:   &http.Server{Handler: newParameterHandler(store)},
+		listener: listener,
+	}
+	go func() {
+		defer recoverGoroutine()
+		err := server.server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			Logger.Errorf("Parameter server stopped: %v", err)
+		}
+	}()
+	Logger.Infof("Serving parameters on %s", listener.Addr())
+	return server, nil
+}
+
+// Where we're listening, e.g. if TuningAddress has port 0
+func (server *ParameterServer) Addr() net.Addr {
+	return server.listener.Addr()
+}
+
+func (server *ParameterServer) Close() error {
+	return shutdownHttpServer(server.server)
+}
+
+type parameterValue struct {
+	Name  string  `json:"name"`
+	Value float64 `json:"value"`
+}
+
+func newParameterHandler(store *ParameterStore) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/parameters", func(response http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodGet {
+			http.Error(response, "Use GET", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJson(response, store.GetAll())
+	})
+	mux.HandleFunc("/parameters/", func(response http.ResponseWriter, request *http.Request) {
+		name := strings.TrimPrefix(request.URL.Path, "/parameters/")
+		if _, ok := findTunableParameter(name); !ok {
+			http.Error(response, fmt.Sprintf("Unknown parameter %s", name), http.StatusNotFound)
+			return
+		}
+		switch request.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			var body parameterValue
+			err := json.NewDecoder(request.Body).Decode(&body)
+			if err != nil {
+				http.Error(response, fmt.Sprintf("Bad request body: %v", err), http.StatusBadRequest)
+				return
+			}
+			err = store.Set(name, body.Value, "http "+request.RemoteAddr)
+			if err != nil {
+				http.Error(response, err.Error(), http.StatusBadRequest)
+				return
+			}
+		default:
+			http.Error(response, "Use GET or PUT", http.StatusMethodNotAllowed)
+			return
+		}
+		value, _ := store.Get(name)
+		writeJson(response, parameterValue{Name: name, Value: value})
+	})
+	mux.HandleFunc("/persist", func(response http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPost {
+			http.Error(response, "Use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		names, err := store.Persist()
+		if err != nil {
+			http.Error(response, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJson(response, map[string]interface{}{"path": store.path, "saved": names})
+	})
+	return mux
+}
+
+func writeJson(response http.ResponseWriter, value interface{}) {
+	response.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(response).Encode(value)
+	if err != nil {
+		Logger.Errorf("Unable to write response: %v", err)
+	}
+}
@@ -0,0 +1,187 @@
+package glider
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Copies conf.toml somewhere that we can write to
+func newTestParameterStore(t *testing.T) (*ParameterStore, string, func()) {
+	directory, err := ioutil.TempDir("", "glider")
+	if err != nil {
+		t.Fatalf("Couldn't create temporary directory: %v", err)
+	}
+	data, err := ioutil.ReadFile("../conf.toml")
+	if err != nil {
+		t.Fatalf("Couldn't read configuration: %v", err)
+	}
+	path := filepath.Join(directory, "conf.toml")
+	err = ioutil.WriteFile(path, data, 0644)
+	if err != nil {
+		t.Fatalf("Couldn't write configuration: %v", err)
+	}
+	return NewParameterStore(path), path, func() { os.RemoveAll(directory) }
+}
+
+func TestParameterStore(t *testing.T) {
+	restore := replaceTestConfiguration(t)
+	defer restore()
+	store, path, remove := newTestParameterStore(t)
+	defer remove()
+
+	for _, name := range store.Names() {
+		if _, ok := findTunableParameter(name); !ok || !strings.Contains(configurationDefaults(), name+" = ") {
+			t.Errorf("%s should be a configuration key with a default", name)
+		}
+	}
+
+	if value, err := store.Get("MaxTargetRoll_d"); err != nil || !approximatelyEqual(value, 25.0) {
+		t.Errorf("Bad MaxTargetRoll_d %v %v", value, err)
+	}
+	err := store.Set("ProportionalRollMultiplier", 3.5, "test")
+	if err != nil {
+		t.Fatalf("Unable to set: %v", err)
+	}
+	err = store.Adjust("MaxTargetRoll_d", 2, "test")
+	if err != nil {
+		t.Fatalf("Unable to adjust: %v", err)
+	}
+	if value, _ := store.Get("ProportionalRollMultiplier"); value != 3.5 {
+		t.Errorf("Should have the new value, got %v", value)
+	}
+	// Not until the pilot applies it
+	if configuration.ProportionalRollMultiplier != 3.0 {
+		t.Errorf("Shouldn't have changed the configuration yet, got %v", configuration.ProportionalRollMultiplier)
+	}
+	if !store.apply() {
+		t.Error("Should have applied the changes")
+	}
+	if configuration.ProportionalRollMultiplier != 3.5 || !approximatelyEqual(ToDegrees(configuration.MaxTargetRoll), 27.0) {
+		t.Errorf("Should have changed the configuration, got %v %v", configuration.ProportionalRollMultiplier, ToDegrees(configuration.MaxTargetRoll))
+	}
+	if store.apply() {
+		t.Error("Nothing should be left to apply")
+	}
+
+	bad := []struct {
+		name  string
+		value float64
+	}{
+		{"ProportionalRollMultiplier", -1.0},
+		{"MaxTargetRoll_d", 90.0},
+		{"ControlFrequency_hz", 20.0},
+	}
+	for _, test := range bad {
+		if err := store.Set(test.name, test.value, "test"); err == nil {
+			t.Errorf("Should have rejected %s = %v", test.name, test.value)
+		}
+	}
+
+	saved, err := store.Persist()
+	if err != nil {
+		t.Fatalf("Unable to persist: %v", err)
+	}
+	if len(saved) != 2 || saved[0] != "MaxTargetRoll_d" || saved[1] != "ProportionalRollMultiplier" {
+		t.Errorf("Bad saved values %v", saved)
+	}
+	layer, err := OpenConfigurationLayer(path)
+	if err != nil {
+		t.Fatalf("Unable to read saved configuration: %v", err)
+	}
+	if layer.Values["ProportionalRollMultiplier"] != 3.5 || layer.Values["MaxTargetRoll_d"] != 27.0 {
+		t.Errorf("Bad saved values %v %v", layer.Values["ProportionalRollMultiplier"], layer.Values["MaxTargetRoll_d"])
+	}
+}
+
+func TestPilotTuning(t *testing.T) {
+	restore := replaceTestConfiguration(t)
+	defer restore()
+	configuration.MissionFile = ""
+	store, _, remove := newTestParameterStore(t)
+	defer remove()
+	hardware := newFakeHardware()
+	waypoints, err := NewWaypoints()
+	if err != nil {
+		t.Fatalf("Unable to load waypoints: %v", err)
+	}
+	pilot := newPilot(hardware, NewTelemetry(hardware), NewControl(hardware.LeftServo, hardware.RightServo), waypoints)
+	pilot.SetParameterStore(store)
+	pilot.state = testMode
+
+	store.Set("ProportionalRollMultiplier", 4.0, "test")
+	store.Set("MaxServoPitchAdjustment_d", 20.0, "test")
+	pilot.step()
+	if pilot.rollPid.proportionalGain != 4.0 || !approximatelyEqual(pilot.pitchPid.outputLimit, ToRadians(20)) {
+		t.Errorf("Should have updated the gains, got %v %v", pilot.rollPid.proportionalGain, pilot.pitchPid.outputLimit)
+	}
+}
+
+func TestParameterServer(t *testing.T) {
+	restore := replaceTestConfiguration(t)
+	defer restore()
+	configuration.TuningAddress = "127.0.0.1:0"
+	store, _, remove := newTestParameterStore(t)
+	defer remove()
+	server, err := NewParameterServer(store)
+	if err != nil {
+		t.Fatalf("Unable to start server: %v", err)
+	}
+	defer server.Close()
+	url := fmt.Sprintf("http://%s", server.Addr())
+
+	request := func(method, path, body string) (int, string) {
+		httpRequest, err := http.NewRequest(method, url+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Bad request: %v", err)
+		}
+		response, err := http.DefaultClient.Do(httpRequest)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer response.Body.Close()
+		data, _ := ioutil.ReadAll(response.Body)
+		return response.StatusCode, string(data)
+	}
+
+	status, body := request("GET", "/parameters", "")
+	values := make(map[string]float64)
+	if err := json.Unmarshal([]byte(body), &values); status != http.StatusOK || err != nil || values["ProportionalRollMultiplier"] != 3.0 {
+		t.Errorf("Bad parameters %v %s", status, body)
+	}
+	status, body = request("PUT", "/parameters/ProportionalRollMultiplier", `{"value": 3.25}`)
+	if status != http.StatusOK || !strings.Contains(body, `"value":3.25`) {
+		t.Errorf("Bad put %v %s", status, body)
+	}
+	if value, _ := store.Get("ProportionalRollMultiplier"); value != 3.25 {
+		t.Errorf("Should have set the value, got %v", value)
+	}
+
+	errors := []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"GET", "/parameters/ControlFrequency_hz", "", http.StatusNotFound},
+		{"PUT", "/parameters/ProportionalRollMultiplier", `{"value": -1}`, http.StatusBadRequest},
+		{"PUT", "/parameters/ProportionalRollMultiplier", `fast`, http.StatusBadRequest},
+		{"DELETE", "/parameters/ProportionalRollMultiplier", "", http.StatusMethodNotAllowed},
+		{"GET", "/persist", "", http.StatusMethodNotAllowed},
+	}
+	for _, test := range errors {
+		if status, body := request(test.method, test.path, test.body); status != test.status {
+			t.Errorf("%s %s should be %v, got %v %s", test.method, test.path, test.status, status, body)
+		}
+	}
+
+	status, body = request("POST", "/persist", "")
+	if status != http.StatusOK || !strings.Contains(body, "ProportionalRollMultiplier") {
+		t.Errorf("Bad persist %v %s", status, body)
+	}
+}
@@ -0,0 +1,147 @@
+// A PID controller for the control loops
+package glider
+
+import (
+	"math"
+	"time"
+)
+
+type Pid struct {
+	proportionalGain float64
+	integralGain     float64
+	derivativeGain   float64
+	// The most that the integral term can contribute to the output
+	integralLimit float64
+	// The output is clamped to +-outputLimit
+	outputLimit float64
+	// Time constant of the low pass filter on the derivative term
+	derivativeTimeConstant float64
+	// If true, the setpoint and measurement are angles that wrap around
+	angular bool
+
+	integral            float64
+	derivative          float64
+	previousMeasurement float64
+	initialized         bool
+}
+
+// Creates a new PID controller. The derivative is low pass filtered at
+// derivativeCutoff_hz; 0 disables the filter.
+func NewPid(proportionalGain, integralGain, derivativeGain, integralLimit, outputLimit, derivativeCutoff_hz float64) *Pid {
+	timeConstant := 0.0
+	if derivativeCutoff_hz > 0 {
+		timeConstant = 1.0 / (2.0 * math.Pi * derivativeCutoff_hz)
+	}
+	return &Pid{
+		proportionalGain:       proportionalGain,
+		integralGain:           integralGain,
+		derivativeGain:         derivativeGain,
+		integralLimit:          math.Abs(integralLimit),
+		outputLimit:            math.Abs(outputLimit),
+		derivativeTimeConstant: timeConstant,
+	}
+}
+
+// Like NewPid, but for controlling an angle that wraps around, e.g. heading
+func NewAnglePid(proportionalGain, integralGain, derivativeGain, integralLimit, outputLimit, derivativeCutoff_hz float64) *Pid {
+	pid := NewPid(proportionalGain, integralGain, derivativeGain, integralLimit, outputLimit, derivativeCutoff_hz)
+	pid.angular = true
+	return pid
+}
+
+// Returns the control output. elapsed is the time since the previous update.
+func (pid *Pid) Update(setpoint, measurement float64, elapsed time.Duration) float64 {
+	dt := elapsed.Seconds()
+	var error_, change float64
+	if pid.angular {
+		error_ = GetAngleTo(measurement, setpoint)
+		change = GetAngleTo(pid.previousMeasurement, measurement)
+	} else {
+		error_ = setpoint - measurement
+		change = measurement - pid.previousMeasurement
+	}
+
+	// Take the derivative of the measurement instead of the error, so that
+	// changing the setpoint doesn't kick the output
+	if pid.initialized && dt > 0 {
+		rawDerivative := -change / dt
+		alpha := 1.0
+		if pid.derivativeTimeConstant > 0 {
+			alpha = dt / (pid.derivativeTimeConstant + dt)
+		}
+		pid.derivative += alpha * (rawDerivative - pid.derivative)
+	}
+	pid.previousMeasurement = measurement
+
+	proportional := pid.proportionalGain * error_
+	derivative := pid.derivativeGain * pid.derivative
+	if pid.initialized && dt > 0 && pid.integralGain != 0 {
+		integral := pid.integral + pid.integralGain*error_*dt
+		integral = clamp(integral, -pid.integralLimit, pid.integralLimit)
+		// Don't wind up if the output is already saturated in the same
+		// direction
+		unclamped := proportional + integral + derivative
+		saturated := math.Abs(unclamped) > pid.outputLimit && math.Signbit(unclamped) == math.Signbit(error_)
+		if !saturated || math.Abs(integral) < math.Abs(pid.integral) {
+			pid.integral = integral
+		}
+	}
+	pid.initialized = true
+
+	output := proportional + pid.integral + derivative
+	return clamp(output, -pid.outputLimit, pid.outputLimit)
+}
+
+// Clears the accumulated state, e.g. when changing modes
+func (pid *Pid) Reset() {
+	pid.integral = 0
+	pid.derivative = 0
+	pid.previousMeasurement = 0
+	pid.initialized = false
+}
+
+// Takes the gains and limits from other, but keeps the accumulated state, so
+// that the gains can be tuned while we're flying
+func (pid *Pid) setGains(other *Pid) {
+	pid.proportionalGain = other.proportionalGain
+	pid.integralGain = other.integralGain
+	pid.derivativeGain = other.derivativeGain
+	pid.integralLimit = other.integralLimit
+	pid.outputLimit = other.outputLimit
+	pid.derivativeTimeConstant = other.derivativeTimeConstant
+	pid.integral = clamp(pid.integral, -pid.integralLimit, pid.integralLimit)
+}
+
+func newRollPid() *Pid {
+	return NewPid(
+		configuration.ProportionalRollMultiplier,
+		configuration.IntegralRollMultiplier,
+		configuration.DerivativeRollMultiplier,
+		configuration.MaxRollIntegral,
+		configuration.MaxServoAngleOffset,
+		configuration.PidDerivativeCutoff_hz,
+	)
+}
+
+func newPitchPid() *Pid {
+	return NewPid(
+		configuration.ProportionalPitchMultiplier,
+		configuration.IntegralPitchMultiplier,
+		configuration.DerivativePitchMultiplier,
+		configuration.MaxPitchIntegral,
+		configuration.MaxServoPitchAdjustment,
+		configuration.PidDerivativeCutoff_hz,
+	)
+}
+
+// Turns a heading error into a target roll
+func newHeadingPid() *Pid {
+	return NewAnglePid(
+		configuration.ProportionalTargetRollMultiplier,
+		configuration.IntegralTargetRollMultiplier,
+		configuration.DerivativeTargetRollMultiplier,
+		configuration.MaxTargetRollIntegral,
+		configuration.MaxTargetRoll,
+		configuration.PidDerivativeCutoff_hz,
+	)
+}
@@ -0,0 +1,92 @@
+package glider
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPidProportional(t *testing.T) {
+	pid := NewPid(2.0, 0.0, 0.0, 0.0, 10.0, 0.0)
+	output := pid.Update(1.0, 0.0, 0)
+	if !approximatelyEqual(output, 2.0) {
+		t.Errorf("Bad output %v", output)
+	}
+	output = pid.Update(100.0, 0.0, time.Second)
+	if !approximatelyEqual(output, 10.0) {
+		t.Errorf("Output not clamped %v", output)
+	}
+}
+
+func TestPidIntegralWindup(t *testing.T) {
+	pid := NewPid(1.0, 1.0, 0.0, 0.5, 2.0, 0.0)
+	var output float64
+	for i := 0; i < 100; i++ {
+		output = pid.Update(1.0, 0.0, 100*time.Millisecond)
+	}
+	if !approximatelyEqual(output, 1.5) {
+		t.Errorf("Integral not clamped %v", output)
+	}
+	if !approximatelyEqual(pid.integral, 0.5) {
+		t.Errorf("Bad integral %v", pid.integral)
+	}
+
+	// When saturated, the integral should stop growing
+	pid = NewPid(1.0, 1.0, 0.0, 100.0, 2.0, 0.0)
+	for i := 0; i < 100; i++ {
+		output = pid.Update(5.0, 0.0, 100*time.Millisecond)
+	}
+	if !approximatelyEqual(output, 2.0) {
+		t.Errorf("Bad saturated output %v", output)
+	}
+	if pid.integral > 1.0 {
+		t.Errorf("Integral wound up to %v", pid.integral)
+	}
+	// So it should recover as soon as the error changes sign
+	output = pid.Update(0.0, 0.5, 100*time.Millisecond)
+	if output > 0.5 {
+		t.Errorf("Slow to recover from saturation %v", output)
+	}
+
+	pid.Reset()
+	if pid.integral != 0 {
+		t.Errorf("Reset didn't clear integral %v", pid.integral)
+	}
+}
+
+func TestPidDerivativeOnMeasurement(t *testing.T) {
+	pid := NewPid(0.0, 0.0, 1.0, 0.0, 10.0, 0.0)
+	pid.Update(0.0, 0.0, 0)
+	// Changing the setpoint shouldn't kick the output
+	output := pid.Update(5.0, 0.0, 100*time.Millisecond)
+	if !approximatelyEqual(output, 0.0) {
+		t.Errorf("Setpoint change kicked the output %v", output)
+	}
+	// Moving toward the setpoint should be damped
+	output = pid.Update(5.0, 0.1, 100*time.Millisecond)
+	if !approximatelyEqual(output, -1.0) {
+		t.Errorf("Bad derivative %v", output)
+	}
+
+	// The filter should smooth out the same step
+	filtered := NewPid(0.0, 0.0, 1.0, 0.0, 10.0, 1.0)
+	filtered.Update(5.0, 0.0, 0)
+	output = filtered.Update(5.0, 0.1, 100*time.Millisecond)
+	if output >= 0 || output <= -1.0 {
+		t.Errorf("Bad filtered derivative %v", output)
+	}
+}
+
+func TestAnglePid(t *testing.T) {
+	pid := NewAnglePid(1.0, 0.0, 1.0, 0.0, 10.0, 0.0)
+	// 350 to 10 degrees should be a 20 degree error, not -340
+	output := pid.Update(ToRadians(10), ToRadians(350), 0)
+	if math.Abs(ToDegrees(output)-20) > 0.01 {
+		t.Errorf("Bad wrapped error %0.1f", ToDegrees(output))
+	}
+	// Crossing north shouldn't spike the derivative
+	output = pid.Update(ToRadians(10), ToRadians(1), time.Second)
+	if math.Abs(ToDegrees(output)) > 10 {
+		t.Errorf("Derivative spiked crossing north %0.1f", ToDegrees(output))
+	}
+}
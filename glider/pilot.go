@@ -1,6 +1,9 @@
 package glider
 
 import (
+	"context"
+	"fmt"
+	"github.com/bskari/go-glider/flightdata"
 	"github.com/nsf/termbox-go"
 	"github.com/stianeikeland/go-rpio/v4"
 	"io"
@@ -19,6 +22,12 @@ const (
 	initializing
 	landed
 	testMode
+	// These came later, so they're at the end to keep the numbers in old logs
+	// and ground station modes the same
+	ascending
+	released
+	landing
+	terminated
 )
 
 func (ps PilotState) String() string {
@@ -30,132 +39,307 @@ func (ps PilotState) String() string {
 		"initializing",
 		"landed",
 		"testMode",
+		"ascending",
+		"released",
+		"landing",
+		"terminated",
 	}[ps]
 }
 
+func (ps PilotState) isValid() bool {
+	return ps >= flying && ps <= terminated
+}
+
 type Pilot struct {
-	state                 PilotState
-	telemetry             *Telemetry
-	control               *Control
-	statusIndicator       *LedStatusIndicator
-	buttonPin             *rpio.Pin
-	buttonPressTime       time.Time
-	zeroSpeedTime         *time.Time
-	waypoints             *Waypoints
-	previousUpdateRoll_r  Radians
-	previousUpdatePitch_r Radians
-	previousAxes          Axes
-	axesIdleTime          time.Time
-}
-
-func NewPilot() (*Pilot, error) {
-	telemetry, err := NewTelemetry()
+	state                PilotState
+	previousState        PilotState
+	telemetry            *Telemetry
+	control              *Control
+	statusIndicator      *LedStatusIndicator
+	buttonPin            digitalInput
+	buttonPressTime      time.Time
+	zeroSpeedTime        *time.Time
+	waypoints            *Waypoints
+	previousLeftAngle_r  Radians
+	previousRightAngle_r Radians
+	previousAxes         Axes
+	axesIdleTime         time.Time
+	rollPid              *Pid
+	pitchPid             *Pid
+	headingPid           *Pid
+	// When the roll and pitch loops last ran
+	controlTime   time.Time
+	targetRoll_r  Radians
+	targetPitch_r Radians
+	scheduler     *Scheduler
+	recorder      *flightdata.Writer
+	mavlink       *MavlinkLink
+	aprs          *AprsBeacon
+	groundStation *GroundStation
+	geofence      *Geofence
+	cutdown       cutdownOutput
+	// The altitude where we started climbing, while we're waiting to see if
+	// it's a balloon launch
+	climbStartAltitude *Meters
+	// When we fired the cutdown, whether it's still on, and when we
+	// started falling
+	releaseTime   time.Time
+	cutdownActive bool
+	fallTime      time.Time
+	// When the accelerometer started reading close to 0 g
+	freeFallStartTime *time.Time
+	// Nil until we start landing
+	landing *Landing
+	// Positive when we're right of the current leg
+	crossTrackError Meters
+	// Nil if we can't be tuned
+	parameters *ParameterStore
+	// Which parameter the dashboard is tuning
+	selectedParameter int
+}
+
+func NewPilot(hardware *Hardware) (*Pilot, error) {
+	telemetry := NewTelemetry(hardware)
+	waypoints, err := NewWaypoints()
 	if err != nil {
 		return nil, err
 	}
 
-	response := rpio.Pin(configuration.ButtonPin)
-	buttonPin := &response
-	buttonPin.Input()
-	buttonPin.PullUp()
+	control := NewControl(hardware.LeftServo, hardware.RightServo)
+	pilot := newPilot(hardware, telemetry, control, waypoints)
+	// TODO
+	//pilot.state = initializing
+	pilot.state = testMode
+	return pilot, nil
+}
 
+func newPilot(hardware *Hardware, telemetry *Telemetry, control *Control, waypoints *Waypoints) *Pilot {
 	return &Pilot{
-		// TODO
-		//state:           initializing,
-		state:           testMode,
-		control:         NewControl(),
+		state:           initializing,
+		control:         control,
 		telemetry:       telemetry,
-		statusIndicator: NewLedStatusIndicator(uint8(initializing)),
-		buttonPin:       buttonPin,
-		buttonPressTime: time.Now(),
+		statusIndicator: NewLedStatusIndicator(hardware.Led, uint8(initializing)),
+		buttonPin:       hardware.Button,
+		cutdown:         hardware.Cutdown,
+		buttonPressTime: pilotClock.Now(),
 		zeroSpeedTime:   nil,
-		waypoints:       NewWaypoints(),
-	}, nil
+		waypoints:       waypoints,
+		rollPid:         newRollPid(),
+		pitchPid:        newPitchPid(),
+		headingPid:      newHeadingPid(),
+	}
+}
+
+// Reports to and takes commands from a ground station
+func (pilot *Pilot) SetMavlinkLink(link *MavlinkLink) {
+	pilot.mavlink = link
+	link.pilot = pilot
+}
+
+// Sends position beacons to chase crews
+func (pilot *Pilot) SetAprsBeacon(beacon *AprsBeacon) {
+	pilot.aprs = beacon
+	beacon.pilot = pilot
+}
+
+// Streams telemetry to browsers
+func (pilot *Pilot) SetGroundStation(station *GroundStation) {
+	pilot.groundStation = station
+	station.pilot = pilot
+}
+
+// Keeps us in or out of zones
+func (pilot *Pilot) SetGeofence(fence *Geofence) {
+	pilot.geofence = fence
+	fence.pilot = pilot
 }
 
-// Run the local glide test, e.g. when throwing the plane down a hill
-func (pilot *Pilot) RunGlideTestForever() {
-	previousState := pilot.state
+// Lets the gains be tuned while we're running
+func (pilot *Pilot) SetParameterStore(store *ParameterStore) {
+	pilot.parameters = store
+}
+
+// How often to log the scheduler stats
+const schedulerStatsLogPeriod = 10 * time.Second
+
+// Run the local glide test, e.g. when throwing the plane down a hill. Runs
+// until q is pressed or ctx is done.
+func (pilot *Pilot) RunGlideTestForever(ctx context.Context) {
+	pilot.previousState = pilot.state
 	Logger.Infof("Starting RunGlideTestForever in state %s", pilot.state)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
 	eventQueue := make(chan termbox.Event)
 	go func() {
+		defer recoverGoroutine()
 		for {
-			eventQueue <- termbox.PollEvent()
-		}
-	}()
-
-	for {
-		if previousState != pilot.state {
-			Logger.Infof("RunGlideTestForever new state %s", pilot.state)
-			previousState = pilot.state
-		}
-		pilot.statusIndicator.BlinkState(uint8(pilot.state))
-
-		// Parse all queued messages
-		for {
-			parsed, err := pilot.telemetry.ParseQueuedMessage()
-			if err != nil && err != io.EOF {
-				Logger.Errorf("Unable to parse GPS message: %v", err)
-				break
+			event := termbox.PollEvent()
+			if event.Type == termbox.EventInterrupt {
+				return
 			}
-			if !parsed {
-				break
+			select {
+			case eventQueue <- event:
+			case <-ctx.Done():
+				return
 			}
 		}
+	}()
 
-		Logger.Debug("Running step")
-		switch pilot.state {
-		case initializing:
-			pilot.runInitializing()
-		case waitingForButton:
-			pilot.runWaitingForButton()
-		case waitingForLaunch:
-			pilot.runWaitForLaunch()
-		case flying:
-			pilot.runFlying()
-		case landed:
-			pilot.runLanded()
-		case testMode:
-			pilot.runGlideDirection()
-		}
+	pilot.telemetry.StartGpsReader(ctx)
+	defer pilot.telemetry.StopGpsReader()
 
+	scheduler := NewScheduler(nil)
+	pilot.addFlightTasks(scheduler)
+	scheduler.AddTask("dashboard", configuration.DashboardPeriod, func() {
 		select {
 		case event := <-eventQueue:
-			// Check for any key presses
-			if event.Type == termbox.EventKey {
-				return
+			if event.Type == termbox.EventKey && pilot.handleKey(event) {
+				cancel()
 			}
 		default:
 			updateDashboard(pilot.telemetry, pilot)
 		}
+	})
+	go func() {
+		<-ctx.Done()
+		scheduler.Stop()
+	}()
+	scheduler.Run()
+	Logger.Info("Stopped RunGlideTestForever")
 
-		// TODO: Maybe we want to figure out how long one iteration
-		// took, then sleep an appropriate amount of time, so we can get
-		// close to however many cycles per second
-		time.Sleep(configuration.IterationSleepTime)
+	// Wake up the event goroutine if it's waiting for a key. If it already
+	// quit, this never returns, so don't wait for it.
+	go termbox.Interrupt()
+}
+
+// Adds the tasks that fly the plane
+func (pilot *Pilot) addFlightTasks(scheduler *Scheduler) {
+	pilot.scheduler = scheduler
+	// The AHRS steps at its own rate whatever state we're in. GetAxes
+	// returns the error from reading the sensors, so the states log it.
+	scheduler.AddTask("ahrs", configuration.AhrsPeriod, func() {
+		pilot.telemetry.UpdateAxes()
+		pilot.recordAhrsStep()
+	})
+	scheduler.AddTask("control", configuration.ControlPeriod, pilot.step)
+	// The GPS reader goroutine parses the sentences as they arrive if it's
+	// running, otherwise we poll for them
+	if pilot.telemetry.gpsReader == nil {
+		scheduler.AddTask("gps", configuration.GpsPeriod, pilot.parseQueuedMessages)
+	}
+	if pilot.mavlink != nil {
+		scheduler.AddTask("mavlink", configuration.MavlinkPeriod, pilot.mavlink.update)
+	}
+	if pilot.aprs != nil {
+		scheduler.AddTask("aprs", aprsCheckPeriod, pilot.aprs.update)
+	}
+	if pilot.groundStation != nil {
+		scheduler.AddTask("groundstation", configuration.GroundStationPeriod, pilot.groundStation.update)
+	}
+	scheduler.AddTask("stats", schedulerStatsLogPeriod, func() {
+		for _, stats := range scheduler.GetStats() {
+			Logger.Infof("Loop stats %v", stats)
+		}
+	})
+}
+
+// Runs one iteration of the current state
+func (pilot *Pilot) step() {
+	if pilot.previousState != pilot.state {
+		Logger.Infof("RunGlideTestForever new state %s", pilot.state)
+		pilot.changedState(pilot.previousState)
+		pilot.previousState = pilot.state
+		pilot.resetControllers()
+	}
+	pilot.statusIndicator.BlinkState(uint8(pilot.state))
+	if pilot.parameters != nil && pilot.parameters.apply() {
+		pilot.updateControllerGains()
+	}
+
+	Logger.Debug("Running step")
+	switch pilot.state {
+	case initializing:
+		pilot.runInitializing()
+	case waitingForButton:
+		pilot.runWaitingForButton()
+	case waitingForLaunch:
+		pilot.runWaitForLaunch()
+	case flying:
+		pilot.runFlying()
+	case landed:
+		pilot.runLanded()
+	case testMode:
+		pilot.runGlideDirection()
+	case ascending:
+		pilot.runAscending()
+	case released:
+		pilot.runReleased()
+	case landing:
+		pilot.runLanding()
+	case terminated:
+		pilot.runTerminated()
+	}
+	pilot.recordTick()
+}
+
+// Clears what the previous state left behind, so that the next landing or
+// release starts over
+func (pilot *Pilot) changedState(previous PilotState) {
+	if previous == landing {
+		pilot.landing = nil
+	}
+	if pilot.state == ascending || pilot.state == released {
+		pilot.releaseTime = time.Time{}
+		pilot.fallTime = time.Time{}
+	}
+}
+
+// Replaces the mission, e.g. from a ground station. A landing in progress
+// starts over at the new landing point.
+func (pilot *Pilot) setWaypoints(waypoints *Waypoints) {
+	pilot.waypoints = waypoints
+	pilot.landing = nil
+}
+
+// Parse all queued messages
+func (pilot *Pilot) parseQueuedMessages() {
+	for {
+		parsed, err := pilot.telemetry.ParseQueuedMessage()
+		if err != nil && err != io.EOF {
+			Logger.Errorf("Unable to parse GPS message: %v", err)
+			break
+		}
+		if !parsed {
+			break
+		}
 	}
 }
 
 func (pilot *Pilot) runInitializing() {
-	if pilot.telemetry.HasGpsLock {
+	if pilot.telemetry.HasGpsLock() {
 		pilot.state = waitingForButton
 		Logger.Info("Got GPS lock, waiting for button")
 	}
 }
 
 func (pilot *Pilot) runWaitingForButton() {
+	if pilot.isAscending() {
+		Logger.Info("Climbing, ascending on a balloon")
+		pilot.state = ascending
+		return
+	}
 	buttonState := pilot.buttonPin.Read()
 	if buttonState == rpio.Low {
 		Logger.Info("Button pressed, waiting for launch")
 		pilot.state = waitingForLaunch
-		pilot.buttonPressTime = time.Now()
+		pilot.buttonPressTime = pilotClock.Now()
 	}
 }
 
 func (pilot *Pilot) runWaitForLaunch() {
 	// Just adjust the ailerons to keep the plane level
-	if time.Since(pilot.buttonPressTime) < configuration.LaunchGlideDuration {
+	if pilotClock.Now().Sub(pilot.buttonPressTime) < configuration.LaunchGlideDuration {
 		pilot.runGlideLevel()
 	} else {
 		pilot.state = flying
@@ -165,17 +349,20 @@ func (pilot *Pilot) runWaitForLaunch() {
 func (pilot *Pilot) runFlying() {
 	// Fly in a direction
 
-	position := pilot.telemetry.GetPosition()
-	if pilot.waypoints.Reached(position) {
-		pilot.waypoints.Next()
+	estimate := pilot.telemetry.GetPositionEstimate()
+	if estimate.IsStale() {
+		// Don't navigate using old data, just keep the wings level until we
+		// get a new fix
+		Logger.Debugf("Stale GPS fix, age:%v uncertainty:%0.1f", estimate.Age, estimate.Uncertainty)
+		pilot.runGlideLevel()
+		return
 	}
-
-	waypoint := pilot.waypoints.GetWaypoint()
+	position := estimate.Point
 	axes, err := pilot.telemetry.GetAxes()
 	if err != nil {
 		// I guess just log it?
 		Logger.Errorf("runFlying unable to get axes: %v", err)
-		time.Sleep(configuration.ErrorSleepDuration)
+		pilotClock.Sleep(configuration.ErrorSleepDuration)
 		return
 	}
 
@@ -184,14 +371,74 @@ func (pilot *Pilot) runFlying() {
 		return
 	}
 
-	targetRoll_r := getTargetRollPosition(axes.Yaw, position, waypoint)
+	if estimate.AltitudeValid && estimate.Altitude-configuration.LandingPointAltitude < configuration.LandingSpiralAltitude {
+		pilot.state = landing
+		Logger.Infof("Low enough to land, spiraling down over %v", pilot.waypoints.GetLandingPoint())
+		return
+	}
+
+	if pilot.geofence != nil && pilot.runGeofence(estimate, axes) {
+		return
+	}
+
+	var targetRoll_r Radians
+	switch configuration.GuidanceMode {
+	case GUIDANCE_MODE_L1:
+		if pilot.waypoints.LegCompleted(position) {
+			pilot.waypoints.Next()
+			Logger.Infof("Leg completed, next waypoint %v", pilot.waypoints.GetWaypoint())
+		}
+		start, waypoint := pilot.waypoints.GetLeg(position)
+		targetRoll_r, pilot.crossTrackError = getTargetRollL1(estimate, axes.Yaw, start, waypoint)
+		Logger.Debugf("crossTrack:%0.1f targetRoll:%0.1f", pilot.crossTrackError, ToDegrees(targetRoll_r))
+	default:
+		if pilot.waypoints.Reached(position) {
+			pilot.waypoints.Next()
+		}
+		waypoint := pilot.waypoints.GetWaypoint()
+		targetRoll_r = getTargetRollPosition(pilot.headingPid, axes.Yaw, position, waypoint, pilot.getControlElapsed())
+	}
 	pilot.adjustAileronsToRollPitch(targetRoll_r, configuration.TargetPitch, axes)
 }
 
+func (pilot *Pilot) runLanding() {
+	if pilot.landing == nil {
+		pilot.landing = newLanding(pilot.waypoints.GetLandingPoint())
+	}
+	estimate := pilot.telemetry.GetPositionEstimate()
+	if estimate.IsStale() || !estimate.AltitudeValid {
+		Logger.Debugf("Stale GPS fix while landing, age:%v uncertainty:%0.1f altitude:%v", estimate.Age, estimate.Uncertainty, estimate.AltitudeValid)
+		pilot.runGlideLevel()
+		return
+	}
+	axes, err := pilot.telemetry.GetAxes()
+	if err != nil {
+		Logger.Errorf("runLanding unable to get axes: %v", err)
+		pilotClock.Sleep(configuration.ErrorSleepDuration)
+		return
+	}
+	if pilot.hasLanded(axes) {
+		pilot.state = landed
+		return
+	}
+
+	// Once we're on final, we're committed
+	if pilot.geofence != nil && pilot.landing.phase == LANDING_PHASE_SPIRAL && pilot.runGeofenceLanding(estimate, axes) {
+		return
+	}
+
+	phase := pilot.landing.phase
+	targetRoll_r, targetPitch_r := pilot.landing.update(estimate, axes.Yaw)
+	if pilot.landing.phase != phase {
+		// The pitch target jumps when we flare
+		pilot.resetControllers()
+	}
+	Logger.Debugf("landing phase:%v targetRoll:%0.1f targetPitch:%0.1f", pilot.landing.phase, ToDegrees(targetRoll_r), ToDegrees(targetPitch_r))
+	pilot.adjustAileronsToRollPitch(targetRoll_r, targetPitch_r, axes)
+}
+
 func (pilot *Pilot) runLanded() {
-	// Move the servos to center
-	pilot.control.SetLeft(90)
-	pilot.control.SetRight(90)
+	pilot.centerServos()
 
 	// When the button is pressed, start over
 	buttonState := pilot.buttonPin.Read()
@@ -201,13 +448,136 @@ func (pilot *Pilot) runLanded() {
 	}
 }
 
+// Returns true once we've been climbing for a while, e.g. on a balloon
+func (pilot *Pilot) isAscending() bool {
+	estimate := pilot.telemetry.GetPositionEstimate()
+	if estimate.IsStale() || !estimate.AltitudeValid || estimate.VerticalSpeed < configuration.AscentSpeed {
+		pilot.climbStartAltitude = nil
+		return false
+	}
+	if pilot.climbStartAltitude == nil {
+		altitude := estimate.Altitude
+		pilot.climbStartAltitude = &altitude
+	}
+	return estimate.Altitude-*pilot.climbStartAltitude >= configuration.AscentAltitudeGain
+}
+
+func (pilot *Pilot) runAscending() {
+	// The ailerons can't do anything while we're hanging from the balloon
+	pilot.centerServos()
+
+	if pilot.isFreeFalling() {
+		pilot.release("we're falling, so the balloon probably burst")
+		return
+	}
+	estimate := pilot.telemetry.GetPositionEstimate()
+	if estimate.IsStale() {
+		return
+	}
+	if estimate.AltitudeValid && estimate.Altitude >= configuration.ReleaseAltitude {
+		pilot.release("we reached the release altitude")
+	} else if configuration.ReleaseDistance > 0 && Distance(estimate.Point, pilot.waypoints.GetWaypoint()) > configuration.ReleaseDistance {
+		pilot.release("we drifted too far from the waypoint")
+	} else if estimate.AltitudeValid && estimate.VerticalSpeed < -configuration.AscentSpeed {
+		pilot.release("we're descending, so the balloon is probably leaking")
+	} else if pilot.geofence != nil {
+		// We can't steer while we're hanging, so get off before we get there
+		if breach, _, ok := pilot.geofence.check(estimate); ok {
+			pilot.release(fmt.Sprintf("of the geofence, %v", breach))
+		}
+	}
+}
+
+// Switches to released, which fires the cutdown and waits to fall
+func (pilot *Pilot) release(reason string) {
+	Logger.Infof("Releasing because %s", reason)
+	pilot.state = released
+}
+
+func (pilot *Pilot) fireCutdown() {
+	pilot.releaseTime = pilotClock.Now()
+	pilot.setCutdown(true)
+}
+
+func (pilot *Pilot) setCutdown(active bool) {
+	pilot.cutdownActive = active
+	err := pilot.cutdown.Set(active)
+	if err != nil {
+		Logger.Errorf("Unable to set cutdown to %v: %v", active, err)
+	}
+}
+
+// Waits for the release to take, holds the wings level while we recover from
+// the fall, then flies
+func (pilot *Pilot) runReleased() {
+	now := pilotClock.Now()
+	if pilot.releaseTime.IsZero() {
+		// We just got here, either from ascending or from the ground station
+		pilot.fireCutdown()
+	}
+	if pilot.cutdownActive && now.Sub(pilot.releaseTime) >= configuration.CutdownDuration {
+		pilot.setCutdown(false)
+	}
+
+	if pilot.fallTime.IsZero() {
+		estimate := pilot.telemetry.GetPositionEstimate()
+		falling := pilot.isFreeFalling() || (!estimate.IsStale() && estimate.AltitudeValid && estimate.VerticalSpeed < -configuration.AscentSpeed)
+		if falling {
+			Logger.Info("Released, stabilizing")
+			pilot.fallTime = now
+		} else {
+			pilot.centerServos()
+			if now.Sub(pilot.releaseTime) >= 2*configuration.CutdownDuration {
+				Logger.Warning("Still not falling, firing the cutdown again")
+				pilot.fireCutdown()
+			}
+			return
+		}
+	}
+
+	if now.Sub(pilot.fallTime) < configuration.ReleaseStabilizeDuration {
+		pilot.runGlideLevel()
+		return
+	}
+	if pilot.cutdownActive {
+		pilot.setCutdown(false)
+	}
+	Logger.Info("Stabilized, flying")
+	pilot.state = flying
+}
+
+// Returns true once the accelerometer has read close to 0 g for a while
+func (pilot *Pilot) isFreeFalling() bool {
+	acceleration_g, err := pilot.telemetry.GetAcceleration()
+	if err != nil {
+		Logger.Errorf("Unable to read accelerometer: %v", err)
+		return false
+	}
+	if acceleration_g > configuration.FreeFallAcceleration_g {
+		pilot.freeFallStartTime = nil
+		return false
+	}
+	now := pilotClock.Now()
+	if pilot.freeFallStartTime == nil {
+		pilot.freeFallStartTime = &now
+	}
+	return now.Sub(*pilot.freeFallStartTime) >= configuration.FreeFallDuration
+}
+
+func (pilot *Pilot) centerServos() {
+	pilot.control.SetLeft(ToRadians(90))
+	pilot.control.SetRight(ToRadians(90))
+	pilot.previousLeftAngle_r = 0
+	pilot.previousRightAngle_r = 0
+}
+
 // Just adjust the ailerons to fly in a direction.
 func (pilot *Pilot) runGlideDirection() {
 	axes, err := pilot.telemetry.GetAxes()
 	if err != nil {
 		// I guess just log it?
 		Logger.Errorf("runGlideDirection unable to get axes: %v", err)
-		time.Sleep(configuration.ErrorSleepDuration)
+		pilotClock.Sleep(configuration.ErrorSleepDuration)
 		return
 	}
 
@@ -217,7 +587,7 @@ func (pilot *Pilot) runGlideDirection() {
 		return
 	}
 
-	targetRoll_r := getTargetRollHeading(axes.Yaw, configuration.FlyDirection)
+	targetRoll_r := getTargetRollHeading(pilot.headingPid, axes.Yaw, configuration.FlyDirection, pilot.getControlElapsed())
 	Logger.Debugf("targetRoll:%0.1f", ToDegrees(targetRoll_r))
 
 	// Now adjust the ailerons to fly that direction
@@ -231,7 +601,7 @@ func (pilot *Pilot) runGlideLevel() {
 	if err != nil {
 		// I guess just log it?
 		Logger.Errorf("runGlideLevel unable to get axes: %v", err)
-		time.Sleep(configuration.ErrorSleepDuration)
+		pilotClock.Sleep(configuration.ErrorSleepDuration)
 		return
 	}
 	// If we've landed, stop adjusting the ailerons
@@ -240,18 +610,19 @@ func (pilot *Pilot) runGlideLevel() {
 		return
 	}
 
+	// We're not steering, so the heading loop will start over when we do
+	pilot.headingPid.Reset()
+
 	// Now adjust the ailerons to fly straight
 	pilot.adjustAileronsToRollPitch(0.0, configuration.TargetPitch, axes)
 }
 
 func (pilot *Pilot) hasLanded(axes Axes) bool {
-	// TODO: When we launch the balloon, check that the altitude is
-	// below configuration.LandingPointAltitude + configuration.LandingPointAltitudeOffset
 	var returnValue bool
 	if math.Abs(pilot.previousAxes.Roll-axes.Roll) > ToRadians(Degrees(1.0)) {
-		pilot.axesIdleTime = time.Now()
+		pilot.axesIdleTime = pilotClock.Now()
 		returnValue = false
-	} else if time.Since(pilot.axesIdleTime) > configuration.LandNoMoveDuration {
+	} else if pilotClock.Now().Sub(pilot.axesIdleTime) > configuration.LandNoMoveDuration {
 		returnValue = true
 	}
 
@@ -260,23 +631,33 @@ func (pilot *Pilot) hasLanded(axes Axes) bool {
 		returnValue = false
 	} else if pilot.zeroSpeedTime == nil {
 		returnValue = false
-	} else if time.Since(*pilot.zeroSpeedTime) > configuration.LandNoMoveDuration {
+	} else if pilotClock.Now().Sub(*pilot.zeroSpeedTime) > configuration.LandNoMoveDuration {
 		returnValue = returnValue && true
 	}
 
 	pilot.previousAxes = axes
+
+	// We can't have landed if we're still way above the landing point, e.g.
+	// while recovering from a balloon drop
+	estimate := pilot.telemetry.GetPositionEstimate()
+	if !estimate.IsStale() && estimate.AltitudeValid && estimate.Altitude > configuration.LandingPointAltitude+configuration.LandingPointAltitudeOffset {
+		returnValue = false
+	}
 	return returnValue
 }
 
 // Adjust the ailerons to match some pitch and roll
 func (pilot *Pilot) adjustAileronsToRollPitch(targetRoll_r, targetPitch_r Radians, axes Axes) {
-	// Just use a P loop for now?
-	rollDifference := axes.Roll - targetRoll_r
-	leftAngle_r := rollDifference * configuration.ProportionalRollMultiplier
+	elapsed := pilot.getControlElapsed()
+	pilot.controlTime = pilotClock.Now()
+	pilot.targetRoll_r = targetRoll_r
+	pilot.targetPitch_r = targetPitch_r
+
+	// Rolling right needs the left aileron up and the right aileron down
+	leftAngle_r := -pilot.rollPid.Update(targetRoll_r, axes.Roll, elapsed)
 	rightAngle_r := leftAngle_r
 
-	adjustment := (targetPitch_r - axes.Pitch) * configuration.ProportionalPitchMultiplier
-	adjustment = clamp(adjustment, -configuration.MaxServoPitchAdjustment, configuration.MaxServoPitchAdjustment)
+	adjustment := pilot.pitchPid.Update(targetPitch_r, axes.Pitch, elapsed)
 
 	leftAngle_r -= adjustment
 	rightAngle_r += adjustment
@@ -286,8 +667,8 @@ func (pilot *Pilot) adjustAileronsToRollPitch(targetRoll_r, targetPitch_r Radian
 
 	// Let's only move the servo when it's changed a little so that the
 	// servo isn't freaking out due to noisy sensors
-	difference_r := math.Abs(pilot.previousUpdateRoll_r - axes.Roll)
-	difference_r += math.Abs(pilot.previousUpdatePitch_r - axes.Pitch)
+	difference_r := math.Abs(pilot.previousLeftAngle_r - leftAngle_r)
+	difference_r += math.Abs(pilot.previousRightAngle_r - rightAngle_r)
 
 	Logger.Debugf("roll:%0.1f targetRoll:%0.1f", ToDegrees(axes.Roll), ToDegrees(targetRoll_r))
 	Logger.Debugf("pitch:%0.1f targetPitch:%0.1f", ToDegrees(axes.Pitch), ToDegrees(targetPitch_r))
@@ -297,23 +678,44 @@ func (pilot *Pilot) adjustAileronsToRollPitch(targetRoll_r, targetPitch_r Radian
 		return
 	}
 
-	pilot.previousUpdateRoll_r = axes.Roll
-	pilot.previousUpdatePitch_r = axes.Pitch
+	pilot.previousLeftAngle_r = leftAngle_r
+	pilot.previousRightAngle_r = rightAngle_r
 	Logger.Debugf("setting leftAngle:%0.1f rightAngle:%0.1f", ToDegrees(leftAngle_r), ToDegrees(rightAngle_r))
 	pilot.control.SetLeft(ToRadians(90) + leftAngle_r)
 	pilot.control.SetRight(ToRadians(90) + rightAngle_r)
 }
 
-func getTargetRollPosition(yaw_r Radians, position, waypoint Point) Radians {
+// Returns the time since the control loops last ran, or 0 if they haven't
+func (pilot *Pilot) getControlElapsed() time.Duration {
+	if pilot.controlTime.IsZero() {
+		return 0
+	}
+	return pilotClock.Now().Sub(pilot.controlTime)
+}
+
+// Clears the control loops, e.g. when changing states, so that the integral
+// and derivative terms from one state don't carry over to the next
+func (pilot *Pilot) resetControllers() {
+	pilot.rollPid.Reset()
+	pilot.pitchPid.Reset()
+	pilot.headingPid.Reset()
+	pilot.controlTime = time.Time{}
+}
+
+// Picks up tuned gains without resetting the controllers
+func (pilot *Pilot) updateControllerGains() {
+	pilot.rollPid.setGains(newRollPid())
+	pilot.pitchPid.setGains(newPitchPid())
+	pilot.headingPid.setGains(newHeadingPid())
+}
+
+func getTargetRollPosition(headingPid *Pid, yaw_r Radians, position, waypoint Point, elapsed time.Duration) Radians {
 	goalHeading_r := Course(position, waypoint)
-	return getTargetRollHeading(yaw_r, goalHeading_r)
+	return getTargetRollHeading(headingPid, yaw_r, goalHeading_r, elapsed)
 }
 
-func getTargetRollHeading(yaw_r, goalHeading_r Radians) Radians {
-	adjustHeading_r := GetAngleTo(yaw_r, goalHeading_r)
-	targetRoll_r := adjustHeading_r * configuration.ProportionalTargetRollMultiplier
-	targetRoll_r = clamp(targetRoll_r, -configuration.MaxTargetRoll, configuration.MaxTargetRoll)
-	return targetRoll_r
+func getTargetRollHeading(headingPid *Pid, yaw_r, goalHeading_r Radians, elapsed time.Duration) Radians {
+	return headingPid.Update(goalHeading_r, yaw_r, elapsed)
 }
 
 func clamp(value, minimum, maximum float64) float64 {
@@ -1,36 +1,155 @@
 package glider
 
 import (
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestGetTargetRollPosition(t *testing.T) {
 	configuration.ProportionalTargetRollMultiplier = 1
 	maxRoll_r := ToRadians(15.0)
 	configuration.MaxTargetRoll = maxRoll_r
-	targetRoll_r := getTargetRollPosition(0, Point{0, 0, 0}, Point{1, 0, 0})
+	configuration.IntegralTargetRollMultiplier = 0
+	configuration.DerivativeTargetRollMultiplier = 0
+	pid := newHeadingPid()
+	targetRoll_r := getTargetRollPosition(pid, 0, Point{0, 0, 0}, Point{1, 0, 0}, time.Second)
 	if !approximatelyEqual(targetRoll_r, 0) {
 		t.Errorf("Bad targetRoll: %v", targetRoll_r)
 	}
 
-	targetRoll_r = getTargetRollPosition(ToRadians(90), Point{0, 0, 0}, Point{1, 0, 0})
+	targetRoll_r = getTargetRollPosition(pid, ToRadians(90), Point{0, 0, 0}, Point{1, 0, 0}, time.Second)
 	if !approximatelyEqual(targetRoll_r, -maxRoll_r) {
 		t.Errorf("Bad targetRoll: %v", targetRoll_r)
 	}
 
-	targetRoll_r = getTargetRollPosition(ToRadians(270), Point{0, 0, 0}, Point{1, 0, 0})
+	targetRoll_r = getTargetRollPosition(pid, ToRadians(270), Point{0, 0, 0}, Point{1, 0, 0}, time.Second)
 	if !approximatelyEqual(targetRoll_r, maxRoll_r) {
 		t.Errorf("Bad targetRoll: %v", targetRoll_r)
 	}
 
 	// If we are close to the target, then the number should be lower
-	targetRoll_r = getTargetRollPosition(ToRadians(1), Point{0, 0, 0}, Point{1, 0, 0})
+	targetRoll_r = getTargetRollPosition(pid, ToRadians(1), Point{0, 0, 0}, Point{1, 0, 0}, time.Second)
 	if targetRoll_r <= -maxRoll_r*0.25 || targetRoll_r > 0 {
 		t.Errorf("Bad targetRoll: %v", targetRoll_r)
 	}
 
-	targetRoll_r = getTargetRollPosition(ToRadians(-1), Point{0, 0, 0}, Point{1, 0, 0})
+	targetRoll_r = getTargetRollPosition(pid, ToRadians(-1), Point{0, 0, 0}, Point{1, 0, 0}, time.Second)
 	if targetRoll_r < 0 || targetRoll_r >= maxRoll_r*0.25 {
 		t.Errorf("Bad targetRoll: %v", targetRoll_r)
 	}
 }
+
+func TestRelease(t *testing.T) {
+	previousClock := pilotClock
+	clock := &simulatedClock{now: time.Now()}
+	pilotClock = clock
+	defer func() {
+		pilotClock = previousClock
+	}()
+	restore := replaceTestConfiguration(t)
+	defer restore()
+	setTestAccelerometerConfiguration()
+	configuration.MissionFile = ""
+	configuration.FreeFallAcceleration_g = 0.3
+	configuration.FreeFallDuration = 200 * time.Millisecond
+	configuration.CutdownDuration = 5 * time.Second
+	configuration.ReleaseStabilizeDuration = 5 * time.Second
+
+	hardware := newFakeHardware()
+	cutdown := &fakeCutdown{}
+	hardware.Cutdown = cutdown
+	waypoints, err := NewWaypoints()
+	if err != nil {
+		t.Fatalf("Unable to load waypoints: %v", err)
+	}
+	pilot := newPilot(hardware, NewTelemetry(hardware), NewControl(hardware.LeftServo, hardware.RightServo), waypoints)
+	pilot.state = ascending
+	pilot.step()
+	if pilot.state != ascending || cutdown.active {
+		t.Fatal("Shouldn't release while hanging still")
+	}
+
+	// The ground station can release us
+	pilot.state = released
+	pilot.step()
+	if !cutdown.active {
+		t.Fatal("Should have fired the cutdown")
+	}
+	clock.Sleep(configuration.CutdownDuration)
+	pilot.step()
+	if cutdown.active {
+		t.Error("Should have turned off the cutdown")
+	}
+	clock.Sleep(configuration.CutdownDuration)
+	pilot.step()
+	if !cutdown.active {
+		t.Error("Should have fired the cutdown again")
+	}
+
+	// Falling
+	hardware.Accelerometer = newFakeImuSensor(accelerometerRawFromAirframe(vector3{0, 0, 0.1}))
+	pilot.telemetry = NewTelemetry(hardware)
+	pilot.step()
+	clock.Sleep(configuration.FreeFallDuration)
+	pilot.step()
+	if pilot.fallTime.IsZero() {
+		t.Fatal("Should have detected free fall")
+	}
+	clock.Sleep(configuration.ReleaseStabilizeDuration)
+	pilot.step()
+	if pilot.state != flying || cutdown.active {
+		t.Errorf("Should be flying with the cutdown off, state %v", pilot.state)
+	}
+
+	// Another flight starts over
+	hardware.Accelerometer = newFakeImuSensor(accelerometerRawFromAirframe(vector3{0, 0, 1}))
+	pilot.telemetry = NewTelemetry(hardware)
+	pilot.state = ascending
+	pilot.step()
+	if !pilot.releaseTime.IsZero() || !pilot.fallTime.IsZero() {
+		t.Error("Should have cleared the last release")
+	}
+	pilot.state = released
+	pilot.step()
+	if !cutdown.active || pilot.state != released {
+		t.Errorf("Should have fired the cutdown and waited to fall, state %v", pilot.state)
+	}
+}
+
+func TestFlyingWithoutAltitude(t *testing.T) {
+	previousClock := pilotClock
+	clock := &simulatedClock{now: time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)}
+	pilotClock = clock
+	defer func() {
+		pilotClock = previousClock
+	}()
+	restore := replaceTestConfiguration(t)
+	defer restore()
+	setTestAccelerometerConfiguration()
+	configuration.MissionFile = ""
+
+	hardware := newFakeHardware()
+	waypoints, err := NewWaypoints()
+	if err != nil {
+		t.Fatalf("Unable to load waypoints: %v", err)
+	}
+	pilot := newPilot(hardware, NewTelemetry(hardware), NewControl(hardware.LeftServo, hardware.RightServo), waypoints)
+	pilot.state = flying
+	pilot.previousState = flying
+
+	// Only RMC sentences, so we know where we are but not how high
+	position := offsetPoint(waypoints.GetWaypoint(), -1000, 0)
+	for i := 0; i < 3; i++ {
+		sentences := formatNmeaSentences(clock.now, position, 0, 10, 0, 1.0)
+		pilot.telemetry.parseSentence(strings.TrimSpace(sentences[0]))
+		pilot.step()
+		clock.Sleep(time.Second)
+	}
+	if !pilot.telemetry.GetPositionEstimate().Valid {
+		t.Fatal("Should have a position")
+	}
+	if pilot.state != flying {
+		t.Errorf("Should still be flying without an altitude, got %v", pilot.state)
+	}
+}
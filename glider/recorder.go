@@ -0,0 +1,72 @@
+// Records every control loop tick and AHRS step to the flight data recorder
+package glider
+
+import (
+	"github.com/bskari/go-glider/flightdata"
+	"github.com/stianeikeland/go-rpio/v4"
+)
+
+// Records every control loop tick and AHRS step to the recorder. Pass nil to
+// stop recording.
+func (pilot *Pilot) SetFlightRecorder(recorder *flightdata.Writer) {
+	pilot.recorder = recorder
+}
+
+func (pilot *Pilot) recordTick() {
+	pilot.record(false)
+}
+
+// Records the sensor readings that the AHRS just stepped with
+func (pilot *Pilot) recordAhrsStep() {
+	pilot.record(true)
+}
+
+func (pilot *Pilot) record(sensorsOnly bool) {
+	if pilot.recorder == nil {
+		return
+	}
+	record := pilot.getFlightDataRecord()
+	record.SensorsOnly = sensorsOnly
+	err := pilot.recorder.Write(&record)
+	if err != nil {
+		Logger.Errorf("Unable to record flight data: %v", err)
+	}
+}
+
+func (pilot *Pilot) getFlightDataRecord() flightdata.Record {
+	telemetry := pilot.telemetry
+	accelerometer, gyroscope, magnetometer, axes := telemetry.getRecentSensors()
+	gps := telemetry.getGpsSnapshot()
+	left_r, right_r := pilot.control.GetAngles()
+	waypoint := pilot.waypoints.GetWaypoint()
+	if pilot.state == landing && pilot.landing != nil {
+		waypoint = pilot.landing.target
+	}
+	return flightdata.Record{
+		Time:              pilotClock.Now(),
+		State:             pilot.state.String(),
+		ButtonPressed:     pilot.buttonPin.Read() == rpio.Low,
+		Accelerometer:     accelerometer,
+		Gyroscope:         gyroscope,
+		Magnetometer:      magnetometer,
+		Roll_d:            ToDegrees(axes.Roll),
+		Pitch_d:           ToDegrees(axes.Pitch),
+		Yaw_d:             ToDegrees(axes.Yaw),
+		GpsLock:           gps.lock,
+		GpsTime:           gps.fixTime,
+		GpsReceived:       gps.fixReceived,
+		Latitude:          gps.point.Latitude,
+		Longitude:         gps.point.Longitude,
+		Altitude_m:        gps.point.Altitude,
+		Speed_mps:         gps.speed,
+		Course_d:          ToDegrees(gps.course),
+		Hdop:              gps.hdop,
+		TargetRoll_d:      ToDegrees(pilot.targetRoll_r),
+		TargetPitch_d:     ToDegrees(pilot.targetPitch_r),
+		LeftServo_d:       ToDegrees(left_r),
+		RightServo_d:      ToDegrees(right_r),
+		WaypointIndex:     pilot.waypoints.index,
+		WaypointLatitude:  waypoint.Latitude,
+		WaypointLongitude: waypoint.Longitude,
+	}
+}
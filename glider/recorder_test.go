@@ -0,0 +1,76 @@
+package glider
+
+import (
+	"bytes"
+	"github.com/bskari/go-glider/flightdata"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestFlightRecorder(t *testing.T) {
+	setTestSimulatorConfiguration()
+	setTestGuidanceConfiguration()
+	configuration.MissionFile = ""
+	configuration.ControlPeriod = 20 * time.Millisecond
+	configuration.GpsPeriod = 100 * time.Millisecond
+	configuration.SimulatorTimeLimit = 20 * time.Second
+
+	var buffer bytes.Buffer
+	recorder, err := flightdata.NewWriter(&buffer)
+	if err != nil {
+		t.Fatalf("Unable to create recorder: %v", err)
+	}
+	simulator := NewSimulator()
+	simulator.SetFlightRecorder(recorder)
+	_, err = simulator.Run()
+	if err != nil {
+		t.Fatalf("Simulation failed: %v", err)
+	}
+
+	reader := flightdata.NewReader(&buffer)
+	records, err := reader.ReadAll()
+	if err != nil {
+		t.Fatalf("Unable to read records: %v", err)
+	}
+	// One record per control loop tick, and one per AHRS step
+	ticks := 0
+	for _, record := range records {
+		if !record.SensorsOnly {
+			ticks++
+		}
+	}
+	if ticks < 900 || ticks > 1001 {
+		t.Errorf("Bad tick count %v", ticks)
+	}
+	ahrsSteps := int(configuration.SimulatorTimeLimit / configuration.AhrsPeriod)
+	if len(records)-ticks < ahrsSteps-1 || len(records)-ticks > ahrsSteps+1 {
+		t.Errorf("Bad AHRS step count %v", len(records)-ticks)
+	}
+	var last flightdata.Record
+	for _, record := range records {
+		if !record.SensorsOnly {
+			last = record
+		}
+	}
+	if last.State != flying.String() {
+		t.Errorf("Bad state %v", last.State)
+	}
+	if !last.GpsLock || last.GpsTime.IsZero() {
+		t.Error("Should have recorded the GPS fix")
+	}
+	if last.Accelerometer == [3]int16{} || last.Magnetometer == [3]int16{} {
+		t.Errorf("Should have recorded the raw sensors %v %v", last.Accelerometer, last.Magnetometer)
+	}
+	left_r, right_r := simulator.control.GetAngles()
+	if math.Abs(last.LeftServo_d-ToDegrees(left_r)) > 0.01 || math.Abs(last.RightServo_d-ToDegrees(right_r)) > 0.01 {
+		t.Errorf("Bad servo angles %v %v", last.LeftServo_d, last.RightServo_d)
+	}
+	for i := 1; i < len(records); i++ {
+		// The AHRS and the control loop step at the same time on ticks
+		if records[i].Time.Before(records[i-1].Time) || (records[i].SensorsOnly && !records[i].Time.After(records[i-1].Time)) {
+			t.Errorf("Records out of order at %v", i)
+			break
+		}
+	}
+}
@@ -0,0 +1,255 @@
+// Replays recorded flights through the current pilot code, so that changes to
+// the control laws can be checked against real flight data
+package glider
+
+import (
+	"errors"
+	"fmt"
+	"github.com/bskari/go-glider/flightdata"
+	"github.com/stianeikeland/go-rpio/v4"
+	"math"
+	"time"
+)
+
+// Servo commands that differ by more than this are reported
+const replayServoTolerance = 0.5 * PI / 180
+
+// Feeds recorded sensor and GPS samples back through Telemetry and the Pilot
+// using a virtual clock, and compares the servo commands that the current
+// code issues with the ones in the recording. The AHRS is stepped with the
+// recorded sensor readings, so changes to it are replayed too.
+type Replay struct {
+	records  []flightdata.Record
+	clock    *simulatedClock
+	hardware *Hardware
+	control  *Control
+	gps      *simulatedGps
+	// The record for the tick or AHRS step being replayed
+	record   *flightdata.Record
+	recorder *flightdata.Writer
+	// Whether to use the recorded attitude for recordings that don't have
+	// the AHRS steps, instead of stepping the AHRS on every tick
+	recordedAttitude bool
+}
+
+// A tick where the replayed servo commands differ from the recorded ones
+type ReplayDifference struct {
+	Time          time.Time
+	RecordedState string
+	ReplayedState string
+	RecordedLeft  Radians
+	RecordedRight Radians
+	ReplayedLeft  Radians
+	ReplayedRight Radians
+}
+
+type ReplayResult struct {
+	Ticks           int
+	StateMismatches int
+	// Servo command differences, over both servos and all ticks
+	RmsDifference Radians
+	MaxDifference Radians
+	// The ticks where either servo differs by more than the tolerance
+	Differences []ReplayDifference
+}
+
+func (result ReplayResult) String() string {
+	return fmt.Sprintf(
+		"replayed %d ticks, %d state mismatches, servo difference RMS %0.2f max %0.2f degrees, %d ticks differ by more than %0.1f degrees",
+		result.Ticks,
+		result.StateMismatches,
+		ToDegrees(result.RmsDifference),
+		ToDegrees(result.MaxDifference),
+		len(result.Differences),
+		ToDegrees(replayServoTolerance),
+	)
+}
+
+func (difference ReplayDifference) String() string {
+	return fmt.Sprintf(
+		"%v recorded %s left:%0.1f right:%0.1f replayed %s left:%0.1f right:%0.1f",
+		difference.Time.Format("15:04:05.000"),
+		difference.RecordedState,
+		ToDegrees(difference.RecordedLeft),
+		ToDegrees(difference.RecordedRight),
+		difference.ReplayedState,
+		ToDegrees(difference.ReplayedLeft),
+		ToDegrees(difference.ReplayedRight),
+	)
+}
+
+func NewReplay(records []flightdata.Record) *Replay {
+	replay := &Replay{
+		records: records,
+		clock:   &simulatedClock{},
+		gps:     &simulatedGps{},
+	}
+	replay.hardware = &Hardware{
+		LeftServo:     &fakeServo{},
+		RightServo:    &fakeServo{},
+		Button:        &replayButton{replay},
+		Led:           &fakeLed{},
+		Cutdown:       &fakeCutdown{},
+		Gps:           replay.gps,
+		Accelerometer: &replaySensor{replay, func(record *flightdata.Record) [3]int16 { return record.Accelerometer }},
+		Magnetometer:  &replaySensor{replay, func(record *flightdata.Record) [3]int16 { return record.Magnetometer }},
+		Gyroscope:     &replaySensor{replay, func(record *flightdata.Record) [3]int16 { return record.Gyroscope }},
+	}
+	replay.control = NewControl(replay.hardware.LeftServo, replay.hardware.RightServo)
+	return replay
+}
+
+// Records the replayed flight to the recorder
+func (replay *Replay) SetFlightRecorder(recorder *flightdata.Writer) {
+	replay.recorder = recorder
+}
+
+// Older recordings only have the control loop ticks, and the AHRS runs faster
+// than that, so stepping it on the ticks gives a different attitude than the
+// pilot had. This uses the recorded attitude for them instead. Recordings
+// with the AHRS steps always step the AHRS.
+func (replay *Replay) SetRecordedAttitude(recordedAttitude bool) {
+	replay.recordedAttitude = recordedAttitude
+}
+
+// Runs the pilot through every recorded tick
+func (replay *Replay) Run() (ReplayResult, error) {
+	if len(replay.records) == 0 {
+		return ReplayResult{}, errors.New("No records to replay")
+	}
+	state, err := parsePilotState(replay.records[0].State)
+	if err != nil {
+		return ReplayResult{}, err
+	}
+
+	replay.clock.now = replay.records[0].Time
+	previousClock := pilotClock
+	pilotClock = replay.clock
+	defer func() {
+		pilotClock = previousClock
+	}()
+
+	waypoints, err := NewWaypoints()
+	if err != nil {
+		return ReplayResult{}, err
+	}
+	telemetry := NewTelemetry(replay.hardware)
+	pilot := newPilot(replay.hardware, telemetry, replay.control, waypoints)
+	pilot.SetFlightRecorder(replay.recorder)
+	pilot.state = state
+	pilot.previousState = state
+
+	hasAhrsSteps := false
+	for i := range replay.records {
+		if replay.records[i].SensorsOnly {
+			hasAhrsSteps = true
+			break
+		}
+	}
+	recordedAttitude := !hasAhrsSteps && replay.recordedAttitude
+	if recordedAttitude {
+		Logger.Info("The recording doesn't have the AHRS steps, using the recorded attitude")
+	} else if !hasAhrsSteps {
+		Logger.Warning("The recording doesn't have the AHRS steps, stepping the AHRS on the ticks instead")
+	}
+	Logger.Infof("Replaying %d records starting in state %s", len(replay.records), state)
+
+	var result ReplayResult
+	var squaredSum float64
+	var gpsTime time.Time
+	for i := range replay.records {
+		replay.record = &replay.records[i]
+		// Each recorded fix was parsed by the GPS task before the record
+		// that first had it
+		if replay.record.GpsLock && !replay.record.GpsTime.Equal(gpsTime) {
+			gpsTime = replay.record.GpsTime
+			if !replay.record.GpsReceived.IsZero() {
+				replay.clock.now = replay.record.GpsReceived
+			}
+			replay.queueGpsSentences()
+			pilot.parseQueuedMessages()
+		}
+		replay.clock.now = replay.record.Time
+		// Step the AHRS with the readings that it had in flight, like
+		// the ahrs task does
+		if replay.record.SensorsOnly {
+			telemetry.UpdateAxes()
+			pilot.recordAhrsStep()
+			continue
+		}
+		if recordedAttitude {
+			telemetry.setAxes(Axes{
+				Roll:  ToRadians(replay.record.Roll_d),
+				Pitch: ToRadians(replay.record.Pitch_d),
+				Yaw:   ToRadians(replay.record.Yaw_d),
+			})
+		} else if !hasAhrsSteps {
+			telemetry.UpdateAxes()
+		}
+		pilot.step()
+
+		left_r, right_r := replay.control.GetAngles()
+		recordedLeft_r := ToRadians(replay.record.LeftServo_d)
+		recordedRight_r := ToRadians(replay.record.RightServo_d)
+		leftDifference := math.Abs(left_r - recordedLeft_r)
+		rightDifference := math.Abs(right_r - recordedRight_r)
+		squaredSum += leftDifference*leftDifference + rightDifference*rightDifference
+		result.MaxDifference = math.Max(result.MaxDifference, math.Max(leftDifference, rightDifference))
+		result.Ticks++
+		if pilot.state.String() != replay.record.State {
+			result.StateMismatches++
+		}
+		if leftDifference > replayServoTolerance || rightDifference > replayServoTolerance {
+			result.Differences = append(result.Differences, ReplayDifference{
+				Time:          replay.record.Time,
+				RecordedState: replay.record.State,
+				ReplayedState: pilot.state.String(),
+				RecordedLeft:  recordedLeft_r,
+				RecordedRight: recordedRight_r,
+				ReplayedLeft:  left_r,
+				ReplayedRight: right_r,
+			})
+		}
+	}
+	result.RmsDifference = math.Sqrt(squaredSum / float64(2*result.Ticks))
+	Logger.Infof("Replay %v", result)
+	return result, nil
+}
+
+// Queues RMC, GGA, and VTG sentences for the recorded fix
+func (replay *Replay) queueGpsSentences() {
+	record := replay.record
+	position := Point{Latitude: record.Latitude, Longitude: record.Longitude}
+	sentences := formatNmeaSentences(record.GpsTime, position, record.Altitude_m, record.Speed_mps, record.Course_d, record.Hdop)
+	replay.gps.lines = append(replay.gps.lines, sentences...)
+}
+
+func parsePilotState(name string) (PilotState, error) {
+	for state := flying; state.isValid(); state++ {
+		if state.String() == name {
+			return state, nil
+		}
+	}
+	return 0, fmt.Errorf("Bad pilot state '%s'", name)
+}
+
+type replaySensor struct {
+	replay *Replay
+	field  func(record *flightdata.Record) [3]int16
+}
+
+func (sensor *replaySensor) SenseRaw() (int16, int16, int16, error) {
+	raw := sensor.field(sensor.replay.record)
+	return raw[0], raw[1], raw[2], nil
+}
+
+type replayButton struct {
+	replay *Replay
+}
+
+func (button *replayButton) Read() rpio.State {
+	if button.replay.record != nil && button.replay.record.ButtonPressed {
+		return rpio.Low
+	}
+	return rpio.High
+}
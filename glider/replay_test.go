@@ -0,0 +1,130 @@
+package glider
+
+import (
+	"bytes"
+	"github.com/bskari/go-glider/flightdata"
+	"testing"
+	"time"
+)
+
+// Records a short simulated flight
+func getTestFlightRecords(t *testing.T) []flightdata.Record {
+	loadTestConfiguration(t)
+	configuration.MissionFile = "../missions/wonderland_lake.kml"
+	configuration.SimulatorTimeLimit = 60 * time.Second
+
+	var buffer bytes.Buffer
+	recorder, err := flightdata.NewWriter(&buffer)
+	if err != nil {
+		t.Fatalf("Unable to create recorder: %v", err)
+	}
+	simulator := NewSimulator()
+	simulator.SetFlightRecorder(recorder)
+	_, err = simulator.Run()
+	if err != nil {
+		t.Fatalf("Simulation failed: %v", err)
+	}
+	records, err := flightdata.NewReader(&buffer).ReadAll()
+	if err != nil {
+		t.Fatalf("Unable to read records: %v", err)
+	}
+	return records
+}
+
+func TestReplay(t *testing.T) {
+	records := getTestFlightRecords(t)
+
+	// Replaying with the same code and configuration should issue the same
+	// servo commands
+	result, err := NewReplay(records).Run()
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	t.Logf("%v", result)
+	ticks := 0
+	for _, record := range records {
+		if !record.SensorsOnly {
+			ticks++
+		}
+	}
+	if ticks == 0 || ticks == len(records) {
+		t.Fatalf("Expected ticks and AHRS steps, got %d ticks in %d records", ticks, len(records))
+	}
+	if result.Ticks != ticks {
+		t.Errorf("Bad tick count %v", result.Ticks)
+	}
+	if result.StateMismatches > 0 {
+		t.Errorf("Bad state mismatches %v", result.StateMismatches)
+	}
+	if len(result.Differences) > 0 || result.RmsDifference > ToRadians(0.1) {
+		t.Errorf("Replay should match the recording: %v", result)
+	}
+
+	// Changing the control laws should change the servo commands
+	configuration.ProportionalRollMultiplier *= 2
+	result, err = NewReplay(records).Run()
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	t.Logf("%v", result)
+	if len(result.Differences) < ticks/10 {
+		t.Errorf("Replay should differ from the recording: %v", result)
+	}
+	configuration.ProportionalRollMultiplier /= 2
+
+	// So should changing the AHRS, because it's stepped with the recorded
+	// sensor readings
+	configuration.MadgwickBeta *= 10
+	result, err = NewReplay(records).Run()
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	t.Logf("%v", result)
+	if len(result.Differences) < ticks/10 {
+		t.Errorf("Replay should differ from the recording: %v", result)
+	}
+}
+
+func TestReplayWithoutAhrsSteps(t *testing.T) {
+	var ticks []flightdata.Record
+	for _, record := range getTestFlightRecords(t) {
+		if !record.SensorsOnly {
+			ticks = append(ticks, record)
+		}
+	}
+
+	// Stepping the AHRS on the ticks gives a different attitude than the
+	// pilot had
+	result, err := NewReplay(ticks).Run()
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	t.Logf("%v", result)
+	if result.Ticks != len(ticks) || result.MaxDifference == 0 {
+		t.Errorf("Replay should differ from the recording: %v", result)
+	}
+
+	replay := NewReplay(ticks)
+	replay.SetRecordedAttitude(true)
+	result, err = replay.Run()
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	t.Logf("%v", result)
+	if result.StateMismatches > 0 || len(result.Differences) > 0 || result.RmsDifference > ToRadians(0.1) {
+		t.Errorf("Replay should match the recording: %v", result)
+	}
+}
+
+func TestParsePilotState(t *testing.T) {
+	for state := flying; state <= testMode; state++ {
+		parsed, err := parsePilotState(state.String())
+		if err != nil || parsed != state {
+			t.Errorf("Bad state %v for %v: %v", parsed, state, err)
+		}
+	}
+	_, err := parsePilotState("crashed")
+	if err == nil {
+		t.Error("Should have failed to parse a bad state")
+	}
+}
@@ -0,0 +1,183 @@
+// Runs tasks at fixed rates and keeps track of how well they keep up
+package glider
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+type Clock interface {
+	Now() time.Time
+	Sleep(duration time.Duration)
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Sleep(duration time.Duration) {
+	time.Sleep(duration)
+}
+
+// The clock that the pilot, telemetry, and logs use. The simulator replaces
+// it so that whole flights can run faster than real time.
+var pilotClock Clock = realClock{}
+
+type TaskStats struct {
+	Name   string
+	Period time.Duration
+	// How many times the task has run
+	Iterations uint64
+	// How long the task took to run
+	LastDuration  time.Duration
+	MaxDuration   time.Duration
+	totalDuration time.Duration
+	// How late the task started compared to its deadline
+	MaxJitter   time.Duration
+	totalJitter time.Duration
+	// Runs that were skipped because we fell behind
+	MissedDeadlines uint64
+	// Runs that took longer than the period
+	Overruns uint64
+}
+
+func (stats TaskStats) MeanDuration() time.Duration {
+	if stats.Iterations == 0 {
+		return 0
+	}
+	return stats.totalDuration / time.Duration(stats.Iterations)
+}
+
+func (stats TaskStats) MeanJitter() time.Duration {
+	if stats.Iterations == 0 {
+		return 0
+	}
+	return stats.totalJitter / time.Duration(stats.Iterations)
+}
+
+func (stats TaskStats) String() string {
+	toMilliseconds := func(duration time.Duration) float64 {
+		return duration.Seconds() * 1000
+	}
+	return fmt.Sprintf(
+		"%s %0.1fHz run:%0.1f/%0.1fms jitter:%0.1f/%0.1fms missed:%d overruns:%d",
+		stats.Name,
+		1.0/stats.Period.Seconds(),
+		toMilliseconds(stats.MeanDuration()),
+		toMilliseconds(stats.MaxDuration),
+		toMilliseconds(stats.MeanJitter()),
+		toMilliseconds(stats.MaxJitter),
+		stats.MissedDeadlines,
+		stats.Overruns,
+	)
+}
+
+type scheduledTask struct {
+	run      func()
+	deadline time.Time
+	stats    TaskStats
+}
+
+// Runs tasks at their own fixed rates on a single goroutine. Each task is
+// scheduled from its previous deadline rather than from when it finished, so
+// the rate doesn't drift with how long the tasks take. If a task falls more
+// than a period behind, the missed runs are skipped instead of run back to
+// back.
+type Scheduler struct {
+	clock   Clock
+	tasks   []*scheduledTask
+	stopped int32
+}
+
+func NewScheduler(clock Clock) *Scheduler {
+	if clock == nil {
+		clock = pilotClock
+	}
+	return &Scheduler{
+		clock: clock,
+		tasks: make([]*scheduledTask, 0, 4),
+	}
+}
+
+// Adds a task. When multiple tasks are due at the same time, they run in the
+// order that they were added.
+func (scheduler *Scheduler) AddTask(name string, period time.Duration, run func()) {
+	scheduler.tasks = append(scheduler.tasks, &scheduledTask{
+		run:   run,
+		stats: TaskStats{Name: name, Period: period},
+	})
+}
+
+// Runs the tasks until Stop is called
+func (scheduler *Scheduler) Run() {
+	if len(scheduler.tasks) == 0 {
+		return
+	}
+	atomic.StoreInt32(&scheduler.stopped, 0)
+	now := scheduler.clock.Now()
+	for _, task := range scheduler.tasks {
+		task.deadline = now
+	}
+
+	for atomic.LoadInt32(&scheduler.stopped) == 0 {
+		task := scheduler.tasks[0]
+		for _, other := range scheduler.tasks[1:] {
+			if other.deadline.Before(task.deadline) {
+				task = other
+			}
+		}
+
+		wait := task.deadline.Sub(scheduler.clock.Now())
+		if wait > 0 {
+			scheduler.clock.Sleep(wait)
+		}
+		scheduler.runTask(task)
+	}
+}
+
+func (scheduler *Scheduler) runTask(task *scheduledTask) {
+	start := scheduler.clock.Now()
+	task.run()
+	end := scheduler.clock.Now()
+
+	stats := &task.stats
+	stats.Iterations++
+	jitter := start.Sub(task.deadline)
+	stats.totalJitter += jitter
+	if jitter > stats.MaxJitter {
+		stats.MaxJitter = jitter
+	}
+	duration := end.Sub(start)
+	stats.LastDuration = duration
+	stats.totalDuration += duration
+	if duration > stats.MaxDuration {
+		stats.MaxDuration = duration
+	}
+	if duration > stats.Period {
+		stats.Overruns++
+	}
+
+	task.deadline = task.deadline.Add(stats.Period)
+	if !task.deadline.After(end) {
+		missed := end.Sub(task.deadline)/stats.Period + 1
+		stats.MissedDeadlines += uint64(missed)
+		task.deadline = task.deadline.Add(missed * stats.Period)
+	}
+}
+
+// Stops the scheduler after the current task finishes. Safe to call from any
+// goroutine.
+func (scheduler *Scheduler) Stop() {
+	atomic.StoreInt32(&scheduler.stopped, 1)
+}
+
+func (scheduler *Scheduler) GetStats() []TaskStats {
+	stats := make([]TaskStats, 0, len(scheduler.tasks))
+	for _, task := range scheduler.tasks {
+		stats = append(stats, task.stats)
+	}
+	return stats
+}
@@ -0,0 +1,86 @@
+package glider
+
+import (
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (clock *fakeClock) Now() time.Time {
+	return clock.now
+}
+
+func (clock *fakeClock) Sleep(duration time.Duration) {
+	clock.now = clock.now.Add(duration)
+}
+
+func TestSchedulerRates(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)}
+	start := clock.now
+	scheduler := NewScheduler(clock)
+	fastCount := 0
+	slowCount := 0
+	scheduler.AddTask("fast", 10*time.Millisecond, func() {
+		fastCount++
+		// Take some time, which shouldn't make the rate drift
+		clock.now = clock.now.Add(3 * time.Millisecond)
+	})
+	scheduler.AddTask("slow", 100*time.Millisecond, func() {
+		slowCount++
+		if clock.now.Sub(start) >= time.Second {
+			scheduler.Stop()
+		}
+	})
+	scheduler.Run()
+
+	if fastCount < 100 || fastCount > 101 {
+		t.Errorf("Bad fast count %d", fastCount)
+	}
+	if slowCount != 11 {
+		t.Errorf("Bad slow count %d", slowCount)
+	}
+	stats := scheduler.GetStats()
+	if stats[0].MissedDeadlines != 0 || stats[0].Overruns != 0 {
+		t.Errorf("Unexpected missed deadlines %v", stats[0])
+	}
+	if stats[0].MeanDuration() != 3*time.Millisecond {
+		t.Errorf("Bad mean duration %v", stats[0].MeanDuration())
+	}
+	// The slow task has to wait for the fast one when they're due together
+	if stats[1].MaxJitter != 3*time.Millisecond {
+		t.Errorf("Bad jitter %v", stats[1].MaxJitter)
+	}
+}
+
+func TestSchedulerOverrun(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)}
+	scheduler := NewScheduler(clock)
+	count := 0
+	scheduler.AddTask("overrun", 10*time.Millisecond, func() {
+		count++
+		if count == 2 {
+			// Blow through a few deadlines
+			clock.now = clock.now.Add(35 * time.Millisecond)
+		}
+		if count == 5 {
+			scheduler.Stop()
+		}
+	})
+	scheduler.Run()
+
+	stats := scheduler.GetStats()[0]
+	if stats.Overruns != 1 {
+		t.Errorf("Bad overruns %d", stats.Overruns)
+	}
+	if stats.MissedDeadlines != 3 {
+		t.Errorf("Bad missed deadlines %d", stats.MissedDeadlines)
+	}
+	// Skipped iterations shouldn't be run back to back, so the next one
+	// should start on a deadline
+	if stats.MaxJitter != 0 {
+		t.Errorf("Bad jitter %v", stats.MaxJitter)
+	}
+}
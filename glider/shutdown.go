@@ -0,0 +1,211 @@
+// Puts the hardware somewhere safe when we stop, whether that's from a
+// signal, a panic, or just finishing
+package glider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// How long to wait for the pilot to stop by itself after a signal before
+// shutting down anyway. Replaced in tests.
+var shutdownTimeout = 2 * time.Second
+
+// How long the HTTP servers give their requests in flight to finish when
+// they're closed, so that a hung client can't hold up shutting down
+const httpServerShutdownTimeout = 1 * time.Second
+
+// Replaced in tests
+var exit = os.Exit
+
+// The shutdown that panics in our own goroutines are sent to, if any
+var activeShutdown *Shutdown
+
+type shutdownCloser struct {
+	name  string
+	close func() error
+}
+
+// Cancels its context on SIGINT or SIGTERM. When closed, it moves the servos
+// to their failsafe positions, syncs the log, runs the closers in the reverse
+// order that they were added, and then closes the hardware.
+type Shutdown struct {
+	hardware *Hardware
+	ctx      context.Context
+	cancel   context.CancelFunc
+	signals  chan os.Signal
+	closed   chan struct{}
+	mutex    sync.Mutex
+	closers  []shutdownCloser
+	once     sync.Once
+}
+
+func NewShutdown(hardware *Hardware) *Shutdown {
+	ctx, cancel := context.WithCancel(context.Background())
+	shutdown := &Shutdown{
+		hardware: hardware,
+		ctx:      ctx,
+		cancel:   cancel,
+		signals:  make(chan os.Signal, 2),
+		closed:   make(chan struct{}),
+	}
+	if hardware != nil {
+		// We might have to move the servos while the pilot is still running
+		hardware.guardOutputs()
+	}
+	signal.Notify(shutdown.signals, syscall.SIGINT, syscall.SIGTERM)
+	go shutdown.watchSignals()
+	activeShutdown = shutdown
+	return shutdown
+}
+
+// Done once we've been told to stop
+func (shutdown *Shutdown) Context() context.Context {
+	return shutdown.ctx
+}
+
+// Adds something to close before the hardware, like the display or a log
+// file
+func (shutdown *Shutdown) Add(name string, close func() error) {
+	shutdown.mutex.Lock()
+	defer shutdown.mutex.Unlock()
+	shutdown.closers = append(shutdown.closers, shutdownCloser{name: name, close: close})
+}
+
+// Runs the shutdown, once. Safe to call from any goroutine.
+func (shutdown *Shutdown) Close() {
+	shutdown.once.Do(func() {
+		close(shutdown.closed)
+		shutdown.cancel()
+		signal.Stop(shutdown.signals)
+
+		if shutdown.hardware != nil {
+			err := shutdown.hardware.Failsafe()
+			if err != nil {
+				Logger.Errorf("Unable to move the servos to failsafe: %v", err)
+			}
+		}
+		err := SyncLogger()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to sync the log: %v\n", err)
+		}
+
+		shutdown.mutex.Lock()
+		closers := shutdown.closers
+		shutdown.closers = nil
+		shutdown.mutex.Unlock()
+		for i := len(closers) - 1; i >= 0; i-- {
+			err := closers[i].close()
+			if err != nil {
+				// The log might be closed already
+				fmt.Fprintf(os.Stderr, "Unable to close %s: %v\n", closers[i].name, err)
+			}
+		}
+
+		if shutdown.hardware != nil {
+			err := shutdown.hardware.Close()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to close the hardware: %v\n", err)
+			}
+		}
+	})
+}
+
+// Shuts down and exits if we're panicking. Defer it directly, at the top of
+// the goroutine.
+func (shutdown *Shutdown) Recover() {
+	if r := recover(); r != nil {
+		shutdown.handlePanic(r)
+	}
+}
+
+func (shutdown *Shutdown) handlePanic(r interface{}) {
+	stack := debug.Stack()
+	Logger.Criticalf("Panic: %v\n%s", r, stack)
+	shutdown.Close()
+	// The display is closed now, so this will be visible
+	fmt.Fprintf(os.Stderr, "panic: %v\n\n%s", r, stack)
+	exit(2)
+}
+
+// Sends panics in our own goroutines to the active shutdown, so that they
+// don't leave the servos where they were
+func recoverGoroutine() {
+	if r := recover(); r != nil {
+		if activeShutdown != nil {
+			activeShutdown.handlePanic(r)
+		}
+		panic(r)
+	}
+}
+
+// Stops server from accepting connections, and waits up to
+// httpServerShutdownTimeout for its requests in flight
+func shutdownHttpServer(server *http.Server) error {
+	ctx, cancel := context.WithTimeout(context.Background(), httpServerShutdownTimeout)
+	defer cancel()
+	return server.Shutdown(ctx)
+}
+
+// The first signal asks everything to stop. If that takes too long, or if
+// another signal comes in, shut down and exit from here.
+func (shutdown *Shutdown) watchSignals() {
+	select {
+	case received := <-shutdown.signals:
+		Logger.Warningf("Got %v, shutting down", received)
+		shutdown.cancel()
+	case <-shutdown.closed:
+		return
+	}
+
+	select {
+	case received := <-shutdown.signals:
+		Logger.Warningf("Got %v again, shutting down now", received)
+	case <-time.After(shutdownTimeout):
+		Logger.Warningf("Still running %v after the signal, shutting down now", shutdownTimeout)
+	case <-shutdown.closed:
+		return
+	}
+	shutdown.Close()
+	exit(1)
+}
+
+// Moves the servos to their failsafe positions. If the outputs are guarded,
+// they stay there, whatever else tries to move them.
+func (hardware *Hardware) Failsafe() error {
+	Logger.Info("Moving the servos to failsafe")
+	left, right := hardware.LeftServo, hardware.RightServo
+	if hardware.guard != nil {
+		hardware.guard.mutex.Lock()
+		defer hardware.guard.mutex.Unlock()
+		hardware.guard.held = true
+		left, right = hardware.guard.left, hardware.guard.right
+	}
+	control := NewControl(left, right)
+	// Try the right one even if the left one failed
+	leftErr := control.SetLeft(ToRadians(90) + configuration.FailsafeLeftServoOffset)
+	rightErr := control.SetRight(ToRadians(90) + configuration.FailsafeRightServoOffset)
+	if leftErr != nil {
+		return leftErr
+	}
+	return rightErr
+}
+
+// Sleeps for duration, or until ctx is done. Returns false if ctx is done.
+func sleepContext(ctx context.Context, duration time.Duration) bool {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,175 @@
+package glider
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Replaces exit, and returns a function that puts it back
+func replaceExit(code *int) func() {
+	previous := exit
+	exit = func(c int) { *code = c }
+	return func() { exit = previous }
+}
+
+func TestShutdownClose(t *testing.T) {
+	loadTestConfiguration(t)
+	configuration.FailsafeLeftServoOffset = ToRadians(10)
+	configuration.FailsafeRightServoOffset = ToRadians(-10)
+	hardware := newFakeHardware()
+	left := hardware.LeftServo.(*fakeServo)
+	right := hardware.RightServo.(*fakeServo)
+	shutdown := NewShutdown(hardware)
+	control := NewControl(hardware.LeftServo, hardware.RightServo)
+	control.SetLeft(ToRadians(130))
+	control.SetRight(ToRadians(50))
+
+	closed := []string{}
+	for _, name := range []string{"log", "display"} {
+		name := name
+		shutdown.Add(name, func() error {
+			closed = append(closed, name)
+			return nil
+		})
+	}
+	shutdown.Close()
+	shutdown.Close()
+
+	if shutdown.Context().Err() == nil {
+		t.Error("Context should be done")
+	}
+	if len(closed) != 2 || closed[0] != "display" || closed[1] != "log" {
+		t.Errorf("Should have closed in reverse order once, got %v", closed)
+	}
+	expected := NewControl(&fakeServo{}, &fakeServo{})
+	expected.SetLeft(ToRadians(100))
+	expected.SetRight(ToRadians(80))
+	left_us, right_us := expected.GetPulseWidths()
+	if left.width_us != left_us {
+		t.Errorf("Bad left failsafe %v, expected %v", left.width_us, left_us)
+	}
+	if right.width_us != right_us {
+		t.Errorf("Bad right failsafe %v, expected %v", right.width_us, right_us)
+	}
+	// Nothing else can move them now
+	if control.SetLeft(ToRadians(130)) == nil || left.width_us != left_us {
+		t.Errorf("Should have held the failsafe, got %v", left.width_us)
+	}
+}
+
+func TestShutdownSignal(t *testing.T) {
+	loadTestConfiguration(t)
+	var code int
+	defer replaceExit(&code)()
+	shutdown := NewShutdown(newFakeHardware())
+	defer shutdown.Close()
+
+	err := syscall.Kill(os.Getpid(), syscall.SIGTERM)
+	if err != nil {
+		t.Fatalf("Unable to send signal: %v", err)
+	}
+	select {
+	case <-shutdown.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("Signal should have canceled the context")
+	}
+	// Stopping in time means we don't exit from the signal goroutine
+	shutdown.Close()
+	time.Sleep(10 * time.Millisecond)
+	if code != 0 {
+		t.Errorf("Shouldn't have exited, got %v", code)
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	loadTestConfiguration(t)
+	configuration.FailsafeLeftServoOffset = ToRadians(10)
+	previousTimeout := shutdownTimeout
+	shutdownTimeout = 50 * time.Millisecond
+	defer func() {
+		shutdownTimeout = previousTimeout
+	}()
+	// We exit from the signal goroutine
+	exited := make(chan int, 1)
+	previousExit := exit
+	exit = func(code int) { exited <- code }
+	defer func() {
+		exit = previousExit
+	}()
+	hardware := newFakeHardware()
+	left := hardware.LeftServo.(*fakeServo)
+	cutdown := hardware.Cutdown.(*fakeCutdown)
+	shutdown := NewShutdown(hardware)
+
+	// A pilot that doesn't notice the signal, and keeps moving everything
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		control := NewControl(hardware.LeftServo, hardware.RightServo)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			control.SetLeft(ToRadians(float64(60 + i%60)))
+			hardware.Cutdown.Set(i%2 == 0)
+		}
+	}()
+
+	err := syscall.Kill(os.Getpid(), syscall.SIGTERM)
+	if err != nil {
+		t.Fatalf("Unable to send signal: %v", err)
+	}
+	select {
+	case code := <-exited:
+		if code != 1 {
+			t.Errorf("Bad exit code %v", code)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Should have shut down after the timeout")
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(stop)
+	<-stopped
+
+	expected := NewControl(&fakeServo{}, &fakeServo{})
+	expected.SetLeft(ToRadians(100))
+	left_us, _ := expected.GetPulseWidths()
+	if left.width_us != left_us {
+		t.Errorf("Should have held the failsafe %v, got %v", left_us, left.width_us)
+	}
+	active := cutdown.active
+	if err := hardware.Cutdown.Set(!active); err == nil || cutdown.active != active {
+		t.Error("Shouldn't move the cutdown after shutting down")
+	}
+	shutdown.Close()
+}
+
+func TestShutdownRecover(t *testing.T) {
+	loadTestConfiguration(t)
+	var code int
+	defer replaceExit(&code)()
+	hardware := newFakeHardware()
+	left := hardware.LeftServo.(*fakeServo)
+	shutdown := NewShutdown(hardware)
+	closed := false
+	shutdown.Add("display", func() error {
+		closed = true
+		return nil
+	})
+
+	func() {
+		defer shutdown.Recover()
+		panic("test")
+	}()
+	if code != 2 || !closed {
+		t.Errorf("Should have shut down and exited, got %v %v", code, closed)
+	}
+	if left.width_us == 0 {
+		t.Error("Should have moved the servos to failsafe")
+	}
+}
@@ -0,0 +1,716 @@
+// Software in the loop simulator, so that whole flights can be tested on a
+// laptop without a Pi
+package glider
+
+import (
+	"fmt"
+	"github.com/bskari/go-glider/flightdata"
+	"github.com/stianeikeland/go-rpio/v4"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Aerodynamic constants for the flight model. The glide ratio, mass, and wing
+// area come from the configuration, and the drag polar is built from them so
+// that the best glide ratio is at the trim angle of attack.
+const simulatedLiftSlope = 5.0 // Per radian
+const simulatedInducedDragFactor = 0.08
+const simulatedTrimAngleOfAttack_d = 2.0
+const simulatedStallAngleOfAttack_d = 15.0
+const simulatedSeaLevelAirDensity = 1.225 // kg/m^3
+const simulatedAirDensityScaleHeight Meters = 8500.0
+
+// How quickly the roll rate and angle of attack follow the servos
+const simulatedRollTimeConstant_s = 0.2
+const simulatedPitchTimeConstant_s = 0.3
+
+const simulatedGpsPeriod = time.Second
+
+// Standard deviations of the sensor noise
+const simulatedGpsNoise Meters = 1.0
+const simulatedAccelerometerNoise_g = 0.01
+const simulatedGyroscopeNoise_dps = 0.2
+const simulatedMagnetometerNoise = 2.0 // Counts
+
+const knotsToMetersPerSecond = 1852.0 / 3600.0
+
+// A clock that only advances when the scheduler sleeps
+type simulatedClock struct {
+	now time.Time
+	// How many times faster than real time to run, or 0 to not sleep at all
+	speedup float64
+}
+
+func (clock *simulatedClock) Now() time.Time {
+	return clock.now
+}
+
+func (clock *simulatedClock) Sleep(duration time.Duration) {
+	clock.now = clock.now.Add(duration)
+	if clock.speedup > 0 {
+		time.Sleep(time.Duration(float64(duration) / clock.speedup))
+	}
+}
+
+// Flies a glider using a simple flight model. The pilot reads synthetic
+// sensor and GPS data from it, and it reads the servo angles that the pilot
+// commands back from Control.
+type Simulator struct {
+	clock    *simulatedClock
+	random   *rand.Rand
+	hardware *Hardware
+	control  *Control
+	gps      *simulatedGps
+	recorder *flightdata.Writer
+	mavlink  *MavlinkLink
+	aprs     *AprsBeacon
+	geofence *Geofence
+	station  *GroundStation
+	origin   Point
+	// Position relative to the launch point
+	north    Meters
+	east     Meters
+	altitude Meters
+	// The velocity through the air. The flight path angle is positive when
+	// climbing, and the heading is clockwise from true north.
+	airspeed     MetersPerSecond
+	flightPath_r Radians
+	heading_r    Radians
+	// Positive is right wing down
+	roll_r          Radians
+	rollRate_r      RadiansPerSecond
+	angleOfAttack_r Radians
+	// Rotation from the airframe (+x right, +y forward, +z up) to east, north,
+	// up
+	attitude [3][3]float64
+	// In the airframe
+	rotationRates vector3
+	// What the accelerometer feels, in g in the airframe
+	specificForce vector3
+	// Ground velocity, east and north
+	groundVelocity [2]MetersPerSecond
+	nextGpsTime    time.Time
+	// Hanging from a balloon until the cutdown fires
+	attached      bool
+	landed        bool
+	distanceFlown Meters
+}
+
+type SimulationResult struct {
+	// How long the simulated flight took
+	FlightTime time.Duration
+	// How long it took to simulate
+	RealTime      time.Duration
+	Landed        bool
+	LandingPoint  Point
+	DistanceFlown Meters
+	FinalState    PilotState
+}
+
+func (result SimulationResult) String() string {
+	outcome := "landed"
+	if !result.Landed {
+		outcome = "timed out"
+	}
+	speedup := result.FlightTime.Seconds() / math.Max(result.RealTime.Seconds(), 1e-3)
+	return fmt.Sprintf(
+		"%s after %v (%0.0fx real time), flew %0.0f m, ended at %0.6f %0.6f %0.1f m in state %s",
+		outcome,
+		result.FlightTime.Round(time.Second),
+		speedup,
+		result.DistanceFlown,
+		result.LandingPoint.Latitude,
+		result.LandingPoint.Longitude,
+		result.LandingPoint.Altitude,
+		result.FinalState,
+	)
+}
+
+// Creates a simulator with the glider launched wings level, trimmed for its
+// best glide ratio, from the configured launch point. If the balloon ascent
+// speed is set, it starts hanging from a balloon there instead.
+func NewSimulator() *Simulator {
+	simulator := &Simulator{
+		clock:  &simulatedClock{now: time.Now(), speedup: configuration.SimulatorSpeedup},
+		random: rand.New(rand.NewSource(1)),
+		gps:    &simulatedGps{},
+		origin: Point{
+			Latitude:  configuration.SimulatorLaunchLatitude,
+			Longitude: configuration.SimulatorLaunchLongitude,
+		},
+		altitude:        configuration.SimulatorLaunchAltitude,
+		flightPath_r:    -math.Atan(1.0 / configuration.SimulatorGlideRatio),
+		heading_r:       configuration.SimulatorLaunchHeading,
+		angleOfAttack_r: ToRadians(simulatedTrimAngleOfAttack_d),
+		attached:        configuration.SimulatorBalloonAscentSpeed > 0,
+	}
+	simulator.hardware = simulator.newHardware()
+	simulator.control = NewControl(simulator.hardware.LeftServo, simulator.hardware.RightServo)
+	simulator.airspeed = simulator.trimAirspeed()
+	simulator.nextGpsTime = simulator.clock.now
+	if simulator.attached {
+		simulator.hang()
+	} else {
+		simulator.updateKinematics(0)
+	}
+	return simulator
+}
+
+// Flies the configured mission until the glider reaches the ground or the
+// time limit runs out
+func (simulator *Simulator) Run() (SimulationResult, error) {
+	previousClock := pilotClock
+	pilotClock = simulator.clock
+	defer func() {
+		pilotClock = previousClock
+	}()
+
+	waypoints, err := NewWaypoints()
+	if err != nil {
+		return SimulationResult{}, err
+	}
+	telemetry := NewTelemetry(simulator.hardware)
+	pilot := newPilot(simulator.hardware, telemetry, simulator.control, waypoints)
+	pilot.SetFlightRecorder(simulator.recorder)
+	if simulator.mavlink != nil {
+		pilot.SetMavlinkLink(simulator.mavlink)
+	}
+	if simulator.aprs != nil {
+		pilot.SetAprsBeacon(simulator.aprs)
+	}
+	if simulator.geofence != nil {
+		pilot.SetGeofence(simulator.geofence)
+	}
+	if simulator.station != nil {
+		pilot.SetGroundStation(simulator.station)
+	}
+
+	start := time.Now()
+	launchTime := simulator.clock.now
+	scheduler := NewScheduler(simulator.clock)
+	scheduler.AddTask("simulator", configuration.SimulatorPeriod, func() {
+		simulator.step(configuration.SimulatorPeriod.Seconds())
+		if simulator.landed || simulator.clock.now.Sub(launchTime) >= configuration.SimulatorTimeLimit {
+			scheduler.Stop()
+		}
+	})
+	pilot.addFlightTasks(scheduler)
+	Logger.Infof("Starting simulation from %v", simulator.GetPosition())
+	scheduler.Run()
+
+	result := SimulationResult{
+		FlightTime:    simulator.clock.now.Sub(launchTime),
+		RealTime:      time.Since(start),
+		Landed:        simulator.landed,
+		LandingPoint:  simulator.GetPosition(),
+		DistanceFlown: simulator.distanceFlown,
+		FinalState:    pilot.state,
+	}
+	Logger.Infof("Simulation %v", result)
+	return result, nil
+}
+
+// Records the simulated flight to the recorder
+func (simulator *Simulator) SetFlightRecorder(recorder *flightdata.Writer) {
+	simulator.recorder = recorder
+}
+
+// Sends the simulated flight to a ground station
+func (simulator *Simulator) SetMavlinkLink(link *MavlinkLink) {
+	simulator.mavlink = link
+}
+
+// Beacons the simulated flight, e.g. to test a TNC
+func (simulator *Simulator) SetAprsBeacon(beacon *AprsBeacon) {
+	simulator.aprs = beacon
+}
+
+// Keeps the simulated flight in or out of zones
+func (simulator *Simulator) SetGeofence(fence *Geofence) {
+	simulator.geofence = fence
+}
+
+// Shows the simulated flight in a browser
+func (simulator *Simulator) SetGroundStation(station *GroundStation) {
+	simulator.station = station
+}
+
+// Returns the true position of the glider
+func (simulator *Simulator) GetPosition() Point {
+	point := fromNorthEast(simulator.origin, simulator.north, simulator.east)
+	point.Altitude = simulator.altitude
+	return point
+}
+
+// Returns the true attitude of the glider, with the yaw from true north
+func (simulator *Simulator) GetAxes() Axes {
+	r := simulator.attitude
+	yaw_r := math.Atan2(r[0][1], r[1][1])
+	if yaw_r < 0 {
+		yaw_r += ToRadians(360.0)
+	}
+	return Axes{
+		Pitch: math.Asin(clamp(r[2][1], -1, 1)),
+		Roll:  math.Atan2(-r[2][0], r[2][2]),
+		Yaw:   yaw_r,
+	}
+}
+
+func simulatedAirDensity(altitude Meters) float64 {
+	return simulatedSeaLevelAirDensity * math.Exp(-altitude/simulatedAirDensityScaleHeight)
+}
+
+// Returns the drag polar coefficients: the parasitic drag, and the lift at
+// zero angle of attack
+func simulatedDragPolar() (float64, float64) {
+	// The best glide ratio is 1 / (2 sqrt(parasiticDrag * inducedDragFactor)),
+	// at a lift coefficient of sqrt(parasiticDrag / inducedDragFactor)
+	glideRatio := configuration.SimulatorGlideRatio
+	parasiticDrag := 1.0 / (4.0 * simulatedInducedDragFactor * glideRatio * glideRatio)
+	bestLift := math.Sqrt(parasiticDrag / simulatedInducedDragFactor)
+	zeroLift := bestLift - simulatedLiftSlope*ToRadians(simulatedTrimAngleOfAttack_d)
+	return parasiticDrag, zeroLift
+}
+
+// The airspeed that balances lift and weight at the current angle of attack
+func (simulator *Simulator) trimAirspeed() MetersPerSecond {
+	_, zeroLift := simulatedDragPolar()
+	lift := zeroLift + simulatedLiftSlope*simulator.angleOfAttack_r
+	weight := configuration.SimulatorMass_kg * gravity_mps2 * math.Cos(simulator.flightPath_r)
+	density := simulatedAirDensity(simulator.altitude)
+	return math.Sqrt(2.0 * weight / (density * configuration.SimulatorWingArea_sqm * lift))
+}
+
+// Returns the aileron and elevator deflections from the commanded servo
+// angles. These mirror adjustAileronsToRollPitch: rolling right moves both
+// servos down from center, and pitching up moves the right one up and the
+// left one down.
+func (simulator *Simulator) getControlSurfaces() (Radians, Radians) {
+	left_r, right_r := simulator.control.GetAngles()
+	leftOffset_r := left_r - ToRadians(90)
+	rightOffset_r := right_r - ToRadians(90)
+	aileron_r := -(leftOffset_r + rightOffset_r) * 0.5
+	elevator_r := (rightOffset_r - leftOffset_r) * 0.5
+	return aileron_r, elevator_r
+}
+
+// Advances the flight model by dt seconds
+func (simulator *Simulator) step(dt float64) {
+	if simulator.landed {
+		return
+	}
+	if simulator.attached {
+		simulator.stepBalloon(dt)
+	} else {
+		simulator.stepGlider(dt)
+	}
+
+	now := simulator.clock.Now()
+	if !now.Before(simulator.nextGpsTime) {
+		simulator.nextGpsTime = now.Add(simulatedGpsPeriod)
+		simulator.queueGpsSentences(now)
+		axes := simulator.GetAxes()
+		Logger.Debugf(
+			"Simulator true position:%v pitch:%0.1f roll:%0.1f yaw:%0.1f airspeed:%0.1f",
+			simulator.GetPosition(),
+			ToDegrees(axes.Pitch),
+			ToDegrees(axes.Roll),
+			ToDegrees(axes.Yaw),
+			simulator.airspeed,
+		)
+	}
+}
+
+// Flies the glider for dt seconds
+func (simulator *Simulator) stepGlider(dt float64) {
+	aileron_r, elevator_r := simulator.getControlSurfaces()
+
+	// The ailerons set the roll rate and the elevator sets the angle of
+	// attack, both after a short lag
+	rollRateCommand := configuration.SimulatorRollResponse * aileron_r
+	simulator.rollRate_r += (rollRateCommand - simulator.rollRate_r) * math.Min(dt/simulatedRollTimeConstant_s, 1)
+	simulator.roll_r = math.Remainder(simulator.roll_r+simulator.rollRate_r*dt, 2*math.Pi)
+	stall_r := ToRadians(simulatedStallAngleOfAttack_d)
+	angleOfAttackCommand := ToRadians(simulatedTrimAngleOfAttack_d) + configuration.SimulatorPitchResponse*elevator_r
+	angleOfAttackCommand = clamp(angleOfAttackCommand, -stall_r, stall_r)
+	simulator.angleOfAttack_r += (angleOfAttackCommand - simulator.angleOfAttack_r) * math.Min(dt/simulatedPitchTimeConstant_s, 1)
+
+	// Point mass equations of motion in the wind axes
+	mass := configuration.SimulatorMass_kg
+	lift, drag := simulator.getAerodynamicForces()
+	speed := simulator.airspeed
+	cosRoll := math.Cos(simulator.roll_r)
+	sinRoll := math.Sin(simulator.roll_r)
+	cosFlightPath := math.Cos(simulator.flightPath_r)
+	sinFlightPath := math.Sin(simulator.flightPath_r)
+	simulator.airspeed += (-drag/mass - gravity_mps2*sinFlightPath) * dt
+	simulator.airspeed = math.Max(simulator.airspeed, 1.0)
+	simulator.flightPath_r += (lift*cosRoll - mass*gravity_mps2*cosFlightPath) / (mass * speed) * dt
+	simulator.flightPath_r = clamp(simulator.flightPath_r, -ToRadians(89), ToRadians(89))
+	simulator.heading_r += lift * sinRoll / (mass * speed * cosFlightPath) * dt
+	simulator.heading_r = math.Mod(simulator.heading_r+2*math.Pi, 2*math.Pi)
+
+	windEast, windNorth := getSimulatedWind()
+	horizontal := simulator.airspeed * math.Cos(simulator.flightPath_r)
+	east := horizontal*math.Sin(simulator.heading_r) + windEast
+	north := horizontal*math.Cos(simulator.heading_r) + windNorth
+	simulator.groundVelocity = [2]MetersPerSecond{east, north}
+	simulator.east += east * dt
+	simulator.north += north * dt
+	simulator.altitude += simulator.airspeed * math.Sin(simulator.flightPath_r) * dt
+	simulator.distanceFlown += math.Sqrt(east*east+north*north) * dt
+
+	simulator.updateKinematics(dt)
+
+	if simulator.altitude <= configuration.SimulatorGroundAltitude {
+		simulator.altitude = configuration.SimulatorGroundAltitude
+		simulator.landed = true
+		Logger.Infof("Simulated glider hit the ground at %v", simulator.GetPosition())
+	}
+}
+
+// Carries the glider up and downwind for dt seconds
+func (simulator *Simulator) stepBalloon(dt float64) {
+	windEast, windNorth := getSimulatedWind()
+	simulator.groundVelocity = [2]MetersPerSecond{windEast, windNorth}
+	simulator.east += windEast * dt
+	simulator.north += windNorth * dt
+	simulator.altitude += configuration.SimulatorBalloonAscentSpeed * dt
+	simulator.hang()
+}
+
+// Hangs level and still from the balloon
+func (simulator *Simulator) hang() {
+	simulator.attitude = rotationAboutZ(-simulator.heading_r)
+	simulator.rotationRates = vector3{0, 0, 0}
+	simulator.specificForce = vector3{0, 0, 1}
+}
+
+// Drops the glider from the balloon, nose down, so that it falls until it
+// picks up enough airspeed to pull out
+func (simulator *Simulator) release() {
+	if !simulator.attached {
+		return
+	}
+	Logger.Infof("Simulated glider released at %v", simulator.GetPosition())
+	simulator.attached = false
+	simulator.airspeed = 1.0
+	simulator.flightPath_r = -ToRadians(89)
+	simulator.roll_r = 0
+	simulator.rollRate_r = 0
+	simulator.updateKinematics(0)
+}
+
+// Returns the east and north components of the wind, which blows from its
+// direction
+func getSimulatedWind() (MetersPerSecond, MetersPerSecond) {
+	east := -configuration.SimulatorWindSpeed * math.Sin(configuration.SimulatorWindDirection)
+	north := -configuration.SimulatorWindSpeed * math.Cos(configuration.SimulatorWindDirection)
+	return east, north
+}
+
+// Returns the lift and drag, in N
+func (simulator *Simulator) getAerodynamicForces() (float64, float64) {
+	parasiticDrag, zeroLift := simulatedDragPolar()
+	liftCoefficient := zeroLift + simulatedLiftSlope*simulator.angleOfAttack_r
+	dragCoefficient := parasiticDrag + simulatedInducedDragFactor*liftCoefficient*liftCoefficient
+	pressure := 0.5 * simulatedAirDensity(simulator.altitude) * simulator.airspeed * simulator.airspeed
+	area := configuration.SimulatorWingArea_sqm
+	return pressure * area * liftCoefficient, pressure * area * dragCoefficient
+}
+
+// Updates the attitude, rotation rates, and specific force from the flight
+// state. dt is the time since the last update, or 0 if there wasn't one.
+func (simulator *Simulator) updateKinematics(dt float64) {
+	// The wind axes are the velocity heading and flight path, banked by the
+	// roll. The airframe is pitched up from them by the angle of attack.
+	wind := multiplyMatrices(
+		multiplyMatrices(rotationAboutZ(-simulator.heading_r), rotationAboutX(simulator.flightPath_r)),
+		rotationAboutY(simulator.roll_r),
+	)
+	attitude := multiplyMatrices(wind, rotationAboutX(simulator.angleOfAttack_r))
+
+	if dt > 0 {
+		// The change in attitude is I + [rates]x * dt
+		change := multiplyMatrices(transpose(simulator.attitude), attitude)
+		simulator.rotationRates = vector3{
+			(change[2][1] - change[1][2]) / (2 * dt),
+			(change[0][2] - change[2][0]) / (2 * dt),
+			(change[1][0] - change[0][1]) / (2 * dt),
+		}
+	}
+	simulator.attitude = attitude
+
+	// Lift is up and drag is back in the wind axes
+	lift, drag := simulator.getAerodynamicForces()
+	weight := configuration.SimulatorMass_kg * gravity_mps2
+	windForce := vector3{0, -drag / weight, lift / weight}
+	simulator.specificForce = multiplyMatrixVector(transpose(rotationAboutX(simulator.angleOfAttack_r)), windForce)
+}
+
+// Returns a vector in the sensor board frame from one in the airframe
+func airframeToBoard(v vector3) vector3 {
+	inverse, err := invertMatrix(configuration.BoardMounting)
+	if err != nil {
+		return v
+	}
+	return multiplyMatrixVector(inverse, v)
+}
+
+func (simulator *Simulator) noise(standardDeviation float64) float64 {
+	return simulator.random.NormFloat64() * standardDeviation
+}
+
+func toRaw(value float64) int16 {
+	return int16(clamp(math.Round(value), math.MinInt16, math.MaxInt16))
+}
+
+// Returns the raw accelerometer reading for a specific force in g in the
+// airframe, undoing the calibration
+func accelerometerRawFromAirframe(g vector3) vector3 {
+	board := airframeToBoard(g)
+	calibration := getAccelerometerCalibration()
+	var raw vector3
+	for i := range raw {
+		raw[i] = board[i]/calibration.Scale[i] + calibration.Bias[i]
+	}
+	return raw
+}
+
+// Returns the raw gyroscope reading for rotation rates in the airframe
+func gyroscopeRawFromAirframe(rates vector3) vector3 {
+	board := airframeToBoard(rates)
+	var raw vector3
+	for i := range raw {
+		raw[i] = ToDegrees(board[i]) * ITG3200_LSB_PER_DEGREE_PER_SECOND
+	}
+	return raw
+}
+
+// Returns the raw magnetometer reading for a field in nT in the airframe,
+// undoing the calibration
+func magnetometerRawFromAirframe(field vector3) (vector3, error) {
+	board := airframeToBoard(field)
+	// The magnetometer is mounted 180 degrees off, so +x is backward and +y
+	// is right
+	chip := vector3{-board[1], board[0], board[2]}
+
+	// In counts of the default gain
+	nanoteslaPerCount := getGainMultiplier(HMC5883L_GAIN_0_00013_T) * 100.0
+	for i := range chip {
+		chip[i] /= nanoteslaPerCount
+	}
+	calibration := getMagnetometerCalibration()
+	inverse, err := invertMatrix(calibration.SoftIron)
+	if err != nil {
+		return vector3{}, err
+	}
+	raw := multiplyMatrixVector(inverse, chip)
+	for i := range raw {
+		raw[i] += calibration.HardIron[i]
+	}
+	return raw, nil
+}
+
+func (simulator *Simulator) noisyRaw(raw vector3, standardDeviation float64) (int16, int16, int16) {
+	return toRaw(raw[0] + simulator.noise(standardDeviation)),
+		toRaw(raw[1] + simulator.noise(standardDeviation)),
+		toRaw(raw[2] + simulator.noise(standardDeviation))
+}
+
+type simulatedAccelerometer struct {
+	simulator *Simulator
+}
+
+func (accelerometer *simulatedAccelerometer) SenseRaw() (int16, int16, int16, error) {
+	simulator := accelerometer.simulator
+	raw := accelerometerRawFromAirframe(simulator.specificForce)
+	x, y, z := simulator.noisyRaw(raw, simulatedAccelerometerNoise_g/getAccelerometerCalibration().Scale[2])
+	return x, y, z, nil
+}
+
+type simulatedGyroscope struct {
+	simulator *Simulator
+}
+
+func (gyroscope *simulatedGyroscope) SenseRaw() (int16, int16, int16, error) {
+	simulator := gyroscope.simulator
+	raw := gyroscopeRawFromAirframe(simulator.rotationRates)
+	x, y, z := simulator.noisyRaw(raw, simulatedGyroscopeNoise_dps*ITG3200_LSB_PER_DEGREE_PER_SECOND)
+	return x, y, z, nil
+}
+
+type simulatedMagnetometer struct {
+	simulator *Simulator
+}
+
+func (magnetometer *simulatedMagnetometer) SenseRaw() (int16, int16, int16, error) {
+	simulator := magnetometer.simulator
+	field := WorldMagneticModel(simulator.GetPosition(), simulator.clock.Now())
+	horizontal := field.Intensity * math.Cos(field.Inclination)
+	world := vector3{
+		horizontal * math.Sin(field.Declination),
+		horizontal * math.Cos(field.Declination),
+		-field.Intensity * math.Sin(field.Inclination),
+	}
+	raw, err := magnetometerRawFromAirframe(multiplyMatrixVector(transpose(simulator.attitude), world))
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	x, y, z := simulator.noisyRaw(raw, simulatedMagnetometerNoise)
+	return x, y, z, nil
+}
+
+// Returns hardware that reads from the simulator. The button is held down, so
+// the pilot launches as soon as it has a GPS lock, unless we're on a balloon.
+func (simulator *Simulator) newHardware() *Hardware {
+	button := rpio.Low
+	if simulator.attached {
+		button = rpio.High
+	}
+	return &Hardware{
+		LeftServo:     &fakeServo{},
+		RightServo:    &fakeServo{},
+		Button:        &fakeDigitalInput{state: button},
+		Led:           &fakeLed{},
+		Cutdown:       &simulatedCutdown{simulator},
+		Gps:           simulator.gps,
+		Accelerometer: &simulatedAccelerometer{simulator},
+		Magnetometer:  &simulatedMagnetometer{simulator},
+		Gyroscope:     &simulatedGyroscope{simulator},
+	}
+}
+
+type simulatedCutdown struct {
+	simulator *Simulator
+}
+
+func (cutdown *simulatedCutdown) Set(active bool) error {
+	if active {
+		cutdown.simulator.release()
+	}
+	return nil
+}
+
+// A serial port that NMEA sentences from the simulator are queued on
+type simulatedGps struct {
+	lines []string
+}
+
+func (gps *simulatedGps) Available() int {
+	available := 0
+	for _, line := range gps.lines {
+		available += len(line)
+	}
+	return available
+}
+
+func (gps *simulatedGps) ReadLine() (string, error) {
+	if len(gps.lines) == 0 {
+		return "", nil
+	}
+	line := gps.lines[0]
+	gps.lines = gps.lines[1:]
+	return line, nil
+}
+
+// Queues RMC, GGA, and VTG sentences for the current position
+func (simulator *Simulator) queueGpsSentences(now time.Time) {
+	position := fromNorthEast(
+		simulator.origin,
+		simulator.north+simulator.noise(simulatedGpsNoise),
+		simulator.east+simulator.noise(simulatedGpsNoise),
+	)
+	altitude := simulator.altitude + simulator.noise(simulatedGpsNoise)
+	east := simulator.groundVelocity[0]
+	north := simulator.groundVelocity[1]
+	speed := math.Sqrt(east*east + north*north)
+	course_d := ToDegrees(math.Atan2(east, north))
+	if course_d < 0 {
+		course_d += 360.0
+	}
+	for _, sentence := range formatNmeaSentences(now, position, altitude, speed, course_d, 1.0) {
+		simulator.gps.lines = append(simulator.gps.lines, sentence)
+	}
+}
+
+func formatNmeaSentences(now time.Time, position Point, altitude Meters, speed MetersPerSecond, course_d Degrees, hdop float64) []string {
+	now = now.UTC()
+	fixTime := fmt.Sprintf("%02d%02d%02d.%02d", now.Hour(), now.Minute(), now.Second(), now.Nanosecond()/1e7)
+	date := fmt.Sprintf("%02d%02d%02d", now.Day(), now.Month(), now.Year()%100)
+	latitude := formatNmeaCoordinate(position.Latitude, 2, "N", "S")
+	longitude := formatNmeaCoordinate(position.Longitude, 3, "E", "W")
+	// Receivers report one speed in both units, so derive the kph from the
+	// rounded knots. Otherwise the two disagree in the last digit, and a
+	// replay of the recorded speed can't rebuild the same sentences.
+	knots := math.Round(speed/knotsToMetersPerSecond*10.0) / 10.0
+	kph := knots * knotsToMetersPerSecond * 3.6
+	return []string{
+		formatNmeaSentence(fmt.Sprintf("GPRMC,%s,A,%s,%s,%05.1f,%05.1f,%s,,", fixTime, latitude, longitude, knots, course_d, date)),
+		formatNmeaSentence(fmt.Sprintf("GPGGA,%s,%s,%s,1,08,%0.1f,%0.1f,M,0.0,M,,", fixTime, latitude, longitude, hdop, altitude)),
+		formatNmeaSentence(fmt.Sprintf("GPVTG,%05.1f,T,,M,%05.1f,N,%05.1f,K", course_d, knots, kph)),
+	}
+}
+
+// Formats a coordinate as degrees and decimal minutes, followed by the
+// hemisphere
+func formatNmeaCoordinate(coordinate Coordinate, degreeDigits int, positive, negative string) string {
+	hemisphere := positive
+	if coordinate < 0 {
+		hemisphere = negative
+		coordinate = -coordinate
+	}
+	degrees := math.Floor(coordinate)
+	minutes := (coordinate - degrees) * 60.0
+	return fmt.Sprintf("%0*d%07.4f,%s", degreeDigits, int(degrees), minutes, hemisphere)
+}
+
+// Adds the $ and the checksum
+func formatNmeaSentence(body string) string {
+	var checksum byte
+	for i := 0; i < len(body); i++ {
+		checksum ^= body[i]
+	}
+	return fmt.Sprintf("$%s*%02X\r\n", body, checksum)
+}
+
+func multiplyMatrices(a, b [3][3]float64) [3][3]float64 {
+	var product [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				product[i][j] += a[i][k] * b[k][j]
+			}
+		}
+	}
+	return product
+}
+
+func transpose(m [3][3]float64) [3][3]float64 {
+	var transposed [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			transposed[i][j] = m[j][i]
+		}
+	}
+	return transposed
+}
+
+func rotationAboutX(angle_r Radians) [3][3]float64 {
+	c := math.Cos(angle_r)
+	s := math.Sin(angle_r)
+	return [3][3]float64{{1, 0, 0}, {0, c, -s}, {0, s, c}}
+}
+
+func rotationAboutY(angle_r Radians) [3][3]float64 {
+	c := math.Cos(angle_r)
+	s := math.Sin(angle_r)
+	return [3][3]float64{{c, 0, s}, {0, 1, 0}, {-s, 0, c}}
+}
+
+func rotationAboutZ(angle_r Radians) [3][3]float64 {
+	c := math.Cos(angle_r)
+	s := math.Sin(angle_r)
+	return [3][3]float64{{c, -s, 0}, {s, c, 0}, {0, 0, 1}}
+}
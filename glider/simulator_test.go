@@ -0,0 +1,276 @@
+package glider
+
+import (
+	"bytes"
+	"github.com/bskari/go-glider/flightdata"
+	"math"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func setTestSimulatorConfiguration() {
+	setTestAccelerometerConfiguration()
+	configuration.MagnetometerHardIron = vector3{72, -148, -105}
+	configuration.MagnetometerSoftIron = [3][3]float64{{1.1, 0, 0}, {0, 0.9, 0}, {0, 0, 1}}
+	configuration.SimulatorPeriod = 10 * time.Millisecond
+	configuration.AhrsPeriod = 10 * time.Millisecond
+	configuration.SimulatorSpeedup = 0
+	configuration.SimulatorTimeLimit = 10 * time.Minute
+	configuration.SimulatorLaunchLatitude = 40.054
+	configuration.SimulatorLaunchLongitude = -105.295
+	configuration.SimulatorLaunchAltitude = 1800
+	configuration.SimulatorLaunchHeading = ToRadians(90)
+	configuration.SimulatorGroundAltitude = 1600
+	configuration.SimulatorGlideRatio = 10
+	configuration.SimulatorMass_kg = 1
+	configuration.SimulatorWingArea_sqm = 0.3
+	configuration.SimulatorRollResponse = 3
+	configuration.SimulatorPitchResponse = 0.2
+	configuration.SimulatorWindSpeed = 0
+	configuration.SimulatorWindDirection = 0
+	configuration.SimulatorBalloonAscentSpeed = 0
+}
+
+func TestSimulatedGlide(t *testing.T) {
+	setTestSimulatorConfiguration()
+	simulator := NewSimulator()
+	start := simulator.GetPosition()
+	// With the servos centered, the glider should stay trimmed at its best
+	// glide ratio
+	for i := 0; i < 3000; i++ {
+		simulator.step(0.01)
+	}
+	end := simulator.GetPosition()
+	distance := Distance(start, end)
+	glideRatio := distance / (start.Altitude - end.Altitude)
+	if math.Abs(glideRatio-configuration.SimulatorGlideRatio) > 0.1 {
+		t.Errorf("Bad glide ratio %v", glideRatio)
+	}
+	course := Course(start, end)
+	if math.Abs(GetAngleTo(course, configuration.SimulatorLaunchHeading)) > ToRadians(1) {
+		t.Errorf("Bad course %v", ToDegrees(course))
+	}
+
+	// Wind should push us downwind
+	configuration.SimulatorWindSpeed = 5
+	configuration.SimulatorWindDirection = ToRadians(0)
+	simulator = NewSimulator()
+	for i := 0; i < 1000; i++ {
+		simulator.step(0.01)
+	}
+	if simulator.north > -45 || simulator.north < -55 {
+		t.Errorf("Bad wind drift %v", simulator.north)
+	}
+}
+
+func TestSimulatedControlResponse(t *testing.T) {
+	setTestSimulatorConfiguration()
+	simulator := NewSimulator()
+	// Both servos down from center should roll right
+	simulator.control.SetLeft(ToRadians(80))
+	simulator.control.SetRight(ToRadians(80))
+	for i := 0; i < 100; i++ {
+		simulator.step(0.01)
+	}
+	axes := simulator.GetAxes()
+	if axes.Roll < ToRadians(10) {
+		t.Errorf("Bad roll %v", ToDegrees(axes.Roll))
+	}
+	if simulator.heading_r <= configuration.SimulatorLaunchHeading {
+		t.Errorf("Should be turning right, heading %v", ToDegrees(simulator.heading_r))
+	}
+
+	// The right servo up and the left down should pitch up
+	simulator = NewSimulator()
+	pitch := simulator.GetAxes().Pitch
+	simulator.control.SetLeft(ToRadians(80))
+	simulator.control.SetRight(ToRadians(100))
+	for i := 0; i < 50; i++ {
+		simulator.step(0.01)
+	}
+	if simulator.GetAxes().Pitch <= pitch {
+		t.Errorf("Should have pitched up from %v to %v", ToDegrees(pitch), ToDegrees(simulator.GetAxes().Pitch))
+	}
+}
+
+func TestSimulatedSensors(t *testing.T) {
+	setTestSimulatorConfiguration()
+	configuration.DeclinationSource = DECLINATION_SOURCE_WORLD_MAGNETIC_MODEL
+	// A board that isn't mounted level
+	configuration.BoardMounting = [3][3]float64{{1, 0, 0}, {0, 0.998342, -0.057564}, {0, 0.057564, 0.998342}}
+
+	for _, heading_d := range []Degrees{0, 45, 135, 200, 300} {
+		configuration.SimulatorLaunchHeading = ToRadians(heading_d)
+		simulator := NewSimulator()
+		telemetry := NewTelemetry(simulator.hardware)
+		previousClock := pilotClock
+		pilotClock = simulator.clock
+		simulator.queueGpsSentences(simulator.clock.now)
+		for {
+			parsed, _ := telemetry.ParseQueuedMessage()
+			if !parsed {
+				break
+			}
+		}
+
+		expected := simulator.GetAxes()
+		for _, filter := range []ahrsFilter_t{AHRS_FILTER_RAW, AHRS_FILTER_MADGWICK} {
+			configuration.AhrsFilter = filter
+			telemetry.ahrs = NewAhrs(filter)
+			var axes Axes
+			for i := 0; i < 10; i++ {
+				simulator.clock.Sleep(10 * time.Millisecond)
+				axes, _ = telemetry.GetAxes()
+			}
+			if math.Abs(axes.Pitch-expected.Pitch) > ToRadians(1.5) {
+				t.Errorf("Bad %v pitch %v, expected %v", filter, ToDegrees(axes.Pitch), ToDegrees(expected.Pitch))
+			}
+			if math.Abs(axes.Roll-expected.Roll) > ToRadians(1.5) {
+				t.Errorf("Bad %v roll %v, expected %v", filter, ToDegrees(axes.Roll), ToDegrees(expected.Roll))
+			}
+			if math.Abs(GetAngleTo(axes.Yaw, expected.Yaw)) > ToRadians(2) {
+				t.Errorf("Bad %v yaw %v, expected %v", filter, ToDegrees(axes.Yaw), ToDegrees(expected.Yaw))
+			}
+		}
+		pilotClock = previousClock
+	}
+}
+
+func TestSimulatedGps(t *testing.T) {
+	when := time.Date(2020, 10, 1, 12, 34, 56, 0, time.UTC)
+	position := Point{Latitude: 40.054, Longitude: -105.295}
+	sentences := formatNmeaSentences(when, position, 1800, 10, 90, 1.0)
+	telemetry := NewTelemetry(newFakeHardware())
+	for _, sentence := range sentences {
+		telemetry.parseSentence(sentence)
+	}
+	if !telemetry.HasGpsLock() {
+		t.Error("Should have GPS lock")
+	}
+	if math.Abs(telemetry.recentPoint.Latitude-position.Latitude) > 1e-6 {
+		t.Errorf("Bad latitude %v", telemetry.recentPoint.Latitude)
+	}
+	if math.Abs(telemetry.recentPoint.Longitude-position.Longitude) > 1e-6 {
+		t.Errorf("Bad longitude %v", telemetry.recentPoint.Longitude)
+	}
+	if telemetry.recentPoint.Altitude != 1800 {
+		t.Errorf("Bad altitude %v", telemetry.recentPoint.Altitude)
+	}
+	if math.Abs(telemetry.GetSpeed()-10) > 0.1 {
+		t.Errorf("Bad speed %v", telemetry.GetSpeed())
+	}
+	if telemetry.GetTimestamp() != when.Unix() {
+		t.Errorf("Bad timestamp %v", telemetry.GetTimestamp())
+	}
+}
+
+// Loads the configuration that we fly with
+func loadTestConfiguration(t *testing.T) {
+	file, err := os.Open("../conf.toml")
+	if err != nil {
+		t.Fatal("Unable to open configuration TOML file")
+	}
+	defer file.Close()
+	err = LoadConfiguration(file)
+	if err != nil {
+		t.Fatalf("Unable to load configuration: '%v'", err)
+	}
+}
+
+// Loads the test configuration like loadTestConfiguration, and returns a
+// function that puts back the configuration from before, so that the test
+// doesn't leave conf.toml's MissionFile, which is relative to the repo root,
+// for the tests after it
+func replaceTestConfiguration(t *testing.T) func() {
+	previous := configuration
+	loadTestConfiguration(t)
+	return func() {
+		configuration = previous
+	}
+}
+
+func TestSimulatedFlight(t *testing.T) {
+	loadTestConfiguration(t)
+	configuration.MissionFile = "../missions/wonderland_lake.kml"
+	configuration.LandingPointAltitude = configuration.SimulatorGroundAltitude
+
+	simulator := NewSimulator()
+	result, err := simulator.Run()
+	if err != nil {
+		t.Fatalf("Simulation failed: %v", err)
+	}
+	if !result.Landed {
+		t.Errorf("Should have landed: %v", result)
+	}
+	if result.FlightTime < time.Minute {
+		t.Errorf("Flight was too short: %v", result)
+	}
+	if result.RealTime >= result.FlightTime {
+		t.Errorf("Should be faster than real time: %v", result)
+	}
+	if result.FinalState != landing {
+		t.Errorf("Bad final state: %v", result)
+	}
+	// The glider should have landed at the waypoints instead of flying off
+	mission, _ := LoadMission(configuration.MissionFile)
+	distance := Distance(result.LandingPoint, mission.Repeating[0])
+	if distance > 300 {
+		t.Errorf("Landed too far from the waypoints, %0.0f m: %v", distance, result)
+	}
+}
+
+func TestSimulatedBalloonDrop(t *testing.T) {
+	loadTestConfiguration(t)
+	configuration.MissionFile = "../missions/wonderland_lake.kml"
+	configuration.DistanceFormula = DISTANCE_FORMULA_HAVERSINE
+	configuration.SimulatorBalloonAscentSpeed = 5
+	configuration.ReleaseAltitude = configuration.SimulatorLaunchAltitude + 500
+
+	var buffer bytes.Buffer
+	recorder, err := flightdata.NewWriter(&buffer)
+	if err != nil {
+		t.Fatalf("Unable to create recorder: %v", err)
+	}
+	simulator := NewSimulator()
+	simulator.SetFlightRecorder(recorder)
+	result, err := simulator.Run()
+	if err != nil {
+		t.Fatalf("Simulation failed: %v", err)
+	}
+	if !result.Landed || result.FinalState != landing {
+		t.Errorf("Should have landed while landing: %v", result)
+	}
+
+	records, err := flightdata.NewReader(&buffer).ReadAll()
+	if err != nil {
+		t.Fatalf("Unable to read records: %v", err)
+	}
+	var states []string
+	var releaseTime, flyingTime time.Time
+	maxAltitude := 0.0
+	for _, record := range records {
+		if len(states) == 0 || states[len(states)-1] != record.State {
+			states = append(states, record.State)
+			if record.State == released.String() {
+				releaseTime = record.Time
+			} else if record.State == flying.String() {
+				flyingTime = record.Time
+			}
+		}
+		maxAltitude = math.Max(maxAltitude, record.Altitude_m)
+	}
+	expected := []string{"initializing", "waitingForButton", "ascending", "released", "flying", "landing"}
+	if !reflect.DeepEqual(states, expected) {
+		t.Fatalf("Expected states %v, got %v", expected, states)
+	}
+	// It should fall, then stabilize
+	elapsed := flyingTime.Sub(releaseTime)
+	if elapsed < configuration.ReleaseStabilizeDuration || elapsed > configuration.ReleaseStabilizeDuration+5*time.Second {
+		t.Errorf("Bad time from release to flying %v", elapsed)
+	}
+	if maxAltitude < configuration.ReleaseAltitude-20 || maxAltitude > configuration.ReleaseAltitude+50 {
+		t.Errorf("Bad release altitude %v", maxAltitude)
+	}
+}
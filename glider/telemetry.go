@@ -1,12 +1,13 @@
 package glider
 
 import (
+	"context"
 	"github.com/adrianmo/go-nmea"
 	"github.com/argandas/serial"
+	"io"
 	"math"
-	"periph.io/x/periph/conn/i2c/i2creg"
-	"periph.io/x/periph/host"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -17,6 +18,7 @@ type Meters = float64
 
 type Coordinate = float64
 type MetersPerSecond = float64
+type RadiansPerSecond = float64
 
 type Point struct {
 	Latitude  Coordinate
@@ -34,6 +36,8 @@ type sensor interface {
 	SenseRaw() (int16, int16, int16, error)
 }
 
+// ReadLine returns whatever is buffered, up to and including the next newline,
+// so it can return part of a line
 type serialInterface interface {
 	Available() int
 	ReadLine() (string, error)
@@ -47,8 +51,23 @@ func (cs *concreteSerial) Available() int {
 	return cs.ser.Available()
 }
 
+// The library's ReadLine throws away the start of a line if the rest hasn't
+// arrived yet, so read a byte at a time instead
 func (cs *concreteSerial) ReadLine() (string, error) {
-	return cs.ser.ReadLine()
+	var line strings.Builder
+	for {
+		b, err := cs.ser.Read()
+		if err != nil {
+			if line.Len() > 0 {
+				return line.String(), nil
+			}
+			return "", err
+		}
+		line.WriteByte(b)
+		if b == '\n' {
+			return line.String(), nil
+		}
+	}
 }
 
 // The number of sensor readings to average together
@@ -58,6 +77,8 @@ type sensorFilter struct {
 	s                sensor
 	previousReadings [sensorFilterAverageCount][3]int32
 	name             string
+	// The most recent reading, before averaging
+	raw [3]int16
 }
 
 func (filter *sensorFilter) SenseRaw() (int16, int16, int16, error) {
@@ -65,6 +86,7 @@ func (filter *sensorFilter) SenseRaw() (int16, int16, int16, error) {
 	if err != nil {
 		return 0, 0, 0, err
 	}
+	filter.raw = [3]int16{x, y, z}
 
 	// Move the previous readings down
 	const LEN = len(filter.previousReadings)
@@ -89,70 +111,86 @@ func (filter *sensorFilter) SenseRaw() (int16, int16, int16, error) {
 	return int16(sums[0] / int32(LEN)), int16(sums[1] / int32(LEN)), int16(sums[2] / int32(LEN)), nil
 }
 
+// The GPS fields can be updated by the GPS reader goroutine and the sensor
+// fields can be read by the dashboard, so each group has its own mutex. If
+// both are needed, sensorMutex is locked first.
 type Telemetry struct {
-	HasGpsLock    bool
-	recentPoint   Point
-	recentSpeed   MetersPerSecond
-	gps           serialInterface
+	gpsMutex     sync.Mutex
+	hasGpsLock   bool
+	recentPoint  Point
+	recentSpeed  MetersPerSecond
+	recentCourse Radians
+	// When the most recent fix was taken, according to the GPS, and when we
+	// parsed it
+	recentFixTime     time.Time
+	recentFixReceived time.Time
+	timestamp         int64
+	gpsFilter         gpsFilter
+	hdop              float64
+	// GPS time of the last position fed to the filter, so that we don't
+	// count the same fix twice when it's in multiple sentences
+	filteredFixTime nmea.Time
+
+	gps      serialInterface
+	gpsLines gpsLineReader
+	// Nil unless the GPS is being read in the background
+	gpsReader  *GpsReader
+	gpsApplied chan struct{}
+
+	sensorMutex   sync.Mutex
+	recentAxes    Axes
 	accelerometer sensorFilter
 	magnetometer  sensorFilter
-	timestamp     int64
+	gyroscope     sensorFilter
+	ahrs          *Ahrs
+	ahrsTime      time.Time
+	// Whether UpdateAxes runs as its own task, so that GetAxes only needs to
+	// return its latest estimate, and the error from reading the sensors
+	axesScheduled   bool
+	recentAxesErr   error
+	declination     Radians
+	declinationTime time.Time
 }
 
-func NewTelemetry() (*Telemetry, error) {
-	var gps serialInterface
-	var accelerometer *Adxl345
-	var magnetometer *Hmc5883L
-	if IsPi() {
-		// Make sure periph is initialized.
-		if _, err := host.Init(); err != nil {
-			return nil, err
-		}
-
-		// Prepare GPS
-		rawGps := serial.New()
-		rawGps.Verbose = false
-		err := rawGps.Open(configuration.GpsTty, configuration.GpsBitRate)
-		if err != nil {
-			return nil, err
-		}
-		gps = &concreteSerial{ser: rawGps}
-
-		// Open a connection, using I²C as an example:
-		bus, err := i2creg.Open("")
-		if err != nil {
-			return nil, err
-		}
-
-		accelerometer, err = NewAdxl345(bus)
-		if err != nil {
-			return nil, err
-		}
-		magnetometer, err = NewHmc5883L(bus)
-		if err != nil {
-			return nil, err
-		}
-	}
+// The most recent GPS values, before filtering
+type gpsSnapshot struct {
+	lock        bool
+	point       Point
+	speed       MetersPerSecond
+	course      Radians
+	fixTime     time.Time
+	fixReceived time.Time
+	hdop        float64
+}
 
+func NewTelemetry(hardware *Hardware) *Telemetry {
 	accelerometerFilter := sensorFilter{
-		s:    accelerometer,
+		s:    hardware.Accelerometer,
 		name: "accel",
 	}
 	magnetometerFilter := sensorFilter{
-		s:    magnetometer,
+		s:    hardware.Magnetometer,
 		name: "mag",
 	}
+	gyroscopeFilter := sensorFilter{
+		s:    hardware.Gyroscope,
+		name: "gyro",
+	}
 	return &Telemetry{
 		recentPoint:   Point{Latitude: 40.0, Longitude: -105.2, Altitude: 1655},
 		recentSpeed:   0.0,
-		gps:           gps,
+		gps:           hardware.Gps,
 		accelerometer: accelerometerFilter,
 		magnetometer:  magnetometerFilter,
-		HasGpsLock:    false,
-	}, nil
+		gyroscope:     gyroscopeFilter,
+		ahrs:          NewAhrs(configuration.AhrsFilter),
+		hasGpsLock:    false,
+	}
 }
 
 func (telemetry *Telemetry) GetFilteredAxes() (Axes, error) {
+	telemetry.sensorMutex.Lock()
+	defer telemetry.sensorMutex.Unlock()
 	xRawA, yRawA, zRawA, err := telemetry.accelerometer.SenseRaw()
 	if err != nil {
 		return Axes{0, 0, 0}, err
@@ -171,7 +209,39 @@ func (telemetry *Telemetry) GetFilteredAxes() (Axes, error) {
 	), nil
 }
 
+// Returns the latest attitude estimate. If UpdateAxes isn't running as its
+// own task, this reads the sensors and steps the AHRS first.
 func (telemetry *Telemetry) GetAxes() (Axes, error) {
+	telemetry.sensorMutex.Lock()
+	defer telemetry.sensorMutex.Unlock()
+	if telemetry.axesScheduled {
+		return telemetry.recentAxes, telemetry.recentAxesErr
+	}
+	return telemetry.updateAxes()
+}
+
+// Reads the sensors and steps the AHRS. The pilot runs this every AhrsPeriod,
+// so that the filter keeps up even when nothing is asking for the attitude,
+// e.g. while we're hanging from a balloon.
+func (telemetry *Telemetry) UpdateAxes() error {
+	telemetry.sensorMutex.Lock()
+	defer telemetry.sensorMutex.Unlock()
+	telemetry.axesScheduled = true
+	_, telemetry.recentAxesErr = telemetry.updateAxes()
+	return telemetry.recentAxesErr
+}
+
+// Replaces the attitude estimate, e.g. with a recorded one. GetAxes returns
+// it instead of reading the sensors.
+func (telemetry *Telemetry) setAxes(axes Axes) {
+	telemetry.sensorMutex.Lock()
+	defer telemetry.sensorMutex.Unlock()
+	telemetry.axesScheduled = true
+	telemetry.recentAxes = axes
+	telemetry.recentAxesErr = nil
+}
+
+func (telemetry *Telemetry) updateAxes() (Axes, error) {
 	xRawA, yRawA, zRawA, err := telemetry.accelerometer.SenseRaw()
 	Logger.Debugf("accel %v %v %v", xRawA, yRawA, zRawA)
 	if err != nil {
@@ -185,48 +255,125 @@ func (telemetry *Telemetry) GetAxes() (Axes, error) {
 		Logger.Info("magnetometer.SenseRaw failed")
 		return Axes{0, 0, 0}, err
 	}
-	return computeAxes(xRawA, yRawA, zRawA, xRawM, yRawM, zRawM), nil
-}
 
-func computeAxes(xRawA, yRawA, zRawA, xRawM, yRawM, zRawM int16) Axes {
-	// Avoid divide by zero problems
-	if zRawA == 0 {
-		zRawA = 1
+	// Keep the old calculation around so that we can compare old logs
+	if configuration.AhrsFilter == AHRS_FILTER_RAW || telemetry.ahrs == nil {
+		telemetry.recentAxes = telemetry.toTrueNorth(computeAxes(xRawA, yRawA, zRawA, xRawM, yRawM, zRawM))
+		return telemetry.recentAxes, nil
 	}
-	// The roll calculation assumes that +y is forward, x is right, and
-	// +z is up
-	x2 := int32(xRawA) * int32(xRawA)
-	z2 := int32(zRawA) * int32(zRawA)
 
-	// Tilt compensated compass readings
-	pitch_r := math.Atan2(float64(yRawA), math.Sqrt(float64(x2+z2)))
-	roll_r := -math.Atan2(float64(xRawA), float64(zRawA))
+	xRateG, yRateG, zRateG, err := telemetry.getRotationRates()
+	if err != nil {
+		return Axes{0, 0, 0}, err
+	}
+	now := pilotClock.Now()
+	var elapsed time.Duration
+	if !telemetry.ahrsTime.IsZero() {
+		elapsed = now.Sub(telemetry.ahrsTime)
+	}
+	telemetry.ahrsTime = now
+	telemetry.ahrs.Update(
+		accelerometerToBody(xRawA, yRawA, zRawA),
+		magnetometerToBody(xRawM, yRawM, zRawM),
+		gyroscopeToBody(xRateG, yRateG, zRateG),
+		elapsed,
+	)
+
+	telemetry.recentAxes = telemetry.toTrueNorth(telemetry.ahrs.GetAxes())
+	return telemetry.recentAxes, nil
+}
 
-	// TODO: I think these roll and pitch offset calculations are wrong.
-	// We need to figure out the x, y, and z components that are off
-	// and then add those above to the raw values.
+// Returns the declination that the compass is corrected with. Until we have a
+// GPS fix, or if the World Magnetic Model is out of date, this is the
+// configured value.
+func (telemetry *Telemetry) GetDeclination() Radians {
+	telemetry.sensorMutex.Lock()
+	defer telemetry.sensorMutex.Unlock()
+	return telemetry.getDeclination()
+}
 
-	pitch_r -= configuration.PitchOffset
-	for pitch_r < ToRadians(-180.0) {
-		pitch_r += ToRadians(360.0)
+func (telemetry *Telemetry) getDeclination() Radians {
+	if configuration.DeclinationSource == DECLINATION_SOURCE_CONFIGURATION {
+		return configuration.Declination
 	}
-	for pitch_r > ToRadians(180.0) {
-		pitch_r -= ToRadians(360.0)
+	now := pilotClock.Now()
+	if !telemetry.declinationTime.IsZero() && now.Sub(telemetry.declinationTime) < declinationUpdateInterval {
+		return telemetry.declination
 	}
+	estimate := telemetry.GetPositionEstimate()
+	if !estimate.Valid {
+		return configuration.Declination
+	}
+	telemetry.declinationTime = now
+	if !wmmValid(now) {
+		Logger.Warningf("The World Magnetic Model is only valid from %0.0f to %0.0f, using the configured declination", wmmEpoch, wmmEpoch+wmmValidYears)
+		telemetry.declination = configuration.Declination
+		return telemetry.declination
+	}
+	field := WorldMagneticModel(estimate.Point, now)
+	telemetry.declination = field.Declination
+	Logger.Debugf("Declination %0.2f", ToDegrees(telemetry.declination))
+	return telemetry.declination
+}
+
+// Converts the yaw from magnetic north to true north
+func (telemetry *Telemetry) toTrueNorth(axes Axes) Axes {
+	axes.Yaw = math.Mod(axes.Yaw+telemetry.getDeclination(), ToRadians(360.0))
+	if axes.Yaw < 0 {
+		axes.Yaw += ToRadians(360.0)
+	}
+	return axes
+}
+
+// Returns the rotation rates around the x, y, and z axes
+func (telemetry *Telemetry) GetRotationRates() (RadiansPerSecond, RadiansPerSecond, RadiansPerSecond, error) {
+	telemetry.sensorMutex.Lock()
+	defer telemetry.sensorMutex.Unlock()
+	return telemetry.getRotationRates()
+}
 
-	roll_r -= configuration.RollOffset
-	for roll_r < ToRadians(-180.0) {
-		roll_r += ToRadians(360.0)
+func (telemetry *Telemetry) getRotationRates() (RadiansPerSecond, RadiansPerSecond, RadiansPerSecond, error) {
+	xRawG, yRawG, zRawG, err := telemetry.gyroscope.SenseRaw()
+	Logger.Debugf("gyro %v %v %v", xRawG, yRawG, zRawG)
+	if err != nil {
+		Logger.Info("gyroscope.SenseRaw failed")
+		return 0, 0, 0, err
 	}
-	for roll_r > ToRadians(180.0) {
-		roll_r -= ToRadians(360.0)
+	return itg3200RawToRadiansPerSecond(xRawG), itg3200RawToRadiansPerSecond(yRawG), itg3200RawToRadiansPerSecond(zRawG), nil
+}
+
+// Returns the magnitude of the specific force that the accelerometer feels, in
+// g. It's 1 when we're sitting still and 0 when we're falling freely.
+func (telemetry *Telemetry) GetAcceleration() (float64, error) {
+	telemetry.sensorMutex.Lock()
+	defer telemetry.sensorMutex.Unlock()
+	xRawA, yRawA, zRawA, err := telemetry.accelerometer.SenseRaw()
+	if err != nil {
+		return 0, err
 	}
+	g := getAccelerometerCalibration().Apply(xRawA, yRawA, zRawA)
+	return math.Sqrt(g[0]*g[0] + g[1]*g[1] + g[2]*g[2]), nil
+}
+
+func computeAxes(xRawA, yRawA, zRawA, xRawM, yRawM, zRawM int16) Axes {
+	// The roll calculation assumes that +y is forward, x is right, and
+	// +z is up
+	accelerometer := boardToAirframe(getAccelerometerCalibration().Apply(xRawA, yRawA, zRawA))
+	xA := accelerometer[0]
+	yA := accelerometer[1]
+	zA := accelerometer[2]
 
-	// Offsets from keeping 2 accelerometer axes at 0 and measuring raw:
-	// (-189 + 334) / 2 = 72, (-399 + 104) / 2 = -148, (-324 + 114) / 2 = -105
-	xM := float64(xRawM - 72)
-	yM := float64(yRawM - -148)
-	zM := float64(zRawM - -105)
+	// Tilt compensated compass readings
+	pitch_r := math.Atan2(yA, math.Sqrt(xA*xA+zA*zA))
+	roll_r := -math.Atan2(xA, zA)
+
+	// Rotate the magnetometer into the airframe too, but keep its 180 degree
+	// offset because the math below expects it
+	calibrated := getMagnetometerCalibration().Apply(xRawM, yRawM, zRawM)
+	magnetometer := boardToAirframe(vector3{calibrated[1], -calibrated[0], calibrated[2]})
+	xM := -magnetometer[1]
+	yM := magnetometer[0]
+	zM := magnetometer[2]
 	xHorizontal := xM*math.Cos(-pitch_r) + yM*math.Sin(roll_r)*math.Sin(-pitch_r) - zM*math.Cos(roll_r)*math.Sin(-pitch_r)
 	yHorizontal := yM*math.Cos(roll_r) + zM*math.Sin(roll_r)
 	yaw_r := math.Atan2(yHorizontal, xHorizontal)
@@ -242,104 +389,163 @@ func computeAxes(xRawA, yRawA, zRawA, xRawM, yRawM, zRawM int16) Axes {
 	}
 }
 
-// Parse any waiting GPS messages. Users need not call this, but may.
+// Returns the most recent raw readings and the axes computed from them
+func (telemetry *Telemetry) getRecentSensors() (accelerometer, gyroscope, magnetometer [3]int16, axes Axes) {
+	telemetry.sensorMutex.Lock()
+	defer telemetry.sensorMutex.Unlock()
+	return telemetry.accelerometer.raw, telemetry.gyroscope.raw, telemetry.magnetometer.raw, telemetry.recentAxes
+}
+
+// Parses the next complete GPS sentence, if there is one. Returns false once
+// there's nothing left to parse, or if the GPS reader goroutine is running,
+// because it parses sentences as they arrive. Users need not call this, but
+// may.
 func (telemetry *Telemetry) ParseQueuedMessage() (bool, error) {
-	const MINIMUM_BUFFER = 100
+	if telemetry.gpsReader != nil {
+		return false, nil
+	}
+	line, ok, err := telemetry.gpsLines.readLine(telemetry.gps)
+	if err != nil || !ok {
+		return false, err
+	}
+	Logger.Debug(line)
+	telemetry.parseSentence(line)
+	return true, nil
+}
 
-	if telemetry.gps.Available() > MINIMUM_BUFFER {
-		// If there is still a message queued, then parse it
-		line, err := telemetry.gps.ReadLine()
-		if err != nil {
-			return false, err
-		}
-		if line != "" {
-			Logger.Debug(strings.TrimSpace(line))
-			telemetry.parseSentence(line)
-		}
-		return true, nil
+// Reads the GPS in its own goroutine until ctx is done or StopGpsReader is
+// called, so that fixes are applied as soon as they arrive instead of when
+// ParseQueuedMessage is next called
+func (telemetry *Telemetry) StartGpsReader(ctx context.Context) {
+	if telemetry.gpsReader != nil {
+		return
 	}
+	reader := NewGpsReader(telemetry.gps)
+	telemetry.gpsReader = reader
+	telemetry.gpsApplied = make(chan struct{})
+	reader.Start(ctx)
+	go func() {
+		defer recoverGoroutine()
+		defer close(telemetry.gpsApplied)
+		for fix := range reader.Fixes() {
+			telemetry.applyGpsFix(fix)
+		}
+	}()
+}
 
-	// No message is ready, let's abort
-	return false, nil
+// Stops the GPS reader goroutine and waits for the last fixes to be applied
+func (telemetry *Telemetry) StopGpsReader() {
+	if telemetry.gpsReader == nil {
+		return
+	}
+	telemetry.gpsReader.Stop()
+	<-telemetry.gpsApplied
+	telemetry.gpsReader = nil
 }
 
 func (telemetry *Telemetry) GetPosition() Point {
-	// TODO: Hook up GPS and return real readings
-	temp := Point{
-		// Solid State Depot
-		Latitude:  40.020,
-		Longitude: -105.250,
-		Altitude:  1609,
-	}
-	return temp
-	/*
-		_, err := telemetry.ParseQueuedMessage()
-		if err != nil {
-			Logger.Errorf("Unable to parse GPS message: %v", err)
-		}
-		// TODO: Do some forward projection or Kalman filtering
+	_, err := telemetry.ParseQueuedMessage()
+	if err != nil && err != io.EOF {
+		Logger.Errorf("Unable to parse GPS message: %v", err)
+	}
+	estimate := telemetry.GetPositionEstimate()
+	if !estimate.Valid {
+		telemetry.gpsMutex.Lock()
+		defer telemetry.gpsMutex.Unlock()
 		return telemetry.recentPoint
-	*/
+	}
+	return estimate.Point
+}
+
+// Returns the filtered position, projected forward to now
+func (telemetry *Telemetry) GetPositionEstimate() PositionEstimate {
+	telemetry.gpsMutex.Lock()
+	defer telemetry.gpsMutex.Unlock()
+	return telemetry.gpsFilter.estimate(pilotClock.Now())
+}
+
+func (telemetry *Telemetry) HasGpsLock() bool {
+	telemetry.gpsMutex.Lock()
+	defer telemetry.gpsMutex.Unlock()
+	return telemetry.hasGpsLock
 }
 
 func (telemetry *Telemetry) GetTimestamp() int64 {
+	telemetry.gpsMutex.Lock()
+	defer telemetry.gpsMutex.Unlock()
 	return telemetry.timestamp
 }
 
 func (telemetry *Telemetry) GetSpeed() MetersPerSecond {
+	telemetry.gpsMutex.Lock()
+	defer telemetry.gpsMutex.Unlock()
 	return telemetry.recentSpeed
 }
 
+func (telemetry *Telemetry) getGpsSnapshot() gpsSnapshot {
+	telemetry.gpsMutex.Lock()
+	defer telemetry.gpsMutex.Unlock()
+	return gpsSnapshot{
+		lock:        telemetry.hasGpsLock,
+		point:       telemetry.recentPoint,
+		speed:       telemetry.recentSpeed,
+		course:      telemetry.recentCourse,
+		fixTime:     telemetry.recentFixTime,
+		fixReceived: telemetry.recentFixReceived,
+		hdop:        telemetry.hdop,
+	}
+}
+
+// Parses a GPS message and saves the output
 func (telemetry *Telemetry) parseSentence(sentence string) {
-	// Parses a GPS message and save the output
-	// We see $GPGSV, $GPRMC, $GPVTG, $GPGGA, $GPGSA, $GPGLL messages
-	// $GPGSV is satellites in view, not useful
-	// $GPRMC has latitude, longitude, speed in knots, and magnetic variation
-	// $GPVTG has speed in knots and km/h
-	// $GPGGA has latitude, longitude, and altitude
-	// $GPGSA is active satellites, not useful
-	// $GPGLL is just latitude and longitude
-
-	if strings.HasPrefix(sentence, "$GPRMC") {
-		parsed, err := nmea.Parse(sentence)
-		if err != nil {
-			Logger.Errorf("Failed to parse GPRMC message '%v': %v\n", sentence, err)
-			return
+	fix, ok, err := parseGpsFix(sentence, pilotClock.Now())
+	if err != nil {
+		Logger.Errorf("Failed to parse GPS message '%v': %v", sentence, err)
+		return
+	}
+	if ok {
+		telemetry.applyGpsFix(fix)
+	}
+}
+
+func (telemetry *Telemetry) applyGpsFix(fix GpsFix) {
+	telemetry.gpsMutex.Lock()
+	defer telemetry.gpsMutex.Unlock()
+
+	switch fix.Type {
+	case nmea.TypeRMC:
+		telemetry.hasGpsLock = fix.Valid
+		telemetry.recentPoint.Latitude = fix.Latitude
+		telemetry.recentPoint.Longitude = fix.Longitude
+		if fix.Valid {
+			telemetry.filterPosition(fix.fixTime, fix.Latitude, fix.Longitude, fix.Received)
+			telemetry.recentSpeed = fix.Speed
+			telemetry.recentCourse = fix.Course
+			telemetry.recentFixTime = fix.Time
+			telemetry.recentFixReceived = fix.Received
+			telemetry.gpsFilter.updateVelocity(telemetry.recentSpeed, telemetry.recentCourse, fix.Received)
 		}
-		message := parsed.(nmea.RMC)
-		telemetry.HasGpsLock = (message.Validity == nmea.ValidRMC)
-		telemetry.recentPoint.Latitude = message.Latitude
-		telemetry.recentPoint.Longitude = message.Longitude
 		if telemetry.timestamp == 0 {
-			t := time.Date(
-				message.Date.YY+2000,
-				time.Month(message.Date.MM),
-				message.Date.DD,
-				message.Time.Hour,
-				message.Time.Minute,
-				message.Time.Second,
-				0,
-				time.UTC,
-			)
-			telemetry.timestamp = t.Unix()
+			telemetry.timestamp = fix.Time.Unix()
 		}
-	} else if strings.HasPrefix(sentence, "$GPGGA") {
-		parsed, err := nmea.Parse(sentence)
-		if err != nil {
-			Logger.Errorf("Failed to parse GPRMC message %v\n", sentence)
-			return
+	case nmea.TypeGGA:
+		telemetry.recentPoint.Latitude = fix.Latitude
+		telemetry.recentPoint.Longitude = fix.Longitude
+		telemetry.recentPoint.Altitude = fix.Altitude
+		if fix.Valid {
+			telemetry.hdop = fix.Hdop
+			telemetry.filterPosition(fix.fixTime, fix.Latitude, fix.Longitude, fix.Received)
+			telemetry.gpsFilter.updateAltitude(fix.Altitude, fix.Received)
 		}
-		message := parsed.(nmea.GGA)
-		telemetry.recentPoint.Latitude = message.Latitude
-		telemetry.recentPoint.Longitude = message.Longitude
-		telemetry.recentPoint.Altitude = message.Altitude
-	} else if strings.HasPrefix(sentence, "$GPVTG") {
-		parsed, err := nmea.Parse(sentence)
-		if err != nil {
-			Logger.Errorf("Failed to parse GPRMC message %v\n", sentence)
-			return
-		}
-		message := parsed.(nmea.VTG)
-		telemetry.recentSpeed = MetersPerSecond(message.GroundSpeedKPH * 1000.0 / 3600.0)
+	case nmea.TypeVTG:
+		telemetry.recentSpeed = fix.Speed
+	}
+}
+
+func (telemetry *Telemetry) filterPosition(fixTime nmea.Time, latitude, longitude Coordinate, now time.Time) {
+	if fixTime.Valid && fixTime == telemetry.filteredFixTime {
+		return
 	}
+	telemetry.filteredFixTime = fixTime
+	telemetry.gpsFilter.updatePosition(latitude, longitude, telemetry.hdop, now)
 }
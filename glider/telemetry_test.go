@@ -6,10 +6,7 @@ import (
 )
 
 func TestParseSentence(t *testing.T) {
-	telemetry, err := NewTelemetry()
-	if err != nil {
-		t.Errorf("Failed to create telemetry: %v", err)
-	}
+	telemetry := NewTelemetry(newFakeHardware())
 	telemetry.parseSentence("$GPRMC,081836,A,3700.00,N,13300.00,W,000.0,360.0,130998,011.3,E*69")
 	if telemetry.recentPoint.Latitude != 37.0 {
 		t.Error("Failed to parse RMC latitude")
@@ -33,10 +30,7 @@ func TestParseSentence(t *testing.T) {
 }
 
 func BenchmarkParseSentence(b *testing.B) {
-	telemetry, err := NewTelemetry()
-	if err != nil {
-		b.Errorf("Failed to create telemetry: %v", err)
-	}
+	telemetry := NewTelemetry(newFakeHardware())
 	for i := 0; i < b.N; i++ {
 		telemetry.parseSentence("$GPRMC,081836,A,3700.00,N,13300.00,W,000.0,360.0,130998,011.3,E*69")
 		telemetry.parseSentence("$GPGGA,134658.00,4300.00,S,04000,E,2,09,1.0,1048.47,M,-16.27,M,08,AAAA*43")
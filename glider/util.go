@@ -1,11 +1,16 @@
 package glider
 
 import (
-	"errors"
+	"fmt"
 	"github.com/BurntSushi/toml"
+	"github.com/bskari/go-glider/aprs"
 	"io"
 	"io/ioutil"
 	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -42,66 +47,8 @@ func approximatelyEqual(a, b float64) bool {
 	return false
 }
 
-var previousLed bool
-var ledEnabled = false
-
-func initializeLed() error {
-	if !ledEnabled {
-		err := ioutil.WriteFile("/sys/class/leds/led0/trigger", []byte("gpio"), 0644)
-		if err != nil {
-			return err
-		}
-		ledEnabled = true
-	}
-	return nil
-}
-
-func ToggleLed() error {
-	if !IsPi() {
-		return nil
-	}
-	err := initializeLed()
-	if err != nil {
-		return err
-	}
-
-	ledValue := "1"
-	if previousLed {
-		ledValue = "0"
-	}
-	previousLed = !previousLed
-
-	err = ioutil.WriteFile("/sys/class/leds/led0/brightness", []byte(ledValue), 0644)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func SetLed(on bool) error {
-	if !IsPi() {
-		return nil
-	}
-	err := initializeLed()
-	if err != nil {
-		return err
-	}
-
-	// The Pi Zero is reversed, because the power LED doubles as the activity
-	// LED, so when there's activity, it's _off_
-	ledValue := "0"
-	if on {
-		ledValue = "1"
-	}
-
-	err = ioutil.WriteFile("/sys/class/leds/led0/brightness", []byte(ledValue), 0644)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
 type LedStatusIndicator struct {
+	led                 statusLed
 	blinksToShow        uint8
 	ledOn               bool
 	currentBlinkCount   uint8
@@ -110,14 +57,15 @@ type LedStatusIndicator struct {
 	betweenSetsOfBlinks time.Duration
 }
 
-func NewLedStatusIndicator(blinksToShow uint8) *LedStatusIndicator {
+func NewLedStatusIndicator(led statusLed, blinksToShow uint8) *LedStatusIndicator {
 	betweenBlinks, _ := time.ParseDuration("150ms")
 	betweenSetsOfBlinks, _ := time.ParseDuration("500ms")
 	return &LedStatusIndicator{
+		led:                 led,
 		blinksToShow:        blinksToShow,
 		ledOn:               false,
 		currentBlinkCount:   0,
-		until:               time.Now(),
+		until:               pilotClock.Now(),
 		betweenBlinks:       betweenBlinks,
 		betweenSetsOfBlinks: betweenSetsOfBlinks,
 	}
@@ -126,11 +74,11 @@ func NewLedStatusIndicator(blinksToShow uint8) *LedStatusIndicator {
 // Continues blinking the state. If the blink finishes, it will start blinking
 // the next state.
 func (statusIndicator *LedStatusIndicator) BlinkState(newBlinkCount uint8) bool {
-	now := time.Now()
+	now := pilotClock.Now()
 
 	if now.After(statusIndicator.until) {
 		statusIndicator.ledOn = !statusIndicator.ledOn
-		SetLed(statusIndicator.ledOn)
+		statusIndicator.led.Set(statusIndicator.ledOn)
 		statusIndicator.until = now.Add(statusIndicator.betweenBlinks)
 		if !statusIndicator.ledOn {
 			statusIndicator.currentBlinkCount++
@@ -148,9 +96,9 @@ func (statusIndicator *LedStatusIndicator) BlinkState(newBlinkCount uint8) bool
 
 func (statusIndicator *LedStatusIndicator) Reset() {
 	statusIndicator.ledOn = false
-	SetLed(false)
+	statusIndicator.led.Set(false)
 	statusIndicator.currentBlinkCount = 0
-	statusIndicator.until = time.Now().Add(statusIndicator.betweenSetsOfBlinks)
+	statusIndicator.until = pilotClock.Now().Add(statusIndicator.betweenSetsOfBlinks)
 }
 
 const PI = 3.14159265358979
@@ -174,140 +122,634 @@ type configuration_t struct {
 	WaypointInRangeDistance          Meters
 	DefaultWaypointLatitude          Coordinate
 	DefaultWaypointLongitude         Coordinate
-	PitchOffset                      Radians
-	RollOffset                       Radians
-	MagnetometerXOffset_t            float64
-	MagnetometerYOffset_t            float64
+	MissionFile                      string
+	GuidanceMode                     guidanceMode_t
+	GuidancePeriod                   time.Duration
+	GuidanceDamping                  float64
+	AccelerometerBias                vector3
+	AccelerometerScale               vector3
+	BoardMounting                    [3][3]float64
+	MagnetometerHardIron             vector3
+	MagnetometerSoftIron             [3][3]float64
 	Declination                      Radians
+	DeclinationSource                declinationSource_t
+	AhrsFilter                       ahrsFilter_t
+	AhrsPeriod                       time.Duration
+	MadgwickBeta                     float64
+	MahonyProportionalGain           float64
+	MahonyIntegralGain               float64
+	ComplementaryGyroWeight          float64
 	GpsTty                           string
 	GpsBitRate                       int
-	IterationSleepTime               time.Duration
+	GpsPositionNoise                 Meters
+	GpsVelocityNoise                 MetersPerSecond
+	GpsAltitudeNoise                 Meters
+	GpsAccelerationNoise             float64
+	GpsStaleDuration                 time.Duration
+	GpsMaxUncertainty                Meters
+	ControlPeriod                    time.Duration
+	GpsPeriod                        time.Duration
+	DashboardPeriod                  time.Duration
 	LandNoMoveDuration               time.Duration
 	LaunchGlideDuration              time.Duration
 	ProportionalRollMultiplier       float64
 	ProportionalPitchMultiplier      float64
 	ProportionalTargetRollMultiplier float64
+	IntegralRollMultiplier           float64
+	IntegralPitchMultiplier          float64
+	IntegralTargetRollMultiplier     float64
+	DerivativeRollMultiplier         float64
+	DerivativePitchMultiplier        float64
+	DerivativeTargetRollMultiplier   float64
+	MaxRollIntegral                  Radians
+	MaxPitchIntegral                 Radians
+	MaxTargetRollIntegral            Radians
+	PidDerivativeCutoff_hz           float64
 	MaxTargetRoll                    Radians
 	LandingPointAltitude             Meters
 	LandingPointAltitudeOffset       Meters
+	LandingSpiralAltitude            Meters
+	LandingSpiralRadius              Meters
+	LandingFinalAltitude             Meters
+	LandingFlareAltitude             Meters
+	LandingFlarePitch                Radians
+	GeofenceFile                     string
+	GeofenceCeiling                  Meters
+	GeofencePredictionTime           time.Duration
+	GeofenceAction                   geofenceAction_t
+	GeofenceLoiterRadius             Meters
 	TargetPitch                      Radians
 	MaxServoPitchAdjustment          Radians
 	MaxServoAngleOffset              Radians
 	LeftServoCenter_us               uint16
 	RightServoCenter_us              uint16
+	FailsafeLeftServoOffset          Radians
+	FailsafeRightServoOffset         Radians
+	Hardware                         hardware_t
 	ButtonPin                        uint8
 	LeftServoPin                     uint8
 	RightServoPin                    uint8
-	ErrorSleepDuration               time.Duration
-	FlyDirection                     Radians
+	AscentSpeed                      MetersPerSecond
+	AscentAltitudeGain               Meters
+	ReleaseAltitude                  Meters
+	ReleaseDistance                  Meters
+	CutdownType                      cutdown_t
+	CutdownPin                       uint8
+	CutdownRelease_us                uint16
+	CutdownDuration                  time.Duration
+	FreeFallAcceleration_g           float64
+	FreeFallDuration                 time.Duration
+	ReleaseStabilizeDuration         time.Duration
+	MavlinkTransport                 mavlinkTransport_t
+	MavlinkUdpAddress                string
+	MavlinkUdpPort                   uint16
+	MavlinkTty                       string
+	MavlinkBitRate                   int
+	MavlinkSystemId                  uint8
+	MavlinkPeriod                    time.Duration
+	TuningAddress                    string
+	GroundStationAddress             string
+	GroundStationPeriod              time.Duration
+	AprsTty                          string
+	AprsBitRate                      int
+	AprsCallsign                     aprs.Address
+	AprsPath                         []aprs.Address
+	AprsSymbol                       string
+	AprsBeaconInterval               time.Duration
+	AprsFastRate                     time.Duration
+	AprsFastSpeed                    MetersPerSecond
+	AprsSlowRate                     time.Duration
+	AprsSlowSpeed                    MetersPerSecond
+	AprsMinTurnAngle                 Radians
+	// Radians times meters per second
+	AprsTurnSlope               float64
+	AprsMinTurnTime             time.Duration
+	ErrorSleepDuration          time.Duration
+	SimulatorPeriod             time.Duration
+	SimulatorSpeedup            float64
+	SimulatorTimeLimit          time.Duration
+	SimulatorLaunchLatitude     Coordinate
+	SimulatorLaunchLongitude    Coordinate
+	SimulatorLaunchAltitude     Meters
+	SimulatorLaunchHeading      Radians
+	SimulatorGroundAltitude     Meters
+	SimulatorGlideRatio         float64
+	SimulatorMass_kg            float64
+	SimulatorWingArea_sqm       float64
+	SimulatorRollResponse       float64
+	SimulatorPitchResponse      float64
+	SimulatorWindSpeed          MetersPerSecond
+	SimulatorWindDirection      Radians
+	SimulatorBalloonAscentSpeed MetersPerSecond
+	FlyDirection                Radians
 }
 
 var configuration configuration_t
 
+// The tags are the schema, see configuration.go. default is the value to use
+// if the key is missing, as TOML, except that strings don't need quotes.
+// Keys that don't have a sensible default are required instead. range is
+// the allowed values for numbers, and units is only needed for keys that
+// don't have a units suffix.
 type tomlConfiguration_t struct {
 	// One of "haversine", "sphericalLawOfCosines", "equirectangular",
 	// or "cachedEquirectangular"
-	DistanceFormula string
+	DistanceFormula string `default:"cachedEquirectangular"`
 	// One of "equirectangular", "cachedEquirectangular"
-	BearingFormula                   string
-	WaypointReachedDistance_m        float64
-	WaypointInRangeDistance_m        float64
-	DefaultWaypointLatitude          float64
-	DefaultWaypointLongitude         float64
-	PitchOffset_d                    float64
-	RollOffset_d                     float64
-	MagnetometerXMax_t               float64
-	MagnetometerXMin_t               float64
-	MagnetometerYMax_t               float64
-	MagnetometerYMin_t               float64
-	Declination_d                    float64
-	GpsTty                           string
-	GpsBitRate                       int64
-	IterationSleepTime_s             float64
-	LandNoMoveDuration_s             float64
-	LaunchGlideDuration_s            float64
-	ProportionalRollMultiplier       float64
-	ProportionalPitchMultiplier      float64
-	ProportionalTargetRollMultiplier float64
-	MaxTargetRoll_d                  float64
-	LandingPointAltitude_m           float64
-	LandingPointAltitudeOffset_m     float64
-	TargetPitch_d                    float64
-	MaxServoPitchAdjustment_d        float64
-	MaxServoAngleOffset_d            float64
-	LeftServoCenter_us               int64
-	RightServoCenter_us              int64
-	ButtonPin                        int64
-	LeftServoPin                     int64
-	RightServoPin                    int64
-	ErrorSleepDuration_s             float64
-	FlyDirection_d                   float64
+	BearingFormula            string  `default:"cachedEquirectangular"`
+	WaypointReachedDistance_m float64 `default:"20.0" range:"(0,)"`
+	WaypointInRangeDistance_m float64 `default:"50.0" range:"(0,)"`
+	DefaultWaypointLatitude   float64 `required:"true" range:"[-90,90]" units:"°"`
+	DefaultWaypointLongitude  float64 `required:"true" range:"[-180,180]" units:"°"`
+	// A GPX, KML, or GeoJSON file
+	MissionFile string `default:""`
+	// One of "direct" or "l1"
+	GuidanceMode         string      `default:"l1"`
+	GuidancePeriod_s     float64     `default:"17.0" range:"(0,)"`
+	GuidanceDamping      float64     `default:"0.75" range:"(0,)"`
+	AccelerometerBias    []float64   `default:"[0.0, 0.0, 0.0]"`
+	AccelerometerScale   []float64   `default:"[0.0039, 0.0039, 0.0039]"`
+	BoardMounting        [][]float64 `default:"[[1.0, 0.0, 0.0], [0.0, 1.0, 0.0], [0.0, 0.0, 1.0]]"`
+	MagnetometerHardIron []float64   `default:"[0.0, 0.0, 0.0]"`
+	MagnetometerSoftIron [][]float64 `default:"[[1.0, 0.0, 0.0], [0.0, 1.0, 0.0], [0.0, 0.0, 1.0]]"`
+	Declination_d        float64     `default:"0.0" range:"[-180,180]"`
+	// One of "worldMagneticModel" or "configuration"
+	DeclinationSource string `default:"worldMagneticModel"`
+	// One of "raw", "complementary", "madgwick", or "mahony"
+	AhrsFilter                       string  `default:"madgwick"`
+	AhrsFrequency_hz                 float64 `default:"100.0" range:"(0,1000]"`
+	MadgwickBeta                     float64 `default:"0.1" range:"[0,)"`
+	MahonyProportionalGain           float64 `default:"1.0" range:"[0,)"`
+	MahonyIntegralGain               float64 `default:"0.05" range:"[0,)"`
+	ComplementaryGyroWeight          float64 `default:"0.98" range:"[0,1]"`
+	GpsTty                           string  `default:"/dev/ttyAMA0"`
+	GpsBitRate                       int64   `default:"9600" range:"(0,)" units:"baud"`
+	GpsPositionNoise_m               float64 `default:"5.0" range:"(0,)"`
+	GpsVelocityNoise_mps             float64 `default:"0.5" range:"(0,)"`
+	GpsAltitudeNoise_m               float64 `default:"10.0" range:"(0,)"`
+	GpsAccelerationNoise_mpss        float64 `default:"2.0" range:"(0,)"`
+	GpsStaleDuration_s               float64 `default:"3.0" range:"(0,)"`
+	GpsMaxUncertainty_m              float64 `default:"50.0" range:"(0,)"`
+	ControlFrequency_hz              float64 `default:"10.0" range:"(0,1000]"`
+	GpsFrequency_hz                  float64 `default:"5.0" range:"(0,1000]"`
+	DashboardFrequency_hz            float64 `default:"2.0" range:"(0,1000]"`
+	LandNoMoveDuration_s             float64 `default:"5.0" range:"[0,)"`
+	LaunchGlideDuration_s            float64 `default:"5.0" range:"[0,)"`
+	ProportionalRollMultiplier       float64 `default:"3.0" range:"(0,)"`
+	ProportionalPitchMultiplier      float64 `default:"2.0" range:"(0,)"`
+	ProportionalTargetRollMultiplier float64 `default:"1.0" range:"(0,)"`
+	IntegralRollMultiplier           float64 `default:"0.0" range:"[0,)"`
+	IntegralPitchMultiplier          float64 `default:"0.5" range:"[0,)"`
+	IntegralTargetRollMultiplier     float64 `default:"0.0" range:"[0,)"`
+	DerivativeRollMultiplier         float64 `default:"0.1" range:"[0,)"`
+	DerivativePitchMultiplier        float64 `default:"0.1" range:"[0,)"`
+	DerivativeTargetRollMultiplier   float64 `default:"0.0" range:"[0,)"`
+	MaxRollIntegral_d                float64 `default:"10.0" range:"[0,90]"`
+	MaxPitchIntegral_d               float64 `default:"10.0" range:"[0,90]"`
+	MaxTargetRollIntegral_d          float64 `default:"10.0" range:"[0,90]"`
+	PidDerivativeCutoff_hz           float64 `default:"2.0" range:"(0,)"`
+	MaxTargetRoll_d                  float64 `default:"25.0" range:"(0,90)"`
+	LandingPointAltitude_m           float64 `required:"true" range:"[-500,9000]"`
+	LandingPointAltitudeOffset_m     float64 `default:"1000.0" range:"[0,)"`
+	LandingSpiralAltitude_m          float64 `default:"150.0" range:"[0,)"`
+	LandingSpiralRadius_m            float64 `default:"100.0" range:"(0,)"`
+	LandingFinalAltitude_m           float64 `default:"40.0" range:"[0,)"`
+	LandingFlareAltitude_m           float64 `default:"3.0" range:"[0,)"`
+	LandingFlarePitch_d              float64 `default:"5.0" range:"[-45,45]"`
+	GeofenceFile                     string  `default:""`
+	GeofenceCeiling_m                float64 `default:"0.0" range:"[0,)"`
+	GeofencePredictionTime_s         float64 `default:"10.0" range:"[0,)"`
+	// One of "steer", "loiter", or "terminate"
+	GeofenceAction             string  `default:"loiter"`
+	GeofenceLoiterRadius_m     float64 `default:"100.0" range:"(0,)"`
+	TargetPitch_d              float64 `default:"-6.0" range:"[-45,45]"`
+	MaxServoPitchAdjustment_d  float64 `default:"25.0" range:"[0,45]"`
+	MaxServoAngleOffset_d      float64 `default:"45.0" range:"(0,45]"`
+	LeftServoCenter_us         int64   `default:"1500" range:"[500,2500]"`
+	RightServoCenter_us        int64   `default:"1500" range:"[500,2500]"`
+	FailsafeLeftServoOffset_d  float64 `default:"0.0" range:"[-45,45]"`
+	FailsafeRightServoOffset_d float64 `default:"0.0" range:"[-45,45]"`
+	Hardware                   string  `default:"auto"`
+	ButtonPin                  int64   `default:"24" range:"[0,27]" units:"BCM"`
+	LeftServoPin               int64   `default:"12" range:"[0,27]" units:"BCM"`
+	RightServoPin              int64   `default:"13" range:"[0,27]" units:"BCM"`
+	AscentSpeed_mps            float64 `default:"1.0" range:"(0,)"`
+	AscentAltitudeGain_m       float64 `default:"50.0" range:"(0,)"`
+	ReleaseAltitude_m          float64 `default:"20000.0" range:"[0,)"`
+	ReleaseDistance_m          float64 `default:"0.0" range:"[0,)"`
+	// One of "none", "servo", or "gpio"
+	CutdownType                string  `default:"none"`
+	CutdownPin                 int64   `default:"21" range:"[0,27]" units:"BCM"`
+	CutdownRelease_us          int64   `default:"2000" range:"[500,2500]"`
+	CutdownDuration_s          float64 `default:"5.0" range:"(0,)"`
+	FreeFallAcceleration_g     float64 `default:"0.3" range:"[0,1]"`
+	FreeFallDuration_s         float64 `default:"0.2" range:"[0,)"`
+	ReleaseStabilizeDuration_s float64 `default:"5.0" range:"[0,)"`
+	// One of "none", "udp", or "serial"
+	MavlinkTransport    string  `default:"none"`
+	MavlinkUdpAddress   string  `default:"127.0.0.1:14550"`
+	MavlinkUdpPort      int64   `default:"14551" range:"[1,65535]"`
+	MavlinkTty          string  `default:"/dev/ttyUSB0"`
+	MavlinkBitRate      int64   `default:"57600" range:"(0,)" units:"baud"`
+	MavlinkSystemId     int64   `default:"1" range:"[1,255]"`
+	MavlinkFrequency_hz float64 `default:"5.0" range:"(0,1000]"`
+	// Empty to disable
+	TuningAddress string `default:"127.0.0.1:4382"`
+	// Empty to disable
+	GroundStationAddress      string  `default:":8080"`
+	GroundStationFrequency_hz float64 `default:"5.0" range:"(0,1000]"`
+	// Empty to disable
+	AprsTty               string  `default:""`
+	AprsBitRate           int64   `default:"9600" range:"(0,)" units:"baud"`
+	AprsCallsign          string  `default:"N0CALL-11"`
+	AprsPath              string  `default:"WIDE2-1"`
+	AprsSymbol            string  `default:"/O"`
+	AprsBeaconInterval_s  float64 `default:"60.0" range:"(0,)"`
+	AprsFastRate_s        float64 `default:"15.0" range:"(0,)"`
+	AprsFastSpeed_mps     float64 `default:"15.0" range:"[0,)"`
+	AprsSlowRate_s        float64 `default:"60.0" range:"(0,)"`
+	AprsSlowSpeed_mps     float64 `default:"2.0" range:"[0,)"`
+	AprsMinTurnAngle_d    float64 `default:"25.0" range:"[0,180]"`
+	AprsTurnSlope         float64 `default:"100.0" range:"[0,)" units:"° m/s"`
+	AprsMinTurnTime_s     float64 `default:"10.0" range:"[0,)"`
+	ErrorSleepDuration_s  float64 `default:"0.01" range:"(0,60]"`
+	SimulatorFrequency_hz float64 `default:"100.0" range:"(0,10000]"`
+	// How many times faster than real time to run the simulator, or 0 to run
+	// as fast as possible
+	SimulatorSpeedup                float64 `default:"0.0" range:"[0,)"`
+	SimulatorTimeLimit_s            float64 `default:"1800.0" range:"(0,)"`
+	SimulatorLaunchLatitude         float64 `default:"40.054" range:"[-90,90]" units:"°"`
+	SimulatorLaunchLongitude        float64 `default:"-105.295" range:"[-180,180]" units:"°"`
+	SimulatorLaunchAltitude_m       float64 `default:"1800.0" range:"[-500,50000]"`
+	SimulatorLaunchHeading_d        float64 `default:"90.0" range:"[0,360]"`
+	SimulatorGroundAltitude_m       float64 `default:"1600.0" range:"[-500,9000]"`
+	SimulatorGlideRatio             float64 `default:"10.0" range:"(0,)"`
+	SimulatorMass_kg                float64 `default:"1.0" range:"(0,)"`
+	SimulatorWingArea_sqm           float64 `default:"0.3" range:"(0,)"`
+	SimulatorRollResponse           float64 `default:"3.0" range:"(0,)"`
+	SimulatorPitchResponse          float64 `default:"0.2" range:"(0,)"`
+	SimulatorWindSpeed_mps          float64 `default:"3.0" range:"[0,)"`
+	SimulatorWindDirection_d        float64 `default:"270.0" range:"[0,360]"`
+	SimulatorBalloonAscentSpeed_mps float64 `default:"0.0" range:"[0,)"`
+	FlyDirection_d                  float64 `default:"355.0" range:"[0,360]"`
 }
 
+// Loads the configuration, filling in the defaults for missing keys. If
+// anything is wrong, returns ConfigurationErrors with every problem and leaves
+// the current configuration alone.
 func LoadConfiguration(configurationReader io.Reader) error {
+	layer, err := ReadConfigurationLayer("configuration", configurationReader)
+	if err != nil {
+		return err
+	}
+	return LoadConfigurationLayers(layer)
+}
+
+// Loads merged configuration text that already has the defaults in it
+func loadConfiguration(text string) error {
 	var tomlConfiguration tomlConfiguration_t
-	_, err := toml.DecodeReader(configurationReader, &tomlConfiguration)
+	metadata, err := toml.Decode(text, &tomlConfiguration)
 	if err != nil {
-		Logger.Errorf("Error loading configuration: %v", err)
+		return fmt.Errorf("Unable to parse configuration: %v", err)
 	}
+	errs := checkConfigurationSchema(&tomlConfiguration, metadata)
+	var loaded configuration_t
 
 	switch tomlConfiguration.DistanceFormula {
 	case "haversine":
-		configuration.DistanceFormula = DISTANCE_FORMULA_HAVERSINE
+		loaded.DistanceFormula = DISTANCE_FORMULA_HAVERSINE
 	case "sphericalLawOfCosines":
-		configuration.DistanceFormula = DISTANCE_FORMULA_SPHERICAL_LAW_OF_COSINES
+		loaded.DistanceFormula = DISTANCE_FORMULA_SPHERICAL_LAW_OF_COSINES
 	case "equirectangular":
-		configuration.DistanceFormula = DISTANCE_FORMULA_EQUIRECTANGULAR
+		loaded.DistanceFormula = DISTANCE_FORMULA_EQUIRECTANGULAR
 	case "cachedEquirectangular":
-		configuration.DistanceFormula = DISTANCE_FORMULA_CACHED_EQUIRECTANGULAR
+		loaded.DistanceFormula = DISTANCE_FORMULA_CACHED_EQUIRECTANGULAR
 	default:
-		return errors.New("Bad DistanceFormula in configuration file")
+		errs.add(newConfigurationError("DistanceFormula", "can't be %q", tomlConfiguration.DistanceFormula))
 	}
 
 	switch tomlConfiguration.BearingFormula {
 	case "equirectangular":
-		configuration.BearingFormula = BEARING_FORMULA_EQUIRECTANGULAR
+		loaded.BearingFormula = BEARING_FORMULA_EQUIRECTANGULAR
 	case "cachedEquirectangular":
-		configuration.BearingFormula = BEARING_FORMULA_CACHED_EQUIRECTANGULAR
+		loaded.BearingFormula = BEARING_FORMULA_CACHED_EQUIRECTANGULAR
+	default:
+		errs.add(newConfigurationError("BearingFormula", "can't be %q", tomlConfiguration.BearingFormula))
+	}
+
+	switch tomlConfiguration.GuidanceMode {
+	case "direct":
+		loaded.GuidanceMode = GUIDANCE_MODE_DIRECT
+	case "l1":
+		loaded.GuidanceMode = GUIDANCE_MODE_L1
+	default:
+		errs.add(newConfigurationError("GuidanceMode", "can't be %q", tomlConfiguration.GuidanceMode))
+	}
+
+	switch tomlConfiguration.DeclinationSource {
+	case "worldMagneticModel":
+		loaded.DeclinationSource = DECLINATION_SOURCE_WORLD_MAGNETIC_MODEL
+	case "configuration":
+		loaded.DeclinationSource = DECLINATION_SOURCE_CONFIGURATION
+	default:
+		errs.add(newConfigurationError("DeclinationSource", "can't be %q", tomlConfiguration.DeclinationSource))
+	}
+
+	switch tomlConfiguration.AhrsFilter {
+	case "raw":
+		loaded.AhrsFilter = AHRS_FILTER_RAW
+	case "complementary":
+		loaded.AhrsFilter = AHRS_FILTER_COMPLEMENTARY
+	case "madgwick":
+		loaded.AhrsFilter = AHRS_FILTER_MADGWICK
+	case "mahony":
+		loaded.AhrsFilter = AHRS_FILTER_MAHONY
+	default:
+		errs.add(newConfigurationError("AhrsFilter", "can't be %q", tomlConfiguration.AhrsFilter))
+	}
+
+	switch tomlConfiguration.Hardware {
+	case "auto":
+		loaded.Hardware = HARDWARE_AUTO
+	case "pi":
+		loaded.Hardware = HARDWARE_PI
+	case "fake":
+		loaded.Hardware = HARDWARE_FAKE
+	default:
+		errs.add(newConfigurationError("Hardware", "can't be %q", tomlConfiguration.Hardware))
+	}
+
+	switch tomlConfiguration.CutdownType {
+	case "none":
+		loaded.CutdownType = CUTDOWN_NONE
+	case "servo":
+		loaded.CutdownType = CUTDOWN_SERVO
+	case "gpio":
+		loaded.CutdownType = CUTDOWN_GPIO
+	default:
+		errs.add(newConfigurationError("CutdownType", "can't be %q", tomlConfiguration.CutdownType))
+	}
+
+	switch tomlConfiguration.GeofenceAction {
+	case "steer":
+		loaded.GeofenceAction = GEOFENCE_ACTION_STEER
+	case "loiter":
+		loaded.GeofenceAction = GEOFENCE_ACTION_LOITER
+	case "terminate":
+		loaded.GeofenceAction = GEOFENCE_ACTION_TERMINATE
+	default:
+		errs.add(newConfigurationError("GeofenceAction", "can't be %q", tomlConfiguration.GeofenceAction))
+	}
+
+	switch tomlConfiguration.MavlinkTransport {
+	case "none":
+		loaded.MavlinkTransport = MAVLINK_TRANSPORT_NONE
+	case "udp":
+		loaded.MavlinkTransport = MAVLINK_TRANSPORT_UDP
+	case "serial":
+		loaded.MavlinkTransport = MAVLINK_TRANSPORT_SERIAL
 	default:
-		return errors.New("Bad BearingFormula in configuration file")
-	}
-
-	configuration.WaypointReachedDistance = float64(tomlConfiguration.WaypointReachedDistance_m)
-	configuration.WaypointInRangeDistance = float64(tomlConfiguration.WaypointInRangeDistance_m)
-	configuration.DefaultWaypointLatitude = tomlConfiguration.DefaultWaypointLatitude
-	configuration.DefaultWaypointLongitude = tomlConfiguration.DefaultWaypointLongitude
-
-	configuration.PitchOffset = ToRadians(Degrees(tomlConfiguration.PitchOffset_d))
-	configuration.RollOffset = ToRadians(Degrees(tomlConfiguration.RollOffset_d))
-	configuration.MagnetometerXOffset_t = float64(tomlConfiguration.MagnetometerXMax_t + tomlConfiguration.MagnetometerXMin_t*0.5)
-	configuration.MagnetometerYOffset_t = float64(tomlConfiguration.MagnetometerYMax_t + tomlConfiguration.MagnetometerYMin_t*0.5)
-	configuration.Declination = float64(tomlConfiguration.Declination_d)
-	configuration.GpsTty = tomlConfiguration.GpsTty
-	configuration.GpsBitRate = int(tomlConfiguration.GpsBitRate)
-
-	configuration.IterationSleepTime = time.Duration(tomlConfiguration.IterationSleepTime_s * float64(time.Second))
-
-	configuration.ButtonPin = uint8(tomlConfiguration.ButtonPin)
-	configuration.LeftServoPin = uint8(tomlConfiguration.LeftServoPin)
-	configuration.RightServoPin = uint8(tomlConfiguration.RightServoPin)
-
-	configuration.LandNoMoveDuration = time.Duration(tomlConfiguration.LandNoMoveDuration_s * float64(time.Second))
-	configuration.LaunchGlideDuration = time.Duration(tomlConfiguration.LaunchGlideDuration_s * float64(time.Second))
-	configuration.ProportionalRollMultiplier = float64(tomlConfiguration.ProportionalRollMultiplier)
-	configuration.ProportionalPitchMultiplier = float64(tomlConfiguration.ProportionalPitchMultiplier)
-	configuration.ProportionalTargetRollMultiplier = float64(tomlConfiguration.ProportionalTargetRollMultiplier)
-	configuration.MaxTargetRoll = ToRadians(Degrees(tomlConfiguration.MaxTargetRoll_d))
-	configuration.LandingPointAltitude = Meters(tomlConfiguration.LandingPointAltitude_m)
-	configuration.LandingPointAltitudeOffset = Meters(tomlConfiguration.LandingPointAltitudeOffset_m)
-	configuration.TargetPitch = ToRadians(Degrees(tomlConfiguration.TargetPitch_d))
-	configuration.MaxServoPitchAdjustment = ToRadians(Degrees(tomlConfiguration.MaxServoPitchAdjustment_d))
-	configuration.MaxServoAngleOffset = ToRadians(Degrees(tomlConfiguration.MaxServoAngleOffset_d))
-	configuration.LeftServoCenter_us = uint16(tomlConfiguration.LeftServoCenter_us)
-	configuration.RightServoCenter_us = uint16(tomlConfiguration.RightServoCenter_us)
-
-	configuration.ErrorSleepDuration = time.Duration(tomlConfiguration.ErrorSleepDuration_s * float64(time.Second))
-	configuration.FlyDirection = ToRadians(Degrees(tomlConfiguration.FlyDirection_d))
+		errs.add(newConfigurationError("MavlinkTransport", "can't be %q", tomlConfiguration.MavlinkTransport))
+	}
+	loaded.AprsCallsign, err = aprs.ParseAddress(tomlConfiguration.AprsCallsign)
+	if err != nil {
+		errs.add(newConfigurationError("AprsCallsign", "is bad: %v", err))
+	}
+	loaded.AprsPath, err = aprs.ParsePath(tomlConfiguration.AprsPath)
+	if err != nil {
+		errs.add(newConfigurationError("AprsPath", "is bad: %v", err))
+	}
+	if len(tomlConfiguration.AprsSymbol) != 2 {
+		errs.add(newConfigurationError("AprsSymbol", "must be 2 characters"))
+	}
+	if tomlConfiguration.AprsFastSpeed_mps <= tomlConfiguration.AprsSlowSpeed_mps {
+		errs.add(newConfigurationError("AprsFastSpeed_mps", "must be more than AprsSlowSpeed_mps"))
+	}
+	if math.Abs(tomlConfiguration.FailsafeLeftServoOffset_d) > tomlConfiguration.MaxServoAngleOffset_d {
+		errs.add(newConfigurationError("FailsafeLeftServoOffset_d", "must be within MaxServoAngleOffset_d"))
+	}
+	if math.Abs(tomlConfiguration.FailsafeRightServoOffset_d) > tomlConfiguration.MaxServoAngleOffset_d {
+		errs.add(newConfigurationError("FailsafeRightServoOffset_d", "must be within MaxServoAngleOffset_d"))
+	}
+
+	loaded.WaypointReachedDistance = float64(tomlConfiguration.WaypointReachedDistance_m)
+	loaded.WaypointInRangeDistance = float64(tomlConfiguration.WaypointInRangeDistance_m)
+	loaded.DefaultWaypointLatitude = tomlConfiguration.DefaultWaypointLatitude
+	loaded.DefaultWaypointLongitude = tomlConfiguration.DefaultWaypointLongitude
+	loaded.MissionFile = tomlConfiguration.MissionFile
+	loaded.GuidancePeriod = time.Duration(tomlConfiguration.GuidancePeriod_s * float64(time.Second))
+	loaded.GuidanceDamping = tomlConfiguration.GuidanceDamping
+
+	errs.add(parseTomlVector(tomlConfiguration.AccelerometerBias, "AccelerometerBias", &loaded.AccelerometerBias))
+	errs.add(parseTomlVector(tomlConfiguration.AccelerometerScale, "AccelerometerScale", &loaded.AccelerometerScale))
+	errs.add(parseTomlMatrix(tomlConfiguration.BoardMounting, "BoardMounting", &loaded.BoardMounting))
+	errs.add(parseTomlVector(tomlConfiguration.MagnetometerHardIron, "MagnetometerHardIron", &loaded.MagnetometerHardIron))
+	errs.add(parseTomlMatrix(tomlConfiguration.MagnetometerSoftIron, "MagnetometerSoftIron", &loaded.MagnetometerSoftIron))
+	loaded.Declination = ToRadians(Degrees(tomlConfiguration.Declination_d))
+	loaded.AhrsPeriod = time.Duration(float64(time.Second) / tomlConfiguration.AhrsFrequency_hz)
+	loaded.MadgwickBeta = tomlConfiguration.MadgwickBeta
+	loaded.MahonyProportionalGain = tomlConfiguration.MahonyProportionalGain
+	loaded.MahonyIntegralGain = tomlConfiguration.MahonyIntegralGain
+	loaded.ComplementaryGyroWeight = tomlConfiguration.ComplementaryGyroWeight
+	loaded.GpsTty = tomlConfiguration.GpsTty
+	loaded.GpsBitRate = int(tomlConfiguration.GpsBitRate)
+	loaded.GpsPositionNoise = Meters(tomlConfiguration.GpsPositionNoise_m)
+	loaded.GpsVelocityNoise = MetersPerSecond(tomlConfiguration.GpsVelocityNoise_mps)
+	loaded.GpsAltitudeNoise = Meters(tomlConfiguration.GpsAltitudeNoise_m)
+	loaded.GpsAccelerationNoise = tomlConfiguration.GpsAccelerationNoise_mpss
+	loaded.GpsStaleDuration = time.Duration(tomlConfiguration.GpsStaleDuration_s * float64(time.Second))
+	loaded.GpsMaxUncertainty = Meters(tomlConfiguration.GpsMaxUncertainty_m)
+
+	loaded.ControlPeriod = time.Duration(float64(time.Second) / tomlConfiguration.ControlFrequency_hz)
+	loaded.GpsPeriod = time.Duration(float64(time.Second) / tomlConfiguration.GpsFrequency_hz)
+	loaded.DashboardPeriod = time.Duration(float64(time.Second) / tomlConfiguration.DashboardFrequency_hz)
+
+	loaded.ButtonPin = uint8(tomlConfiguration.ButtonPin)
+	loaded.LeftServoPin = uint8(tomlConfiguration.LeftServoPin)
+	loaded.RightServoPin = uint8(tomlConfiguration.RightServoPin)
+
+	loaded.AscentSpeed = MetersPerSecond(tomlConfiguration.AscentSpeed_mps)
+	loaded.AscentAltitudeGain = Meters(tomlConfiguration.AscentAltitudeGain_m)
+	loaded.ReleaseAltitude = Meters(tomlConfiguration.ReleaseAltitude_m)
+	loaded.ReleaseDistance = Meters(tomlConfiguration.ReleaseDistance_m)
+	loaded.CutdownPin = uint8(tomlConfiguration.CutdownPin)
+	loaded.CutdownRelease_us = uint16(tomlConfiguration.CutdownRelease_us)
+	loaded.CutdownDuration = time.Duration(tomlConfiguration.CutdownDuration_s * float64(time.Second))
+	loaded.FreeFallAcceleration_g = tomlConfiguration.FreeFallAcceleration_g
+	loaded.FreeFallDuration = time.Duration(tomlConfiguration.FreeFallDuration_s * float64(time.Second))
+	loaded.ReleaseStabilizeDuration = time.Duration(tomlConfiguration.ReleaseStabilizeDuration_s * float64(time.Second))
+
+	loaded.MavlinkUdpAddress = tomlConfiguration.MavlinkUdpAddress
+	loaded.MavlinkUdpPort = uint16(tomlConfiguration.MavlinkUdpPort)
+	loaded.MavlinkTty = tomlConfiguration.MavlinkTty
+	loaded.MavlinkBitRate = int(tomlConfiguration.MavlinkBitRate)
+	loaded.MavlinkSystemId = uint8(tomlConfiguration.MavlinkSystemId)
+	loaded.MavlinkPeriod = time.Duration(float64(time.Second) / tomlConfiguration.MavlinkFrequency_hz)
+	loaded.TuningAddress = tomlConfiguration.TuningAddress
+	loaded.GroundStationAddress = tomlConfiguration.GroundStationAddress
+	loaded.GroundStationPeriod = time.Duration(float64(time.Second) / tomlConfiguration.GroundStationFrequency_hz)
+
+	loaded.AprsTty = tomlConfiguration.AprsTty
+	loaded.AprsBitRate = int(tomlConfiguration.AprsBitRate)
+	loaded.AprsSymbol = tomlConfiguration.AprsSymbol
+	loaded.AprsBeaconInterval = time.Duration(tomlConfiguration.AprsBeaconInterval_s * float64(time.Second))
+	loaded.AprsFastRate = time.Duration(tomlConfiguration.AprsFastRate_s * float64(time.Second))
+	loaded.AprsFastSpeed = MetersPerSecond(tomlConfiguration.AprsFastSpeed_mps)
+	loaded.AprsSlowRate = time.Duration(tomlConfiguration.AprsSlowRate_s * float64(time.Second))
+	loaded.AprsSlowSpeed = MetersPerSecond(tomlConfiguration.AprsSlowSpeed_mps)
+	loaded.AprsMinTurnAngle = ToRadians(Degrees(tomlConfiguration.AprsMinTurnAngle_d))
+	loaded.AprsTurnSlope = ToRadians(tomlConfiguration.AprsTurnSlope)
+	loaded.AprsMinTurnTime = time.Duration(tomlConfiguration.AprsMinTurnTime_s * float64(time.Second))
+
+	loaded.LandNoMoveDuration = time.Duration(tomlConfiguration.LandNoMoveDuration_s * float64(time.Second))
+	loaded.LaunchGlideDuration = time.Duration(tomlConfiguration.LaunchGlideDuration_s * float64(time.Second))
+	loaded.ProportionalRollMultiplier = float64(tomlConfiguration.ProportionalRollMultiplier)
+	loaded.ProportionalPitchMultiplier = float64(tomlConfiguration.ProportionalPitchMultiplier)
+	loaded.ProportionalTargetRollMultiplier = float64(tomlConfiguration.ProportionalTargetRollMultiplier)
+	loaded.IntegralRollMultiplier = tomlConfiguration.IntegralRollMultiplier
+	loaded.IntegralPitchMultiplier = tomlConfiguration.IntegralPitchMultiplier
+	loaded.IntegralTargetRollMultiplier = tomlConfiguration.IntegralTargetRollMultiplier
+	loaded.DerivativeRollMultiplier = tomlConfiguration.DerivativeRollMultiplier
+	loaded.DerivativePitchMultiplier = tomlConfiguration.DerivativePitchMultiplier
+	loaded.DerivativeTargetRollMultiplier = tomlConfiguration.DerivativeTargetRollMultiplier
+	loaded.MaxRollIntegral = ToRadians(Degrees(tomlConfiguration.MaxRollIntegral_d))
+	loaded.MaxPitchIntegral = ToRadians(Degrees(tomlConfiguration.MaxPitchIntegral_d))
+	loaded.MaxTargetRollIntegral = ToRadians(Degrees(tomlConfiguration.MaxTargetRollIntegral_d))
+	loaded.PidDerivativeCutoff_hz = tomlConfiguration.PidDerivativeCutoff_hz
+	loaded.MaxTargetRoll = ToRadians(Degrees(tomlConfiguration.MaxTargetRoll_d))
+	loaded.LandingPointAltitude = Meters(tomlConfiguration.LandingPointAltitude_m)
+	loaded.LandingPointAltitudeOffset = Meters(tomlConfiguration.LandingPointAltitudeOffset_m)
+	loaded.LandingSpiralAltitude = Meters(tomlConfiguration.LandingSpiralAltitude_m)
+	loaded.LandingSpiralRadius = Meters(tomlConfiguration.LandingSpiralRadius_m)
+	loaded.LandingFinalAltitude = Meters(tomlConfiguration.LandingFinalAltitude_m)
+	loaded.LandingFlareAltitude = Meters(tomlConfiguration.LandingFlareAltitude_m)
+	loaded.LandingFlarePitch = ToRadians(Degrees(tomlConfiguration.LandingFlarePitch_d))
+	loaded.GeofenceFile = tomlConfiguration.GeofenceFile
+	loaded.GeofenceCeiling = Meters(tomlConfiguration.GeofenceCeiling_m)
+	loaded.GeofencePredictionTime = time.Duration(tomlConfiguration.GeofencePredictionTime_s * float64(time.Second))
+	loaded.GeofenceLoiterRadius = Meters(tomlConfiguration.GeofenceLoiterRadius_m)
+	loaded.TargetPitch = ToRadians(Degrees(tomlConfiguration.TargetPitch_d))
+	loaded.MaxServoPitchAdjustment = ToRadians(Degrees(tomlConfiguration.MaxServoPitchAdjustment_d))
+	loaded.MaxServoAngleOffset = ToRadians(Degrees(tomlConfiguration.MaxServoAngleOffset_d))
+	loaded.LeftServoCenter_us = uint16(tomlConfiguration.LeftServoCenter_us)
+	loaded.RightServoCenter_us = uint16(tomlConfiguration.RightServoCenter_us)
+	loaded.FailsafeLeftServoOffset = ToRadians(Degrees(tomlConfiguration.FailsafeLeftServoOffset_d))
+	loaded.FailsafeRightServoOffset = ToRadians(Degrees(tomlConfiguration.FailsafeRightServoOffset_d))
+
+	loaded.ErrorSleepDuration = time.Duration(tomlConfiguration.ErrorSleepDuration_s * float64(time.Second))
+
+	loaded.SimulatorPeriod = time.Duration(float64(time.Second) / tomlConfiguration.SimulatorFrequency_hz)
+	loaded.SimulatorSpeedup = tomlConfiguration.SimulatorSpeedup
+	loaded.SimulatorTimeLimit = time.Duration(tomlConfiguration.SimulatorTimeLimit_s * float64(time.Second))
+	loaded.SimulatorLaunchLatitude = tomlConfiguration.SimulatorLaunchLatitude
+	loaded.SimulatorLaunchLongitude = tomlConfiguration.SimulatorLaunchLongitude
+	loaded.SimulatorLaunchAltitude = Meters(tomlConfiguration.SimulatorLaunchAltitude_m)
+	loaded.SimulatorLaunchHeading = ToRadians(Degrees(tomlConfiguration.SimulatorLaunchHeading_d))
+	loaded.SimulatorGroundAltitude = Meters(tomlConfiguration.SimulatorGroundAltitude_m)
+	loaded.SimulatorGlideRatio = tomlConfiguration.SimulatorGlideRatio
+	loaded.SimulatorMass_kg = tomlConfiguration.SimulatorMass_kg
+	loaded.SimulatorWingArea_sqm = tomlConfiguration.SimulatorWingArea_sqm
+	loaded.SimulatorRollResponse = tomlConfiguration.SimulatorRollResponse
+	loaded.SimulatorPitchResponse = tomlConfiguration.SimulatorPitchResponse
+	loaded.SimulatorWindSpeed = MetersPerSecond(tomlConfiguration.SimulatorWindSpeed_mps)
+	loaded.SimulatorWindDirection = ToRadians(Degrees(tomlConfiguration.SimulatorWindDirection_d))
+	loaded.SimulatorBalloonAscentSpeed = MetersPerSecond(tomlConfiguration.SimulatorBalloonAscentSpeed_mps)
+	loaded.FlyDirection = ToRadians(Degrees(tomlConfiguration.FlyDirection_d))
+
+	if len(errs) > 0 {
+		return errs
+	}
+	configuration = loaded
+	return nil
+}
+
+// Replaces values in a TOML configuration file, e.g. after a calibration.
+// Values should already be formatted as TOML. Comments and other values are
+// left alone, and keys that aren't already in the file are appended.
+func WriteConfigurationValues(path string, values map[string]string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(string(data), "\n")
+	keyRegex := regexp.MustCompile(`^\s*([A-Za-z_]+)\s*=`)
+	written := make(map[string]bool)
+	for i, line := range lines {
+		match := keyRegex.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		value, ok := values[match[1]]
+		if !ok {
+			continue
+		}
+		lines[i] = fmt.Sprintf("%s = %s", match[1], value)
+		written[match[1]] = true
+	}
+	// Trailing newline
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	for key, value := range values {
+		if !written[key] {
+			lines = append(lines, fmt.Sprintf("%s = %s", key, value))
+		}
+	}
+
+	// Write to a temporary file and then move it, so that we don't leave a
+	// half written configuration if we crash
+	temporary, err := ioutil.TempFile(filepath.Dir(path), ".conf-*.toml")
+	if err != nil {
+		return err
+	}
+	_, err = temporary.WriteString(strings.Join(lines, "\n") + "\n")
+	if err != nil {
+		temporary.Close()
+		os.Remove(temporary.Name())
+		return err
+	}
+	err = temporary.Close()
+	if err != nil {
+		os.Remove(temporary.Name())
+		return err
+	}
+	return os.Rename(temporary.Name(), path)
+}
 
+func parseTomlVector(values []float64, name string, v *vector3) error {
+	if len(values) != 3 {
+		return newConfigurationError(name, "must have 3 values")
+	}
+	copy(v[:], values)
+	return nil
+}
+
+func parseTomlMatrix(values [][]float64, name string, m *[3][3]float64) error {
+	if len(values) != 3 {
+		return newConfigurationError(name, "must be 3x3")
+	}
+	for i, row := range values {
+		if len(row) != 3 {
+			return newConfigurationError(name, "must be 3x3")
+		}
+		copy(m[i][:], row)
+	}
 	return nil
 }
+
+// TOML won't decode an integer into a float, so always include a decimal point
+func formatTomlFloat(value float64) string {
+	formatted := strconv.FormatFloat(value, 'f', -1, 64)
+	if !strings.Contains(formatted, ".") {
+		formatted += ".0"
+	}
+	return formatted
+}
+
+func formatTomlVector(v vector3) string {
+	return fmt.Sprintf("[%s, %s, %s]", formatTomlFloat(v[0]), formatTomlFloat(v[1]), formatTomlFloat(v[2]))
+}
+
+func formatTomlMatrix(m [3][3]float64) string {
+	return fmt.Sprintf("[%s, %s, %s]", formatTomlVector(m[0]), formatTomlVector(m[1]), formatTomlVector(m[2]))
+}
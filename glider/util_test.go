@@ -77,9 +77,7 @@ func TestConfigurationValues(t *testing.T) {
 
 	configuration := configuration_t{}
 	configurationType := reflect.TypeOf(configuration)
-	// We average the hard offsets for the magnetometer, so there are
-	// two fewer values
-	if configurationType.NumField() != valueCount-2 {
+	if configurationType.NumField() != valueCount {
 		t.Errorf("TOML file has %v values but configuration_t has %v", valueCount, configurationType.NumField())
 	}
 }
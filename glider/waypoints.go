@@ -1,5 +1,9 @@
 package glider
 
+import (
+	"math"
+)
+
 // Continues through several waypoints, then repeats the last few
 type Waypoints struct {
 	first            []Point
@@ -7,26 +11,43 @@ type Waypoints struct {
 	index            int
 	inRange          bool
 	previousDistance Meters
+	// The start of the current leg
+	previous      Point
+	previousValid bool
 }
 
-func NewWaypoints() *Waypoints {
-	// Wonderland Lake landing site
-	return &Waypoints{
-		first: []Point{},
-		repeating: []Point{
-			Point{
-				Latitude:  40.055966,
-				Longitude: -105.290124,
-			},
-			Point{
-				Latitude:  40.055994,
-				Longitude: -105.288681,
+// Loads the waypoints from the configured mission file. If there isn't one,
+// just circles the default waypoint.
+func NewWaypoints() (*Waypoints, error) {
+	if configuration.MissionFile == "" {
+		Logger.Warning("No MissionFile configured, using the default waypoint")
+		return newWaypointsFromMission(Mission{
+			Repeating: []Point{
+				Point{
+					Latitude:  configuration.DefaultWaypointLatitude,
+					Longitude: configuration.DefaultWaypointLongitude,
+				},
 			},
-			Point{
-				Latitude:  40.054785,
-				Longitude: -105.289467,
-			},
-		},
+		}), nil
+	}
+
+	mission, err := LoadMission(configuration.MissionFile)
+	if err != nil {
+		return nil, err
+	}
+	Logger.Infof(
+		"Loaded %d first and %d repeating waypoints from %s",
+		len(mission.First),
+		len(mission.Repeating),
+		configuration.MissionFile,
+	)
+	return newWaypointsFromMission(mission), nil
+}
+
+func newWaypointsFromMission(mission Mission) *Waypoints {
+	return &Waypoints{
+		first:            mission.First,
+		repeating:        mission.Repeating,
 		index:            0,
 		inRange:          false,
 		previousDistance: 1000000,
@@ -47,7 +68,14 @@ func (waypoints *Waypoints) GetWaypoint() Point {
 	}
 }
 
+// Returns where we land, the first of the repeating waypoints
+func (waypoints *Waypoints) GetLandingPoint() Point {
+	return waypoints.repeating[0]
+}
+
 func (waypoints *Waypoints) Next() {
+	waypoints.previous = waypoints.GetWaypoint()
+	waypoints.previousValid = true
 	waypoints.index++
 	if waypoints.index >= len(waypoints.first)+len(waypoints.repeating) {
 		waypoints.index = len(waypoints.first)
@@ -74,3 +102,30 @@ func (waypoints *Waypoints) Reached(current Point) bool {
 	}
 	return false
 }
+
+// Returns the start and end of the current leg. The first leg starts wherever
+// we are when this is first called.
+func (waypoints *Waypoints) GetLeg(current Point) (Point, Point) {
+	if !waypoints.previousValid {
+		waypoints.previous = current
+		waypoints.previousValid = true
+	}
+	return waypoints.previous, waypoints.GetWaypoint()
+}
+
+// Returns true if we've reached the waypoint, or passed the line through it
+// that's perpendicular to the leg. This works even if the wind has blown us
+// off the leg.
+func (waypoints *Waypoints) LegCompleted(current Point) bool {
+	start, end := waypoints.GetLeg(current)
+	if Distance(current, end) < configuration.WaypointReachedDistance {
+		return true
+	}
+	trackNorth, trackEast := toNorthEast(start, end)
+	length := math.Sqrt(trackNorth*trackNorth + trackEast*trackEast)
+	if length < configuration.WaypointReachedDistance {
+		return false
+	}
+	_, alongTrack := getTrackErrors(start, end, current)
+	return alongTrack >= length
+}
@@ -0,0 +1,131 @@
+// Evaluates the World Magnetic Model, so that we can correct the compass to
+// true north wherever we end up
+package glider
+
+import (
+	"math"
+	"time"
+)
+
+type declinationSource_t uint8
+
+const (
+	// Compute the declination from the GPS position
+	DECLINATION_SOURCE_WORLD_MAGNETIC_MODEL declinationSource_t = iota
+	// Always use Declination_d, e.g. for bench testing
+	DECLINATION_SOURCE_CONFIGURATION
+)
+
+// How often to recompute the declination as we move
+const declinationUpdateInterval = time.Minute
+
+type MagneticField struct {
+	// Angle from true north to magnetic north, positive east
+	Declination Radians
+	// Angle below horizontal, positive down
+	Inclination Radians
+	// Total field strength, in nT
+	Intensity float64
+}
+
+// WGS 84 ellipsoid, in km
+const wgs84SemiMajorAxis_km = 6378.137
+const wgs84Flattening = 1 / 298.257223563
+
+// The geomagnetic reference radius, in km
+const wmmReferenceRadius_km = 6371.2
+
+// Returns the decimal year, e.g. 2020.5 for the middle of 2020
+func decimalYear(when time.Time) float64 {
+	when = when.UTC()
+	start := time.Date(when.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(when.Year()+1, 1, 1, 0, 0, 0, 0, time.UTC)
+	return float64(when.Year()) + when.Sub(start).Seconds()/end.Sub(start).Seconds()
+}
+
+// Returns whether the coefficients are still good for a date. NOAA releases a
+// new model every five years.
+func wmmValid(when time.Time) bool {
+	year := decimalYear(when)
+	return year >= wmmEpoch && year < wmmEpoch+wmmValidYears
+}
+
+// Computes the magnetic field at a point. The altitude is height above the
+// ellipsoid, but the difference from MSL doesn't matter at this accuracy.
+// Dates outside of the model's validity just extrapolate the secular
+// variation, so check wmmValid first.
+func WorldMagneticModel(point Point, when time.Time) MagneticField {
+	latitude_r := ToCoordinateRadians(point.Latitude)
+	longitude_r := ToCoordinateRadians(point.Longitude)
+	altitude_km := point.Altitude / 1000.0
+	years := decimalYear(when) - wmmEpoch
+
+	// Convert from geodetic to geocentric spherical coordinates
+	eccentricity2 := wgs84Flattening * (2 - wgs84Flattening)
+	sinLatitude := math.Sin(latitude_r)
+	primeVerticalRadius := wgs84SemiMajorAxis_km / math.Sqrt(1-eccentricity2*sinLatitude*sinLatitude)
+	p := (primeVerticalRadius + altitude_km) * math.Cos(latitude_r)
+	z := (primeVerticalRadius*(1-eccentricity2) + altitude_km) * sinLatitude
+	radius := math.Sqrt(p*p + z*z)
+	geocentricLatitude_r := math.Asin(z / radius)
+
+	// Schmidt semi-normalized associated Legendre functions of the cosine of
+	// the colatitude, and their derivatives with respect to the colatitude
+	cosTheta := math.Sin(geocentricLatitude_r)
+	sinTheta := math.Cos(geocentricLatitude_r)
+	// Avoid dividing by zero at the poles
+	if sinTheta < 1e-10 {
+		sinTheta = 1e-10
+	}
+	var legendre, derivative [wmmMaxDegree + 1][wmmMaxDegree + 1]float64
+	legendre[0][0] = 1
+	for n := 1; n <= wmmMaxDegree; n++ {
+		for m := 0; m <= n; m++ {
+			if n == m {
+				k := 1.0
+				if n > 1 {
+					k = math.Sqrt(float64(2*n-1) / float64(2*n))
+				}
+				legendre[n][n] = k * sinTheta * legendre[n-1][n-1]
+				derivative[n][n] = k * (cosTheta*legendre[n-1][n-1] + sinTheta*derivative[n-1][n-1])
+			} else {
+				previous := 0.0
+				previousDerivative := 0.0
+				if n >= 2 {
+					previous = legendre[n-2][m]
+					previousDerivative = derivative[n-2][m]
+				}
+				k := math.Sqrt(float64((n-1)*(n-1) - m*m))
+				divisor := math.Sqrt(float64(n*n - m*m))
+				legendre[n][m] = (float64(2*n-1)*cosTheta*legendre[n-1][m] - k*previous) / divisor
+				derivative[n][m] = (float64(2*n-1)*(cosTheta*derivative[n-1][m]-sinTheta*legendre[n-1][m]) - k*previousDerivative) / divisor
+			}
+		}
+	}
+
+	// Field in geocentric north, east, and down
+	var north, east, down float64
+	for _, coefficient := range wmmCoefficients {
+		n := coefficient.n
+		m := coefficient.m
+		g := coefficient.g + years*coefficient.gDot
+		h := coefficient.h + years*coefficient.hDot
+		ratio := math.Pow(wmmReferenceRadius_km/radius, float64(n+2))
+		cosM := math.Cos(float64(m) * longitude_r)
+		sinM := math.Sin(float64(m) * longitude_r)
+		north += ratio * (g*cosM + h*sinM) * derivative[n][m]
+		east += ratio * float64(m) * (g*sinM - h*cosM) * legendre[n][m] / sinTheta
+		down -= ratio * float64(n+1) * (g*cosM + h*sinM) * legendre[n][m]
+	}
+
+	// Rotate back to geodetic
+	difference := geocentricLatitude_r - latitude_r
+	north, down = north*math.Cos(difference)-down*math.Sin(difference), north*math.Sin(difference)+down*math.Cos(difference)
+
+	horizontal := math.Sqrt(north*north + east*east)
+	return MagneticField{
+		Declination: math.Atan2(east, north),
+		Inclination: math.Atan2(down, horizontal),
+		Intensity:   math.Sqrt(horizontal*horizontal + down*down),
+	}
+}
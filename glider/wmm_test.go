@@ -0,0 +1,90 @@
+package glider
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestWorldMagneticModel(t *testing.T) {
+	// At the start of WMM2025
+	tests := []struct {
+		point         Point
+		declination_d Degrees
+		inclination_d Degrees
+	}{
+		{Point{Latitude: 80, Longitude: 0, Altitude: 0}, 1.28, 83.21},
+		{Point{Latitude: 0, Longitude: 120, Altitude: 0}, -0.16, -14.93},
+		{Point{Latitude: -80, Longitude: 240, Altitude: 0}, 68.78, -72.0},
+	}
+	when := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, test := range tests {
+		field := WorldMagneticModel(test.point, when)
+		if math.Abs(ToDegrees(field.Declination)-test.declination_d) > 0.05 {
+			t.Errorf("Bad declination %0.2f, expected %0.2f", ToDegrees(field.Declination), test.declination_d)
+		}
+		if math.Abs(ToDegrees(field.Inclination)-test.inclination_d) > 0.1 {
+			t.Errorf("Bad inclination %0.2f, expected %0.2f", ToDegrees(field.Inclination), test.inclination_d)
+		}
+	}
+
+	// Boulder is about 7.7 degrees east
+	boulder := WorldMagneticModel(Point{Latitude: 40.015, Longitude: -105.27, Altitude: 1655}, time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC))
+	if math.Abs(ToDegrees(boulder.Declination)-7.7) > 0.2 {
+		t.Errorf("Bad Boulder declination %0.2f", ToDegrees(boulder.Declination))
+	}
+
+	if !wmmValid(when) || !wmmValid(time.Date(2029, 12, 31, 0, 0, 0, 0, time.UTC)) {
+		t.Error("Should be valid from 2025 to 2030")
+	}
+	if wmmValid(time.Date(2024, 12, 31, 0, 0, 0, 0, time.UTC)) || wmmValid(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Error("Should have expired")
+	}
+}
+
+func TestDecimalYear(t *testing.T) {
+	if !approximatelyEqual(decimalYear(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)), 2020.0) {
+		t.Error("Bad decimal year for the start of 2020")
+	}
+	// 2020 is a leap year
+	if !approximatelyEqual(decimalYear(time.Date(2020, 7, 2, 0, 0, 0, 0, time.UTC)), 2020.5) {
+		t.Errorf("Bad decimal year for the middle of 2020 %v", decimalYear(time.Date(2020, 7, 2, 0, 0, 0, 0, time.UTC)))
+	}
+}
+
+func TestTrueNorth(t *testing.T) {
+	setTestGpsConfiguration()
+	configuration.Declination = ToRadians(-3.0)
+
+	// Without a fix, use the configured value
+	configuration.DeclinationSource = DECLINATION_SOURCE_WORLD_MAGNETIC_MODEL
+	telemetry := Telemetry{}
+	axes := telemetry.toTrueNorth(Axes{Yaw: ToRadians(1.0)})
+	if math.Abs(ToDegrees(axes.Yaw)-358.0) > 0.01 {
+		t.Errorf("Bad yaw without fix %0.2f", ToDegrees(axes.Yaw))
+	}
+
+	// Boulder
+	telemetry.gpsFilter.updatePosition(40.015, -105.27, 1.0, time.Now())
+	declination_d := ToDegrees(telemetry.GetDeclination())
+	if declination_d < 5.0 || declination_d > 10.0 {
+		t.Errorf("Bad declination %0.2f", declination_d)
+	}
+
+	// An expired model falls back to the configured value
+	previousClock := pilotClock
+	pilotClock = &simulatedClock{now: time.Date(2031, 1, 1, 0, 0, 0, 0, time.UTC)}
+	expired := Telemetry{}
+	expired.gpsFilter.updatePosition(40.015, -105.27, 1.0, time.Now())
+	if !approximatelyEqual(expired.GetDeclination(), ToRadians(-3.0)) {
+		t.Errorf("Should have used the configured declination, got %0.2f", ToDegrees(expired.GetDeclination()))
+	}
+	pilotClock = previousClock
+
+	// The override should win
+	configuration.DeclinationSource = DECLINATION_SOURCE_CONFIGURATION
+	if !approximatelyEqual(telemetry.GetDeclination(), ToRadians(-3.0)) {
+		t.Errorf("Bad overridden declination %0.2f", ToDegrees(telemetry.GetDeclination()))
+	}
+	configuration.DeclinationSource = DECLINATION_SOURCE_WORLD_MAGNETIC_MODEL
+}
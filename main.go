@@ -3,9 +3,9 @@ package main
 import (
 	"flag"
 	"fmt"
+	"github.com/bskari/go-glider/flightdata"
 	"github.com/bskari/go-glider/glider"
 	"github.com/nsf/termbox-go"
-	"github.com/stianeikeland/go-rpio/v4"
 	"io/ioutil"
 	"os"
 	"os/exec"
@@ -77,57 +77,80 @@ import (
 // 34 = ground, connect to AA black
 
 func main() {
-	if os.Getuid() != 0 {
-		fmt.Println("Must run as root")
-		return
-	}
-
 	dumpSensorsPtr := flag.Bool("dump", false, "Dump the sensor data")
 	serveCalibrationPtr := flag.Bool("calibrate", false, "Dump calibration over TCP")
+	calibrateMagnetometerPtr := flag.Bool("calibrate-magnetometer", false, "Calibrate the magnetometer hard and soft iron")
+	calibrateAccelerometerPtr := flag.Bool("calibrate-accelerometer", false, "Calibrate the accelerometer in six orientations")
 	glidePtr := flag.Bool("glide", false, "Run the glide test")
 	servoPtr := flag.Bool("servo", false, "Run the servo test")
+	simulatePtr := flag.Bool("simulate", false, "Fly the mission in the simulator, without a Pi")
+	replayPtr := flag.String("replay", "", "Replay a flight data recorder file through the pilot and compare the servo commands")
+	replayRecordedAttitudePtr := flag.Bool("replay-recorded-attitude", false, "Use the recorded attitude when replaying an older file that doesn't have the AHRS steps")
+	checkConfigurationPtr := flag.Bool("check-config", false, "Check the configuration and report every problem with it")
+	airframePtr := flag.String("airframe", os.Getenv("GLIDER_AIRFRAME"), "Airframe profile to load after conf.toml, from airframes/<name>.toml or a path. Defaults to $GLIDER_AIRFRAME.")
+	sitePtr := flag.String("site", os.Getenv("GLIDER_SITE"), "Site or mission configuration to load after the airframe. Defaults to $GLIDER_SITE.")
+	var overrides configurationOverrides
+	flag.Var(&overrides, "set", "Override a configuration value, like -set ProportionalRollMultiplier=3.5. Can be repeated.")
 	flag.Parse()
 
-	os.Mkdir("logs", 0655)
+	// Load configuration
+	err := loadConfiguration(*airframePtr, *sitePtr, overrides)
+	if err != nil {
+		printConfigurationErrors(err)
+		os.Exit(1)
+	}
+	if *checkConfigurationPtr {
+		fmt.Println("Configuration is OK")
+		return
+	}
 
-	if glider.IsPi() {
-		err := rpio.Open()
-		if err != nil {
-			fmt.Printf("Failed to initialize RPIO: %v\n", err)
-			return
-		}
-		defer rpio.Close()
+	// The simulator and replays don't touch any hardware
+	if os.Getuid() != 0 && !*simulatePtr && *replayPtr == "" {
+		fmt.Println("Must run as root")
+		return
 	}
 
-	// Load configuration
-	file, err := os.Open("conf.toml")
-	if err != nil {
-		panic("Couldn't open configuration file")
+	os.Mkdir("logs", 0655)
+
+	if *simulatePtr {
+		runSimulation()
+		return
+	}
+	if *replayPtr != "" {
+		runReplay(*replayPtr, *replayRecordedAttitudePtr)
+		return
 	}
-	defer file.Close()
-	err = glider.LoadConfiguration(file)
+
+	hardware, err := glider.NewHardware()
 	if err != nil {
-		panic(err)
+		fmt.Printf("Failed to initialize hardware: %v\n", err)
+		return
 	}
+	// Whether we finish, get a signal, or panic, put the servos somewhere
+	// safe before closing everything
+	shutdown := glider.NewShutdown(hardware)
+	defer shutdown.Close()
+	defer shutdown.Recover()
 
 	if *dumpSensorsPtr {
-		dumpSensors()
+		dumpSensors(hardware)
 	} else if *serveCalibrationPtr {
-		serveCalibrationData(4381)
+		serveCalibrationData(hardware, 4381)
+	} else if *calibrateMagnetometerPtr {
+		calibrateMagnetometer(hardware)
+	} else if *calibrateAccelerometerPtr {
+		calibrateAccelerometer(hardware)
 	} else if *glidePtr {
-		runGlide()
+		runGlide(hardware, shutdown)
 	} else if *servoPtr {
-		testServos()
+		testServos(hardware)
 	} else {
 		flag.PrintDefaults()
 	}
 }
 
-func runGlide() {
-	telemetry, err := glider.NewTelemetry()
-	if err != nil {
-		panic(fmt.Sprintf("Couldn't initialize telemetry: %v", err))
-	}
+func runGlide(hardware *glider.Hardware, shutdown *glider.Shutdown) {
+	telemetry := glider.NewTelemetry(hardware)
 
 	// Wait for the GPS to get a lock, so we can set the clock
 	timeSet := false
@@ -137,22 +160,18 @@ func runGlide() {
 		timeSet = true
 	} else {
 		glider.Logger.Info("Waiting for timestamp from GPS")
+		telemetry.StartGpsReader(shutdown.Context())
 		for i := 0; i < 3; i++ {
 			time.Sleep(time.Millisecond * 100)
-			glider.ToggleLed()
+			hardware.Led.Toggle()
 			time.Sleep(time.Millisecond * 900)
-			glider.ToggleLed()
-			// Parse a queued up message
-			_, err := telemetry.ParseQueuedMessage()
-			if err != nil {
-				glider.Logger.Errorf("Unable to parse GPS message: %v", err)
-				break
-			}
+			hardware.Led.Toggle()
 			// 1601261144 = September 27 2020
-			if telemetry.GetTimestamp() > 1601261144 {
+			if telemetry.GetTimestamp() > 1601261144 || shutdown.Context().Err() != nil {
 				break
 			}
 		}
+		telemetry.StopGpsReader()
 		timestamp := telemetry.GetTimestamp()
 		if timestamp > 1601261144 {
 			now := time.Unix(timestamp, 0)
@@ -178,21 +197,98 @@ func runGlide() {
 	if err != nil {
 		panic(err)
 	}
-	defer fileLog.Close()
+	// Keep the log open until everything else has shut down
+	shutdown.Add("log", fileLog.Close)
 	fileLog.Chown(1000, 1000) // User "pi"
 	glider.ConfigureLogger(fileLog)
+	err = glider.WriteConfigurationHeader(fileLog)
+	if err != nil {
+		glider.Logger.Errorf("Unable to write configuration to log: %v", err)
+	}
 	glider.Logger.Info("Starting Pilot")
-	pilot, err := glider.NewPilot()
+	pilot, err := glider.NewPilot(hardware)
 	if err != nil {
 		glider.Logger.Errorf("Couldn't create Pilot: %v", err)
+		return
+	}
+
+	// Keep flying even if we can't record
+	recorderFile, recorder, err := openFlightRecorder(strings.TrimSuffix(logName, ".log") + ".csv")
+	if err != nil {
+		glider.Logger.Errorf("Couldn't open flight data recorder: %v", err)
+	} else {
+		shutdown.Add("flight data recorder", func() error {
+			recorderFile.Sync()
+			return recorderFile.Close()
+		})
+		recorderFile.Chown(1000, 1000) // User "pi"
+		pilot.SetFlightRecorder(recorder)
+	}
+
+	// Or without tuning
+	store := glider.NewParameterStore(savePath)
+	pilot.SetParameterStore(store)
+	parameterServer, err := glider.NewParameterServer(store)
+	if err != nil {
+		glider.Logger.Errorf("Couldn't serve parameters: %v", err)
+	} else if parameterServer != nil {
+		defer parameterServer.Close()
+	}
+
+	// Keep flying without a ground station too
+	link, err := glider.NewMavlinkLink()
+	if err != nil {
+		glider.Logger.Errorf("Couldn't open MAVLink: %v", err)
+	} else if link != nil {
+		defer link.Close()
+		pilot.SetMavlinkLink(link)
+	}
+	beacon, err := glider.NewAprsBeacon()
+	if err != nil {
+		glider.Logger.Errorf("Couldn't open APRS TNC: %v", err)
+	} else if beacon != nil {
+		defer beacon.Close()
+		pilot.SetAprsBeacon(beacon)
+	}
+	station, err := glider.NewGroundStation()
+	if err != nil {
+		glider.Logger.Errorf("Couldn't serve ground station: %v", err)
+	} else if station != nil {
+		defer station.Close()
+		pilot.SetGroundStation(station)
+	}
+	// Don't fly without the zones that we were told to respect
+	fence, err := glider.NewGeofence()
+	if err != nil {
+		glider.Logger.Errorf("Couldn't load geofence: %v", err)
+		return
+	} else if fence != nil {
+		pilot.SetGeofence(fence)
 	}
 
 	// Set up display
 	err = termbox.Init()
 	check(err)
-	defer termbox.Close()
+	shutdown.Add("display", func() error {
+		termbox.Close()
+		return nil
+	})
 
-	pilot.RunGlideTestForever()
+	pilot.RunGlideTestForever(shutdown.Context())
+}
+
+// Opens a flight data recorder file, appending if it already exists
+func openFlightRecorder(path string) (*os.File, *flightdata.Writer, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	recorder, err := flightdata.NewWriter(file)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return file, recorder, nil
 }
 
 func getLogName(timeSet bool) string {
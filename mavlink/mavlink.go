@@ -0,0 +1,311 @@
+// Package mavlink encodes and decodes the MAVLink v2 messages that the glider
+// uses to talk to ground stations like QGroundControl and Mission Planner.
+// Only the messages that we send or handle are defined. Version 1 frames can
+// be decoded too, but we only send version 2.
+package mavlink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	STX_V1 = 0xFE
+	STX_V2 = 0xFD
+	// Sent in heartbeats
+	MAVLINK_VERSION = 3
+
+	headerLengthV1   = 6
+	headerLengthV2   = 10
+	checksumLength   = 2
+	signatureLength  = 13
+	incompatSigned   = 0x01
+	maxPayloadLength = 255
+)
+
+// Values for the MAVLink enums that we use
+const (
+	MAV_TYPE_FIXED_WING   = 1
+	MAV_TYPE_GCS          = 6
+	MAV_AUTOPILOT_GENERIC = 0
+	MAV_AUTOPILOT_INVALID = 8
+
+	MAV_MODE_FLAG_CUSTOM_MODE_ENABLED = 1
+	MAV_MODE_FLAG_AUTO_ENABLED        = 4
+	MAV_MODE_FLAG_GUIDED_ENABLED      = 8
+	MAV_MODE_FLAG_STABILIZE_ENABLED   = 16
+	MAV_MODE_FLAG_SAFETY_ARMED        = 128
+
+	MAV_STATE_BOOT    = 1
+	MAV_STATE_STANDBY = 3
+	MAV_STATE_ACTIVE  = 4
+
+	MAV_STATE_FLIGHT_TERMINATION = 8
+
+	MAV_SEVERITY_WARNING = 4
+	MAV_SEVERITY_NOTICE  = 5
+	MAV_SEVERITY_INFO    = 6
+
+	GPS_FIX_TYPE_NO_FIX = 1
+	GPS_FIX_TYPE_3D_FIX = 3
+
+	MAV_FRAME_GLOBAL                  = 0
+	MAV_FRAME_GLOBAL_RELATIVE_ALT     = 3
+	MAV_FRAME_GLOBAL_INT              = 5
+	MAV_FRAME_GLOBAL_RELATIVE_ALT_INT = 6
+	MAV_FRAME_GLOBAL_TERRAIN_ALT      = 10
+	MAV_FRAME_GLOBAL_TERRAIN_ALT_INT  = 11
+
+	MAV_CMD_NAV_WAYPOINT      = 16
+	MAV_CMD_NAV_LOITER_UNLIM  = 17
+	MAV_CMD_NAV_LOITER_TURNS  = 18
+	MAV_CMD_NAV_LOITER_TIME   = 19
+	MAV_CMD_NAV_LAND          = 21
+	MAV_CMD_NAV_LOITER_TO_ALT = 31
+
+	MAV_MISSION_TYPE_MISSION = 0
+
+	MAV_MISSION_ACCEPTED          = 0
+	MAV_MISSION_ERROR             = 1
+	MAV_MISSION_UNSUPPORTED_FRAME = 2
+	MAV_MISSION_UNSUPPORTED       = 3
+	MAV_MISSION_INVALID_SEQUENCE  = 13
+	MAV_MISSION_DENIED            = 14
+)
+
+// A message that can be sent or received. Messages are structs whose fields
+// are in wire order, i.e. sorted by size with extension fields last.
+type Message interface {
+	MessageId() uint32
+}
+
+type messageInfo struct {
+	crcExtra uint8
+	new      func() Message
+}
+
+var messages = map[uint32]messageInfo{
+	0:   {50, func() Message { return &Heartbeat{} }},
+	11:  {89, func() Message { return &SetMode{} }},
+	24:  {24, func() Message { return &GpsRawInt{} }},
+	30:  {39, func() Message { return &Attitude{} }},
+	33:  {104, func() Message { return &GlobalPositionInt{} }},
+	36:  {222, func() Message { return &ServoOutputRaw{} }},
+	40:  {230, func() Message { return &MissionRequest{} }},
+	42:  {28, func() Message { return &MissionCurrent{} }},
+	43:  {132, func() Message { return &MissionRequestList{} }},
+	44:  {221, func() Message { return &MissionCount{} }},
+	45:  {232, func() Message { return &MissionClearAll{} }},
+	47:  {153, func() Message { return &MissionAck{} }},
+	51:  {196, func() Message { return &MissionRequestInt{} }},
+	73:  {38, func() Message { return &MissionItemInt{} }},
+	253: {83, func() Message { return &StatusText{} }},
+}
+
+var ErrUnknownMessage = errors.New("Unknown MAVLink message")
+
+// One received frame
+type Frame struct {
+	Version     uint8
+	Sequence    uint8
+	SystemId    uint8
+	ComponentId uint8
+	MessageId   uint32
+	Payload     []byte
+}
+
+// Encodes messages into version 2 frames
+type Encoder struct {
+	SystemId    uint8
+	ComponentId uint8
+	sequence    uint8
+}
+
+func NewEncoder(systemId, componentId uint8) *Encoder {
+	return &Encoder{SystemId: systemId, ComponentId: componentId}
+}
+
+func (encoder *Encoder) Encode(message Message) ([]byte, error) {
+	info, ok := messages[message.MessageId()]
+	if !ok {
+		return nil, ErrUnknownMessage
+	}
+	var payload bytes.Buffer
+	err := binary.Write(&payload, binary.LittleEndian, message)
+	if err != nil {
+		return nil, err
+	}
+	// Version 2 drops the trailing zeros, but always sends at least one byte
+	trimmed := bytes.TrimRight(payload.Bytes(), "\x00")
+	if len(trimmed) == 0 {
+		trimmed = payload.Bytes()[:1]
+	}
+	if len(trimmed) > maxPayloadLength {
+		return nil, fmt.Errorf("Payload for message %d is too long", message.MessageId())
+	}
+
+	id := message.MessageId()
+	frame := make([]byte, 0, headerLengthV2+len(trimmed)+checksumLength)
+	frame = append(
+		frame,
+		STX_V2,
+		uint8(len(trimmed)),
+		0, // Incompatibility flags
+		0, // Compatibility flags
+		encoder.sequence,
+		encoder.SystemId,
+		encoder.ComponentId,
+		uint8(id),
+		uint8(id>>8),
+		uint8(id>>16),
+	)
+	frame = append(frame, trimmed...)
+	crc := checksum(frame[1:], info.crcExtra)
+	frame = append(frame, uint8(crc), uint8(crc>>8))
+	encoder.sequence++
+	return frame, nil
+}
+
+// Decodes the payload of a frame into its message
+func Decode(frame Frame) (Message, error) {
+	info, ok := messages[frame.MessageId]
+	if !ok {
+		return nil, ErrUnknownMessage
+	}
+	message := info.new()
+	// Senders drop trailing zeros, and might know about extension fields
+	// that we don't
+	size := binary.Size(message)
+	payload := make([]byte, size)
+	copy(payload, frame.Payload)
+	err := binary.Read(bytes.NewReader(payload), binary.LittleEndian, message)
+	if err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// Splits a byte stream into frames, skipping anything that isn't a valid
+// frame
+type Parser struct {
+	buffer []byte
+	// How many bytes were skipped because they weren't part of a valid frame
+	Skipped int
+}
+
+// Adds received bytes, and returns any frames that they completed. Frames
+// for unknown messages are skipped, because we can't check them, so their
+// length can't be trusted either.
+func (parser *Parser) Parse(data []byte) []Frame {
+	parser.buffer = append(parser.buffer, data...)
+	var frames []Frame
+	for {
+		start := findStart(parser.buffer)
+		if start < 0 {
+			parser.Skipped += len(parser.buffer)
+			parser.buffer = parser.buffer[:0]
+			return frames
+		}
+		parser.Skipped += start
+		parser.buffer = parser.buffer[start:]
+
+		frame, length, ok := parser.parseFrame()
+		if length == 0 {
+			// Wait for the rest of the frame
+			return frames
+		}
+		if !ok {
+			// Not really a frame, so look for the next start byte
+			parser.Skipped++
+			parser.buffer = parser.buffer[1:]
+			continue
+		}
+		parser.buffer = parser.buffer[length:]
+		if frame != nil {
+			frames = append(frames, *frame)
+		}
+	}
+}
+
+// Returns the index of the first start byte, or -1
+func findStart(buffer []byte) int {
+	for i, b := range buffer {
+		if b == STX_V1 || b == STX_V2 {
+			return i
+		}
+	}
+	return -1
+}
+
+// Tries to parse a frame at the start of the buffer. Returns the frame if it
+// was valid and known, the length of the frame or 0 if it's incomplete, and
+// whether the frame was valid.
+func (parser *Parser) parseFrame() (*Frame, int, bool) {
+	buffer := parser.buffer
+	var frame Frame
+	var headerLength int
+	if buffer[0] == STX_V2 {
+		headerLength = headerLengthV2
+		if len(buffer) < headerLength {
+			return nil, 0, true
+		}
+		frame = Frame{
+			Version:     2,
+			Sequence:    buffer[4],
+			SystemId:    buffer[5],
+			ComponentId: buffer[6],
+			MessageId:   uint32(buffer[7]) | uint32(buffer[8])<<8 | uint32(buffer[9])<<16,
+		}
+	} else {
+		headerLength = headerLengthV1
+		if len(buffer) < headerLength {
+			return nil, 0, true
+		}
+		frame = Frame{
+			Version:     1,
+			Sequence:    buffer[2],
+			SystemId:    buffer[3],
+			ComponentId: buffer[4],
+			MessageId:   uint32(buffer[5]),
+		}
+	}
+
+	payloadLength := int(buffer[1])
+	length := headerLength + payloadLength + checksumLength
+	if frame.Version == 2 && buffer[2]&incompatSigned != 0 {
+		length += signatureLength
+	}
+	if len(buffer) < length {
+		return nil, 0, true
+	}
+
+	info, ok := messages[frame.MessageId]
+	if !ok {
+		return nil, length, false
+	}
+	end := headerLength + payloadLength
+	crc := checksum(buffer[1:end], info.crcExtra)
+	if uint8(crc) != buffer[end] || uint8(crc>>8) != buffer[end+1] {
+		return nil, length, false
+	}
+	frame.Payload = append([]byte(nil), buffer[headerLength:end]...)
+	return &frame, length, true
+}
+
+// CRC-16/MCRF4XX, a.k.a. X.25, over the frame after the start byte, then
+// the message's CRC extra byte
+func checksum(data []byte, crcExtra uint8) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc = accumulate(b, crc)
+	}
+	return accumulate(crcExtra, crc)
+}
+
+func accumulate(b uint8, crc uint16) uint16 {
+	tmp := b ^ uint8(crc)
+	tmp ^= tmp << 4
+	return (crc >> 8) ^ uint16(tmp)<<8 ^ uint16(tmp)<<3 ^ uint16(tmp)>>4
+}
@@ -0,0 +1,213 @@
+package mavlink
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Base fields of each message in wire order, from the MAVLink definitions
+var definitions = map[uint32]string{
+	0:   "HEARTBEAT uint32_t custom_mode, uint8_t type, uint8_t autopilot, uint8_t base_mode, uint8_t system_status, uint8_t mavlink_version",
+	11:  "SET_MODE uint32_t custom_mode, uint8_t target_system, uint8_t base_mode",
+	24:  "GPS_RAW_INT uint64_t time_usec, int32_t lat, int32_t lon, int32_t alt, uint16_t eph, uint16_t epv, uint16_t vel, uint16_t cog, uint8_t fix_type, uint8_t satellites_visible",
+	30:  "ATTITUDE uint32_t time_boot_ms, float roll, float pitch, float yaw, float rollspeed, float pitchspeed, float yawspeed",
+	33:  "GLOBAL_POSITION_INT uint32_t time_boot_ms, int32_t lat, int32_t lon, int32_t alt, int32_t relative_alt, int16_t vx, int16_t vy, int16_t vz, uint16_t hdg",
+	36:  "SERVO_OUTPUT_RAW uint32_t time_usec, uint16_t servo1_raw, uint16_t servo2_raw, uint16_t servo3_raw, uint16_t servo4_raw, uint16_t servo5_raw, uint16_t servo6_raw, uint16_t servo7_raw, uint16_t servo8_raw, uint8_t port",
+	40:  "MISSION_REQUEST uint16_t seq, uint8_t target_system, uint8_t target_component",
+	42:  "MISSION_CURRENT uint16_t seq",
+	43:  "MISSION_REQUEST_LIST uint8_t target_system, uint8_t target_component",
+	44:  "MISSION_COUNT uint16_t count, uint8_t target_system, uint8_t target_component",
+	45:  "MISSION_CLEAR_ALL uint8_t target_system, uint8_t target_component",
+	47:  "MISSION_ACK uint8_t target_system, uint8_t target_component, uint8_t type",
+	51:  "MISSION_REQUEST_INT uint16_t seq, uint8_t target_system, uint8_t target_component",
+	73:  "MISSION_ITEM_INT float param1, float param2, float param3, float param4, int32_t x, int32_t y, float z, uint16_t seq, uint16_t command, uint8_t target_system, uint8_t target_component, uint8_t frame, uint8_t current, uint8_t autocontinue",
+	253: "STATUSTEXT uint8_t severity, char[50] text",
+}
+
+func TestCrcExtra(t *testing.T) {
+	if len(definitions) != len(messages) {
+		t.Fatalf("Expected %d definitions, got %d", len(messages), len(definitions))
+	}
+	for id, definition := range definitions {
+		fields := strings.Split(definition, ", ")
+		name := strings.Fields(fields[0])[0]
+		fields[0] = strings.TrimPrefix(fields[0], name+" ")
+
+		crc := uint16(0xFFFF)
+		add := func(s string) {
+			for _, b := range []byte(s) {
+				crc = accumulate(b, crc)
+			}
+		}
+		add(name + " ")
+		for _, field := range fields {
+			parts := strings.Fields(field)
+			fieldType := parts[0]
+			arrayLength := 0
+			if bracket := strings.IndexByte(fieldType, '['); bracket >= 0 {
+				for _, digit := range fieldType[bracket+1 : len(fieldType)-1] {
+					arrayLength = arrayLength*10 + int(digit-'0')
+				}
+				fieldType = fieldType[:bracket]
+			}
+			add(fieldType + " " + parts[1] + " ")
+			if arrayLength > 0 {
+				crc = accumulate(uint8(arrayLength), crc)
+			}
+		}
+		crcExtra := uint8(crc) ^ uint8(crc>>8)
+		if messages[id].crcExtra != crcExtra {
+			t.Errorf("Bad CRC extra for %s, expected %d, got %d", name, crcExtra, messages[id].crcExtra)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	sent := []Message{
+		&Heartbeat{CustomMode: 2, Type: MAV_TYPE_FIXED_WING, BaseMode: MAV_MODE_FLAG_CUSTOM_MODE_ENABLED, SystemStatus: MAV_STATE_ACTIVE, MavlinkVersion: MAVLINK_VERSION},
+		&Attitude{TimeBootMs: 1234, Roll: -0.5, Pitch: 0.1, Yaw: 3},
+		&GlobalPositionInt{Lat: 400540123, Lon: -1052950456, Alt: 1800500, Vx: -100, Hdg: 27050},
+		&ServoOutputRaw{TimeUsec: 99, Servos: [8]uint16{1500, 1200}},
+		&MissionItemInt{X: 400540123, Y: -1052950456, Z: 100, Seq: 3, Command: MAV_CMD_NAV_WAYPOINT, TargetSystem: 1, Autocontinue: 1},
+		&MissionAck{TargetSystem: 255, Type: MAV_MISSION_ACCEPTED},
+		NewStatusText(MAV_SEVERITY_INFO, "Flying"),
+	}
+	encoder := NewEncoder(1, 1)
+	var stream []byte
+	for _, message := range sent {
+		frame, err := encoder.Encode(message)
+		if err != nil {
+			t.Fatalf("Unable to encode %T: %v", message, err)
+		}
+		stream = append(stream, frame...)
+	}
+
+	// Feed it a byte at a time, to make sure partial frames are handled
+	var parser Parser
+	var frames []Frame
+	for _, b := range stream {
+		frames = append(frames, parser.Parse([]byte{b})...)
+	}
+	if len(frames) != len(sent) {
+		t.Fatalf("Expected %d frames, got %d", len(sent), len(frames))
+	}
+	if parser.Skipped != 0 {
+		t.Errorf("Skipped %d bytes", parser.Skipped)
+	}
+	for i, frame := range frames {
+		if frame.Version != 2 || frame.Sequence != uint8(i) || frame.SystemId != 1 || frame.ComponentId != 1 {
+			t.Errorf("Bad frame header %v", frame)
+		}
+		received, err := Decode(frame)
+		if err != nil {
+			t.Fatalf("Unable to decode %v: %v", frame, err)
+		}
+		if !reflect.DeepEqual(received, sent[i]) {
+			t.Errorf("Expected %v, got %v", sent[i], received)
+		}
+	}
+	if frames[6].MessageId != 253 {
+		t.Errorf("Bad message ID %d", frames[6].MessageId)
+	}
+}
+
+func TestTruncation(t *testing.T) {
+	frame, err := NewEncoder(1, 1).Encode(&MissionAck{TargetSystem: 255})
+	if err != nil {
+		t.Fatalf("Unable to encode: %v", err)
+	}
+	if frame[1] != 1 || len(frame) != headerLengthV2+1+checksumLength {
+		t.Errorf("Trailing zeros should have been dropped, got %v", frame)
+	}
+
+	// Empty payloads still send one byte
+	frame, err = NewEncoder(1, 1).Encode(&MissionCurrent{})
+	if err != nil {
+		t.Fatalf("Unable to encode: %v", err)
+	}
+	if frame[1] != 1 {
+		t.Errorf("Bad payload length %d", frame[1])
+	}
+}
+
+func TestParserResynchronizes(t *testing.T) {
+	encoder := NewEncoder(1, 1)
+	heartbeat, _ := encoder.Encode(&Heartbeat{Type: MAV_TYPE_GCS})
+	corrupted, _ := encoder.Encode(&Heartbeat{Type: MAV_TYPE_GCS})
+	corrupted[12] ^= 0xFF
+	ack, _ := encoder.Encode(&MissionAck{Type: MAV_MISSION_ERROR})
+
+	var stream []byte
+	// A false start byte, whose frame fails the checksum
+	stream = append(stream, 'x', STX_V2, 3)
+	stream = append(stream, heartbeat...)
+	stream = append(stream, corrupted...)
+	stream = append(stream, ack...)
+
+	var parser Parser
+	frames := parser.Parse(stream)
+	if len(frames) != 2 {
+		t.Fatalf("Expected 2 frames, got %d", len(frames))
+	}
+	if frames[0].MessageId != 0 || frames[1].MessageId != 47 {
+		t.Errorf("Bad frames %v", frames)
+	}
+	if parser.Skipped == 0 {
+		t.Errorf("Should have skipped the garbage")
+	}
+}
+
+func TestParseVersionOneAndSigned(t *testing.T) {
+	// Version 1 frames have a shorter header, and the CRC covers it the same
+	// way
+	payload := []byte{0, 0, 0, 0, MAV_TYPE_GCS, MAV_AUTOPILOT_INVALID, 0, 0, MAVLINK_VERSION}
+	v1 := []byte{STX_V1, uint8(len(payload)), 7, 255, 190, 0}
+	v1 = append(v1, payload...)
+	crc := checksum(v1[1:], messages[0].crcExtra)
+	v1 = append(v1, uint8(crc), uint8(crc>>8))
+
+	// Signed frames have a signature that we ignore
+	signed, _ := NewEncoder(255, 190).Encode(&SetMode{CustomMode: 1, TargetSystem: 1, BaseMode: 1})
+	signed[2] |= incompatSigned
+	end := len(signed) - checksumLength
+	crc = checksum(signed[1:end], messages[11].crcExtra)
+	signed[end] = uint8(crc)
+	signed[end+1] = uint8(crc >> 8)
+	signed = append(signed, bytes.Repeat([]byte{0xAA}, signatureLength)...)
+
+	// Unknown messages are skipped
+	unknown := []byte{STX_V2, 2, 0, 0, 0, 1, 1, 0xFF, 0xFF, 0, 1, 2, 3, 4}
+
+	var parser Parser
+	frames := parser.Parse(append(append(v1, unknown...), signed...))
+	if len(frames) != 2 {
+		t.Fatalf("Expected 2 frames, got %d", len(frames))
+	}
+	if frames[0].Version != 1 || frames[0].Sequence != 7 || frames[0].SystemId != 255 {
+		t.Errorf("Bad version 1 frame %v", frames[0])
+	}
+	message, err := Decode(frames[1])
+	if err != nil {
+		t.Fatalf("Unable to decode: %v", err)
+	}
+	setMode, ok := message.(*SetMode)
+	if !ok || setMode.CustomMode != 1 || setMode.TargetSystem != 1 {
+		t.Errorf("Bad message %v", message)
+	}
+	if parser.Skipped != len(unknown) {
+		t.Errorf("Skipped %d bytes", parser.Skipped)
+	}
+}
+
+func TestStatusText(t *testing.T) {
+	message := NewStatusText(MAV_SEVERITY_WARNING, strings.Repeat("a", 60))
+	if message.String() != strings.Repeat("a", 50) {
+		t.Errorf("Bad text '%s'", message)
+	}
+	message = NewStatusText(MAV_SEVERITY_WARNING, "Landed")
+	if message.String() != "Landed" {
+		t.Errorf("Bad text '%s'", message)
+	}
+}
@@ -0,0 +1,175 @@
+package mavlink
+
+import (
+	"bytes"
+)
+
+// The fields of each message are in wire order, not the order in the message
+// definitions, so that they can be encoded directly
+
+type Heartbeat struct {
+	CustomMode     uint32
+	Type           uint8
+	Autopilot      uint8
+	BaseMode       uint8
+	SystemStatus   uint8
+	MavlinkVersion uint8
+}
+
+type SetMode struct {
+	CustomMode   uint32
+	TargetSystem uint8
+	BaseMode     uint8
+}
+
+type GpsRawInt struct {
+	TimeUsec uint64
+	// Degrees * 1e7
+	Lat int32
+	Lon int32
+	// Millimeters above mean sea level
+	Alt int32
+	// Dilution of precision * 100
+	Eph uint16
+	Epv uint16
+	// Centimeters per second
+	Vel uint16
+	// Degrees * 100
+	Cog               uint16
+	FixType           uint8
+	SatellitesVisible uint8
+}
+
+type Attitude struct {
+	TimeBootMs uint32
+	// Radians and radians per second
+	Roll       float32
+	Pitch      float32
+	Yaw        float32
+	Rollspeed  float32
+	Pitchspeed float32
+	Yawspeed   float32
+}
+
+type GlobalPositionInt struct {
+	TimeBootMs uint32
+	// Degrees * 1e7
+	Lat int32
+	Lon int32
+	// Millimeters
+	Alt         int32
+	RelativeAlt int32
+	// Centimeters per second, north east down
+	Vx int16
+	Vy int16
+	Vz int16
+	// Degrees * 100
+	Hdg uint16
+}
+
+type ServoOutputRaw struct {
+	TimeUsec uint32
+	// Microseconds
+	Servos [8]uint16
+	Port   uint8
+}
+
+type MissionRequest struct {
+	Seq             uint16
+	TargetSystem    uint8
+	TargetComponent uint8
+	MissionType     uint8
+}
+
+type MissionCurrent struct {
+	Seq uint16
+}
+
+type MissionRequestList struct {
+	TargetSystem    uint8
+	TargetComponent uint8
+	MissionType     uint8
+}
+
+type MissionCount struct {
+	Count           uint16
+	TargetSystem    uint8
+	TargetComponent uint8
+	MissionType     uint8
+}
+
+type MissionClearAll struct {
+	TargetSystem    uint8
+	TargetComponent uint8
+	MissionType     uint8
+}
+
+type MissionAck struct {
+	TargetSystem    uint8
+	TargetComponent uint8
+	Type            uint8
+	MissionType     uint8
+}
+
+type MissionRequestInt struct {
+	Seq             uint16
+	TargetSystem    uint8
+	TargetComponent uint8
+	MissionType     uint8
+}
+
+type MissionItemInt struct {
+	Param1 float32
+	Param2 float32
+	Param3 float32
+	Param4 float32
+	// Degrees * 1e7 in global frames
+	X               int32
+	Y               int32
+	Z               float32
+	Seq             uint16
+	Command         uint16
+	TargetSystem    uint8
+	TargetComponent uint8
+	Frame           uint8
+	Current         uint8
+	Autocontinue    uint8
+	MissionType     uint8
+}
+
+type StatusText struct {
+	Severity uint8
+	// Null terminated unless it's the full length
+	Text [50]byte
+}
+
+func (*Heartbeat) MessageId() uint32          { return 0 }
+func (*SetMode) MessageId() uint32            { return 11 }
+func (*GpsRawInt) MessageId() uint32          { return 24 }
+func (*Attitude) MessageId() uint32           { return 30 }
+func (*GlobalPositionInt) MessageId() uint32  { return 33 }
+func (*ServoOutputRaw) MessageId() uint32     { return 36 }
+func (*MissionRequest) MessageId() uint32     { return 40 }
+func (*MissionCurrent) MessageId() uint32     { return 42 }
+func (*MissionRequestList) MessageId() uint32 { return 43 }
+func (*MissionCount) MessageId() uint32       { return 44 }
+func (*MissionClearAll) MessageId() uint32    { return 45 }
+func (*MissionAck) MessageId() uint32         { return 47 }
+func (*MissionRequestInt) MessageId() uint32  { return 51 }
+func (*MissionItemInt) MessageId() uint32     { return 73 }
+func (*StatusText) MessageId() uint32         { return 253 }
+
+// Makes a status text message, truncating long text
+func NewStatusText(severity uint8, text string) *StatusText {
+	message := &StatusText{Severity: severity}
+	copy(message.Text[:], text)
+	return message
+}
+
+func (message *StatusText) String() string {
+	end := bytes.IndexByte(message.Text[:], 0)
+	if end < 0 {
+		end = len(message.Text)
+	}
+	return string(message.Text[:end])
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"github.com/bskari/go-glider/flightdata"
+	"github.com/bskari/go-glider/glider"
+	"os"
+	"strings"
+	"time"
+)
+
+// How many differing ticks to print
+const maxPrintedDifferences = 20
+
+func runReplay(path string, recordedAttitude bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		fmt.Printf("Unable to open %s: %v\n", path, err)
+		return
+	}
+	defer file.Close()
+	reader := flightdata.NewReader(file)
+	records, err := reader.ReadAll()
+	if err != nil {
+		fmt.Printf("Unable to read %s: %v\n", path, err)
+		return
+	}
+	if reader.Skipped > 0 {
+		fmt.Printf("Skipped %d torn or corrupted records\n", reader.Skipped)
+	}
+
+	now := time.Now()
+	logName := fmt.Sprintf("logs/%04d-%02d-%02d-%02d-%02d-%02d-replay.log", now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), now.Second())
+	fileLog, err := os.OpenFile(logName, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		panic(err)
+	}
+	defer fileLog.Close()
+	glider.ConfigureLogger(fileLog)
+	err = glider.WriteConfigurationHeader(fileLog)
+	if err != nil {
+		panic(err)
+	}
+
+	recorderName := strings.TrimSuffix(logName, ".log") + ".csv"
+	recorderFile, recorder, err := openFlightRecorder(recorderName)
+	if err != nil {
+		panic(err)
+	}
+	defer recorderFile.Close()
+
+	fmt.Printf("Replaying %d records, logging to %s and %s\n", len(records), logName, recorderName)
+	replay := glider.NewReplay(records)
+	replay.SetFlightRecorder(recorder)
+	replay.SetRecordedAttitude(recordedAttitude)
+	result, err := replay.Run()
+	if err != nil {
+		fmt.Printf("Replay failed: %v\n", err)
+		return
+	}
+	for i, difference := range result.Differences {
+		if i == maxPrintedDifferences {
+			fmt.Printf("... and %d more\n", len(result.Differences)-i)
+			break
+		}
+		fmt.Println(difference)
+	}
+	fmt.Printf("Replay %v\n", result)
+}
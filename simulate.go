@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"github.com/bskari/go-glider/glider"
+	"os"
+	"strings"
+	"time"
+)
+
+func runSimulation() {
+	now := time.Now()
+	logName := fmt.Sprintf("logs/%04d-%02d-%02d-%02d-%02d-%02d-simulation.log", now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), now.Second())
+	fileLog, err := os.OpenFile(logName, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		panic(err)
+	}
+	defer fileLog.Close()
+	glider.ConfigureLogger(fileLog)
+	err = glider.WriteConfigurationHeader(fileLog)
+	if err != nil {
+		panic(err)
+	}
+
+	recorderName := strings.TrimSuffix(logName, ".log") + ".csv"
+	recorderFile, recorder, err := openFlightRecorder(recorderName)
+	if err != nil {
+		panic(err)
+	}
+	defer recorderFile.Close()
+
+	fmt.Printf("Simulating, logging to %s and %s\n", logName, recorderName)
+	simulator := glider.NewSimulator()
+	simulator.SetFlightRecorder(recorder)
+	link, err := glider.NewMavlinkLink()
+	if err != nil {
+		panic(err)
+	}
+	if link != nil {
+		defer link.Close()
+		simulator.SetMavlinkLink(link)
+	}
+	beacon, err := glider.NewAprsBeacon()
+	if err != nil {
+		panic(err)
+	}
+	if beacon != nil {
+		defer beacon.Close()
+		simulator.SetAprsBeacon(beacon)
+	}
+	station, err := glider.NewGroundStation()
+	if err != nil {
+		panic(err)
+	}
+	if station != nil {
+		defer station.Close()
+		simulator.SetGroundStation(station)
+	}
+	fence, err := glider.NewGeofence()
+	if err != nil {
+		panic(err)
+	}
+	if fence != nil {
+		simulator.SetGeofence(fence)
+	}
+	result, err := simulator.Run()
+	if err != nil {
+		fmt.Printf("Simulation failed: %v\n", err)
+		return
+	}
+	fmt.Printf("Simulation %v\n", result)
+}
@@ -4,22 +4,14 @@ import (
 	"bufio"
 	"fmt"
 	"github.com/bskari/go-glider/glider"
-	"github.com/stianeikeland/go-rpio/v4"
 	"os"
 	"strconv"
 	"time"
 )
 
-const LEFT_SERVO_PIN = 12
-const RIGHT_SERVO_PIN = 13
-
-func testServos() {
-	if !glider.IsPi() {
-		fmt.Println("Not a Pi")
-		return
-	}
+func testServos(hardware *glider.Hardware) {
 	fmt.Println("Resetting angles to 90")
-	control := glider.NewControl()
+	control := glider.NewControl(hardware.LeftServo, hardware.RightServo)
 	control.SetLeft(glider.ToRadians(90))
 	control.SetRight(glider.ToRadians(90))
 	fmt.Print("Enter i to iterate through angles, d for manual duty cycle: ")
@@ -31,7 +23,7 @@ func testServos() {
 	if line == "i\n" {
 		iterate(control)
 	} else if line == "d\n" {
-		dutyCycle()
+		dutyCycle(hardware)
 	} else {
 		fmt.Println("Invalid option")
 	}
@@ -70,15 +62,11 @@ func iterate(control *glider.Control) {
 }
 
 // Manual testing with oscilloscope
-func dutyCycle() {
-	left := rpio.Pin(LEFT_SERVO_PIN)
-	right := rpio.Pin(RIGHT_SERVO_PIN)
-	left.Pwm()
-	right.Pwm()
+func dutyCycle(hardware *glider.Hardware) {
+	left := hardware.LeftServo
+	right := hardware.RightServo
 	const HERTZ = glider.HERTZ
 	const MULTIPLIER = glider.MULTIPLIER
-	left.Freq(HERTZ * MULTIPLIER)
-	right.Freq(HERTZ * MULTIPLIER)
 
 	reader := bufio.NewReader(os.Stdin)
 	fmt.Printf("Set Hz to %v\n", HERTZ)
@@ -100,8 +88,10 @@ func dutyCycle() {
 			return
 		}
 
-		left.DutyCycle(valueu32, MULTIPLIER)
-		right.DutyCycle(valueu32, MULTIPLIER)
+		// The cycle is 20000 us long, the same as the multiplier, so the duty
+		// cycle is the pulse width
+		left.SetPulseWidth(valueu32)
+		right.SetPulseWidth(valueu32)
 
 		fmt.Print("Enter duty cycle: ")
 		line, err = reader.ReadString('\n')
@@ -0,0 +1,383 @@
+// Package websocket implements just enough of RFC 6455 to stream messages
+// between the glider and a browser: the opening handshake, unfragmented
+// frames in either direction, fragmented frames from the peer, and the ping
+// and close control frames. Extensions and subprotocols aren't supported.
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Appended to the key in the handshake
+const acceptGuid = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	OpContinuation = 0x0
+	OpText         = 0x1
+	OpBinary       = 0x2
+	OpClose        = 0x8
+	OpPing         = 0x9
+	OpPong         = 0xA
+)
+
+// Close status codes
+const (
+	CloseNormal        = 1000
+	CloseProtocolError = 1002
+	CloseTooBig        = 1009
+)
+
+// The largest message that we'll read. We only expect small commands from
+// browsers.
+const MaxMessageLength = 64 * 1024
+
+const finBit = 0x80
+const maskBit = 0x80
+
+// How long to wait to send a close frame when closing
+const closeTimeout = time.Second
+
+var ErrClosed = errors.New("websocket: connection closed")
+var ErrMessageTooBig = errors.New("websocket: message too big")
+
+// A protocol violation by the peer
+type ProtocolError struct {
+	Message string
+}
+
+func (err *ProtocolError) Error() string {
+	return "websocket: " + err.Message
+}
+
+// A WebSocket connection. Writes can be called from any goroutine, but only
+// one goroutine should read.
+type Conn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	// Clients mask what they send, servers don't
+	client     bool
+	writeMutex sync.Mutex
+	closed     bool
+}
+
+// Finishes the opening handshake for a WebSocket request, and takes over its
+// connection
+func Upgrade(response http.ResponseWriter, request *http.Request) (*Conn, error) {
+	if request.Method != http.MethodGet {
+		http.Error(response, "Use GET", http.StatusMethodNotAllowed)
+		return nil, &ProtocolError{"handshake must be a GET"}
+	}
+	if !hasToken(request.Header, "Connection", "upgrade") || !hasToken(request.Header, "Upgrade", "websocket") {
+		http.Error(response, "Not a WebSocket handshake", http.StatusBadRequest)
+		return nil, &ProtocolError{"missing Upgrade: websocket"}
+	}
+	if request.Header.Get("Sec-WebSocket-Version") != "13" {
+		response.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(response, "Unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil, &ProtocolError{"unsupported version " + request.Header.Get("Sec-WebSocket-Version")}
+	}
+	key := request.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(response, "Missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, &ProtocolError{"missing Sec-WebSocket-Key"}
+	}
+	hijacker, ok := response.(http.Hijacker)
+	if !ok {
+		http.Error(response, "Can't upgrade this connection", http.StatusInternalServerError)
+		return nil, errors.New("websocket: response can't be hijacked")
+	}
+
+	conn, buffer, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	handshake := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + AcceptKey(key) + "\r\n\r\n"
+	if _, err := conn.Write([]byte(handshake)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// Keep reading through the buffer, in case the client didn't wait for
+	// the handshake to finish
+	return &Conn{conn: conn, reader: buffer.Reader}, nil
+}
+
+// Returns the Sec-WebSocket-Accept value for a Sec-WebSocket-Key
+func AcceptKey(key string) string {
+	hash := sha1.Sum([]byte(key + acceptGuid))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// Whether any comma separated value of the header is token, ignoring case
+func hasToken(header http.Header, name string, token string) bool {
+	for _, value := range header[http.CanonicalHeaderKey(name)] {
+		for _, field := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Connects to a WebSocket server at address, e.g. "127.0.0.1:8080", and
+// requests path, e.g. "/ws". Used by tests and tools; the glider only serves.
+func Dial(address string, path string) (*Conn, error) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+	request := fmt.Sprintf(
+		"GET %s HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n\r\n",
+		path,
+		address,
+		key,
+	)
+	if _, err := conn.Write([]byte(request)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, fmt.Errorf("websocket: handshake failed with %s", response.Status)
+	}
+	if response.Header.Get("Sec-WebSocket-Accept") != AcceptKey(key) {
+		conn.Close()
+		return nil, &ProtocolError{"bad Sec-WebSocket-Accept"}
+	}
+	return &Conn{conn: conn, reader: reader, client: true}, nil
+}
+
+func (conn *Conn) RemoteAddr() net.Addr {
+	return conn.conn.RemoteAddr()
+}
+
+// Limits how long writes can block, e.g. when a phone drops off the hotspot
+func (conn *Conn) SetWriteDeadline(deadline time.Time) error {
+	return conn.conn.SetWriteDeadline(deadline)
+}
+
+func (conn *Conn) WriteText(data []byte) error {
+	return conn.writeFrame(OpText, data)
+}
+
+func (conn *Conn) WriteBinary(data []byte) error {
+	return conn.writeFrame(OpBinary, data)
+}
+
+func (conn *Conn) writeFrame(opcode byte, payload []byte) error {
+	conn.writeMutex.Lock()
+	defer conn.writeMutex.Unlock()
+	if conn.closed {
+		return ErrClosed
+	}
+	_, err := conn.conn.Write(encodeFrame(opcode, payload, conn.client))
+	return err
+}
+
+// Encodes a single, final frame. Clients mask their frames with a random key.
+func encodeFrame(opcode byte, payload []byte, masked bool) []byte {
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, finBit|opcode)
+
+	var maskFlag byte
+	if masked {
+		maskFlag = maskBit
+	}
+	length := len(payload)
+	switch {
+	case length < 126:
+		frame = append(frame, maskFlag|byte(length))
+	case length <= 0xFFFF:
+		frame = append(frame, maskFlag|126, 0, 0)
+		binary.BigEndian.PutUint16(frame[len(frame)-2:], uint16(length))
+	default:
+		frame = append(frame, maskFlag|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[len(frame)-8:], uint64(length))
+	}
+
+	if !masked {
+		return append(frame, payload...)
+	}
+	var key [4]byte
+	rand.Read(key[:])
+	frame = append(frame, key[:]...)
+	start := len(frame)
+	frame = append(frame, payload...)
+	maskPayload(frame[start:], key)
+	return frame
+}
+
+func maskPayload(payload []byte, key [4]byte) {
+	for i := range payload {
+		payload[i] ^= key[i%4]
+	}
+}
+
+type frameHeader struct {
+	fin    bool
+	opcode byte
+	length uint64
+	masked bool
+	key    [4]byte
+}
+
+func (conn *Conn) readFrameHeader() (frameHeader, error) {
+	var header frameHeader
+	var start [2]byte
+	if _, err := io.ReadFull(conn.reader, start[:]); err != nil {
+		return header, err
+	}
+	if start[0]&0x70 != 0 {
+		return header, &ProtocolError{"reserved bits set without an extension"}
+	}
+	header.fin = start[0]&finBit != 0
+	header.opcode = start[0] & 0x0F
+	header.masked = start[1]&maskBit != 0
+	header.length = uint64(start[1] & 0x7F)
+
+	switch header.length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(conn.reader, extended[:]); err != nil {
+			return header, err
+		}
+		header.length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(conn.reader, extended[:]); err != nil {
+			return header, err
+		}
+		header.length = binary.BigEndian.Uint64(extended[:])
+	}
+	if header.masked {
+		if _, err := io.ReadFull(conn.reader, header.key[:]); err != nil {
+			return header, err
+		}
+	}
+
+	// Clients have to mask, and servers can't
+	if header.masked == conn.client {
+		return header, &ProtocolError{"bad masking"}
+	}
+	if header.opcode >= OpClose && (!header.fin || header.length > 125) {
+		return header, &ProtocolError{"bad control frame"}
+	}
+	return header, nil
+}
+
+// Reads the next text or binary message. Answers pings while it waits.
+// Returns io.EOF after the peer closes the connection.
+func (conn *Conn) ReadMessage() (byte, []byte, error) {
+	var opcode byte
+	var message []byte
+	for {
+		header, err := conn.readFrameHeader()
+		if err != nil {
+			conn.fail(err)
+			return 0, nil, err
+		}
+		if header.length > MaxMessageLength || uint64(len(message))+header.length > MaxMessageLength {
+			conn.closeWithStatus(CloseTooBig)
+			return 0, nil, ErrMessageTooBig
+		}
+		payload := make([]byte, header.length)
+		if _, err := io.ReadFull(conn.reader, payload); err != nil {
+			return 0, nil, err
+		}
+		if header.masked {
+			maskPayload(payload, header.key)
+		}
+
+		switch header.opcode {
+		case OpPing:
+			if err := conn.writeFrame(OpPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case OpPong:
+			continue
+		case OpClose:
+			conn.closeWithStatus(CloseNormal)
+			return 0, nil, io.EOF
+		case OpText, OpBinary:
+			if message != nil {
+				err := &ProtocolError{"new message in the middle of a fragmented one"}
+				conn.fail(err)
+				return 0, nil, err
+			}
+			opcode = header.opcode
+			message = payload
+		case OpContinuation:
+			if message == nil {
+				err := &ProtocolError{"continuation without a message"}
+				conn.fail(err)
+				return 0, nil, err
+			}
+			message = append(message, payload...)
+		default:
+			err := &ProtocolError{fmt.Sprintf("unknown opcode %d", header.opcode)}
+			conn.fail(err)
+			return 0, nil, err
+		}
+		if header.fin {
+			return opcode, message, nil
+		}
+	}
+}
+
+// Closes with a protocol error status if the peer misbehaved
+func (conn *Conn) fail(err error) {
+	if _, ok := err.(*ProtocolError); ok {
+		conn.closeWithStatus(CloseProtocolError)
+	}
+}
+
+// Sends a close frame and closes the connection. Safe to call more than
+// once.
+func (conn *Conn) Close() error {
+	return conn.closeWithStatus(CloseNormal)
+}
+
+func (conn *Conn) closeWithStatus(status uint16) error {
+	conn.writeMutex.Lock()
+	defer conn.writeMutex.Unlock()
+	if conn.closed {
+		return nil
+	}
+	conn.closed = true
+	// Best effort, the peer might already be gone
+	var payload [2]byte
+	binary.BigEndian.PutUint16(payload[:], status)
+	conn.conn.SetWriteDeadline(time.Now().Add(closeTimeout))
+	conn.conn.Write(encodeFrame(OpClose, payload[:], conn.client))
+	return conn.conn.Close()
+}
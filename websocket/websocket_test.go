@@ -0,0 +1,174 @@
+package websocket
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptKey(t *testing.T) {
+	// From RFC 6455 section 1.3
+	accept := AcceptKey("dGhlIHNhbXBsZSBub25jZQ==")
+	if accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Bad accept key %s", accept)
+	}
+}
+
+func TestEncodeFrame(t *testing.T) {
+	// From RFC 6455 section 5.7
+	frame := encodeFrame(OpText, []byte("Hello"), false)
+	if !bytes.Equal(frame, []byte{0x81, 0x05, 0x48, 0x65, 0x6c, 0x6c, 0x6f}) {
+		t.Errorf("Bad frame % x", frame)
+	}
+
+	lengths := []struct {
+		length     int
+		headerSize int
+	}{
+		{125, 2},
+		{126, 4},
+		{0xFFFF, 4},
+		{0x10000, 10},
+	}
+	for _, test := range lengths {
+		frame := encodeFrame(OpBinary, make([]byte, test.length), false)
+		if len(frame) != test.headerSize+test.length {
+			t.Errorf("Frame with %d bytes should have a %d byte header, got %d total", test.length, test.headerSize, len(frame))
+		}
+		// Masked frames have a 4 byte key too
+		frame = encodeFrame(OpBinary, make([]byte, test.length), true)
+		if len(frame) != test.headerSize+4+test.length || frame[1]&maskBit == 0 {
+			t.Errorf("Bad masked frame with %d bytes", test.length)
+		}
+	}
+}
+
+// Echoes every message back
+func newEchoServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		conn, err := Upgrade(response, request)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			opcode, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if opcode == OpText {
+				conn.WriteText(message)
+			} else {
+				conn.WriteBinary(message)
+			}
+		}
+	}))
+}
+
+func TestEcho(t *testing.T) {
+	server := newEchoServer()
+	defer server.Close()
+	conn, err := Dial(strings.TrimPrefix(server.URL, "http://"), "/")
+	if err != nil {
+		t.Fatalf("Unable to dial: %v", err)
+	}
+	defer conn.Close()
+
+	long := strings.Repeat("glider", 10000)
+	for _, message := range []string{"hello", "", long} {
+		if err := conn.WriteText([]byte(message)); err != nil {
+			t.Fatalf("Unable to write: %v", err)
+		}
+		opcode, echoed, err := conn.ReadMessage()
+		if err != nil || opcode != OpText || string(echoed) != message {
+			t.Errorf("Bad echo of %d bytes: %v %d bytes %v", len(message), opcode, len(echoed), err)
+		}
+	}
+
+	// Fragments are joined, and pings in the middle are answered
+	var frames []byte
+	first := encodeFrame(OpBinary, []byte("frag"), true)
+	first[0] &^= finBit
+	frames = append(frames, first...)
+	frames = append(frames, encodeFrame(OpPing, []byte("ping"), true)...)
+	frames = append(frames, encodeFrame(OpContinuation, []byte("ment"), true)...)
+	conn.conn.Write(frames)
+	// The pong comes first, but ReadMessage skips it
+	opcode, echoed, err := conn.ReadMessage()
+	if err != nil || opcode != OpBinary || string(echoed) != "fragment" {
+		t.Errorf("Bad fragmented echo: %v %q %v", opcode, echoed, err)
+	}
+
+	// The server answers a close with a close
+	conn.conn.Write(encodeFrame(OpClose, []byte{0x03, 0xE8}, true))
+	if _, _, err := conn.ReadMessage(); err != io.EOF {
+		t.Errorf("Expected EOF after closing, got %v", err)
+	}
+	if err := conn.WriteText([]byte("late")); err != ErrClosed {
+		t.Errorf("Expected ErrClosed, got %v", err)
+	}
+}
+
+func TestProtocolErrors(t *testing.T) {
+	server := newEchoServer()
+	defer server.Close()
+	address := strings.TrimPrefix(server.URL, "http://")
+
+	bad := [][]byte{
+		// Not masked
+		encodeFrame(OpText, []byte("hello"), false),
+		// Reserved bits
+		append([]byte{0xC1}, encodeFrame(OpText, []byte("hello"), true)[1:]...),
+		// Continuation without a message
+		encodeFrame(OpContinuation, []byte("hello"), true),
+		// Too big
+		encodeFrame(OpText, make([]byte, MaxMessageLength+1), true),
+	}
+	for i, frame := range bad {
+		conn, err := Dial(address, "/")
+		if err != nil {
+			t.Fatalf("Unable to dial: %v", err)
+		}
+		conn.conn.Write(frame)
+		if _, _, err := conn.ReadMessage(); err != io.EOF {
+			t.Errorf("%d: expected the server to close, got %v", i, err)
+		}
+		conn.Close()
+	}
+}
+
+func TestUpgradeErrors(t *testing.T) {
+	server := newEchoServer()
+	defer server.Close()
+
+	tests := []struct {
+		method  string
+		headers map[string]string
+		status  int
+	}{
+		{"GET", map[string]string{}, http.StatusBadRequest},
+		{"POST", map[string]string{}, http.StatusMethodNotAllowed},
+		{"GET", map[string]string{"Connection": "keep-alive, Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "8", "Sec-WebSocket-Key": "x"}, http.StatusUpgradeRequired},
+		{"GET", map[string]string{"Connection": "Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "13"}, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		request, err := http.NewRequest(test.method, server.URL, nil)
+		if err != nil {
+			t.Fatalf("Bad request: %v", err)
+		}
+		for name, value := range test.headers {
+			request.Header.Set(name, value)
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		response.Body.Close()
+		if response.StatusCode != test.status {
+			t.Errorf("%s %v should be %d, got %d", test.method, test.headers, test.status, response.StatusCode)
+		}
+	}
+}